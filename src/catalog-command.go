@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/compress"
+)
+
+// runCatalog prints the archives of a catalog matching the query, or
+// rebuilds the catalog from a full scan of its target with -rebuild.
+func runCatalog(args []string) error {
+	flags := flag.NewFlagSet("catalog", flag.ExitOnError)
+	path := flags.String(flagCatalog, "", "Catalog file, as given to the sync run")
+	target := flags.String(flagTarget, "", "Directory or URL with compressed files (required with -rebuild)")
+	trashDir := flags.String(flagTrashDir, compress.DefaultTrashDir, "Trash directory inside target")
+	rebuild := flags.Bool("rebuild", false, "Rebuild the catalog from a full scan of the target")
+	tmdbId := flags.Int("tmdb", 0, "Only show the movie with this TMDB ID")
+	asJSON := flags.Bool("json", false, "Print entries as JSON")
+	remote := registerTargetFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return fmt.Errorf("catalog is required")
+	}
+
+	catalog, err := compress.OpenCatalog(*path)
+	if err != nil {
+		return err
+	}
+	if *rebuild {
+		if *target == "" {
+			return fmt.Errorf("target is required to rebuild the catalog")
+		}
+		store, err := remote.open(*target)
+		if err != nil {
+			return err
+		}
+		if err := compress.RebuildCatalog(catalog, store, *trashDir); err != nil {
+			return err
+		}
+		fmt.Printf("Rebuilt catalog of %s: %d archives\n", store, len(catalog.Archives))
+		return nil
+	}
+	if catalog.BuiltAt.IsZero() {
+		return fmt.Errorf("catalog %s was never built, run a sync or catalog -rebuild", *path)
+	}
+
+	var entries []compress.CatalogEntry
+	for _, entry := range catalog.Entries() {
+		if matchesCatalogQuery(entry, flags.Args(), *tmdbId) {
+			entries = append(entries, entry)
+		}
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "Movie\tArchive\tSize\tCodec\tArchived")
+	for _, entry := range entries {
+		movie := entry.Movie
+		if entry.Metadata != nil {
+			movie += " [" + describeMovie(entry.Metadata) + "]"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", movie, entry.Archive, formatBytes(entry.Size),
+			entry.Codec, entry.ModTime.Local().Format(time.DateTime))
+	}
+	writer.Flush()
+	fmt.Printf("%d of %d archives, catalog of %s updated %s\n", len(entries), len(catalog.Archives),
+		catalog.Target, catalog.UpdatedAt.Local().Format(time.DateTime))
+	return nil
+}
+
+// matchesCatalogQuery reports whether entry has tmdbId, if not 0, and
+// contains every text in its movie path or title, ignoring case
+func matchesCatalogQuery(entry compress.CatalogEntry, texts []string, tmdbId int) bool {
+	if tmdbId != 0 && (entry.Metadata == nil || entry.Metadata.TmdbID != tmdbId) {
+		return false
+	}
+	haystack := strings.ToLower(entry.Movie)
+	if entry.Metadata != nil {
+		haystack += "\n" + strings.ToLower(entry.Metadata.Title)
+	}
+	for _, text := range texts {
+		if !strings.Contains(haystack, strings.ToLower(text)) {
+			return false
+		}
+	}
+	return true
+}
@@ -69,6 +69,7 @@ func decodeJSON(respBody io.ReadCloser, result interface{}) error {
 // - headers: optional custom headers
 //
 // Returns error if request fails or status code is not 2xx.
+// A 204 No Content response leaves result untouched.
 func SendRequest(method, endpoint string, result, data interface{}, headers map[string]string) error {
 	if endpoint == "" {
 		return fmt.Errorf("endpoint cannot be empty")
@@ -94,6 +95,9 @@ func SendRequest(method, endpoint string, result, data interface{}, headers map[
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
 		return fmt.Errorf("HTTP %d", resp.StatusCode)
 	}
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
 
 	// Decode response
 	if err := decodeJSON(resp.Body, result); err != nil {
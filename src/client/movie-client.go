@@ -29,6 +29,19 @@ func FetchMoviesListToCompress(token string) (model.MovieResponse, error) {
 	return cResp, nil
 }
 
+// ReportCompression acknowledges to the server that a movie from the
+// /movies/sync list was archived, or failed to be, so it can clear needSync.
+func ReportCompression(token string, report model.CompressReport) error {
+	URL := fmt.Sprintf("%s/movies/sync/report", serverURI)
+
+	headers := map[string]string{
+		"Authorization": fmt.Sprintf("Bearer %s", token),
+	}
+
+	var cResp interface{}
+	return SendRequest("POST", URL, &cResp, report, headers)
+}
+
 func FetchMoviesListToSync(token string) ([]model.MovieToRadarrResponse, error) {
 	URL := fmt.Sprintf("%s/movies", serverURI)
 
@@ -1,7 +1,14 @@
 package client
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"testing"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/model"
 )
 
 func TestSetServerUri(t *testing.T) {
@@ -80,3 +87,44 @@ func TestGetAllMoviesOnRadarrIntegration(t *testing.T) {
 	// This would require mocking HTTP response
 	t.Skip("Integration test - requires HTTP mock server")
 }
+
+func TestReportCompression(t *testing.T) {
+	var received model.CompressReport
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/movies/sync/report" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	SetServerUri(server.URL)
+
+	report := model.CompressReport{
+		Path:       "a/movie.mkv",
+		Status:     model.CompressStatusArchived,
+		Archive:    "a/movie.mkv.tar.gz",
+		Size:       42,
+		SHA256:     "abc",
+		Codec:      "tar+gzip",
+		ArchivedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Policies: []model.CompressPolicyReport{
+			{Policy: "media", Files: 1, Bytes: 40, CompressedBytes: 41, SavedBytes: -1},
+		},
+	}
+	if err := ReportCompression("token", report); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(received, report) {
+		t.Errorf("Expected %+v, got %+v", report, received)
+	}
+
+	if err := ReportCompression("wrong", report); err == nil {
+		t.Error("Expected error for rejected token")
+	}
+}
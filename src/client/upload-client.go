@@ -0,0 +1,197 @@
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultUploadChunkSize is the size of each PATCH request of an upload
+const DefaultUploadChunkSize = 8 * 1024 * 1024
+
+// tusVersion is the tus resumable upload protocol version spoken by the client.
+// See https://tus.io/protocols/resumable-upload
+const tusVersion = "1.0.0"
+
+// uploadHTTPClient shares the pooled transport but has no overall timeout,
+// since uploading a large chunk can take longer than regular API calls
+var uploadHTTPClient = &http.Client{Transport: httpClient.Transport}
+
+// ErrUploadNotFound is returned when the server no longer knows an upload,
+// e.g. because it expired; the upload has to be created again.
+var ErrUploadNotFound = errors.New("upload not found")
+
+// CreateUpload announces an upload of size bytes to the server and returns its URL.
+// metadata is sent as tus Upload-Metadata (e.g. filename, checksum).
+func CreateUpload(token string, size int64, metadata map[string]string) (string, error) {
+	endpoint := fmt.Sprintf("%s/movies/uploads", serverURI)
+	req, err := newTusRequest("POST", endpoint, token, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Upload-Length", strconv.FormatInt(size, 10))
+	if len(metadata) > 0 {
+		req.Header.Set("Upload-Metadata", encodeUploadMetadata(metadata))
+	}
+
+	resp, err := doTusRequest(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("server did not return an upload location")
+	}
+	// The location may be relative to the endpoint
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid endpoint %q: %w", endpoint, err)
+	}
+	resolved, err := base.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("invalid upload location %q: %w", location, err)
+	}
+	return resolved.String(), nil
+}
+
+// UploadOffset returns how many bytes of the upload at uploadURL the server has stored.
+// Returns ErrUploadNotFound if the server does not know the upload.
+func UploadOffset(token, uploadURL string) (int64, error) {
+	req, err := newTusRequest("HEAD", uploadURL, token, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := doTusRequest(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return parseUploadOffset(resp)
+}
+
+// UploadChunk sends chunk to be stored at offset of the upload and returns
+// the new offset confirmed by the server.
+// Returns ErrUploadNotFound if the server does not know the upload.
+func UploadChunk(token, uploadURL string, offset int64, chunk []byte) (int64, error) {
+	req, err := newTusRequest("PATCH", uploadURL, token, bytes.NewReader(chunk))
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = int64(len(chunk))
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+
+	resp, err := doTusRequest(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return parseUploadOffset(resp)
+}
+
+// TerminateUpload asks the server to discard the upload at uploadURL and
+// the bytes it stored, with the tus termination extension.
+// Returns ErrUploadNotFound if the server does not know the upload.
+func TerminateUpload(token, uploadURL string) error {
+	req, err := newTusRequest("DELETE", uploadURL, token, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := doTusRequest(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// UploadFrom sends r, positioned at offset, to the upload at uploadURL in chunks
+// of chunkSize bytes until size bytes are stored. onProgress, if not nil, is
+// called with the confirmed offset after each chunk, so callers can record it.
+func UploadFrom(token, uploadURL string, r io.Reader, offset, size, chunkSize int64,
+	onProgress func(offset int64)) error {
+	if chunkSize <= 0 {
+		chunkSize = DefaultUploadChunkSize
+	}
+
+	buf := make([]byte, chunkSize)
+	for offset < size {
+		n, err := io.ReadFull(r, buf[:min(chunkSize, size-offset)])
+		if err != nil {
+			return fmt.Errorf("failed to read upload content at offset %d: %w", offset, err)
+		}
+
+		next, err := UploadChunk(token, uploadURL, offset, buf[:n])
+		if err != nil {
+			return fmt.Errorf("failed to upload chunk at offset %d: %w", offset, err)
+		}
+		if next != offset+int64(n) {
+			return fmt.Errorf("server stored upload up to offset %d, expected %d", next, offset+int64(n))
+		}
+		offset = next
+		if onProgress != nil {
+			onProgress(offset)
+		}
+	}
+	return nil
+}
+
+func newTusRequest(method, endpoint, token string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, endpoint, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	return req, nil
+}
+
+// doTusRequest sends req. Returns ErrUploadNotFound for 404 and 410,
+// an error for any other non-2xx status code.
+func doTusRequest(req *http.Request) (*http.Response, error) {
+	resp, err := uploadHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+			return nil, ErrUploadNotFound
+		}
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+func parseUploadOffset(resp *http.Response) (int64, error) {
+	offset, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("invalid Upload-Offset %q", resp.Header.Get("Upload-Offset"))
+	}
+	return offset, nil
+}
+
+// encodeUploadMetadata encodes metadata as "key base64(value),..." sorted by key
+func encodeUploadMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(metadata[key])))
+	}
+	return strings.Join(pairs, ",")
+}
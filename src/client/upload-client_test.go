@@ -0,0 +1,140 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/testutil"
+)
+
+func newTestTusServer(t *testing.T) *testutil.TusServer {
+	t.Helper()
+	server := testutil.NewTusServer("token")
+	t.Cleanup(server.Close)
+	SetServerUri(server.URL)
+	return server
+}
+
+func TestUploadInChunks(t *testing.T) {
+	server := newTestTusServer(t)
+	content := []byte("0123456789abcdef")
+
+	uploadURL, err := CreateUpload("token", int64(len(content)), map[string]string{"filename": "movie.mkv.tar.gz"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.HasPrefix(uploadURL, server.URL+"/movies/uploads/") {
+		t.Errorf("Expected absolute upload URL, got %s", uploadURL)
+	}
+
+	if err := UploadFrom("token", uploadURL, bytes.NewReader(content), 0, int64(len(content)), 5, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if server.Patches() != 4 {
+		t.Errorf("Expected 4 chunks, got %d", server.Patches())
+	}
+
+	uploads := server.Uploads()
+	if len(uploads) != 1 || !bytes.Equal(uploads[0].Data, content) {
+		t.Fatalf("Expected uploaded content, got %v", uploads)
+	}
+	if uploads[0].Metadata["filename"] != "movie.mkv.tar.gz" {
+		t.Errorf("Expected filename metadata, got %v", uploads[0].Metadata)
+	}
+}
+
+func TestUploadResumesAtOffset(t *testing.T) {
+	server := newTestTusServer(t)
+	content := []byte("0123456789")
+
+	uploadURL, err := CreateUpload("token", int64(len(content)), nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	server.FailPatchesAfter(1)
+	if err := UploadFrom("token", uploadURL, bytes.NewReader(content), 0, int64(len(content)), 4, nil); err == nil {
+		t.Fatal("Expected error for interrupted upload")
+	}
+	server.FailPatchesAfter(-1)
+
+	offset, err := UploadOffset("token", uploadURL)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if offset != 4 {
+		t.Fatalf("Expected offset 4, got %d", offset)
+	}
+
+	var progress []int64
+	err = UploadFrom("token", uploadURL, bytes.NewReader(content[offset:]), offset, int64(len(content)), 4,
+		func(offset int64) { progress = append(progress, offset) })
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(progress) != 2 || progress[1] != 10 {
+		t.Errorf("Expected progress up to 10, got %v", progress)
+	}
+	if !bytes.Equal(server.Uploads()[0].Data, content) {
+		t.Errorf("Expected resumed content, got %q", server.Uploads()[0].Data)
+	}
+}
+
+func TestUploadChunkOffsetMismatch(t *testing.T) {
+	newTestTusServer(t)
+	uploadURL, err := CreateUpload("token", 10, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := UploadChunk("token", uploadURL, 3, []byte("abc")); err == nil || !strings.Contains(err.Error(), "HTTP 409") {
+		t.Errorf("Expected HTTP 409 error, got %v", err)
+	}
+}
+
+func TestUploadNotFound(t *testing.T) {
+	server := newTestTusServer(t)
+	uploadURL, err := CreateUpload("token", 10, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	server.Forget()
+
+	if _, err := UploadOffset("token", uploadURL); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("Expected ErrUploadNotFound, got %v", err)
+	}
+}
+
+func TestTerminateUpload(t *testing.T) {
+	server := newTestTusServer(t)
+	uploadURL, err := CreateUpload("token", 10, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if err := TerminateUpload("token", uploadURL); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(server.Uploads()) != 0 || server.Terminated() != 1 {
+		t.Errorf("Expected the upload to be discarded, got %d uploads", len(server.Uploads()))
+	}
+	if err := TerminateUpload("token", uploadURL); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("Expected ErrUploadNotFound, got %v", err)
+	}
+}
+
+func TestUploadRejectsBadToken(t *testing.T) {
+	newTestTusServer(t)
+	if _, err := CreateUpload("wrong", 10, nil); err == nil || !strings.Contains(err.Error(), "HTTP 401") {
+		t.Errorf("Expected HTTP 401 error, got %v", err)
+	}
+}
+
+func TestEncodeUploadMetadata(t *testing.T) {
+	got := encodeUploadMetadata(map[string]string{"name": "a", "filename": "b.tar.gz"})
+	expected := "filename Yi50YXIuZ3o=,name YQ=="
+	if got != expected {
+		t.Errorf("Expected '%s', got '%s'", expected, got)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// command is a CLI subcommand selected by the first argument.
+// Without a known subcommand the app runs the default sync.
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{name: "verify", usage: "Verify archives against their checksum manifests", run: runVerify},
+	{name: "restore", usage: "Extract the archive of a movie by title or TMDB ID", run: runRestore},
+	{name: "list", usage: "List the content of archives without extracting", run: runList},
+	{name: "catalog", usage: "Query or rebuild the catalog of archives", run: runCatalog},
+	{name: "prune", usage: "Remove trashed archives past their retention", run: runPrune},
+	{name: "consolidate", usage: "Merge the deltas of incremental archives into fresh bases", run: runConsolidate},
+	{name: "keygen", usage: "Create a key pair for archive encryption", run: runKeygen},
+}
+
+// findCommand returns the subcommand named name, or nil if there is none.
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// printCommands lists the available subcommands on stderr.
+func printCommands() {
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.usage)
+	}
+}
@@ -0,0 +1,257 @@
+package compress
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// Catalog is a local index of the archives in a target, keyed by movie path,
+// so runs find obsolete and outdated archives without walking the target.
+// It is kept in a JSON file replaced atomically on every change, and rebuilt
+// from a full scan of the target when missing, when it belongs to another
+// target, or on demand (see RebuildCatalog).
+//
+// Archives changed in the target by other means are only noticed after a rebuild.
+type Catalog struct {
+	path string
+	// Target is the target the catalog indexes, as described by its String method
+	Target string `json:"target"`
+	// BuiltAt is when the catalog was last rebuilt from a scan of the target
+	BuiltAt time.Time `json:"builtAt"`
+	// UpdatedAt is when the catalog was last changed
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Archives maps movie paths to their archive
+	Archives map[string]CatalogEntry `json:"archives"`
+}
+
+// CatalogEntry is the archive of one movie
+type CatalogEntry struct {
+	Movie   string `json:"movie"`
+	Archive string `json:"archive"`
+	// Size is the size of the archive, deltas included, SHA256 that of the
+	// archive or of the base of an incremental archive
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+	Codec  string `json:"codec"`
+	// Volumes is the number of volumes of a split archive
+	Volumes int `json:"volumes,omitempty"`
+	// Deltas is the number of deltas of an incremental archive
+	Deltas int `json:"deltas,omitempty"`
+	// ModTime is when the archive was stored
+	ModTime time.Time `json:"modTime"`
+	// Source is the movie as it was compressed, unknown for entries found by a rebuild
+	Source *SourceFingerprint `json:"source,omitempty"`
+	// Metadata identifies the movie, if embedded in its archive
+	Metadata *io_archive.MovieMetadata `json:"metadata,omitempty"`
+}
+
+// SourceFingerprint identifies a version of a movie in the source
+type SourceFingerprint struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// matches reports whether f and other are the same version
+func (f SourceFingerprint) matches(other SourceFingerprint) bool {
+	return f.Size == other.Size && f.ModTime.Equal(other.ModTime)
+}
+
+// fingerprint returns the fingerprint of the source file or directory info
+func fingerprint(info *storage.File) *SourceFingerprint {
+	return &SourceFingerprint{Size: info.Size, ModTime: info.ModTime.UTC().Truncate(time.Second)}
+}
+
+// OpenCatalog loads the catalog kept at the local path, or returns an empty
+// one, to be built on first use, if the file does not exist
+func OpenCatalog(path string) (*Catalog, error) {
+	if path == "" {
+		return nil, fmt.Errorf("catalog path cannot be empty")
+	}
+	catalog := &Catalog{path: path, Archives: make(map[string]CatalogEntry)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return catalog, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog: %w", err)
+	}
+	if err := json.Unmarshal(data, catalog); err != nil {
+		return nil, fmt.Errorf("failed to parse catalog %s: %w", path, err)
+	}
+	if catalog.Archives == nil {
+		catalog.Archives = make(map[string]CatalogEntry)
+	}
+	return catalog, nil
+}
+
+// Get returns the archive of movie
+func (c *Catalog) Get(movie string) (CatalogEntry, bool) {
+	entry, ok := c.Archives[movie]
+	return entry, ok
+}
+
+// Entries returns the archives sorted by movie path
+func (c *Catalog) Entries() []CatalogEntry {
+	entries := make([]CatalogEntry, 0, len(c.Archives))
+	for _, movie := range slices.Sorted(maps.Keys(c.Archives)) {
+		entries = append(entries, c.Archives[movie])
+	}
+	return entries
+}
+
+// commit applies change to a copy of the archives and saves it. The catalog
+// is only changed once the file is replaced, so a failed save changes nothing.
+func (c *Catalog) commit(change func(next *Catalog)) error {
+	next := *c
+	next.Archives = maps.Clone(c.Archives)
+	change(&next)
+	next.UpdatedAt = time.Now().UTC()
+	if err := next.save(); err != nil {
+		return err
+	}
+	*c = next
+	return nil
+}
+
+// save replaces the catalog file, written to a temporary file first
+func (c *Catalog) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir, name := filepath.Split(c.path)
+	if dir == "" {
+		dir = "."
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to save catalog: %w", err)
+	}
+	if err := storage.WriteFile(storage.NewLocal(dir), name, data); err != nil {
+		return fmt.Errorf("failed to save catalog: %w", err)
+	}
+	return nil
+}
+
+// put records the archive of entry.Movie
+func (c *Catalog) put(entry CatalogEntry) error {
+	return c.commit(func(next *Catalog) {
+		next.Archives[entry.Movie] = entry
+	})
+}
+
+// remove forgets the archive of movie
+func (c *Catalog) remove(movie string) error {
+	if _, ok := c.Archives[movie]; !ok {
+		return nil
+	}
+	return c.commit(func(next *Catalog) {
+		delete(next.Archives, movie)
+	})
+}
+
+// outdated reports whether movie in source needs compressing to archive:
+// the catalog has no such archive, or the movie differs from the version
+// compressed, or, when that version is unknown, is newer than the archive
+func (c *Catalog) outdated(source storage.Storage, movie, archive string) (bool, error) {
+	entry, ok := c.Get(movie)
+	if !ok || entry.Archive != archive {
+		return true, nil
+	}
+	info, err := source.Stat(movie)
+	if err != nil || info == nil {
+		return false, err
+	}
+	if entry.Source != nil {
+		return !fingerprint(info).matches(*entry.Source), nil
+	}
+	return info.ModTime.Truncate(time.Second).After(entry.ModTime.Truncate(time.Second)), nil
+}
+
+// ensure rebuilds the catalog if it was never built or indexes another target
+func (c *Catalog) ensure(target storage.Storage, trashDir string) error {
+	if !c.BuiltAt.IsZero() && c.Target == target.String() {
+		return nil
+	}
+	fmt.Printf("Building catalog of %s\n", target)
+	return RebuildCatalog(c, target, trashDir)
+}
+
+// RebuildCatalog replaces the content of catalog with the archives found in
+// target outside trashDir, read from their manifests. Source fingerprints
+// are unknown until the movies are compressed again.
+func RebuildCatalog(catalog *Catalog, target storage.Storage, trashDir string) error {
+	archives, err := io_archive.FindArchives(target, "")
+	if err != nil {
+		return err
+	}
+
+	found := make(map[string]CatalogEntry)
+	for _, archive := range archives {
+		if isInTrash(archive, trashDir) {
+			continue
+		}
+		entry, err := scanArchive(target, archive)
+		if err != nil {
+			return err
+		}
+		found[entry.Movie] = entry
+	}
+
+	return catalog.commit(func(next *Catalog) {
+		next.Archives = found
+		next.Target = target.String()
+		next.BuiltAt = time.Now().UTC()
+	})
+}
+
+// scanArchive returns the catalog entry of the archive name in target
+func scanArchive(target storage.Storage, name string) (CatalogEntry, error) {
+	entry := CatalogEntry{Movie: movieName(name), Archive: name, Codec: archiveCodec(name)}
+	info, err := io_archive.StatArchive(target, name)
+	if err != nil {
+		return entry, err
+	}
+	if info != nil {
+		entry.Size, entry.ModTime = info.Size, info.ModTime.UTC()
+	}
+
+	manifest, err := io_archive.ReadManifestIn(target, name)
+	if err != nil {
+		return entry, fmt.Errorf("%s: %w", name, err)
+	}
+	if manifest != nil {
+		entry.Size = manifest.Size
+		entry.SHA256 = manifest.SHA256
+		entry.Volumes = len(manifest.Volumes)
+		entry.Metadata = manifest.Metadata
+	}
+
+	chain, err := io_archive.ReadChain(target, name)
+	if err != nil {
+		return entry, fmt.Errorf("%s: %w", name, err)
+	}
+	if chain != nil {
+		entry.Deltas = len(chain.Deltas)
+		for _, delta := range chain.Deltas {
+			entry.Size += delta.Size
+			entry.ModTime = delta.CreatedAt
+		}
+	}
+	return entry, nil
+}
+
+// archiveCodec returns the codec of the archive name
+func archiveCodec(name string) string {
+	if io_archive.IsEncrypted(name) {
+		return io_archive.EncryptedCodec
+	}
+	return io_archive.Codec
+}
@@ -0,0 +1,151 @@
+package compress
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// statCounter is a memory store counting the archives stat'ed in it
+type statCounter struct {
+	*storage.Memory
+	archiveStats int
+}
+
+func (s *statCounter) Stat(name string) (*storage.File, error) {
+	if _, ok := io_archive.TrimExtension(name); ok {
+		s.archiveStats++
+	}
+	return s.Memory.Stat(name)
+}
+
+func TestRebuildCatalog(t *testing.T) {
+	modTime := time.Now().Add(-time.Hour)
+	source := storage.NewMemory()
+	source.WriteFile("a/movie.mkv", []byte("content"), modTime)
+	source.WriteFile("b/other.mkv", []byte("other"), modTime)
+	target := storage.NewMemory()
+	opts := &SyncOptions{
+		Source: source,
+		Target: target,
+		Metadata: func(moviePath string) *io_archive.MovieMetadata {
+			return &io_archive.MovieMetadata{Title: moviePath}
+		},
+	}
+	if err := SyncAndCompress("", "", []string{"a/movie.mkv", "b/other.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	target.WriteFile(".trash/20240101T000000Z/c/old.mkv.tar.gz", []byte("old"), modTime)
+
+	path := filepath.Join(t.TempDir(), "catalog", "catalog.json")
+	catalog, err := OpenCatalog(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := RebuildCatalog(catalog, target, DefaultTrashDir); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	entries := catalog.Entries()
+	if len(entries) != 2 || entries[0].Movie != "a/movie.mkv" || entries[1].Movie != "b/other.mkv" {
+		t.Fatalf("Expected the two archives outside the trash, got %+v", entries)
+	}
+	manifest, err := io_archive.ReadManifestIn(target, "a/movie.mkv.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := entries[0]
+	if entry.Archive != "a/movie.mkv.tar.gz" || entry.Size != manifest.Size || entry.SHA256 != manifest.SHA256 ||
+		entry.Codec != io_archive.Codec || entry.Source != nil {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+	if entry.Metadata == nil || entry.Metadata.Title != "a/movie.mkv" {
+		t.Errorf("Expected the embedded metadata, got %+v", entry.Metadata)
+	}
+	if catalog.Target != target.String() || catalog.BuiltAt.IsZero() {
+		t.Errorf("Expected the catalog to record its target, got %q at %s", catalog.Target, catalog.BuiltAt)
+	}
+
+	reopened, err := OpenCatalog(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(reopened.Entries(), catalog.Entries()) {
+		t.Errorf("Expected the saved catalog %+v, got %+v", catalog.Entries(), reopened.Entries())
+	}
+}
+
+func TestSyncAndCompressWithCatalog(t *testing.T) {
+	modTime := time.Now().Add(-time.Hour)
+	source := storage.NewMemory()
+	source.WriteFile("a/movie.mkv", []byte("content"), modTime)
+	source.WriteFile("b/other.mkv", []byte("other"), modTime)
+	source.WriteFile("c/third.mkv", []byte("third"), modTime)
+	target := &statCounter{Memory: storage.NewMemory()}
+	catalog, err := OpenCatalog(filepath.Join(t.TempDir(), "catalog.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &SyncOptions{Source: source, Target: target, Catalog: catalog, MaxDeletePercent: 100}
+
+	movies := []string{"a/movie.mkv", "b/other.mkv", "c/third.mkv"}
+	if err := SyncAndCompress("", "", movies, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(catalog.Archives) != 3 {
+		t.Fatalf("Expected 3 archives in the catalog, got %+v", catalog.Archives)
+	}
+
+	// A changed movie is compressed again and a removed one trashed
+	source.WriteFile("a/movie.mkv", []byte("new content"), modTime)
+	if err := SyncAndCompress("", "", []string{"a/movie.mkv", "b/other.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, ok := catalog.Get("c/third.mkv"); ok {
+		t.Error("Expected the trashed archive to leave the catalog")
+	}
+	if info, _ := target.Stat("c/third.mkv.tar.gz"); info != nil {
+		t.Error("Expected the obsolete archive to be trashed")
+	}
+	entry, ok := catalog.Get("a/movie.mkv")
+	if !ok || entry.Source == nil || entry.Source.Size != int64(len("new content")) {
+		t.Errorf("Expected the new version in the catalog, got %+v", entry)
+	}
+
+	// Unchanged movies are checked without looking up their archives in the target
+	target.archiveStats = 0
+	if err := SyncAndCompress("", "", []string{"a/movie.mkv", "b/other.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if target.archiveStats != 0 {
+		t.Errorf("Expected archives to be looked up in the catalog, got %d stats", target.archiveStats)
+	}
+}
+
+func TestCatalogFailedSaveChangesNothing(t *testing.T) {
+	dir := t.TempDir()
+	blocker := filepath.Join(dir, "file")
+	if err := os.WriteFile(blocker, []byte("not a directory"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := OpenCatalog(filepath.Join(dir, "catalog.json"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// The catalog cannot be written below a file
+	catalog.path = filepath.Join(blocker, "catalog.json")
+
+	err = catalog.put(CatalogEntry{Movie: "a/movie.mkv", Archive: "a/movie.mkv.tar.gz"})
+	if err == nil || !strings.Contains(err.Error(), "failed to save catalog") {
+		t.Fatalf("Expected save error, got %v", err)
+	}
+	if len(catalog.Archives) != 0 || !catalog.UpdatedAt.IsZero() {
+		t.Errorf("Expected the catalog unchanged, got %+v", catalog)
+	}
+}
@@ -0,0 +1,97 @@
+package compress
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// DefaultChainLength is the number of deltas from which Consolidate merges
+// an incremental archive into a fresh base
+const DefaultChainLength = 5
+
+// ConsolidateOptions configures Consolidate
+type ConsolidateOptions struct {
+	// ChainLength is the number of deltas from which an incremental archive
+	// is consolidated (default DefaultChainLength)
+	ChainLength int
+	// TrashDir holds trashed archives, which are left alone (default .trash)
+	TrashDir string
+	// Compress configures the new bases. Encrypted bases need its Encryption.
+	Compress *io_archive.CompressOptions
+	// Decryption opens encrypted archives
+	Decryption *io_archive.Decryption
+	// Catalog, if set, lists the incremental archives instead of a walk of
+	// the target, and is updated with the new bases
+	Catalog *Catalog
+}
+
+// Consolidate merges the deltas of the incremental archives in target whose
+// chain reached opts.ChainLength into fresh bases, from the archives alone
+// (see io_archive.Consolidate). Returns the number of archives consolidated.
+func Consolidate(target storage.Storage, opts ConsolidateOptions) (int, error) {
+	length := opts.ChainLength
+	if length <= 0 {
+		length = DefaultChainLength
+	}
+	trashDir := strings.Trim(opts.TrashDir, "/")
+	if trashDir == "" {
+		trashDir = DefaultTrashDir
+	}
+
+	var archives []string
+	if opts.Catalog != nil {
+		if err := opts.Catalog.ensure(target, trashDir); err != nil {
+			return 0, fmt.Errorf("catalog failed: %w", err)
+		}
+		for _, entry := range opts.Catalog.Entries() {
+			if entry.Deltas >= length {
+				archives = append(archives, entry.Archive)
+			}
+		}
+	} else {
+		var err error
+		if archives, err = io_archive.FindArchives(target, ""); err != nil {
+			return 0, err
+		}
+	}
+
+	consolidated := 0
+	for _, archive := range archives {
+		if isInTrash(archive, trashDir) {
+			continue
+		}
+		chain, err := io_archive.ReadChain(target, archive)
+		if err != nil {
+			return consolidated, err
+		}
+		// An interrupted consolidation is always finished
+		if chain == nil || (len(chain.Deltas) < length && chain.Merged == 0) {
+			continue
+		}
+
+		manifest, err := io_archive.Consolidate(target, archive, opts.Decryption, opts.Compress)
+		if err != nil {
+			return consolidated, fmt.Errorf("failed to consolidate %s: %w", archive, err)
+		}
+		consolidated++
+		fmt.Printf("Consolidated: %s (%d deltas, %d bytes)\n", archive, len(chain.Deltas)+chain.Merged, manifest.Size)
+
+		if opts.Catalog != nil {
+			entry, err := scanArchive(target, archive)
+			if err != nil {
+				return consolidated, err
+			}
+			// The content did not change, only how it is stored
+			if previous, ok := opts.Catalog.Get(entry.Movie); ok {
+				entry.Source = previous.Source
+			}
+			if err := opts.Catalog.put(entry); err != nil {
+				return consolidated, fmt.Errorf("failed to record %s in the catalog: %w", archive, err)
+			}
+		}
+	}
+	return consolidated, nil
+}
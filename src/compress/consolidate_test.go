@@ -0,0 +1,114 @@
+package compress
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// addSubtitle adds a subtitle to the movie directory dir of sourceDir, dated
+// after the previous run so the movie is found outdated
+func addSubtitle(t *testing.T, sourceDir, dir, name string, run int) {
+	t.Helper()
+	writeMovie(t, sourceDir, dir+"/"+name, "subtitle "+name)
+	later := time.Now().Add(time.Duration(run) * time.Hour)
+	if err := os.Chtimes(filepath.Join(sourceDir, dir), later, later); err != nil {
+		t.Fatalf("Failed to touch %s: %v", dir, err)
+	}
+}
+
+// incrementalSync compresses the movie directory a/movie of sourceDir into
+// targetDir incrementally, with catalog
+func incrementalSync(t *testing.T, sourceDir, targetDir string, catalog *Catalog) {
+	t.Helper()
+	opts := &SyncOptions{Incremental: true, Catalog: catalog}
+	if err := SyncAndCompress(sourceDir, targetDir, []string{"a/movie"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestSyncAndCompressIncremental(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	writeMovie(t, sourceDir, "a/movie/movie.mkv", "video")
+	catalog, err := OpenCatalog(filepath.Join(t.TempDir(), "catalog.json"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	incrementalSync(t, sourceDir, targetDir, catalog)
+	addSubtitle(t, sourceDir, "a/movie", "movie.en.srt", 1)
+	incrementalSync(t, sourceDir, targetDir, catalog)
+	// Nothing changed since the delta
+	incrementalSync(t, sourceDir, targetDir, catalog)
+
+	target := storage.NewLocal(targetDir)
+	chain, err := io_archive.ReadChain(target, "a/movie.tar.gz")
+	if err != nil || chain == nil || len(chain.Deltas) != 1 {
+		t.Fatalf("Expected a chain of one delta, got %+v, %v", chain, err)
+	}
+	if chain.Deltas[0].Changed[len(chain.Deltas[0].Changed)-1] != "movie/movie.en.srt" {
+		t.Errorf("Expected the delta to hold the subtitle, got %v", chain.Deltas[0].Changed)
+	}
+	if entry, ok := catalog.Get("a/movie"); !ok || entry.Deltas != 1 || entry.Archive != "a/movie.tar.gz" {
+		t.Errorf("Expected the catalog to count the delta, got %+v", entry)
+	}
+
+	if err := moveToTrash(target, DefaultTrashDir, "a/movie.tar.gz"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	files, _ := target.List("")
+	for _, file := range storage.Files(files) {
+		if strings.HasPrefix(file.Name, "a/") {
+			t.Errorf("Expected %s to be trashed with its base", file.Name)
+		}
+	}
+	if removed, err := PruneTrash(target, DefaultTrashDir, 0); err != nil || removed != 1 {
+		t.Errorf("Expected one archive pruned, got %d, %v", removed, err)
+	}
+}
+
+func TestConsolidate(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	writeMovie(t, sourceDir, "a/movie/movie.mkv", "video")
+	catalog, err := OpenCatalog(filepath.Join(t.TempDir(), "catalog.json"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	incrementalSync(t, sourceDir, targetDir, catalog)
+	for run, name := range []string{"movie.en.srt", "movie.pt.srt"} {
+		addSubtitle(t, sourceDir, "a/movie", name, run+1)
+		incrementalSync(t, sourceDir, targetDir, catalog)
+	}
+	target := storage.NewLocal(targetDir)
+
+	if count, err := Consolidate(target, ConsolidateOptions{ChainLength: 3, Catalog: catalog}); err != nil || count != 0 {
+		t.Fatalf("Expected no chain long enough, got %d, %v", count, err)
+	}
+	if count, err := Consolidate(target, ConsolidateOptions{ChainLength: 2, Catalog: catalog}); err != nil || count != 1 {
+		t.Fatalf("Expected one archive consolidated, got %d, %v", count, err)
+	}
+
+	entry, ok := catalog.Get("a/movie")
+	if !ok || entry.Deltas != 0 || entry.Source == nil {
+		t.Errorf("Expected the catalog to keep the source of a base without deltas, got %+v", entry)
+	}
+	if info, _ := target.Stat(io_archive.DeltaName("a/movie.tar.gz", 1)); info != nil {
+		t.Error("Expected the deltas to be removed")
+	}
+	dest := t.TempDir()
+	if _, err := io_archive.ExtractFrom(target, "a/movie.tar.gz", dest, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, name := range []string{"movie.mkv", "movie.en.srt", "movie.pt.srt"} {
+		if _, err := os.Stat(filepath.Join(dest, "movie", name)); err != nil {
+			t.Errorf("Expected %s to be restored: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package compress
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
+)
+
+// dedupRun sums the content of the movies stored in a chunk store during a
+// run and the bytes of the chunks that were new
+type dedupRun struct {
+	chunks *io_archive.ChunkStore
+	size   int64
+	stored int64
+}
+
+// checkDedup fails when opts.Dedup is combined with options that do not
+// apply to snapshots: chunks are neither encrypted nor uploaded, snapshots
+// have no deltas and are not cataloged
+func checkDedup(opts SyncOptions) error {
+	if !opts.Dedup {
+		return nil
+	}
+	switch {
+	case opts.Compress != nil && opts.Compress.Encryption != nil:
+		return fmt.Errorf("dedup cannot be combined with encryption")
+	case opts.Incremental:
+		return fmt.Errorf("dedup cannot be combined with incremental archives")
+	case opts.Uploader != nil:
+		return fmt.Errorf("dedup cannot be combined with uploads")
+	case opts.Catalog != nil:
+		return fmt.Errorf("dedup cannot be combined with a catalog")
+	}
+	return nil
+}
+
+// openDedup opens the chunk store of the target when opts.Dedup is set,
+// nil otherwise
+func openDedup(opts SyncOptions) (*dedupRun, error) {
+	if err := checkDedup(opts); err != nil || !opts.Dedup {
+		return nil, err
+	}
+	chunks, err := io_archive.OpenChunkStore(opts.Target, opts.ChunkSizes)
+	if err != nil {
+		return nil, err
+	}
+	return &dedupRun{chunks: chunks}, nil
+}
+
+// put stores moviePath of opts.Source as the snapshot name and returns it
+// described as a manifest: its size is the bytes of the new chunks, what
+// the movie added to the target
+func (d *dedupRun) put(opts SyncOptions, moviePath, name string,
+	archiveOpts *io_archive.CompressOptions) (*io_archive.Manifest, error) {
+	snapshot, err := d.chunks.Put(name, opts.Source, moviePath, archiveOpts)
+	if err != nil {
+		return nil, err
+	}
+	d.size += snapshot.Size
+	d.stored += snapshot.StoredSize
+	fmt.Printf("  Dedup: %d chunks, %d new, %d of %d bytes stored\n",
+		snapshot.Chunks, snapshot.NewChunks, snapshot.StoredSize, snapshot.Size)
+
+	manifest := &io_archive.Manifest{
+		Archive:     path.Base(name),
+		Size:        snapshot.StoredSize,
+		CreatedAt:   snapshot.CreatedAt,
+		Skipped:     snapshot.Skipped,
+		SkippedSize: snapshot.SkippedSize,
+		Warnings:    snapshot.Warnings,
+		Metadata:    snapshot.Metadata,
+	}
+	for _, entry := range snapshot.Entries {
+		if entry.SHA256 != "" {
+			manifest.Entries = append(manifest.Entries, io_archive.ManifestEntry{
+				Name:   entry.Name,
+				Size:   entry.Size,
+				SHA256: entry.SHA256,
+			})
+		}
+	}
+	return manifest, nil
+}
+
+// ratio returns how many bytes of content each byte stored during the run
+// holds, 0 if nothing was stored
+func (d *dedupRun) ratio() float64 {
+	if d.stored <= 0 {
+		return 0
+	}
+	return float64(d.size) / float64(d.stored)
+}
+
+// report prints the dedup ratio of the run and of the whole chunk store
+func (d *dedupRun) report() {
+	if d == nil || d.size == 0 {
+		return
+	}
+	stats := d.chunks.Stats()
+	if d.stored == 0 {
+		fmt.Printf("Dedup: all %d bytes of content already stored, store ratio %.2f (%d chunks, %d bytes)\n",
+			d.size, stats.Ratio(), stats.Chunks, stats.Size)
+		return
+	}
+	fmt.Printf("Dedup ratio: %.2f (%d bytes stored for %d bytes of content), store ratio %.2f (%d chunks, %d bytes)\n",
+		d.ratio(), d.stored, d.size, stats.Ratio(), stats.Chunks, stats.Size)
+}
@@ -0,0 +1,138 @@
+package compress
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
+	"github.com/pedrosantosdev/radarr-sync-go/src/model"
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// testChunkSizes keeps chunks small so test movies span many of them
+var testChunkSizes = io_archive.ChunkSizes{Min: 64, Average: 256, Max: 1024}
+
+// writeEditions writes two editions of a movie, with different videos and
+// the same extras
+func writeEditions(t *testing.T, sourceDir string) {
+	t.Helper()
+	extras := strings.Repeat("behind the scenes ", 500)
+	writeMovie(t, sourceDir, "a/movie/movie.mkv", strings.Repeat("theatrical cut ", 400))
+	writeMovie(t, sourceDir, "a/movie/extras/making-of.mkv", extras)
+	writeMovie(t, sourceDir, "b/movie/movie.mkv", strings.Repeat("director's cut ", 400))
+	writeMovie(t, sourceDir, "b/movie/extras/making-of.mkv", extras)
+}
+
+func TestSyncAndCompressDedup(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	writeEditions(t, sourceDir)
+
+	var reports []model.CompressReport
+	opts := &SyncOptions{
+		Dedup:      true,
+		ChunkSizes: &testChunkSizes,
+		Report: func(report model.CompressReport) error {
+			reports = append(reports, report)
+			return nil
+		},
+	}
+	if err := SyncAndCompress(sourceDir, targetDir, []string{"a/movie", "b/movie"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(reports) != 2 || reports[1].Archive != "b/movie."+io_archive.SnapshotExtension ||
+		reports[1].Codec != io_archive.DedupCodec || reports[1].DedupedBytes < 9000 {
+		t.Fatalf("Expected the extras of the second movie to be deduplicated, got %+v", reports)
+	}
+
+	target := storage.NewLocal(targetDir)
+	chunks, err := io_archive.OpenChunkStore(target, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if ratio := chunks.Stats().Ratio(); ratio < 1.4 {
+		t.Errorf("Expected a dedup ratio above 1.4, got %.2f", ratio)
+	}
+	dest := t.TempDir()
+	if _, err := chunks.Restore("b/movie."+io_archive.SnapshotExtension, dest, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dest, "movie", "movie.mkv")); string(data) != strings.Repeat("director's cut ", 400) {
+		t.Errorf("Expected the movie to be restored, got %d bytes", len(data))
+	}
+
+	// An unchanged movie is not stored again, a removed one is trashed
+	reports = nil
+	opts.MaxDeletePercent = 100
+	if err := SyncAndCompress(sourceDir, targetDir, []string{"a/movie"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(reports) != 0 {
+		t.Errorf("Expected nothing stored, got %+v", reports)
+	}
+	if info, _ := target.Stat("b/movie." + io_archive.SnapshotExtension); info != nil {
+		t.Error("Expected the snapshot of the removed movie to be trashed")
+	}
+
+	// Pruning the trash releases the chunks only the trashed snapshot used
+	// and sweeps chunks nothing references
+	before := chunks.Stats()
+	sum := sha256.Sum256([]byte("orphan"))
+	orphan := io_archive.DedupDir + "/" + hex.EncodeToString(sum[:1]) + "/" + hex.EncodeToString(sum[:])
+	writeMovie(t, targetDir, orphan, "orphan")
+	if removed, err := PruneTrash(target, DefaultTrashDir, 0); err != nil || removed != 1 {
+		t.Fatalf("Expected one snapshot pruned, got %d, %v", removed, err)
+	}
+	chunks, _ = io_archive.OpenChunkStore(target, nil)
+	after := chunks.Stats()
+	if after.Size >= before.Size || after.Referenced != 6000+9000 {
+		t.Errorf("Expected the chunks of the director's cut to be freed, got %+v, was %+v", after, before)
+	}
+	if info, _ := target.Stat(orphan); info != nil {
+		t.Error("Expected the orphaned chunk to be swept")
+	}
+}
+
+func TestSyncAndCompressDedupReplacesArchive(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	writeMovie(t, sourceDir, "a/movie/movie.mkv", "video")
+	if err := SyncAndCompress(sourceDir, targetDir, []string{"a/movie"}, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	opts := &SyncOptions{Dedup: true, ChunkSizes: &testChunkSizes}
+	if err := SyncAndCompress(sourceDir, targetDir, []string{"a/movie"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	target := storage.NewLocal(targetDir)
+	if info, _ := target.Stat("a/movie.tar.gz"); info != nil {
+		t.Error("Expected the archive to be replaced by the snapshot")
+	}
+	if info, _ := target.Stat("a/movie." + io_archive.SnapshotExtension); info == nil {
+		t.Error("Expected the snapshot to be stored")
+	}
+}
+
+func TestSyncAndCompressDedupRejectsOptions(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	writeMovie(t, sourceDir, "a/movie/movie.mkv", "video")
+
+	for _, opts := range []*SyncOptions{
+		{Dedup: true, Incremental: true},
+		{Dedup: true, Compress: &io_archive.CompressOptions{Encryption: &io_archive.Encryption{Passphrase: "secret"}}},
+	} {
+		err := SyncAndCompress(sourceDir, targetDir, []string{"a/movie"}, opts)
+		if err == nil || !strings.Contains(err.Error(), "dedup cannot be combined") {
+			t.Errorf("Expected a dedup error, got %v", err)
+		}
+	}
+	if files, _ := os.ReadDir(targetDir); len(files) != 0 {
+		t.Errorf("Expected nothing written, got %d files", len(files))
+	}
+}
@@ -0,0 +1,245 @@
+//go:build linux
+
+package compress
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// watchMask selects the inotify events marking a change below a directory
+const watchMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM | syscall.IN_DELETE
+
+// dirWatcher reports changed paths below a local directory tree with
+// inotify, watching directories created or moved into the tree as well.
+// Events are read as they come and queued until the consumer takes them, so
+// a slow consumer never makes the kernel queue overflow.
+type dirWatcher struct {
+	// file reads fd, which is only used directly to add watches since
+	// file.Fd would make reads blocking
+	file   *os.File
+	fd     int
+	root   string
+	dirs   map[int32]string
+	events chan string
+	errors chan error
+	done   chan struct{}
+
+	// mu guards queued, the changed paths not delivered yet. A path changed
+	// again before it is delivered is sent once.
+	mu     sync.Mutex
+	queued map[string]bool
+	// wake signals deliver that paths were queued
+	wake chan struct{}
+}
+
+// newDirWatcher starts watching every directory below root
+func newDirWatcher(root string) (*dirWatcher, error) {
+	w, err := openDirWatcher(root)
+	if err != nil {
+		return nil, err
+	}
+	go w.read()
+	go w.deliver()
+	return w, nil
+}
+
+// openDirWatcher adds watches on every directory below root
+func openDirWatcher(root string) (*dirWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start inotify: %w", err)
+	}
+	// A non-blocking descriptor is read through the runtime poller, so Close
+	// interrupts a pending read
+	w := &dirWatcher{
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		fd:     fd,
+		root:   root,
+		dirs:   make(map[int32]string),
+		events: make(chan string),
+		errors: make(chan error, 1),
+		done:   make(chan struct{}),
+		queued: make(map[string]bool),
+		wake:   make(chan struct{}, 1),
+	}
+	if err := w.addTree(""); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Events returns changed paths, slash-separated and relative to the root
+func (w *dirWatcher) Events() <-chan string {
+	return w.events
+}
+
+// Errors returns the error that stopped the watch
+func (w *dirWatcher) Errors() <-chan error {
+	return w.errors
+}
+
+func (w *dirWatcher) Close() error {
+	close(w.done)
+	return w.file.Close()
+}
+
+// addTree watches the directory rel and every directory below it
+func (w *dirWatcher) addTree(rel string) error {
+	start := filepath.Join(w.root, filepath.FromSlash(rel))
+	return filepath.WalkDir(start, func(dir string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, dir, watchMask)
+		if err != nil {
+			return fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+		name, err := filepath.Rel(w.root, dir)
+		if err != nil {
+			return err
+		}
+		w.dirs[int32(wd)] = filepath.ToSlash(name)
+		return nil
+	})
+}
+
+// removeTree stops watching the directory rel and every directory below it
+func (w *dirWatcher) removeTree(rel string) {
+	for wd, dir := range w.dirs {
+		if dir == rel || strings.HasPrefix(dir, rel+"/") {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.dirs, wd)
+		}
+	}
+}
+
+// read decodes events into the queue until the watcher is closed
+func (w *dirWatcher) read() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				w.errors <- fmt.Errorf("failed to read inotify events: %w", err)
+			}
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			wd := int32(binary.NativeEndian.Uint32(buf[offset:]))
+			mask := binary.NativeEndian.Uint32(buf[offset+4:])
+			length := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+length]
+			offset += syscall.SizeofInotifyEvent + length
+
+			if err := w.handle(wd, mask, strings.TrimRight(string(nameBytes), "\x00")); err != nil {
+				w.errors <- err
+				return
+			}
+		}
+	}
+}
+
+// handle queues the path of an event on name in the directory watched as wd
+func (w *dirWatcher) handle(wd int32, mask uint32, name string) error {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		return w.rescan()
+	}
+	dir, ok := w.dirs[wd]
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(w.dirs, wd)
+		return nil
+	}
+	if !ok || name == "" {
+		return nil
+	}
+	name = path.Join(dir, name)
+	if mask&syscall.IN_ISDIR != 0 && mask&syscall.IN_MOVED_FROM != 0 {
+		// Watches follow a moved directory, so events in a directory moved
+		// out of the tree would be reported under its previous path. One
+		// moved within the tree is watched again on its IN_MOVED_TO.
+		w.removeTree(name)
+	}
+	if mask&syscall.IN_ISDIR != 0 && mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+		// Directories moved in or created late are watched too
+		if err := w.addTree(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	w.queue(name)
+	return nil
+}
+
+// rescan recovers from an overflow of the kernel queue, which dropped
+// events: directories created meanwhile are watched and every entry of the
+// root is reported as changed, so all movies below it are checked again
+func (w *dirWatcher) rescan() error {
+	if err := w.addTree(""); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(w.root)
+	if err != nil {
+		return fmt.Errorf("failed to rescan %s: %w", w.root, err)
+	}
+	for _, entry := range entries {
+		w.queue(entry.Name())
+	}
+	return nil
+}
+
+// queue records a changed path for deliver
+func (w *dirWatcher) queue(name string) {
+	w.mu.Lock()
+	w.queued[name] = true
+	w.mu.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the queued paths, sorted, and empties the queue
+func (w *dirWatcher) take() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	names := make([]string, 0, len(w.queued))
+	for name := range w.queued {
+		names = append(names, name)
+	}
+	clear(w.queued)
+	sort.Strings(names)
+	return names
+}
+
+// deliver sends queued paths on events until the watcher is closed
+func (w *dirWatcher) deliver() {
+	defer close(w.events)
+	for {
+		select {
+		case <-w.wake:
+		case <-w.done:
+			return
+		}
+		for _, name := range w.take() {
+			select {
+			case w.events <- name:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
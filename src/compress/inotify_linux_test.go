@@ -0,0 +1,151 @@
+package compress
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDirWatcherReportsChanges(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "a"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	watcher, err := newDirWatcher(dir)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer watcher.Close()
+
+	next := func() string {
+		t.Helper()
+		select {
+		case path := <-watcher.Events():
+			return path
+		case err := <-watcher.Errors():
+			t.Fatalf("Expected no error, got %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for an event")
+		}
+		return ""
+	}
+	// waitFor skips events until one on path
+	waitFor := func(path string) {
+		t.Helper()
+		for next() != path {
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "a", "movie.mkv"), []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("a/movie.mkv")
+
+	// Directories created after the start are watched too
+	if err := os.MkdirAll(filepath.Join(dir, "b", "Movie"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("b")
+	time.Sleep(50 * time.Millisecond)
+	if err := os.WriteFile(filepath.Join(dir, "b", "Movie", "movie.mkv"), []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("b/Movie/movie.mkv")
+}
+
+func TestDirWatcherRescansOnOverflow(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "a"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	watcher, err := openDirWatcher(dir)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer watcher.file.Close()
+
+	// Created while the events were lost
+	if err := os.MkdirAll(filepath.Join(dir, "b", "Movie"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.handle(-1, syscall.IN_Q_OVERFLOW, ""); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if queued := watcher.take(); !reflect.DeepEqual(queued, []string{"a", "b"}) {
+		t.Errorf("Expected every entry of the root to be reported, got %v", queued)
+	}
+	watched := make(map[string]bool)
+	for _, name := range watcher.dirs {
+		watched[name] = true
+	}
+	if !watched["b/Movie"] {
+		t.Errorf("Expected the new directories to be watched, got %v", watcher.dirs)
+	}
+}
+
+func TestDirWatcherFollowsMovedDirectories(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	for _, name := range []string{"a/Movie/extras", "a/Other", "b"} {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	watcher, err := openDirWatcher(dir)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer watcher.file.Close()
+	watchOf := func(name string) int32 {
+		for wd, dir := range watcher.dirs {
+			if dir == name {
+				return wd
+			}
+		}
+		t.Fatalf("Expected %s to be watched, got %v", name, watcher.dirs)
+		return 0
+	}
+	watched := func() []string {
+		var names []string
+		for _, name := range watcher.dirs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	a, b := watchOf("a"), watchOf("b")
+
+	// Moved within the tree, with the events the kernel sends
+	if err := os.Rename(filepath.Join(dir, "a", "Movie"), filepath.Join(dir, "b", "Movie")); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.handle(a, syscall.IN_MOVED_FROM|syscall.IN_ISDIR, "Movie"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := watcher.handle(b, syscall.IN_MOVED_TO|syscall.IN_ISDIR, "Movie"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := []string{".", "a", "a/Other", "b", "b/Movie", "b/Movie/extras"}
+	if got := watched(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected the moved directories under their new path %v, got %v", expected, got)
+	}
+
+	// Moved out of the tree
+	if err := os.Rename(filepath.Join(dir, "a", "Other"), filepath.Join(outside, "Other")); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.handle(a, syscall.IN_MOVED_FROM|syscall.IN_ISDIR, "Other"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected = []string{".", "a", "b", "b/Movie", "b/Movie/extras"}
+	if got := watched(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected the directory moved out not to be watched, got %v", got)
+	}
+	if queued := watcher.take(); !reflect.DeepEqual(queued, []string{"a/Movie", "a/Other", "b/Movie"}) {
+		t.Errorf("Expected both ends of the moves to be reported, got %v", queued)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package compress
+
+import "fmt"
+
+// dirWatcher is only implemented with Linux inotify
+type dirWatcher struct{}
+
+func newDirWatcher(root string) (*dirWatcher, error) {
+	return nil, fmt.Errorf("watch mode requires inotify and is only supported on Linux")
+}
+
+func (w *dirWatcher) Events() <-chan string { return nil }
+
+func (w *dirWatcher) Errors() <-chan error { return nil }
+
+func (w *dirWatcher) Close() error { return nil }
@@ -2,96 +2,263 @@ package compress
 
 import (
 	"fmt"
-	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
+	"github.com/pedrosantosdev/radarr-sync-go/src/model"
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
 )
 
 // SyncAndCompress synchronizes compressed archives and compresses new files.
 //
 // Logic:
-// 1. Removes compressed files in target that are not in moviePaths
+// 1. Moves compressed files in target that are not in moviePaths to the trash
 // 2. Identifies files in moviePaths that need compression (don't exist or are outdated)
 // 3. Compresses identified files
+// 4. Uploads new archives to the server when opts.Uploader is set
+//
+// Each archived or failed movie is reported through opts.Report, if set,
+// and the progress of compression with its ETA through opts.Progress.
+// With opts.Window, movies are only compressed during that daily window.
+// With opts.Incremental, changed movies get a delta of their archive. With
+// opts.Dedup, movies are stored in the chunk store of the target instead and
+// the dedup ratio of the run is printed.
+// opts.Metadata identifies each movie inside its archive. With opts.Catalog,
+// archives are looked up in the catalog instead of the target (see Catalog).
+// Movies whose estimated archive does not fit in the free space of the
+// target or in opts.Quota are handled by opts.QuotaPolicy before phase 3.
+//
+// The target mirrors the relative layout of source: "a/b/movie.mkv" is
+// archived as "<target>/a/b/movie.mkv.tar.gz".
 //
 // Parameters:
-// - source: directory containing original files
-// - target: directory to save compressed files
-// - moviePaths: list of relative file paths to compress
+// - source: directory containing original files, used unless opts.Source is set
+// - target: directory to save compressed files, used unless opts.Target is set
+// - moviePaths: list of file paths relative to source to compress
+// - opts: storage, trash, delete threshold and upload settings, nil for defaults
 //
-// Returns error if any operation fails.
-func SyncAndCompress(source, target string, moviePaths []string) error {
-	if source == "" {
+// Archives trashed longer than the retention period are pruned on each run,
+// as are archives left staged by an interrupted run.
+// Uploads left unfinished by a previous run are resumed in phase 4.
+// Returns error if any operation fails or too many archives would be removed.
+func SyncAndCompress(source, target string, moviePaths []string, opts *SyncOptions) error {
+	if source == "" && (opts == nil || opts.Source == nil) {
 		return fmt.Errorf("source path cannot be empty")
 	}
-	if target == "" {
+	if target == "" && (opts == nil || opts.Target == nil) {
 		return fmt.Errorf("target path cannot be empty")
 	}
 	if len(moviePaths) == 0 {
 		return nil // Nothing to do
 	}
 
-	// Create map for O(1) lookup
+	// Create map for O(1) lookup, keyed by normalized relative path
 	movieSet := make(map[string]bool)
-	for _, path := range moviePaths {
-		movieSet[path] = true
+	movieKeys := make([]string, 0, len(moviePaths))
+	for _, moviePath := range moviePaths {
+		key, err := movieKey(moviePath)
+		if err != nil {
+			return err
+		}
+		movieSet[key] = true
+		movieKeys = append(movieKeys, key)
 	}
 
-	// Phase 1: Remove compressed files not in moviePaths
-	if err := cleanupObsoleteArchives(target, movieSet); err != nil {
+	options := opts.withDefaults(source, target)
+	if err := checkDedup(options); err != nil {
+		return err
+	}
+	store := options.Target
+	if options.Catalog != nil {
+		if err := options.Catalog.ensure(store, options.TrashDir); err != nil {
+			return fmt.Errorf("catalog failed: %w", err)
+		}
+	}
+
+	// Phase 1: Trash compressed files not in moviePaths
+	if err := cleanupObsoleteArchives(store, movieSet, options); err != nil {
 		return fmt.Errorf("cleanup phase failed: %w", err)
 	}
+	if _, err := PruneTrash(store, options.TrashDir, options.TrashRetention); err != nil {
+		return fmt.Errorf("prune phase failed: %w", err)
+	}
+	if err := sweepPartial(store); err != nil {
+		return fmt.Errorf("prune phase failed: %w", err)
+	}
+
+	// Phases 2 to 4: Compress new or changed movies and upload their archives
+	return compressMovies(options, movieKeys)
+}
+
+// compressMovies runs phases 2 to 4 of SyncAndCompress for moviePaths:
+// compresses those whose archive is missing or outdated and uploads new
+// archives when opts.Uploader is set. Other archives are left untouched.
+func compressMovies(options SyncOptions, moviePaths []string) error {
+	store := options.Target
+	dedup, err := openDedup(options)
+	if err != nil {
+		return err
+	}
 
 	// Phase 2: Identify files to compress
-	needsCompress, err := identifyFilesToCompress(source, target, moviePaths)
+	extension := archiveExtension(options)
+	needsCompress, err := identifyFilesToCompress(options.Source, store, options.Catalog, moviePaths, extension)
 	if err != nil {
 		return fmt.Errorf("diff phase failed: %w", err)
 	}
 
+	// Leave out movies whose archives would not fit in the target
+	stats, err := loadCompressionStats(store)
+	if err != nil {
+		return fmt.Errorf("space check failed: %w", err)
+	}
+	if needsCompress, err = checkSpace(options, needsCompress, stats, dedup); err != nil {
+		return fmt.Errorf("space check failed: %w", err)
+	}
+
+	var uploads *uploadQueue
+	if options.Uploader != nil {
+		if uploads, err = loadUploadQueue(options.Uploader, store); err != nil {
+			return fmt.Errorf("upload phase failed: %w", err)
+		}
+	}
+
 	// Phase 3: Compress files
-	if err := compressFiles(source, target, needsCompress); err != nil {
+	err = compressFiles(options, needsCompress, uploads, stats, dedup)
+	if len(needsCompress) > 0 {
+		// Record the ratio of the archives written, even if one failed
+		if err := stats.save(store); err != nil {
+			fmt.Printf("Failed to save %s: %v\n", StatsName, err)
+		}
+	}
+	if err != nil {
 		return fmt.Errorf("compression phase failed: %w", err)
 	}
 
+	// Phase 4: Upload new archives, including those left pending by an interrupted run
+	if uploads != nil {
+		if err := uploads.run(); err != nil {
+			return fmt.Errorf("upload phase failed: %w", err)
+		}
+	}
+
 	return nil
 }
 
-// cleanupObsoleteArchives removes compressed files in target that are not in movieSet.
-func cleanupObsoleteArchives(target string, movieSet map[string]bool) error {
-	compressedFiles, err := io_archive.FindWildcard(target, "*."+io_archive.Extension)
-	if err != nil {
-		return err
+// cleanupObsoleteArchives moves compressed files in target that are not in movieSet
+// to the trash. Nothing is moved if the share of obsolete archives exceeds the threshold.
+// With opts.Catalog, the archives are those of the catalog instead of a walk of target.
+func cleanupObsoleteArchives(target storage.Storage, movieSet map[string]bool, opts SyncOptions) error {
+	var archives []string
+	if opts.Catalog != nil {
+		for _, entry := range opts.Catalog.Entries() {
+			archives = append(archives, entry.Archive)
+		}
+	} else {
+		// Volume sets are listed once, as their archive name
+		var err error
+		if archives, err = io_archive.FindArchives(target, ""); err != nil {
+			return err
+		}
+		if opts.Dedup {
+			snapshots, err := io_archive.FindSnapshots(target, "")
+			if err != nil {
+				return err
+			}
+			archives = append(archives, snapshots...)
+		}
 	}
 
-	for _, compressedPath := range compressedFiles {
-		filename := filepath.Base(compressedPath)
-		// Remove extension to get original filename
-		movieName := strings.TrimSuffix(filename, "."+io_archive.Extension)
+	total := 0
+	var obsolete []string
+	for _, archive := range archives {
+		// Archives already in the trash are not part of the backup set
+		if isInTrash(archive, opts.TrashDir) {
+			continue
+		}
+		total++
+
+		if !movieSet[movieName(archive)] {
+			obsolete = append(obsolete, archive)
+		}
+	}
+
+	if err := checkDeleteThreshold(len(obsolete), total, opts.MaxDeletePercent); err != nil {
+		return err
+	}
 
-		if !movieSet[movieName] {
-			if err := os.Remove(compressedPath); err != nil {
-				return fmt.Errorf("failed to remove %s: %w", compressedPath, err)
+	for _, name := range obsolete {
+		if err := moveToTrash(target, opts.TrashDir, name); err != nil {
+			return err
+		}
+		if opts.Catalog != nil {
+			if err := opts.Catalog.remove(movieName(name)); err != nil {
+				return err
 			}
 		}
+		fmt.Printf("Trashed: %s\n", name)
 	}
 
 	return nil
 }
 
+// movieName returns the movie path an archive or snapshot name in the target belongs to
+func movieName(archive string) string {
+	if name, ok := strings.CutSuffix(archive, "."+io_archive.SnapshotExtension); ok {
+		return name
+	}
+	name, _ := io_archive.TrimExtension(archive)
+	return name
+}
+
+// archiveExtension returns the extension of the archives or snapshots
+// written with opts
+func archiveExtension(opts SyncOptions) string {
+	if opts.Dedup {
+		return io_archive.SnapshotExtension
+	}
+	return io_archive.ArchiveExtension(opts.Compress)
+}
+
+// movieKey normalizes a movie path to the slash-separated relative form used
+// as key in the target. Absolute paths and paths escaping the source are rejected.
+func movieKey(moviePath string) (string, error) {
+	cleaned := filepath.ToSlash(filepath.Clean(moviePath))
+	if filepath.IsAbs(moviePath) || strings.HasPrefix(cleaned, "/") ||
+		cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("invalid movie path %q: must be relative to source", moviePath)
+	}
+	return cleaned, nil
+}
+
 // identifyFilesToCompress returns list of files that need to be compressed.
 // A file needs compression if:
-// - Compressed file doesn't exist
-// - Original file is newer than compressed file
-func identifyFilesToCompress(source, target string, moviePaths []string) ([]string, error) {
+// - Compressed file with extension doesn't exist
+// - Original file is newer than compressed file, or than its chain if incremental
+//
+// With a catalog, archives are looked up in it instead of the target, and
+// a file also needs compression when it differs from the compressed version.
+func identifyFilesToCompress(source, target storage.Storage, catalog *Catalog, moviePaths []string,
+	extension string) ([]string, error) {
 	var needsCompress []string
 
 	for _, moviePath := range moviePaths {
-		filename := filepath.Base(moviePath)
+		if catalog != nil {
+			outdated, err := catalog.outdated(source, moviePath, moviePath+"."+extension)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check source file for %s: %w", moviePath, err)
+			}
+			if outdated {
+				needsCompress = append(needsCompress, moviePath)
+			}
+			continue
+		}
 
 		// Check if compressed file exists
-		compressedInfo, err := io_archive.GetFileInfo(target, filename, io_archive.Extension)
+		compressedInfo, err := io_archive.StatArchive(target, moviePath+"."+extension)
 		if err != nil {
 			return nil, fmt.Errorf("failed to check compressed file for %s: %w", moviePath, err)
 		}
@@ -103,7 +270,7 @@ func identifyFilesToCompress(source, target string, moviePaths []string) ([]stri
 		}
 
 		// Check if original file is newer
-		originalInfo, err := io_archive.GetFileInfo(source, filename, "")
+		originalInfo, err := source.Stat(moviePath)
 		if err != nil {
 			return nil, fmt.Errorf("failed to check source file for %s: %w", moviePath, err)
 		}
@@ -113,8 +280,18 @@ func identifyFilesToCompress(source, target string, moviePaths []string) ([]stri
 			continue
 		}
 
-		// If original is newer, needs recompression
-		if originalInfo.ModTime().After(compressedInfo.ModTime()) {
+		// An incremental archive is up to date as of its last delta, or of
+		// the last time its movie was found unchanged
+		compressedAt := compressedInfo.ModTime
+		if chainInfo, err := target.Stat(io_archive.ChainPath(moviePath + "." + extension)); err != nil {
+			return nil, fmt.Errorf("failed to check compressed file for %s: %w", moviePath, err)
+		} else if chainInfo != nil && chainInfo.ModTime.After(compressedAt) {
+			compressedAt = chainInfo.ModTime
+		}
+
+		// If original is newer, needs recompression. Remote targets report
+		// modification times in whole seconds, so compare at that precision.
+		if originalInfo.ModTime.Truncate(time.Second).After(compressedAt.Truncate(time.Second)) {
 			needsCompress = append(needsCompress, moviePath)
 		}
 	}
@@ -122,18 +299,229 @@ func identifyFilesToCompress(source, target string, moviePaths []string) ([]stri
 	return needsCompress, nil
 }
 
-// compressFiles compresses list of files from source to target.
-func compressFiles(source, target string, moviePaths []string) error {
-	for _, moviePath := range moviePaths {
-		fullPath := filepath.Join(source, moviePath)
+// compressFiles compresses list of files from opts.Source to opts.Target,
+// or stores them in the chunk store of dedup if not nil.
+// Each archive is queued in uploads, if not nil, as soon as it is stored,
+// added to stats unless a delta or snapshot, and its outcome passed to
+// opts.Report, if set. An archive of the movie in another format, e.g. unencrypted, is replaced and moved to the trash.
+func compressFiles(opts SyncOptions, moviePaths []string, uploads *uploadQueue, stats *compressionStats,
+	dedup *dedupRun) error {
+	extension := archiveExtension(opts)
+	codec := io_archive.Codec
+	switch extension {
+	case io_archive.EncryptedExtension:
+		codec = io_archive.EncryptedCodec
+	case io_archive.SnapshotExtension:
+		codec = io_archive.DedupCodec
+	}
+	var replacedExtensions []string
+	for _, other := range []string{io_archive.Extension, io_archive.EncryptedExtension, io_archive.SnapshotExtension} {
+		if other != extension {
+			replacedExtensions = append(replacedExtensions, other)
+		}
+	}
+	progress, err := newSyncProgress(opts, moviePaths)
+	if err != nil {
+		return err
+	}
+	defer dedup.report()
+
+	for index, moviePath := range moviePaths {
+		name := moviePath + "." + extension
+
+		if window := opts.Window; window != nil && !window.Contains(now()) {
+			if !window.Pause {
+				fmt.Printf("Compression window %s closed, %d movies left for the next run\n",
+					window, len(moviePaths)-index)
+				return nil
+			}
+			fmt.Printf("Waiting for compression window %s\n", window)
+			window.waitForWindow()
+		}
 
-		outputPath, err := io_archive.Compress(fullPath, target, nil)
+		archiveOpts := progress.archiveOptions(opts.Window.archiveOptions(opts.Compress), index)
+		archiveOpts = metadataOptions(archiveOpts, opts.Metadata, moviePath)
+		var sourceInfo *storage.File
+		if opts.Catalog != nil {
+			// Fingerprint the version about to be compressed, a change while
+			// compressing makes the next run compress it again
+			if sourceInfo, err = opts.Source.Stat(moviePath); err != nil {
+				return fmt.Errorf("failed to check source file for %s: %w", moviePath, err)
+			}
+		}
+		manifest, written, err := compressMovie(opts, dedup, moviePath, name, archiveOpts)
 		if err != nil {
+			sendReport(opts.Report, model.CompressReport{
+				Path:       moviePath,
+				Status:     model.CompressStatusFailed,
+				ArchivedAt: time.Now().UTC(),
+				Error:      err.Error(),
+			})
 			return fmt.Errorf("failed to compress %s: %w", moviePath, err)
 		}
 
-		fmt.Printf("Compressed: %s -> %s\n", moviePath, filepath.Base(outputPath))
+		if manifest == nil {
+			fmt.Printf("Unchanged: %s\n", moviePath)
+			if err := recordInCatalog(opts, moviePath, name, codec, nil, sourceInfo); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fmt.Printf("Compressed: %s -> %s\n", moviePath, written)
+		if _, _, delta := io_archive.SplitDelta(written); dedup == nil && !delta {
+			stats.add(manifest)
+		}
+		if manifest.Skipped > 0 {
+			fmt.Printf("  Skipped %d files (%d bytes)\n", manifest.Skipped, manifest.SkippedSize)
+		}
+		for _, warning := range manifest.Warnings {
+			fmt.Printf("  Warning: %s\n", warning)
+		}
+		var policies []model.CompressPolicyReport
+		for _, policy := range manifest.Policies {
+			fmt.Printf("  Policy %s (level %d): %d files, %d -> %d bytes, saved %d bytes\n", policy.Name,
+				policy.Level, policy.Files, policy.Size, policy.CompressedSize, policy.Saved())
+			policies = append(policies, model.CompressPolicyReport{
+				Policy:          policy.Name,
+				Level:           policy.Level,
+				Files:           policy.Files,
+				Bytes:           policy.Size,
+				CompressedBytes: policy.CompressedSize,
+				SavedBytes:      policy.Saved(),
+			})
+		}
+		report := model.CompressReport{
+			Path:         moviePath,
+			Status:       model.CompressStatusArchived,
+			Archive:      written,
+			Size:         manifest.Size,
+			SHA256:       manifest.SHA256,
+			Codec:        codec,
+			ArchivedAt:   manifest.CreatedAt,
+			SkippedFiles: manifest.Skipped,
+			SkippedBytes: manifest.SkippedSize,
+			Policies:     policies,
+		}
+		if dedup != nil {
+			for _, entry := range manifest.Entries {
+				report.DedupedBytes += entry.Size
+			}
+			report.DedupedBytes -= manifest.Size
+		}
+		sendReport(opts.Report, report)
+
+		for _, replacedExtension := range replacedExtensions {
+			replaced := moviePath + "." + replacedExtension
+			if info, err := io_archive.StatArchive(opts.Target, replaced); err != nil {
+				return err
+			} else if info != nil {
+				if err := moveToTrash(opts.Target, opts.TrashDir, replaced); err != nil {
+					return err
+				}
+				fmt.Printf("Trashed: %s (replaced by %s)\n", replaced, name)
+			}
+		}
+
+		if err := recordInCatalog(opts, moviePath, name, codec, manifest, sourceInfo); err != nil {
+			return err
+		}
+
+		if uploads != nil {
+			if err := uploads.add(written, manifest); err != nil {
+				return fmt.Errorf("failed to queue upload of %s: %w", written, err)
+			}
+		}
 	}
 
 	return nil
 }
+
+// compressMovie writes the archive name of moviePath or, with opts.Incremental,
+// a delta of it, and returns the manifest and name of the archive written.
+// With dedup, name is the snapshot of moviePath in its chunk store instead.
+// The manifest is nil when an incremental archive is already up to date.
+func compressMovie(opts SyncOptions, dedup *dedupRun, moviePath, name string,
+	archiveOpts *io_archive.CompressOptions) (*io_archive.Manifest, string, error) {
+	if dedup != nil {
+		manifest, err := dedup.put(opts, moviePath, name, archiveOpts)
+		return manifest, name, err
+	}
+	if !opts.Incremental {
+		manifest, err := io_archive.CompressInto(opts.Target, name, opts.Source, moviePath, archiveOpts)
+		return manifest, name, err
+	}
+	manifest, _, err := io_archive.CompressIncremental(opts.Target, name, opts.Source, moviePath, archiveOpts)
+	if err != nil || manifest == nil {
+		return manifest, name, err
+	}
+	return manifest, path.Join(path.Dir(name), manifest.Archive), nil
+}
+
+// recordInCatalog puts the archive name of moviePath, described by manifest,
+// in opts.Catalog, if set, with the fingerprint sourceInfo of the version
+// compressed. Incremental archives are described by their manifests and
+// chain instead, manifest being nil when they were up to date.
+func recordInCatalog(opts SyncOptions, moviePath, name, codec string, manifest *io_archive.Manifest,
+	sourceInfo *storage.File) error {
+	if opts.Catalog == nil || sourceInfo == nil {
+		return nil
+	}
+	var entry CatalogEntry
+	if opts.Incremental {
+		var err error
+		if entry, err = scanArchive(opts.Target, name); err != nil {
+			return fmt.Errorf("failed to record %s in the catalog: %w", name, err)
+		}
+	} else {
+		entry = CatalogEntry{
+			Movie:    moviePath,
+			Archive:  name,
+			Size:     manifest.Size,
+			SHA256:   manifest.SHA256,
+			Codec:    codec,
+			Volumes:  len(manifest.Volumes),
+			ModTime:  manifest.CreatedAt,
+			Metadata: manifest.Metadata,
+		}
+	}
+	entry.Source = fingerprint(sourceInfo)
+	if err := opts.Catalog.put(entry); err != nil {
+		return fmt.Errorf("failed to record %s in the catalog: %w", name, err)
+	}
+	return nil
+}
+
+// metadataOptions returns a copy of opts embedding the metadata lookup
+// returns for moviePath, which defaults its SourcePath to moviePath
+func metadataOptions(opts *io_archive.CompressOptions, lookup func(string) *io_archive.MovieMetadata,
+	moviePath string) *io_archive.CompressOptions {
+	if lookup == nil {
+		return opts
+	}
+	found := lookup(moviePath)
+	if found == nil {
+		return opts
+	}
+	metadata := *found
+	if metadata.SourcePath == "" {
+		metadata.SourcePath = moviePath
+	}
+	var result io_archive.CompressOptions
+	if opts != nil {
+		result = *opts
+	}
+	result.Metadata = &metadata
+	return &result
+}
+
+// sendReport passes r to report, if not nil. A failed report only
+// delays the server's view of the backup, so it is printed, not returned.
+func sendReport(report func(model.CompressReport) error, r model.CompressReport) {
+	if report == nil {
+		return
+	}
+	if err := report(r); err != nil {
+		fmt.Printf("Failed to report %s: %v\n", r.Path, err)
+	}
+}
\ No newline at end of file
@@ -1,9 +1,19 @@
 package compress
 
 import (
+	"crypto/rand"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
+	"github.com/pedrosantosdev/radarr-sync-go/src/model"
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+	"github.com/pedrosantosdev/radarr-sync-go/src/testutil"
 )
 
 // Tests for SyncAndCompress function
@@ -11,7 +21,7 @@ import (
 func TestSyncAndCompressValidateSourceEmpty(t *testing.T) {
 	targetDir := t.TempDir()
 
-	err := SyncAndCompress("", targetDir, []string{})
+	err := SyncAndCompress("", targetDir, []string{}, nil)
 
 	if err == nil {
 		t.Error("Expected error for empty source")
@@ -21,7 +31,7 @@ func TestSyncAndCompressValidateSourceEmpty(t *testing.T) {
 func TestSyncAndCompressValidateTargetEmpty(t *testing.T) {
 	sourceDir := t.TempDir()
 
-	err := SyncAndCompress(sourceDir, "", []string{})
+	err := SyncAndCompress(sourceDir, "", []string{}, nil)
 
 	if err == nil {
 		t.Error("Expected error for empty target")
@@ -32,7 +42,7 @@ func TestSyncAndCompressEmptyFileList(t *testing.T) {
 	sourceDir := t.TempDir()
 	targetDir := t.TempDir()
 
-	err := SyncAndCompress(sourceDir, targetDir, []string{})
+	err := SyncAndCompress(sourceDir, targetDir, []string{}, nil)
 
 	if err != nil {
 		t.Fatalf("Expected no error for empty file list, got %v", err)
@@ -42,7 +52,7 @@ func TestSyncAndCompressEmptyFileList(t *testing.T) {
 func TestSyncAndCompressSourceNotFound(t *testing.T) {
 	targetDir := t.TempDir()
 
-	err := SyncAndCompress("/non/existent/source", targetDir, []string{"test"})
+	err := SyncAndCompress("/non/existent/source", targetDir, []string{"test"}, nil)
 
 	if err == nil {
 		t.Error("Expected error for non-existent source")
@@ -58,9 +68,542 @@ func TestSyncAndCompressTargetNotFound(t *testing.T) {
 		t.Fatalf("Failed to create test file: %v", err)
 	}
 
-	err = SyncAndCompress(sourceDir, "/non/existent/target", []string{"test.txt"})
+	err = SyncAndCompress(sourceDir, "/non/existent/target", []string{"test.txt"}, nil)
 
 	if err == nil {
 		t.Error("Expected error for non-existent target")
 	}
 }
+
+func writeMovie(t *testing.T, sourceDir, relPath, content string) {
+	t.Helper()
+	fullPath := filepath.Join(sourceDir, filepath.FromSlash(relPath))
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
+		t.Fatalf("Failed to create movie directory: %v", err)
+	}
+	if err := os.WriteFile(fullPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create movie file: %v", err)
+	}
+}
+
+func TestSyncAndCompressMirrorsRelativeLayout(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	writeMovie(t, sourceDir, "a/movie.mkv", "first")
+	writeMovie(t, sourceDir, "b/movie.mkv", "second")
+
+	err := SyncAndCompress(sourceDir, targetDir, []string{"a/movie.mkv", "b/movie.mkv"}, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for _, dir := range []string{"a", "b"} {
+		archivePath := filepath.Join(targetDir, dir, "movie.mkv.tar.gz")
+		if _, err := os.Stat(archivePath); err != nil {
+			t.Errorf("Expected archive %s, got error: %v", archivePath, err)
+		}
+	}
+}
+
+func TestSyncAndCompressKeepsNestedArchives(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	writeMovie(t, sourceDir, "a/movie.mkv", "content")
+	moviePaths := []string{"a/movie.mkv"}
+
+	if err := SyncAndCompress(sourceDir, targetDir, moviePaths, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	archivePath := filepath.Join(targetDir, "a", "movie.mkv.tar.gz")
+	before, err := os.Stat(archivePath)
+	if err != nil {
+		t.Fatalf("Expected archive, got error: %v", err)
+	}
+
+	if err := SyncAndCompress(sourceDir, targetDir, moviePaths, nil); err != nil {
+		t.Fatalf("Expected no error on second run, got %v", err)
+	}
+	after, err := os.Stat(archivePath)
+	if err != nil {
+		t.Fatalf("Expected archive to survive second run, got error: %v", err)
+	}
+	if !after.ModTime().Equal(before.ModTime()) {
+		t.Error("Expected up-to-date archive not to be recompressed")
+	}
+}
+
+func TestSyncAndCompressRemovesObsoleteNestedArchive(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	writeMovie(t, sourceDir, "a/old.mkv", "old")
+	writeMovie(t, sourceDir, "b/new.mkv", "new")
+
+	opts := &SyncOptions{MaxDeletePercent: 100}
+
+	if err := SyncAndCompress(sourceDir, targetDir, []string{"a/old.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := SyncAndCompress(sourceDir, targetDir, []string{"b/new.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(targetDir, "a")); !os.IsNotExist(err) {
+		t.Error("Expected obsolete archive and its empty directory to be removed")
+	}
+	trashed, err := filepath.Glob(filepath.Join(targetDir, DefaultTrashDir, "*", "a", "old.mkv.tar.gz"))
+	if err != nil || len(trashed) != 1 {
+		t.Errorf("Expected obsolete archive in trash, got %v, %v", trashed, err)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "b", "new.mkv.tar.gz")); err != nil {
+		t.Errorf("Expected new archive, got error: %v", err)
+	}
+}
+
+func TestSyncAndCompressRejectsEscapingPath(t *testing.T) {
+	err := SyncAndCompress(t.TempDir(), t.TempDir(), []string{"../outside.mkv"}, nil)
+	if err == nil {
+		t.Error("Expected error for path escaping source")
+	}
+}
+
+func newTestS3Target(t *testing.T, server *testutil.S3Server) storage.Storage {
+	t.Helper()
+	target, err := storage.Open("s3://"+server.Bucket+"/movies", storage.Config{S3: storage.S3Config{
+		Endpoint:  server.URL,
+		AccessKey: "access",
+		SecretKey: "secret",
+	}})
+	if err != nil {
+		t.Fatalf("Failed to open target: %v", err)
+	}
+	return target
+}
+
+func TestSyncAndCompressToS3(t *testing.T) {
+	server := testutil.NewS3Server("backups")
+	defer server.Close()
+	target := newTestS3Target(t, server)
+
+	sourceDir := t.TempDir()
+	writeMovie(t, sourceDir, "a/movie.mkv", "content")
+	writeMovie(t, sourceDir, "b/other.mkv", "other")
+
+	opts := &SyncOptions{Target: target, MaxDeletePercent: 100}
+	if err := SyncAndCompress(sourceDir, "", []string{"a/movie.mkv", "b/other.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for _, key := range []string{"movies/a/movie.mkv.tar.gz", "movies/a/movie.mkv.tar.gz.sha256.json"} {
+		if server.Object(key) == nil {
+			t.Errorf("Expected object %s, got keys %v", key, server.Keys())
+		}
+	}
+
+	if err := SyncAndCompress(sourceDir, "", []string{"a/movie.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if server.Object("movies/b/other.mkv.tar.gz") != nil {
+		t.Error("Expected obsolete archive to be moved")
+	}
+
+	trashed := 0
+	for _, key := range server.Keys() {
+		if strings.HasPrefix(key, "movies/"+DefaultTrashDir+"/") && strings.HasSuffix(key, "b/other.mkv.tar.gz") {
+			trashed++
+		}
+	}
+	if trashed != 1 {
+		t.Errorf("Expected obsolete archive in trash, got keys %v", server.Keys())
+	}
+}
+
+func newTestSFTPTarget(t *testing.T) (*storage.SFTP, string) {
+	t.Helper()
+	server, err := testutil.NewSFTPServer(t.TempDir(), "user", "secret")
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	t.Cleanup(server.Close)
+
+	root := t.TempDir()
+	target, err := storage.Open("sftp://user@"+server.Addr+root, storage.Config{SFTP: storage.SFTPConfig{
+		Password:       "secret",
+		KnownHostsFile: server.KnownHosts,
+	}})
+	if err != nil {
+		t.Fatalf("Failed to open target: %v", err)
+	}
+	sftpTarget := target.(*storage.SFTP)
+	t.Cleanup(func() { sftpTarget.Close() })
+	return sftpTarget, root
+}
+
+func TestSyncAndCompressToSFTP(t *testing.T) {
+	target, root := newTestSFTPTarget(t)
+
+	sourceDir := t.TempDir()
+	writeMovie(t, sourceDir, "a/movie.mkv", "content")
+	writeMovie(t, sourceDir, "b/other.mkv", "other")
+
+	opts := &SyncOptions{Target: target, MaxDeletePercent: 100}
+	if err := SyncAndCompress(sourceDir, "", []string{"a/movie.mkv", "b/other.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, name := range []string{"a/movie.mkv.tar.gz", "a/movie.mkv.tar.gz.sha256.json", "b/other.mkv.tar.gz"} {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Errorf("Expected %s on server, got %v", name, err)
+		}
+	}
+
+	if err := SyncAndCompress(sourceDir, "", []string{"a/movie.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "b")); !os.IsNotExist(err) {
+		t.Error("Expected empty directory of obsolete archive to be removed")
+	}
+	matches, _ := filepath.Glob(filepath.Join(root, DefaultTrashDir, "*", "b", "other.mkv.tar.gz"))
+	if len(matches) != 1 {
+		t.Errorf("Expected obsolete archive in trash, got %v", matches)
+	}
+}
+
+func newTestWebDAVTarget(t *testing.T) (storage.Storage, *testutil.WebDAVServer) {
+	t.Helper()
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "movies"), 0o755); err != nil {
+		t.Fatalf("Failed to create collection: %v", err)
+	}
+	server := testutil.NewWebDAVServer(root, "user", "secret")
+	t.Cleanup(server.Close)
+
+	location := "webdav://user@" + strings.TrimPrefix(server.URL, "http://") + "/movies"
+	target, err := storage.Open(location, storage.Config{WebDAV: storage.WebDAVConfig{Password: "secret"}})
+	if err != nil {
+		t.Fatalf("Failed to open target: %v", err)
+	}
+	return target, server
+}
+
+func TestSyncAndCompressToWebDAV(t *testing.T) {
+	target, server := newTestWebDAVTarget(t)
+
+	sourceDir := t.TempDir()
+	writeMovie(t, sourceDir, "a/movie.mkv", "content")
+	writeMovie(t, sourceDir, "b/other.mkv", "other")
+
+	opts := &SyncOptions{Target: target, MaxDeletePercent: 100}
+	if err := SyncAndCompress(sourceDir, "", []string{"a/movie.mkv", "b/other.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, name := range []string{"a/movie.mkv.tar.gz", "a/movie.mkv.tar.gz.sha256.json", "b/other.mkv.tar.gz"} {
+		if _, err := os.Stat(filepath.Join(server.Root, "movies", filepath.FromSlash(name))); err != nil {
+			t.Errorf("Expected %s on server, got %v", name, err)
+		}
+	}
+
+	// Unchanged archives are not uploaded again
+	puts := server.Requests("PUT")
+	if err := SyncAndCompress(sourceDir, "", []string{"a/movie.mkv", "b/other.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if server.Requests("PUT") != puts {
+		t.Errorf("Expected no uploads for unchanged movies, got %d", server.Requests("PUT")-puts)
+	}
+
+	if err := SyncAndCompress(sourceDir, "", []string{"a/movie.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(server.Root, "movies", DefaultTrashDir, "*", "b", "other.mkv.tar.gz"))
+	if len(matches) != 1 {
+		t.Errorf("Expected obsolete archive in trash, got %v", matches)
+	}
+}
+
+func TestSyncAndCompressInMemory(t *testing.T) {
+	source := storage.NewMemory()
+	source.WriteFile("a/movie.mkv", []byte("content"), time.Now().Add(-time.Hour))
+	target := storage.NewMemory()
+
+	opts := &SyncOptions{Source: source, Target: target}
+	if err := SyncAndCompress("", "", []string{"a/movie.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	result, err := io_archive.VerifyIn(target, "a/movie.mkv.tar.gz")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !result.OK() {
+		t.Errorf("Expected valid archive, got %s: %v", result.Status, result.Problems)
+	}
+}
+
+func TestSyncAndCompressReportsResults(t *testing.T) {
+	source := storage.NewMemory()
+	source.WriteFile("a/movie.mkv", []byte("content"), time.Now().Add(-time.Hour))
+	target := storage.NewMemory()
+
+	var reports []model.CompressReport
+	opts := &SyncOptions{Source: source, Target: target, Report: func(report model.CompressReport) error {
+		reports = append(reports, report)
+		return fmt.Errorf("server unavailable")
+	}}
+	// A failing report does not fail the run
+	if err := SyncAndCompress("", "", []string{"a/movie.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("Expected 1 report, got %d", len(reports))
+	}
+	manifest, _ := io_archive.ReadManifestIn(target, "a/movie.mkv.tar.gz")
+	report := reports[0]
+	if report.Status != model.CompressStatusArchived || report.Path != "a/movie.mkv" ||
+		report.Archive != "a/movie.mkv.tar.gz" || report.Codec != io_archive.Codec {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if report.Size != manifest.Size || report.SHA256 != manifest.SHA256 || report.ArchivedAt.IsZero() {
+		t.Errorf("Expected archive size and checksum in report, got %+v", report)
+	}
+
+	reports = nil
+	err := SyncAndCompress("", "", []string{"a/movie.mkv", "missing.mkv"}, opts)
+	if err == nil {
+		t.Fatal("Expected error for missing movie")
+	}
+	if len(reports) != 1 || reports[0].Status != model.CompressStatusFailed ||
+		reports[0].Path != "missing.mkv" || reports[0].Error == "" {
+		t.Errorf("Expected failure report for missing movie, got %+v", reports)
+	}
+}
+
+func TestSyncAndCompressEncrypted(t *testing.T) {
+	identity, recipient, _ := io_archive.GenerateIdentity()
+	source := storage.NewMemory()
+	source.WriteFile("a/movie.mkv", []byte("content"), time.Now().Add(-time.Hour))
+	source.WriteFile("b/other.mkv", []byte("other"), time.Now().Add(-time.Hour))
+	target := storage.NewMemory()
+
+	// A plain archive from before encryption was enabled is replaced
+	movies := []string{"a/movie.mkv", "b/other.mkv"}
+	if err := SyncAndCompress("", "", movies, &SyncOptions{Source: source, Target: target}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var reports []model.CompressReport
+	opts := &SyncOptions{
+		Source:   source,
+		Target:   target,
+		Compress: &io_archive.CompressOptions{Encryption: &io_archive.Encryption{Recipients: []string{recipient}}},
+		Report: func(report model.CompressReport) error {
+			reports = append(reports, report)
+			return nil
+		},
+	}
+	if err := SyncAndCompress("", "", movies, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, movie := range movies {
+		if info, _ := target.Stat(movie + ".tar.gz.enc"); info == nil {
+			t.Errorf("Expected encrypted archive for %s", movie)
+		}
+		if info, _ := target.Stat(movie + ".tar.gz"); info != nil {
+			t.Errorf("Expected plain archive of %s to be trashed", movie)
+		}
+	}
+	if len(reports) != 2 || reports[0].Codec != io_archive.EncryptedCodec {
+		t.Errorf("Expected encrypted codec in reports, got %+v", reports)
+	}
+
+	dest := t.TempDir()
+	_, err := io_archive.ExtractFrom(target, "a/movie.mkv.tar.gz.enc", dest,
+		&io_archive.ExtractOptions{Decryption: &io_archive.Decryption{Identities: []string{identity}}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Encrypted archives of movies no longer listed are trashed
+	opts.MaxDeletePercent = 100
+	if err := SyncAndCompress("", "", []string{"a/movie.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if info, _ := target.Stat("b/other.mkv.tar.gz.enc"); info != nil {
+		t.Error("Expected obsolete encrypted archive to be trashed")
+	}
+}
+
+func TestSyncAndCompressVolumes(t *testing.T) {
+	content := make([]byte, 5000)
+	rand.Read(content)
+	source := storage.NewMemory()
+	source.WriteFile("a/movie.mkv", content, time.Now().Add(-time.Hour))
+	source.WriteFile("b/other.mkv", []byte("other"), time.Now().Add(-time.Hour))
+	target := storage.NewMemory()
+
+	var reports []model.CompressReport
+	opts := &SyncOptions{
+		Source:   source,
+		Target:   target,
+		Compress: &io_archive.CompressOptions{VolumeSize: 512},
+		Report: func(report model.CompressReport) error {
+			reports = append(reports, report)
+			return nil
+		},
+	}
+	movies := []string{"a/movie.mkv", "b/other.mkv"}
+	if err := SyncAndCompress("", "", movies, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	files, _ := io_archive.ArchiveFiles(target, "a/movie.mkv.tar.gz")
+	if len(files) < 2 {
+		t.Fatalf("Expected several volumes, got %v", files)
+	}
+
+	// An unchanged volume set is not compressed again
+	reports = nil
+	if err := SyncAndCompress("", "", movies, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(reports) != 0 {
+		t.Errorf("Expected no recompression, got %+v", reports)
+	}
+
+	// The volume set of an obsolete movie is trashed as one archive
+	opts.MaxDeletePercent = 50
+	if err := SyncAndCompress("", "", []string{"b/other.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if files, _ := io_archive.ArchiveFiles(target, "a/movie.mkv.tar.gz"); len(files) != 0 {
+		t.Errorf("Expected all volumes to be trashed, got %v", files)
+	}
+	trashed, _ := io_archive.FindArchives(target, DefaultTrashDir)
+	if len(trashed) != 1 || !strings.HasSuffix(trashed[0], "/a/movie.mkv.tar.gz") {
+		t.Errorf("Expected trashed volume set, got %v", trashed)
+	}
+	if info, _ := target.Stat("a/movie.mkv.tar.gz.sha256.json"); info != nil {
+		t.Error("Expected manifest to be trashed")
+	}
+}
+
+func TestSyncAndCompressReportsSkippedFiles(t *testing.T) {
+	source := storage.NewMemory()
+	modTime := time.Now().Add(-time.Hour)
+	source.WriteFile("a/Movie/movie.mkv", []byte("content"), modTime)
+	source.WriteFile("a/Movie/movie.mkv.part", []byte("partial"), modTime)
+	source.WriteFile("a/Movie/sample/sample.mkv", []byte("sample"), modTime)
+	target := storage.NewMemory()
+
+	var reports []model.CompressReport
+	opts := &SyncOptions{
+		Source:   source,
+		Target:   target,
+		Compress: &io_archive.CompressOptions{Exclude: []string{"*.part", "**/sample/**"}},
+		Report: func(report model.CompressReport) error {
+			reports = append(reports, report)
+			return nil
+		},
+	}
+	if err := SyncAndCompress("", "", []string{"a/Movie"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("Expected 1 report, got %d", len(reports))
+	}
+	if reports[0].SkippedFiles != 2 || reports[0].SkippedBytes != int64(len("partial")+len("sample")) {
+		t.Errorf("Expected 2 skipped files in report, got %+v", reports[0])
+	}
+}
+
+func TestSyncAndCompressReportsProgress(t *testing.T) {
+	source := storage.NewMemory()
+	source.WriteFile("a/movie.mkv", []byte("content"), time.Now().Add(-time.Hour))
+	source.WriteFile("b/Other/other.mkv", []byte("other content"), time.Now().Add(-time.Hour))
+	target := storage.NewMemory()
+
+	var reports []SyncProgress
+	opts := &SyncOptions{Source: source, Target: target, Progress: func(progress SyncProgress) {
+		reports = append(reports, progress)
+	}}
+	if err := SyncAndCompress("", "", []string{"a/movie.mkv", "b/Other"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var finished []string
+	for _, progress := range reports {
+		if progress.Total != 20 || progress.Movies != 2 {
+			t.Errorf("Expected 2 movies of 20 bytes, got %+v", progress)
+		}
+		if progress.Archive.Done {
+			finished = append(finished, progress.Movie)
+		}
+	}
+	if len(finished) != 2 || finished[0] != "a/movie.mkv" || finished[1] != "b/Other" {
+		t.Errorf("Expected a final report per movie, got %v", finished)
+	}
+	last := reports[len(reports)-1]
+	if last.Index != 2 || last.Done != last.Total || last.ETA != 0 {
+		t.Errorf("Expected complete last report, got %+v", last)
+	}
+}
+
+func TestSyncAndCompressReportsPolicies(t *testing.T) {
+	source := storage.NewMemory()
+	modTime := time.Now().Add(-time.Hour)
+	source.WriteFile("a/Movie/movie.mkv", []byte("video"), modTime)
+	source.WriteFile("a/Movie/movie.srt", []byte(strings.Repeat("subtitle\n", 100)), modTime)
+	target := storage.NewMemory()
+
+	var reports []model.CompressReport
+	opts := &SyncOptions{
+		Source:   source,
+		Target:   target,
+		Compress: &io_archive.CompressOptions{Policies: io_archive.DefaultPolicies()},
+		Report: func(report model.CompressReport) error {
+			reports = append(reports, report)
+			return nil
+		},
+	}
+	if err := SyncAndCompress("", "", []string{"a/Movie"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(reports) != 1 || len(reports[0].Policies) != 2 {
+		t.Fatalf("Expected 2 policies in report, got %+v", reports)
+	}
+	text := reports[0].Policies[1]
+	if text.Policy != "text" || text.Files != 1 || text.Bytes != 900 ||
+		text.SavedBytes != text.Bytes-text.CompressedBytes || text.SavedBytes <= 0 {
+		t.Errorf("Expected bytes saved on subtitles, got %+v", text)
+	}
+}
+
+func TestSyncAndCompressEmbedsMetadata(t *testing.T) {
+	source := storage.NewMemory()
+	source.WriteFile("a/movie.mkv", []byte("content"), time.Now().Add(-time.Hour))
+	source.WriteFile("b/unknown.mkv", []byte("other"), time.Now().Add(-time.Hour))
+	target := storage.NewMemory()
+
+	opts := &SyncOptions{
+		Source: source,
+		Target: target,
+		Metadata: func(moviePath string) *io_archive.MovieMetadata {
+			if moviePath != "a/movie.mkv" {
+				return nil
+			}
+			return &io_archive.MovieMetadata{TmdbID: 603, Title: "The Matrix", Year: 1999}
+		},
+	}
+	if err := SyncAndCompress("", "", []string{"a/movie.mkv", "b/unknown.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	metadata, err := io_archive.ReadMetadata(target, "a/movie.mkv.tar.gz", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := &io_archive.MovieMetadata{TmdbID: 603, Title: "The Matrix", Year: 1999, SourcePath: "a/movie.mkv"}
+	if !reflect.DeepEqual(metadata, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, metadata)
+	}
+	if metadata, err := io_archive.ReadMetadata(target, "b/unknown.mkv.tar.gz", nil); err != nil || metadata != nil {
+		t.Errorf("Expected no metadata for an unknown movie, got %+v, %v", metadata, err)
+	}
+}
@@ -0,0 +1,90 @@
+package compress
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
+	"github.com/pedrosantosdev/radarr-sync-go/src/model"
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// SyncOptions configures SyncAndCompress
+type SyncOptions struct {
+	// Source holds the movies (default local directory given as source)
+	Source storage.Storage
+	// Target stores the archives (default local directory given as target)
+	Target storage.Storage
+	// Compress configures new archives, e.g. their encryption (default gzip level 7)
+	Compress *io_archive.CompressOptions
+	// TrashDir receives obsolete archives instead of deleting them,
+	// relative to the target root (default .trash)
+	TrashDir string
+	// TrashRetention is how long trashed archives are kept (default 30 days)
+	TrashRetention time.Duration
+	// MaxDeletePercent aborts the run when more than this percentage of the
+	// archives in target would be trashed (default 25, 100 disables the check)
+	MaxDeletePercent float64
+	// Uploader, if set, uploads each new archive to the server
+	Uploader *Uploader
+	// Report, if set, is called after each movie is archived or fails to be.
+	// A report error is printed and does not stop the run.
+	Report func(report model.CompressReport) error
+	// Progress, if set, is called as movies are compressed with the
+	// progress of the current archive and of the whole run
+	Progress func(progress SyncProgress)
+	// Window, if set, limits compression to a daily time range
+	Window *Window
+	// Quota, if positive, is the most bytes the target may hold, trash included
+	Quota int64
+	// QuotaPolicy applies to movies whose estimated archive does not fit in
+	// the free space of the target or in Quota (default QuotaAbort)
+	QuotaPolicy QuotaPolicy
+	// Metadata, if set, returns what is known of the movie at a path relative
+	// to the source, embedded in its archive. nil embeds nothing.
+	Metadata func(moviePath string) *io_archive.MovieMetadata
+	// Catalog, if set, indexes the archives of the target, kept up to date
+	// as archives are written and trashed
+	Catalog *Catalog
+	// Incremental stores changes to movie directories already archived as
+	// delta archives of their base instead of compressing them again
+	// (see io_archive.CompressIncremental and Consolidate)
+	Incremental bool
+	// Dedup stores movies as snapshots in the chunk store of the target,
+	// sharing identical content between movies, instead of as archives
+	// (see io_archive.ChunkStore). Chunks are compressed as Compress selects
+	// for their file. Encryption, uploads, incremental archives and the
+	// catalog do not apply to snapshots.
+	Dedup bool
+	// ChunkSizes are the chunk sizes of a new chunk store, nil for
+	// io_archive.DefaultChunkSizes. An existing store keeps its own.
+	ChunkSizes *io_archive.ChunkSizes
+}
+
+// withDefaults returns a copy of opts with unset fields filled for source and target
+func (opts *SyncOptions) withDefaults(source, target string) SyncOptions {
+	var result SyncOptions
+	if opts != nil {
+		result = *opts
+	}
+	if result.Source == nil {
+		result.Source = storage.NewLocal(source)
+	}
+	if result.Target == nil {
+		result.Target = storage.NewLocal(target)
+	}
+	result.TrashDir = strings.Trim(result.TrashDir, "/")
+	if result.TrashDir == "" {
+		result.TrashDir = DefaultTrashDir
+	}
+	if result.TrashRetention <= 0 {
+		result.TrashRetention = DefaultTrashRetention
+	}
+	if result.MaxDeletePercent <= 0 {
+		result.MaxDeletePercent = DefaultMaxDeletePercent
+	}
+	if result.QuotaPolicy == "" {
+		result.QuotaPolicy = QuotaAbort
+	}
+	return result
+}
@@ -0,0 +1,114 @@
+package compress
+
+import (
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// SyncProgress reports how far the compression phase of SyncAndCompress is
+type SyncProgress struct {
+	// Movie is the movie being compressed, the Index-th (from 1) of Movies
+	Movie  string
+	Index  int
+	Movies int
+	// Archive is the progress of the archive of Movie, Archive.Done is
+	// set once it is complete
+	Archive io_archive.Progress
+	// Done is the content compressed so far across all movies, of Total
+	// bytes. The size of a movie is estimated from the source until its
+	// archive starts.
+	Done  int64
+	Total int64
+	// Elapsed is the time since compression started and ETA the estimated
+	// time left, 0 until enough is compressed to estimate it
+	Elapsed time.Duration
+	ETA     time.Duration
+}
+
+// syncProgress combines the archive progress of each movie into SyncProgress
+type syncProgress struct {
+	report  func(SyncProgress)
+	movies  []string
+	sizes   []int64
+	done    int64
+	started time.Time
+}
+
+// newSyncProgress estimates the size of moviePaths in source.
+// Returns nil if opts.Progress is not set.
+func newSyncProgress(opts SyncOptions, moviePaths []string) (*syncProgress, error) {
+	if opts.Progress == nil {
+		return nil, nil
+	}
+	sizes := make([]int64, len(moviePaths))
+	for i, moviePath := range moviePaths {
+		size, err := contentSize(opts.Source, moviePath)
+		if err != nil {
+			return nil, err
+		}
+		sizes[i] = size
+	}
+	return &syncProgress{report: opts.Progress, movies: moviePaths, sizes: sizes, started: time.Now()}, nil
+}
+
+// archiveOptions returns a copy of opts reporting the progress of movie index
+func (p *syncProgress) archiveOptions(opts *io_archive.CompressOptions, index int) *io_archive.CompressOptions {
+	if p == nil {
+		return opts
+	}
+	var result io_archive.CompressOptions
+	if opts != nil {
+		result = *opts
+	}
+	result.Progress = func(archive io_archive.Progress) {
+		p.update(index, archive)
+	}
+	return &result
+}
+
+func (p *syncProgress) update(index int, archive io_archive.Progress) {
+	p.sizes[index] = archive.Total
+	progress := SyncProgress{
+		Movie:   p.movies[index],
+		Index:   index + 1,
+		Movies:  len(p.movies),
+		Archive: archive,
+		Done:    p.done + archive.Read,
+		Elapsed: time.Since(p.started),
+	}
+	for _, size := range p.sizes {
+		progress.Total += size
+	}
+	if progress.Done > 0 && progress.Total > progress.Done {
+		remaining := float64(progress.Total-progress.Done) / float64(progress.Done)
+		progress.ETA = time.Duration(float64(progress.Elapsed) * remaining)
+	}
+	if archive.Done {
+		p.done += archive.Total
+	}
+	p.report(progress)
+}
+
+// contentSize returns the size of the regular files of name in source
+func contentSize(source storage.Storage, name string) (int64, error) {
+	info, err := source.Stat(name)
+	if err != nil || info == nil {
+		return 0, err
+	}
+	if !info.IsDir {
+		return info.Size, nil
+	}
+	files, err := source.List(name)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, file := range files {
+		if file.Mode.IsRegular() {
+			size += file.Size
+		}
+	}
+	return size, nil
+}
@@ -0,0 +1,62 @@
+package compress
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// yearSuffix matches the year Radarr appends to movie folder names, e.g. " (2017)"
+var yearSuffix = regexp.MustCompile(` \(\d{4}\)$`)
+
+// FindMovieArchive returns the archive in target named after title, ignoring
+// case and a trailing " (year)", or else the single archive whose name
+// contains title. Plain and encrypted archives, volume sets and snapshots are
+// all considered, those trashed into trashDir (default .trash) are not.
+func FindMovieArchive(target storage.Storage, title, trashDir string) (string, error) {
+	trashDir = strings.Trim(trashDir, "/")
+	if trashDir == "" {
+		trashDir = DefaultTrashDir
+	}
+	archives, err := io_archive.FindArchives(target, "")
+	if err != nil {
+		return "", err
+	}
+	snapshots, err := io_archive.FindSnapshots(target, "")
+	if err != nil {
+		return "", err
+	}
+
+	needle := strings.ToLower(title)
+	var matches, exact []string
+	for _, archive := range append(archives, snapshots...) {
+		if isInTrash(archive, trashDir) {
+			continue
+		}
+		name, _ := io_archive.TrimExtension(path.Base(archive))
+		name = strings.ToLower(strings.TrimSuffix(name, "."+io_archive.SnapshotExtension))
+		if strings.Contains(name, needle) {
+			matches = append(matches, archive)
+		}
+		if name == needle || yearSuffix.ReplaceAllString(name, "") == needle {
+			exact = append(exact, archive)
+		}
+	}
+	if len(exact) == 1 {
+		return exact[0], nil
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no archive found for %q", title)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("%d archives match %q, select one with -archive: %s",
+			len(matches), title, strings.Join(matches, ", "))
+	}
+}
@@ -0,0 +1,34 @@
+package compress
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+func TestFindMovieArchiveSkipsTrash(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	writeMovie(t, sourceDir, "a/The Matrix (1999)/movie.mkv", "video")
+	writeMovie(t, sourceDir, "a/The Matrix Reloaded (2003)/movie.mkv", "video")
+	if err := SyncAndCompress(sourceDir, targetDir, []string{"a/The Matrix (1999)", "a/The Matrix Reloaded (2003)"},
+		nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// The previous archive of the movie, trashed when it was archived again
+	writeMovie(t, targetDir, DefaultTrashDir+"/20240102T030405Z/a/The Matrix (1999).tar.gz", "previous")
+
+	target := storage.NewLocal(targetDir)
+	archive, err := FindMovieArchive(target, "the matrix", "")
+	if err != nil || archive != "a/The Matrix (1999).tar.gz" {
+		t.Errorf("Expected the live archive, got %q, %v", archive, err)
+	}
+	if _, err := FindMovieArchive(target, "matrix re", ""); err != nil {
+		t.Errorf("Expected a single partial match, got %v", err)
+	}
+	if _, err := FindMovieArchive(target, "matrix", ".other-trash"); err == nil ||
+		!strings.Contains(err.Error(), "3 archives match") {
+		t.Errorf("Expected the trashed copy to match outside the trash dir, got %v", err)
+	}
+}
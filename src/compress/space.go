@@ -0,0 +1,253 @@
+package compress
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
+	"github.com/pedrosantosdev/radarr-sync-go/src/model"
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// StatsName is the file in the target recording how much previous archives
+// compressed, to estimate the size of new ones
+const StatsName = ".compression-stats.json"
+
+// DefaultCompressionRatio is the archive to source size ratio assumed
+// without history: movies are mostly compressed already
+const DefaultCompressionRatio = 1.0
+
+// estimateMargin covers tar headers and the spread of compression ratios
+const estimateMargin = 1.05
+
+// QuotaPolicy selects what happens to movies whose archive would not fit in
+// the free space of the target or in SyncOptions.Quota
+type QuotaPolicy string
+
+const (
+	// QuotaAbort fails the run before any archive is written
+	QuotaAbort QuotaPolicy = "abort"
+	// QuotaSkip leaves movies that do not fit for a later run
+	QuotaSkip QuotaPolicy = "skip"
+	// QuotaPrune removes the oldest trashed archives to make room,
+	// then skips movies that still do not fit
+	QuotaPrune QuotaPolicy = "prune"
+)
+
+// ParseQuotaPolicy returns the policy named by value: abort, skip or prune
+func ParseQuotaPolicy(value string) (QuotaPolicy, error) {
+	switch policy := QuotaPolicy(value); policy {
+	case QuotaAbort, QuotaSkip, QuotaPrune:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid quota policy %q: must be abort, skip or prune", value)
+	}
+}
+
+// compressionStats sums the sizes of the movies compressed so far and of their archives
+type compressionStats struct {
+	SourceBytes  int64 `json:"sourceBytes"`
+	ArchiveBytes int64 `json:"archiveBytes"`
+}
+
+// loadCompressionStats reads the stats recorded in target, empty if there are none
+func loadCompressionStats(target storage.Storage) (*compressionStats, error) {
+	stats := &compressionStats{}
+	info, err := target.Stat(StatsName)
+	if err != nil || info == nil {
+		return stats, err
+	}
+	data, err := storage.ReadFile(target, StatsName)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, stats); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", StatsName, err)
+	}
+	return stats, nil
+}
+
+// add records an archive of the files of manifest. Only full archives are
+// recorded: deltas and snapshots store part of the content of their movie,
+// so their sizes do not tell how much movies compress.
+func (s *compressionStats) add(manifest *io_archive.Manifest) {
+	for _, entry := range manifest.Entries {
+		s.SourceBytes += entry.Size
+	}
+	s.ArchiveBytes += manifest.Size
+}
+
+// save writes the stats to target
+func (s *compressionStats) save(target storage.Storage) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return storage.WriteFile(target, StatsName, data)
+}
+
+// ratio returns the archive to source size ratio of previous archives
+func (s *compressionStats) ratio() float64 {
+	if s.SourceBytes <= 0 || s.ArchiveBytes <= 0 {
+		return DefaultCompressionRatio
+	}
+	return float64(s.ArchiveBytes) / float64(s.SourceBytes)
+}
+
+// estimate returns the expected archive size of size bytes of movie files
+func (s *compressionStats) estimate(size int64) int64 {
+	return int64(math.Ceil(float64(size) * s.ratio() * estimateMargin))
+}
+
+// checkSpace returns the movies of moviePaths whose estimated archives fit
+// in the target, applying opts.QuotaPolicy to the others. The space is the
+// free space of a target implementing storage.SpaceReporter, further limited
+// by opts.Quota. Archives being replaced are not deducted, as both versions
+// exist while the new one is written. Incremental archives and snapshots in
+// dedup are estimated from the content they would add, see writtenSize.
+func checkSpace(opts SyncOptions, moviePaths []string, stats *compressionStats, dedup *dedupRun) ([]string, error) {
+	available, limited, err := availableSpace(opts)
+	if err != nil || !limited {
+		return moviePaths, err
+	}
+
+	fits := make([]string, 0, len(moviePaths))
+	for _, moviePath := range moviePaths {
+		size, err := writtenSize(opts, dedup, moviePath)
+		if err != nil {
+			return nil, err
+		}
+		estimate := stats.estimate(size)
+		if estimate > available && opts.QuotaPolicy == QuotaPrune {
+			freed, err := pruneOldestTrash(opts.Target, opts.TrashDir, estimate-available)
+			if err != nil {
+				return nil, fmt.Errorf("failed to prune the trash: %w", err)
+			}
+			available += freed
+		}
+		if estimate <= available {
+			available -= estimate
+			fits = append(fits, moviePath)
+			continue
+		}
+
+		if opts.QuotaPolicy != QuotaSkip && opts.QuotaPolicy != QuotaPrune {
+			return nil, fmt.Errorf("not enough space in target for %s: needs about %d bytes, %d available",
+				moviePath, estimate, available)
+		}
+		fmt.Printf("Skipped: %s needs about %d bytes, %d available\n", moviePath, estimate, available)
+		sendReport(opts.Report, model.CompressReport{
+			Path:       moviePath,
+			Status:     model.CompressStatusSkipped,
+			ArchivedAt: time.Now().UTC(),
+			Error:      fmt.Sprintf("not enough space: needs about %d bytes, %d available", estimate, available),
+		})
+	}
+	return fits, nil
+}
+
+// writtenSize returns the content size of moviePath the next archive of it
+// holds: for incremental archives the files added or changed since the last
+// archive of the chain, in dedup the files not unchanged since its previous
+// snapshot, otherwise every file
+func writtenSize(opts SyncOptions, dedup *dedupRun, moviePath string) (int64, error) {
+	name := moviePath + "." + archiveExtension(opts)
+	switch {
+	case dedup != nil:
+		return dedup.chunks.PutSize(name, opts.Source, moviePath, opts.Compress)
+	case opts.Incremental:
+		return io_archive.IncrementalSize(opts.Target, name, opts.Source, moviePath, opts.Compress)
+	default:
+		return contentSize(opts.Source, moviePath)
+	}
+}
+
+// availableSpace returns the bytes new archives may take in opts.Target,
+// and false if neither its free space nor a quota limit them
+func availableSpace(opts SyncOptions) (int64, bool, error) {
+	available := int64(math.MaxInt64)
+	limited := false
+	if reporter, ok := opts.Target.(storage.SpaceReporter); ok {
+		free, err := reporter.FreeSpace()
+		switch {
+		case errors.Is(err, errors.ErrUnsupported):
+		case err != nil:
+			return 0, false, err
+		default:
+			available, limited = free, true
+		}
+	}
+
+	if opts.Quota > 0 {
+		files, err := opts.Target.List("")
+		if err != nil {
+			return 0, false, err
+		}
+		var used int64
+		for _, file := range storage.Files(files) {
+			used += file.Size
+		}
+		available, limited = min(available, max(opts.Quota-used, 0)), true
+	}
+	return available, limited, nil
+}
+
+// pruneOldestTrash permanently removes trashed archives, oldest first, until
+// at least needed bytes are freed or the trash is empty. An archive is
+// removed with all its volumes, its manifest and its deltas, a snapshot
+// with the chunks no other snapshot references. Returns the bytes freed.
+func pruneOldestTrash(target storage.Storage, trashDir string, needed int64) (int64, error) {
+	files, err := target.List("")
+	if err != nil {
+		return 0, err
+	}
+
+	type trashedArchive struct {
+		name    string
+		trashed time.Time
+		files   []storage.File
+	}
+	archives := make(map[string]*trashedArchive)
+	for _, file := range storage.Files(files) {
+		if !isInTrash(file.Name, trashDir) {
+			continue
+		}
+		name := archiveOf(file.Name)
+		if archives[name] == nil {
+			archives[name] = &trashedArchive{name: name, trashed: trashedAt(file, trashDir)}
+		}
+		archives[name].files = append(archives[name].files, file)
+	}
+
+	oldest := make([]*trashedArchive, 0, len(archives))
+	for _, archive := range archives {
+		oldest = append(oldest, archive)
+	}
+	sort.Slice(oldest, func(i, j int) bool {
+		if !oldest[i].trashed.Equal(oldest[j].trashed) {
+			return oldest[i].trashed.Before(oldest[j].trashed)
+		}
+		return oldest[i].name < oldest[j].name
+	})
+
+	var freed int64
+	var chunks *io_archive.ChunkStore
+	for _, archive := range oldest {
+		if freed >= needed {
+			break
+		}
+		for _, file := range archive.files {
+			chunksFreed, err := removeTrashed(target, file.Name, &chunks)
+			if err != nil {
+				return freed, err
+			}
+			freed += file.Size + chunksFreed
+		}
+		fmt.Printf("Pruned: %s\n", archive.name)
+	}
+	return freed, nil
+}
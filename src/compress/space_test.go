@@ -0,0 +1,220 @@
+package compress
+
+import (
+	"bytes"
+	"crypto/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
+	"github.com/pedrosantosdev/radarr-sync-go/src/model"
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// spaceStore is a memory store reporting a fixed free space
+type spaceStore struct {
+	*storage.Memory
+	free int64
+}
+
+func (s *spaceStore) FreeSpace() (int64, error) {
+	return s.free, nil
+}
+
+func TestParseQuotaPolicy(t *testing.T) {
+	for _, value := range []string{"abort", "skip", "prune"} {
+		if policy, err := ParseQuotaPolicy(value); err != nil || string(policy) != value {
+			t.Errorf("ParseQuotaPolicy(%q) = %q, %v", value, policy, err)
+		}
+	}
+	if _, err := ParseQuotaPolicy("delete"); err == nil {
+		t.Error("Expected error for unknown policy")
+	}
+}
+
+func TestCompressionStatsRatio(t *testing.T) {
+	stats := &compressionStats{}
+	if stats.ratio() != DefaultCompressionRatio {
+		t.Errorf("Expected default ratio without history, got %v", stats.ratio())
+	}
+
+	stats.add(&io_archive.Manifest{Size: 500, Entries: []io_archive.ManifestEntry{{Size: 600}, {Size: 400}}})
+	if stats.ratio() != 0.5 {
+		t.Errorf("Expected ratio 0.5, got %v", stats.ratio())
+	}
+	if estimate := stats.estimate(1000); estimate != 525 {
+		t.Errorf("Expected estimate with margin of 525, got %d", estimate)
+	}
+
+	target := storage.NewMemory()
+	if err := stats.save(target); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	loaded, err := loadCompressionStats(target)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if *loaded != *stats {
+		t.Errorf("Expected %+v, got %+v", stats, loaded)
+	}
+}
+
+func TestSyncAndCompressChecksFreeSpace(t *testing.T) {
+	modTime := time.Now().Add(-time.Hour)
+	source := storage.NewMemory()
+	source.WriteFile("a/movie.mkv", bytes.Repeat([]byte("a"), 1000), modTime)
+	target := &spaceStore{Memory: storage.NewMemory(), free: 1000}
+
+	err := SyncAndCompress("", "", []string{"a/movie.mkv"}, &SyncOptions{Source: source, Target: target})
+	if err == nil || !strings.Contains(err.Error(), "not enough space") {
+		t.Fatalf("Expected not enough space error, got %v", err)
+	}
+	if info, _ := target.Stat("a/movie.mkv.tar.gz"); info != nil {
+		t.Error("Expected no archive to be written")
+	}
+
+	// A history of well compressed movies makes the archive fit
+	stats := &compressionStats{SourceBytes: 10000, ArchiveBytes: 100}
+	if err := stats.save(target); err != nil {
+		t.Fatal(err)
+	}
+	if err := SyncAndCompress("", "", []string{"a/movie.mkv"}, &SyncOptions{Source: source, Target: target}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	loaded, err := loadCompressionStats(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.SourceBytes != 11000 || loaded.ArchiveBytes <= 100 {
+		t.Errorf("Expected the new archive in the stats, got %+v", loaded)
+	}
+}
+
+func TestSyncAndCompressQuotaSkip(t *testing.T) {
+	modTime := time.Now().Add(-time.Hour)
+	source := storage.NewMemory()
+	source.WriteFile("a/large.mkv", bytes.Repeat([]byte("a"), 3000), modTime)
+	source.WriteFile("b/small.mkv", bytes.Repeat([]byte("b"), 1000), modTime)
+	target := storage.NewMemory()
+
+	var reports []model.CompressReport
+	opts := &SyncOptions{
+		Source:      source,
+		Target:      target,
+		Quota:       2000,
+		QuotaPolicy: QuotaSkip,
+		Report: func(report model.CompressReport) error {
+			reports = append(reports, report)
+			return nil
+		},
+	}
+	if err := SyncAndCompress("", "", []string{"a/large.mkv", "b/small.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if info, _ := target.Stat("a/large.mkv.tar.gz"); info != nil {
+		t.Error("Expected the movie over the quota to be skipped")
+	}
+	if info, _ := target.Stat("b/small.mkv.tar.gz"); info == nil {
+		t.Error("Expected the movie within the quota to be archived")
+	}
+	var statuses []string
+	for _, report := range reports {
+		statuses = append(statuses, report.Path+":"+report.Status)
+	}
+	expected := []string{"a/large.mkv:" + model.CompressStatusSkipped, "b/small.mkv:" + model.CompressStatusArchived}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("Expected reports %v, got %v", expected, statuses)
+	}
+}
+
+func TestSyncAndCompressQuotaPrunesOldestTrash(t *testing.T) {
+	modTime := time.Now().Add(-time.Hour)
+	source := storage.NewMemory()
+	source.WriteFile("a/movie.mkv", bytes.Repeat([]byte("a"), 1000), modTime)
+	target := storage.NewMemory()
+	oldest := ".trash/20200101T000000Z/old/movie.mkv.tar.gz"
+	newer := ".trash/20240101T000000Z/other/movie.mkv.tar.gz"
+	target.WriteFile(oldest, bytes.Repeat([]byte("o"), 2000), modTime)
+	target.WriteFile(io_archive.ManifestPath(oldest), []byte("{}"), modTime)
+	target.WriteFile(newer, bytes.Repeat([]byte("n"), 2000), modTime)
+
+	opts := &SyncOptions{
+		Source:         source,
+		Target:         target,
+		Quota:          4050,
+		QuotaPolicy:    QuotaPrune,
+		TrashRetention: 100 * 365 * 24 * time.Hour,
+	}
+	if err := SyncAndCompress("", "", []string{"a/movie.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if info, _ := target.Stat("a/movie.mkv.tar.gz"); info == nil {
+		t.Error("Expected the movie to be archived after pruning")
+	}
+	for _, name := range []string{oldest, io_archive.ManifestPath(oldest)} {
+		if info, _ := target.Stat(name); info != nil {
+			t.Errorf("Expected %s to be pruned", name)
+		}
+	}
+	if info, _ := target.Stat(newer); info == nil {
+		t.Error("Expected the newer trashed archive to be kept")
+	}
+}
+
+func TestCheckSpaceEstimatesChangedContent(t *testing.T) {
+	modTime := time.Now().Add(-time.Hour)
+	video := make([]byte, 3000)
+	rand.Read(video)
+
+	for _, mode := range []string{"incremental", "dedup"} {
+		source := storage.NewMemory()
+		source.WriteFile("a/movie/movie.mkv", video, modTime)
+		target := &spaceStore{Memory: storage.NewMemory(), free: 1 << 30}
+		opts := &SyncOptions{Source: source, Target: target, Incremental: mode == "incremental",
+			Dedup: mode == "dedup", ChunkSizes: &testChunkSizes}
+		if err := SyncAndCompress("", "", []string{"a/movie"}, opts); err != nil {
+			t.Fatalf("%s: expected no error, got %v", mode, err)
+		}
+		if stats, _ := loadCompressionStats(target); mode == "dedup" && stats.SourceBytes != 0 {
+			t.Errorf("Expected snapshots to be left out of the stats, got %+v", stats)
+		}
+
+		// Only the new subtitle is written by the next run
+		source.WriteFile("a/movie/movie.en.srt", bytes.Repeat([]byte("s"), 100), modTime.Add(time.Minute))
+		target.free = 1000
+		options := opts.withDefaults("", "")
+		dedup, err := openDedup(options)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", mode, err)
+		}
+		fits, err := checkSpace(options, []string{"a/movie"}, &compressionStats{}, dedup)
+		if err != nil || len(fits) != 1 {
+			t.Errorf("%s: expected the subtitle to fit, got %v, %v", mode, fits, err)
+		}
+	}
+}
+
+func TestSyncAndCompressLeavesDeltasOutOfStats(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	writeMovie(t, sourceDir, "a/movie/movie.mkv", strings.Repeat("video ", 200))
+	incrementalSync(t, sourceDir, targetDir, nil)
+	target := storage.NewLocal(targetDir)
+	base, err := loadCompressionStats(target)
+	if err != nil || base.SourceBytes != 1200 {
+		t.Fatalf("Expected the base archive in the stats, got %+v, %v", base, err)
+	}
+
+	addSubtitle(t, sourceDir, "a/movie", "movie.en.srt", 1)
+	incrementalSync(t, sourceDir, targetDir, nil)
+	if chain, _ := io_archive.ReadChain(target, "a/movie.tar.gz"); chain == nil || len(chain.Deltas) != 1 {
+		t.Fatalf("Expected a delta to be written, got %+v", chain)
+	}
+	if loaded, err := loadCompressionStats(target); err != nil || *loaded != *base {
+		t.Errorf("Expected the delta to be left out of the stats %+v, got %+v, %v", base, loaded, err)
+	}
+}
@@ -0,0 +1,204 @@
+package compress
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// DefaultTrashDir is the trash directory created inside target
+const DefaultTrashDir = ".trash"
+
+// DefaultTrashRetention is how long trashed archives are kept by default
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// DefaultMaxDeletePercent is the default share of archives a single run may remove
+const DefaultMaxDeletePercent = 25.0
+
+// trashStampLayout names the per-run trash directory, recording when its
+// archives were trashed independently of the storage modification times
+const trashStampLayout = "20060102T150405Z"
+
+// checkDeleteThreshold fails when trashing obsolete of total archives exceeds maxPercent
+func checkDeleteThreshold(obsolete, total int, maxPercent float64) error {
+	if total == 0 || obsolete == 0 {
+		return nil
+	}
+	percent := float64(obsolete) * 100 / float64(total)
+	if percent > maxPercent {
+		return fmt.Errorf("refusing to remove %d of %d archives (%.0f%% > %.0f%%), "+
+			"check the server list or raise the delete threshold", obsolete, total, percent, maxPercent)
+	}
+	return nil
+}
+
+// isInTrash reports whether name is stored under trashDir
+func isInTrash(name, trashDir string) bool {
+	return strings.HasPrefix(name, trashDir+"/")
+}
+
+// moveToTrash moves an archive, or all its volumes, and its manifest into a
+// trash directory named after the current time, keeping its relative path:
+// "a/movie.mkv.tar.gz" -> ".trash/20240102T030405Z/a/movie.mkv.tar.gz".
+// The chain and deltas of an incremental archive go with it.
+func moveToTrash(target storage.Storage, trashDir, name string) error {
+	trashDir = trashDir + "/" + time.Now().UTC().Format(trashStampLayout) + "/"
+	trashName := trashDir + name
+
+	files, err := io_archive.ArchiveFiles(target, name)
+	if err != nil {
+		return err
+	}
+	chainFiles, err := io_archive.ChainFiles(target, name)
+	if err != nil {
+		return err
+	}
+	for _, file := range append(files, chainFiles...) {
+		if err := target.Rename(file, trashDir+file); err != nil {
+			return err
+		}
+	}
+
+	manifestName := name + "." + io_archive.ManifestExtension
+	manifest, err := target.Stat(manifestName)
+	if err != nil {
+		return err
+	}
+	if manifest != nil {
+		return target.Rename(manifestName, trashName+"."+io_archive.ManifestExtension)
+	}
+	return nil
+}
+
+// trashedAt returns when a file under trashDir was trashed, from its trash
+// directory name, falling back to its modification time.
+func trashedAt(file storage.File, trashDir string) time.Time {
+	stamp, _, _ := strings.Cut(strings.TrimPrefix(file.Name, trashDir+"/"), "/")
+	if trashed, err := time.Parse(trashStampLayout, stamp); err == nil {
+		return trashed
+	}
+	return file.ModTime
+}
+
+// PruneTrash permanently removes files trashed longer than retention ago from
+// trashDir in target. A retention of zero empties the trash. Removing a
+// snapshot releases its chunks (see io_archive.ChunkStore), and the chunk
+// store of target, if any, is swept of chunks no snapshot references.
+// Returns the number of archives removed.
+func PruneTrash(target storage.Storage, trashDir string, retention time.Duration) (int, error) {
+	trashDir = strings.Trim(trashDir, "/")
+	if trashDir == "" {
+		return 0, fmt.Errorf("trash path cannot be empty")
+	}
+
+	files, err := target.List("")
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-retention)
+	removed := 0
+	var chunks *io_archive.ChunkStore
+	for _, file := range files {
+		if file.IsDir || !isInTrash(file.Name, trashDir) {
+			continue
+		}
+		if retention > 0 && trashedAt(file, trashDir).After(cutoff) {
+			continue
+		}
+
+		if _, err := removeTrashed(target, file.Name, &chunks); err != nil {
+			return removed, err
+		}
+		if isArchiveFile(file.Name) {
+			removed++
+		}
+	}
+	return removed, sweepChunks(target, chunks)
+}
+
+// sweepChunks removes the chunks no snapshot references from the chunk
+// store of target, chunks if already open, when target has one
+func sweepChunks(target storage.Storage, chunks *io_archive.ChunkStore) error {
+	if chunks == nil {
+		exists, err := io_archive.HasChunkStore(target)
+		if err != nil || !exists {
+			return err
+		}
+		if chunks, err = io_archive.OpenChunkStore(target, nil); err != nil {
+			return err
+		}
+	}
+	freed, err := chunks.Sweep()
+	if err != nil {
+		return fmt.Errorf("failed to sweep chunks: %w", err)
+	}
+	if freed > 0 {
+		fmt.Printf("Swept: %d bytes of unreferenced chunks\n", freed)
+	}
+	return nil
+}
+
+// sweepPartial removes the archives an interrupted run left staged in target
+func sweepPartial(target storage.Storage) error {
+	removed, err := io_archive.SweepPartial(target, "")
+	if err != nil {
+		return fmt.Errorf("failed to sweep interrupted archives: %w", err)
+	}
+	if removed > 0 {
+		fmt.Printf("Swept: %d interrupted archives\n", removed)
+	}
+	return nil
+}
+
+// removeTrashed removes the trashed file name from target and returns the
+// bytes of chunks freed. A snapshot releases its chunks in the chunk store
+// of target, opened into chunks on first use.
+func removeTrashed(target storage.Storage, name string, chunks **io_archive.ChunkStore) (int64, error) {
+	if !io_archive.IsSnapshot(name) {
+		return 0, target.Remove(name)
+	}
+	if *chunks == nil {
+		store, err := io_archive.OpenChunkStore(target, nil)
+		if err != nil {
+			return 0, err
+		}
+		*chunks = store
+	}
+	return (*chunks).Remove(name)
+}
+
+// isArchiveFile reports whether the stored file name is an archive, its
+// first volume or a snapshot. Deltas are counted with their base.
+func isArchiveFile(name string) bool {
+	if io_archive.IsSnapshot(name) {
+		return true
+	}
+	if archive, index, ok := io_archive.SplitVolume(name); ok {
+		if index != 1 {
+			return false
+		}
+		name = archive
+	}
+	_, isArchive := io_archive.TrimExtension(name)
+	_, _, isDelta := io_archive.SplitDelta(name)
+	return isArchive && !isDelta
+}
+
+// archiveOf returns the archive the stored file name belongs to: the
+// archive itself, one of its volumes, its manifest, or the chain or a delta
+// of an incremental archive
+func archiveOf(name string) string {
+	name = strings.TrimSuffix(name, "."+io_archive.ManifestExtension)
+	name = strings.TrimSuffix(name, "."+io_archive.ChainExtension)
+	if archive, _, ok := io_archive.SplitVolume(name); ok {
+		name = archive
+	}
+	if base, _, ok := io_archive.SplitDelta(name); ok {
+		name = base
+	}
+	return name
+}
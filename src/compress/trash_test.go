@@ -0,0 +1,149 @@
+package compress
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+func TestSyncAndCompressAbortsAboveDeleteThreshold(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	writeMovie(t, sourceDir, "one.mkv", "1")
+	writeMovie(t, sourceDir, "two.mkv", "2")
+	writeMovie(t, sourceDir, "three.mkv", "3")
+
+	all := []string{"one.mkv", "two.mkv", "three.mkv"}
+	if err := SyncAndCompress(sourceDir, targetDir, all, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// A truncated server list would trash two of three archives
+	err := SyncAndCompress(sourceDir, targetDir, []string{"one.mkv"}, &SyncOptions{MaxDeletePercent: 50})
+	if err == nil {
+		t.Fatal("Expected error above delete threshold")
+	}
+
+	for _, name := range all {
+		if _, err := os.Stat(filepath.Join(targetDir, name+".tar.gz")); err != nil {
+			t.Errorf("Expected %s archive to be kept, got error: %v", name, err)
+		}
+	}
+}
+
+func TestCheckDeleteThreshold(t *testing.T) {
+	if err := checkDeleteThreshold(0, 0, 25); err != nil {
+		t.Errorf("Expected no error for empty target, got %v", err)
+	}
+	if err := checkDeleteThreshold(1, 4, 25); err != nil {
+		t.Errorf("Expected no error at threshold, got %v", err)
+	}
+	if err := checkDeleteThreshold(2, 4, 25); err == nil {
+		t.Error("Expected error above threshold")
+	}
+}
+
+func TestSyncOptionsDefaults(t *testing.T) {
+	var opts *SyncOptions
+	result := opts.withDefaults("/movies", "/backups")
+
+	if result.TrashDir != DefaultTrashDir {
+		t.Errorf("Expected default trash dir, got '%s'", result.TrashDir)
+	}
+	if result.TrashRetention != DefaultTrashRetention {
+		t.Errorf("Expected default retention, got %v", result.TrashRetention)
+	}
+	if result.MaxDeletePercent != DefaultMaxDeletePercent {
+		t.Errorf("Expected default threshold, got %f", result.MaxDeletePercent)
+	}
+	if result.Source.String() != "/movies" {
+		t.Errorf("Expected local source '/movies', got '%s'", result.Source)
+	}
+	if result.Target.String() != "/backups" {
+		t.Errorf("Expected local target '/backups', got '%s'", result.Target)
+	}
+}
+
+func writeTrashed(t *testing.T, targetDir string, trashed time.Time, name string) {
+	t.Helper()
+	writeMovie(t, targetDir, DefaultTrashDir+"/"+trashed.UTC().Format(trashStampLayout)+"/"+name, "archive")
+}
+
+func TestPruneTrashRetention(t *testing.T) {
+	targetDir := t.TempDir()
+	target := storage.NewLocal(targetDir)
+	writeTrashed(t, targetDir, time.Now().Add(-48*time.Hour), "old.mkv.tar.gz")
+	writeTrashed(t, targetDir, time.Now(), "new.mkv.tar.gz")
+
+	removed, err := PruneTrash(target, DefaultTrashDir, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 archive removed, got %d", removed)
+	}
+	recent, _ := filepath.Glob(filepath.Join(targetDir, DefaultTrashDir, "*", "new.mkv.tar.gz"))
+	if len(recent) != 1 {
+		t.Error("Expected recent archive to be kept")
+	}
+
+	removed, err = PruneTrash(target, DefaultTrashDir, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected trash to be emptied, got %d removed", removed)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, DefaultTrashDir)); !os.IsNotExist(err) {
+		t.Error("Expected empty trash directories to be removed")
+	}
+}
+
+func TestPruneTrashEmptyTrash(t *testing.T) {
+	removed, err := PruneTrash(storage.NewLocal(t.TempDir()), DefaultTrashDir, 0)
+	if err != nil {
+		t.Fatalf("Expected no error for missing trash, got %v", err)
+	}
+	if removed != 0 {
+		t.Errorf("Expected 0 removed, got %d", removed)
+	}
+}
+
+func TestTrashedAtFallsBackToModTime(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	file := storage.File{Name: DefaultTrashDir + "/movie.mkv.tar.gz", ModTime: modTime}
+
+	if got := trashedAt(file, DefaultTrashDir); !got.Equal(modTime) {
+		t.Errorf("Expected %v, got %v", modTime, got)
+	}
+
+	file.Name = DefaultTrashDir + "/20200101T000000Z/movie.mkv.tar.gz"
+	expected := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if got := trashedAt(file, DefaultTrashDir); !got.Equal(expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestSyncAndCompressSweepsStagedArchives(t *testing.T) {
+	source := storage.NewMemory()
+	source.WriteFile("a/movie.mkv", []byte("content"), time.Now().Add(-time.Hour))
+	target := storage.NewMemory()
+	// Left behind by a run that crashed while writing another movie
+	target.WriteFile("b/gone.mkv.tar.gz.partial", []byte("partial"), time.Now())
+	target.WriteFile("b/gone.mkv.tar.gz.partial.sha256.json", []byte("{}"), time.Now())
+
+	opts := &SyncOptions{Source: source, Target: target}
+	if err := SyncAndCompress("", "", []string{"a/movie.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	files, _ := target.List("")
+	for _, file := range files {
+		if strings.HasPrefix(file.Name, "b/") {
+			t.Errorf("Expected staged files to be swept, found %s", file.Name)
+		}
+	}
+}
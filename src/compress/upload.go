@@ -0,0 +1,225 @@
+package compress
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/client"
+	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// UploadStateName is the file in the target recording archives whose upload
+// to the server has not finished, so interrupted uploads resume on the next run
+const UploadStateName = ".uploads.json"
+
+// Uploader sends new archives to the media server with resumable chunked uploads
+type Uploader struct {
+	// Token authenticates against the server, as returned by client.Login
+	Token string
+	// ChunkSize is the size of each upload request (default client.DefaultUploadChunkSize)
+	ChunkSize int64
+}
+
+// uploadEntry is an archive waiting to be uploaded
+type uploadEntry struct {
+	// URL of the upload on the server, empty until it was created
+	URL    string `json:"url,omitempty"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Error is why the last attempt failed, kept until the upload succeeds
+	Error string `json:"error,omitempty"`
+}
+
+// uploadQueue tracks pending uploads, persisted in the target after every change
+type uploadQueue struct {
+	uploader *Uploader
+	target   storage.Storage
+	pending  map[string]uploadEntry
+}
+
+// loadUploadQueue reads the pending uploads recorded in target
+func loadUploadQueue(uploader *Uploader, target storage.Storage) (*uploadQueue, error) {
+	queue := &uploadQueue{uploader: uploader, target: target, pending: make(map[string]uploadEntry)}
+
+	info, err := target.Stat(UploadStateName)
+	if err != nil || info == nil {
+		return queue, err
+	}
+	data, err := storage.ReadFile(target, UploadStateName)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &queue.pending); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", UploadStateName, err)
+	}
+	return queue, nil
+}
+
+func (q *uploadQueue) save() error {
+	if len(q.pending) == 0 {
+		info, err := q.target.Stat(UploadStateName)
+		if err != nil || info == nil {
+			return err
+		}
+		return q.target.Remove(UploadStateName)
+	}
+
+	data, err := json.MarshalIndent(q.pending, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", UploadStateName, err)
+	}
+	return storage.WriteFile(q.target, UploadStateName, data)
+}
+
+// add queues the archive name described by manifest. An unfinished upload
+// of a previous version of it is terminated, one of the same content is
+// resumed.
+func (q *uploadQueue) add(name string, manifest *io_archive.Manifest) error {
+	entry := uploadEntry{Size: manifest.Size, SHA256: manifest.SHA256}
+	if previous, ok := q.pending[name]; ok {
+		if previous.SHA256 == entry.SHA256 && previous.Size == entry.Size {
+			entry.URL = previous.URL
+		} else {
+			q.terminate(name, previous)
+		}
+	}
+	q.pending[name] = entry
+	return q.save()
+}
+
+// terminate discards the server upload of entry, if created, so the bytes
+// of a version of name that will not be completed do not hold space on the
+// server until it expires them. Failures are only reported.
+func (q *uploadQueue) terminate(name string, entry uploadEntry) {
+	if entry.URL == "" {
+		return
+	}
+	err := client.TerminateUpload(q.uploader.Token, entry.URL)
+	if err != nil && !errors.Is(err, client.ErrUploadNotFound) {
+		fmt.Printf("Failed to terminate previous upload of %s: %v\n", name, err)
+	}
+}
+
+// run uploads every pending archive in name order, resuming uploads
+// the server already holds part of. An archive that fails stays pending
+// with its error recorded and the others are still uploaded; the failures
+// are returned together.
+func (q *uploadQueue) run() error {
+	names := make([]string, 0, len(q.pending))
+	for name := range q.pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var failures []error
+	for _, name := range names {
+		uploaded, err := q.upload(name)
+		if err != nil {
+			err = fmt.Errorf("failed to upload %s: %w", name, err)
+			fmt.Printf("Failed upload: %v\n", err)
+			failures = append(failures, err)
+
+			entry := q.pending[name]
+			entry.Error = err.Error()
+			q.pending[name] = entry
+			if err := q.save(); err != nil {
+				return errors.Join(append(failures, err)...)
+			}
+			continue
+		}
+		delete(q.pending, name)
+		if err := q.save(); err != nil {
+			return err
+		}
+		if uploaded {
+			fmt.Printf("Uploaded: %s\n", name)
+		} else {
+			fmt.Printf("Skipped upload: %s no longer exists\n", name)
+		}
+	}
+	return errors.Join(failures...)
+}
+
+// upload sends one pending archive, creating the server upload if needed.
+// Returns false if the archive no longer exists in the target.
+func (q *uploadQueue) upload(name string) (bool, error) {
+	entry := q.pending[name]
+	token := q.uploader.Token
+
+	// The archive may have been replaced or trashed since it was queued
+	manifest, err := io_archive.ReadManifestIn(q.target, name)
+	if err != nil {
+		return false, err
+	}
+	if manifest == nil {
+		q.terminate(name, entry)
+		return false, nil
+	}
+	if manifest.SHA256 != entry.SHA256 || manifest.Size != entry.Size {
+		q.terminate(name, entry)
+		entry = uploadEntry{Size: manifest.Size, SHA256: manifest.SHA256}
+	}
+	entry.Error = ""
+
+	var offset int64
+	if entry.URL != "" {
+		offset, err = client.UploadOffset(token, entry.URL)
+		if errors.Is(err, client.ErrUploadNotFound) {
+			entry.URL = ""
+		} else if err != nil {
+			return false, err
+		}
+	}
+	if entry.URL == "" {
+		offset = 0
+		entry.URL, err = client.CreateUpload(token, entry.Size, map[string]string{
+			"filename": path.Base(name),
+			"name":     name,
+			"movie":    movieName(name),
+			"sha256":   entry.SHA256,
+		})
+		if err != nil {
+			return false, err
+		}
+	} else if offset > 0 {
+		fmt.Printf("Resuming upload of %s at %d of %d bytes\n", name, offset, entry.Size)
+	}
+	q.pending[name] = entry
+	if err := q.save(); err != nil {
+		return false, err
+	}
+
+	if offset >= entry.Size {
+		return true, nil
+	}
+
+	reader, err := io_archive.OpenArchive(q.target, name)
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+	if err := skipTo(reader, offset); err != nil {
+		return false, fmt.Errorf("failed to seek to offset %d: %w", offset, err)
+	}
+
+	err = client.UploadFrom(token, entry.URL, reader, offset, entry.Size, q.uploader.ChunkSize, nil)
+	return err == nil, err
+}
+
+// skipTo advances r by offset bytes, seeking when r supports it
+func skipTo(r io.Reader, offset int64) error {
+	if offset == 0 {
+		return nil
+	}
+	if seeker, ok := r.(io.Seeker); ok {
+		_, err := seeker.Seek(offset, io.SeekStart)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, r, offset)
+	return err
+}
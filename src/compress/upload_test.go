@@ -0,0 +1,170 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/client"
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+	"github.com/pedrosantosdev/radarr-sync-go/src/testutil"
+)
+
+func newTestUploadServer(t *testing.T) *testutil.TusServer {
+	t.Helper()
+	server := testutil.NewTusServer("token")
+	t.Cleanup(server.Close)
+	client.SetServerUri(server.URL)
+	return server
+}
+
+func TestSyncAndCompressUploadsArchives(t *testing.T) {
+	server := newTestUploadServer(t)
+	source := storage.NewMemory()
+	source.WriteFile("a/movie.mkv", []byte("content"), time.Now().Add(-time.Hour))
+	target := storage.NewMemory()
+
+	opts := &SyncOptions{Source: source, Target: target, Uploader: &Uploader{Token: "token"}}
+	if err := SyncAndCompress("", "", []string{"a/movie.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	uploads := server.Uploads()
+	if len(uploads) != 1 || !uploads[0].Complete() {
+		t.Fatalf("Expected one complete upload, got %v", uploads)
+	}
+	archive, _ := storage.ReadFile(target, "a/movie.mkv.tar.gz")
+	if !bytes.Equal(uploads[0].Data, archive) {
+		t.Error("Expected uploaded content to match the archive")
+	}
+	if uploads[0].Metadata["movie"] != "a/movie.mkv" {
+		t.Errorf("Expected movie metadata, got %v", uploads[0].Metadata)
+	}
+	if info, _ := target.Stat(UploadStateName); info != nil {
+		t.Error("Expected upload state to be removed when nothing is pending")
+	}
+
+	// Unchanged archives are not uploaded again
+	if err := SyncAndCompress("", "", []string{"a/movie.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(server.Uploads()) != 1 {
+		t.Errorf("Expected no new upload, got %d uploads", len(server.Uploads()))
+	}
+}
+
+func TestSyncAndCompressResumesInterruptedUpload(t *testing.T) {
+	server := newTestUploadServer(t)
+	source := storage.NewMemory()
+	source.WriteFile("movie.mkv", bytes.Repeat([]byte("x"), 4096), time.Now().Add(-time.Hour))
+	target := storage.NewMemory()
+
+	opts := &SyncOptions{Source: source, Target: target, Uploader: &Uploader{Token: "token", ChunkSize: 16}}
+	server.FailPatchesAfter(2)
+	if err := SyncAndCompress("", "", []string{"movie.mkv"}, opts); err == nil {
+		t.Fatal("Expected error for interrupted upload")
+	}
+	if info, _ := target.Stat(UploadStateName); info == nil {
+		t.Fatal("Expected pending upload to be recorded")
+	}
+
+	server.FailPatchesAfter(-1)
+	if err := SyncAndCompress("", "", []string{"movie.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	uploads := server.Uploads()
+	if len(uploads) != 1 || !uploads[0].Complete() {
+		t.Fatalf("Expected the interrupted upload to be completed, got %d uploads", len(uploads))
+	}
+	archive, _ := storage.ReadFile(target, "movie.mkv.tar.gz")
+	if !bytes.Equal(uploads[0].Data, archive) {
+		t.Error("Expected uploaded content to match the archive")
+	}
+	expected := (len(archive) + 15) / 16
+	if server.Patches() != expected {
+		t.Errorf("Expected %d chunks in total, got %d", expected, server.Patches())
+	}
+}
+
+func TestSyncAndCompressRecreatesExpiredUpload(t *testing.T) {
+	server := newTestUploadServer(t)
+	source := storage.NewMemory()
+	source.WriteFile("movie.mkv", []byte("content"), time.Now().Add(-time.Hour))
+	target := storage.NewMemory()
+
+	opts := &SyncOptions{Source: source, Target: target, Uploader: &Uploader{Token: "token", ChunkSize: 16}}
+	server.FailPatchesAfter(1)
+	if err := SyncAndCompress("", "", []string{"movie.mkv"}, opts); err == nil {
+		t.Fatal("Expected error for interrupted upload")
+	}
+
+	server.Forget()
+	server.FailPatchesAfter(-1)
+	if err := SyncAndCompress("", "", []string{"movie.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	uploads := server.Uploads()
+	if len(uploads) != 1 || !uploads[0].Complete() {
+		t.Fatalf("Expected a new complete upload, got %d uploads", len(uploads))
+	}
+}
+
+func TestSyncAndCompressUploadContinuesAfterFailure(t *testing.T) {
+	server := newTestUploadServer(t)
+	server.Reject("a.mkv.tar.gz")
+	source := storage.NewMemory()
+	source.WriteFile("a.mkv", []byte("rejected"), time.Now().Add(-time.Hour))
+	source.WriteFile("b.mkv", []byte("accepted"), time.Now().Add(-time.Hour))
+	target := storage.NewMemory()
+
+	opts := &SyncOptions{Source: source, Target: target, Uploader: &Uploader{Token: "token"}}
+	err := SyncAndCompress("", "", []string{"a.mkv", "b.mkv"}, opts)
+	if err == nil || !strings.Contains(err.Error(), "a.mkv.tar.gz") {
+		t.Fatalf("Expected an error for the rejected archive, got %v", err)
+	}
+
+	uploads := server.Uploads()
+	if len(uploads) != 1 || uploads[0].Metadata["name"] != "b.mkv.tar.gz" || !uploads[0].Complete() {
+		t.Fatalf("Expected the other archive to be uploaded, got %v", uploads)
+	}
+	data, _ := storage.ReadFile(target, UploadStateName)
+	var pending map[string]uploadEntry
+	if err := json.Unmarshal(data, &pending); err != nil || len(pending) != 1 ||
+		!strings.Contains(pending["a.mkv.tar.gz"].Error, "403") {
+		t.Errorf("Expected the failure to be recorded on the rejected archive, got %s", data)
+	}
+}
+
+func TestSyncAndCompressTerminatesReplacedUpload(t *testing.T) {
+	server := newTestUploadServer(t)
+	source := storage.NewMemory()
+	source.WriteFile("movie.mkv", bytes.Repeat([]byte("x"), 4096), time.Now().Add(-time.Hour))
+	target := storage.NewMemory()
+
+	opts := &SyncOptions{Source: source, Target: target, Uploader: &Uploader{Token: "token", ChunkSize: 16}}
+	server.FailPatchesAfter(2)
+	if err := SyncAndCompress("", "", []string{"movie.mkv"}, opts); err == nil {
+		t.Fatal("Expected error for interrupted upload")
+	}
+
+	// The movie changes before the upload is resumed
+	source.WriteFile("movie.mkv", bytes.Repeat([]byte("y"), 2048), time.Now().Add(time.Hour))
+	server.FailPatchesAfter(-1)
+	if err := SyncAndCompress("", "", []string{"movie.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if server.Terminated() != 1 {
+		t.Errorf("Expected the unfinished upload of the previous version to be terminated, got %d",
+			server.Terminated())
+	}
+	uploads := server.Uploads()
+	archive, _ := storage.ReadFile(target, "movie.mkv.tar.gz")
+	if len(uploads) != 1 || !uploads[0].Complete() || !bytes.Equal(uploads[0].Data, archive) {
+		t.Fatalf("Expected only the new version to be uploaded, got %d uploads", len(uploads))
+	}
+}
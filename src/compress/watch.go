@@ -0,0 +1,207 @@
+package compress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultStableFor is how long a changed movie must keep its size before it is compressed
+const DefaultStableFor = 30 * time.Second
+
+// WatchOptions configures Watch
+type WatchOptions struct {
+	// StableFor is how long a changed path must see no event and keep its
+	// size before its movie is compressed (default DefaultStableFor)
+	StableFor time.Duration
+	// Movies returns the movie paths to archive, relative to the source,
+	// e.g. the server's /movies/sync list. Changes outside them are ignored.
+	Movies func() ([]string, error)
+	// Failed, if set, is called with the error of a pass that failed, e.g.
+	// an unreadable movie or a full target. The watch goes on either way.
+	Failed func(err error)
+}
+
+// Watch compresses movies below the local directory source as they land,
+// until ctx is done. Changes are reported by inotify; once a changed path is
+// stable, the movie containing it is looked up in watch.Movies and, if
+// listed, compressed and uploaded as by SyncAndCompress. Unlike
+// SyncAndCompress, archives of other movies are left untouched, and a pass
+// that fails is logged and reported to watch.Failed without ending the
+// watch. Only a failing watcher or ctx ends it.
+func Watch(ctx context.Context, source, target string, opts *SyncOptions, watch WatchOptions) error {
+	if source == "" {
+		return fmt.Errorf("source path cannot be empty")
+	}
+	if target == "" && (opts == nil || opts.Target == nil) {
+		return fmt.Errorf("target path cannot be empty")
+	}
+	if watch.Movies == nil {
+		return fmt.Errorf("movie list is required")
+	}
+
+	watcher, err := newDirWatcher(source)
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	fmt.Printf("Watching %s for new movies\n", source)
+	options := opts.withDefaults(source, target)
+	if err := checkDedup(options); err != nil {
+		return err
+	}
+	if options.Catalog != nil {
+		if err := options.Catalog.ensure(options.Target, options.TrashDir); err != nil {
+			return fmt.Errorf("catalog failed: %w", err)
+		}
+	}
+	return newMovieWatch(options, watch).run(ctx, watcher.Events(), watcher.Errors())
+}
+
+// pendingPath is a changed path waiting to be stable
+type pendingPath struct {
+	changed time.Time
+	size    int64
+}
+
+// movieWatch debounces changed paths and compresses their movies once stable
+type movieWatch struct {
+	options SyncOptions
+	watch   WatchOptions
+	pending map[string]*pendingPath
+}
+
+func newMovieWatch(options SyncOptions, watch WatchOptions) *movieWatch {
+	if watch.StableFor <= 0 {
+		watch.StableFor = DefaultStableFor
+	}
+	return &movieWatch{options: options, watch: watch, pending: make(map[string]*pendingPath)}
+}
+
+// run handles changed paths from events, relative to the source, and checks
+// pending paths a few times per StableFor, until ctx is done or events closes
+func (m *movieWatch) run(ctx context.Context, events <-chan string, errors <-chan error) error {
+	ticker := time.NewTicker(max(m.watch.StableFor/4, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errors:
+			return fmt.Errorf("watch failed: %w", err)
+		case path, ok := <-events:
+			if !ok {
+				return nil
+			}
+			m.changed(path)
+		case <-ticker.C:
+			if err := m.check(); err != nil {
+				fmt.Printf("Compression failed: %v\n", err)
+				if m.watch.Failed != nil {
+					m.watch.Failed(err)
+				}
+			}
+		}
+	}
+}
+
+// changed records an event on path
+func (m *movieWatch) changed(path string) {
+	if entry, ok := m.pending[path]; ok {
+		entry.changed = now()
+		return
+	}
+	m.pending[path] = &pendingPath{changed: now(), size: -1}
+}
+
+// check compresses the movies of pending paths that are stable: without
+// events for StableFor and with the same size as on the previous check.
+// A path that cannot be read is dropped until its next event, the others
+// are still compressed.
+func (m *movieWatch) check() error {
+	var stable []string
+	var failures []error
+	for path, entry := range m.pending {
+		if now().Sub(entry.changed) < m.watch.StableFor {
+			continue
+		}
+		info, err := m.options.Source.Stat(path)
+		if err != nil {
+			failures = append(failures, err)
+			delete(m.pending, path)
+			continue
+		}
+		if info == nil {
+			// Removed or moved away, nothing to compress
+			delete(m.pending, path)
+			continue
+		}
+		size, err := contentSize(m.options.Source, path)
+		if err != nil {
+			failures = append(failures, err)
+			delete(m.pending, path)
+			continue
+		}
+		if size != entry.size {
+			// Still growing, or first check: wait another StableFor
+			entry.size = size
+			entry.changed = now()
+			continue
+		}
+		stable = append(stable, path)
+		delete(m.pending, path)
+	}
+	if len(stable) == 0 {
+		return errors.Join(failures...)
+	}
+
+	list, err := m.watch.Movies()
+	if err != nil {
+		// The paths are checked again once the server answers
+		fmt.Printf("Failed to fetch the movie list: %v\n", err)
+		for _, path := range stable {
+			m.changed(path)
+		}
+		return errors.Join(failures...)
+	}
+	movies, err := moviesOf(stable, list)
+	if err != nil {
+		return errors.Join(append(failures, err)...)
+	}
+	if len(movies) == 0 {
+		sort.Strings(stable)
+		fmt.Printf("Ignored changes not in the movie list: %s\n", strings.Join(stable, ", "))
+		return errors.Join(failures...)
+	}
+	if err := compressMovies(m.options, movies); err != nil {
+		failures = append(failures, err)
+	}
+	return errors.Join(failures...)
+}
+
+// moviesOf returns the movies of list containing one of paths or contained
+// in one, e.g. a movie folder moved in as part of its parent, sorted
+func moviesOf(paths, list []string) ([]string, error) {
+	found := make(map[string]bool)
+	for _, moviePath := range list {
+		movie, err := movieKey(moviePath)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			if path == movie || strings.HasPrefix(path, movie+"/") || strings.HasPrefix(movie, path+"/") {
+				found[movie] = true
+			}
+		}
+	}
+	movies := make([]string, 0, len(found))
+	for movie := range found {
+		movies = append(movies, movie)
+	}
+	sort.Strings(movies)
+	return movies, nil
+}
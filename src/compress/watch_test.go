@@ -0,0 +1,177 @@
+package compress
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+func TestMoviesOf(t *testing.T) {
+	list := []string{"a/Movie", "b/other.mkv", "c/Third"}
+	movies, err := moviesOf([]string{"a/Movie/movie.mkv", "b/other.mkv", "c", "d/unknown.mkv"}, list)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := []string{"a/Movie", "b/other.mkv", "c/Third"}
+	if !reflect.DeepEqual(movies, expected) {
+		t.Errorf("Expected %v, got %v", expected, movies)
+	}
+
+	if _, err := moviesOf([]string{"a"}, []string{"../escape"}); err == nil {
+		t.Error("Expected error for a movie path escaping the source")
+	}
+}
+
+func TestMovieWatchCompressesStableMovies(t *testing.T) {
+	clock := fakeClock(t, at(12, 0))
+	source := storage.NewMemory()
+	source.WriteFile("a/movie.mkv", []byte("part"), *clock)
+	source.WriteFile("x/ignored.mkv", []byte("ignored"), *clock)
+	target := storage.NewMemory()
+	target.WriteFile("b/other.mkv.tar.gz", []byte("kept"), *clock)
+
+	options := (&SyncOptions{Source: source, Target: target}).withDefaults("", "")
+	watch := newMovieWatch(options, WatchOptions{
+		StableFor: time.Minute,
+		Movies:    func() ([]string, error) { return []string{"a/movie.mkv", "b/other.mkv"}, nil },
+	})
+	watch.changed("a/movie.mkv")
+	watch.changed("x/ignored.mkv")
+
+	check := func() {
+		t.Helper()
+		if err := watch.check(); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	archived := func() bool {
+		t.Helper()
+		info, err := target.Stat("a/movie.mkv.tar.gz")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		return info != nil
+	}
+
+	// Not quiet long enough yet, then the first size seen
+	check()
+	*clock = clock.Add(time.Minute)
+	check()
+	// The file keeps growing: its new size restarts the wait
+	source.WriteFile("a/movie.mkv", []byte("part and more"), *clock)
+	*clock = clock.Add(time.Minute)
+	check()
+	if archived() {
+		t.Fatal("Expected no archive while the movie is still being written")
+	}
+
+	*clock = clock.Add(time.Minute)
+	check()
+	if !archived() {
+		t.Fatal("Expected the stable movie to be archived")
+	}
+	if len(watch.pending) != 0 {
+		t.Errorf("Expected no pending paths, got %v", watch.pending)
+	}
+	if info, _ := target.Stat("x/ignored.mkv.tar.gz"); info != nil {
+		t.Error("Expected no archive for a movie not in the list")
+	}
+	// Watch mode must not trash archives of other movies
+	if info, _ := target.Stat("b/other.mkv.tar.gz"); info == nil {
+		t.Error("Expected the other archive to be kept")
+	}
+}
+
+func TestMovieWatchRetriesWhenListFails(t *testing.T) {
+	clock := fakeClock(t, at(12, 0))
+	source := storage.NewMemory()
+	source.WriteFile("a/movie.mkv", []byte("content"), *clock)
+	target := storage.NewMemory()
+
+	fail := true
+	options := (&SyncOptions{Source: source, Target: target}).withDefaults("", "")
+	watch := newMovieWatch(options, WatchOptions{
+		StableFor: time.Minute,
+		Movies: func() ([]string, error) {
+			if fail {
+				return nil, errors.New("server unavailable")
+			}
+			return []string{"a/movie.mkv"}, nil
+		},
+	})
+	watch.changed("a/movie.mkv")
+	for range 2 {
+		*clock = clock.Add(time.Minute)
+		if err := watch.check(); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if _, ok := watch.pending["a/movie.mkv"]; !ok {
+		t.Fatal("Expected the path to be checked again after the list failed")
+	}
+
+	fail = false
+	for range 2 {
+		*clock = clock.Add(time.Minute)
+		if err := watch.check(); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if info, _ := target.Stat("a/movie.mkv.tar.gz"); info == nil {
+		t.Error("Expected the movie to be archived once the list is available")
+	}
+}
+
+func TestMovieWatchKeepsRunningAfterFailedPass(t *testing.T) {
+	source := storage.NewMemory()
+	source.WriteFile("a/movie.mkv", []byte("content"), time.Now())
+	target := storage.NewMemory()
+
+	// The first pass fails on an invalid movie list, the next one succeeds
+	passes := 0
+	failed := make(chan error, 10)
+	options := (&SyncOptions{Source: source, Target: target}).withDefaults("", "")
+	watch := newMovieWatch(options, WatchOptions{
+		StableFor: 20 * time.Millisecond,
+		Movies: func() ([]string, error) {
+			passes++
+			if passes == 1 {
+				return []string{"../escape"}, nil
+			}
+			return []string{"a/movie.mkv"}, nil
+		},
+		Failed: func(err error) { failed <- err },
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan string)
+	done := make(chan error, 1)
+	go func() { done <- watch.run(ctx, events, nil) }()
+
+	events <- "a/movie.mkv"
+	select {
+	case <-failed:
+	case err := <-done:
+		t.Fatalf("Expected the watch to go on after a failed pass, it ended with %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the failed pass")
+	}
+	events <- "a/movie.mkv"
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if info, _ := target.Stat("a/movie.mkv.tar.gz"); info != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the movie to be archived by a later pass")
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Expected no error once canceled, got %v", err)
+	}
+}
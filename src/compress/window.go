@@ -0,0 +1,127 @@
+package compress
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
+)
+
+// now and sleep read and wait on the clock for compression windows, replaced in tests
+var (
+	now   = time.Now
+	sleep = time.Sleep
+)
+
+// Window is a daily time range during which compression may run, in local
+// time. A window whose end is before its start spans midnight, e.g. 22:00-06:00.
+type Window struct {
+	// Start and End are offsets from midnight
+	Start time.Duration
+	End   time.Duration
+	// Pause holds archives in progress when the window ends until it
+	// reopens. Otherwise the current archive is finished and the remaining
+	// movies are left for the next run.
+	Pause bool
+}
+
+// ParseWindow parses a window written as HH:MM-HH:MM, e.g. "01:00-07:00"
+func ParseWindow(value string) (*Window, error) {
+	start, end, found := strings.Cut(value, "-")
+	if !found {
+		return nil, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", value)
+	}
+	var window Window
+	var err error
+	if window.Start, err = parseTimeOfDay(start); err != nil {
+		return nil, fmt.Errorf("invalid window %q: %w", value, err)
+	}
+	if window.End, err = parseTimeOfDay(end); err != nil {
+		return nil, fmt.Errorf("invalid window %q: %w", value, err)
+	}
+	if window.Start == window.End {
+		return nil, fmt.Errorf("invalid window %q: start and end are equal", value)
+	}
+	return &window, nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	parsed, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute, nil
+}
+
+// Contains reports whether t is inside the window
+func (w *Window) Contains(t time.Time) bool {
+	offset := sinceMidnight(t)
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// NextStart returns when the window next opens after t
+func (w *Window) NextStart(t time.Time) time.Time {
+	midnight := t.Add(-sinceMidnight(t))
+	start := midnight.Add(w.Start)
+	if !start.After(t) {
+		start = midnight.AddDate(0, 0, 1).Add(w.Start)
+	}
+	return start
+}
+
+// String formats the window as ParseWindow reads it
+func (w *Window) String() string {
+	format := func(offset time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(offset.Hours()), int(offset.Minutes())%60)
+	}
+	return format(w.Start) + "-" + format(w.End)
+}
+
+// sinceMidnight returns the local time of day of t
+func sinceMidnight(t time.Time) time.Duration {
+	hour, minute, second := t.Clock()
+	return time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute +
+		time.Duration(second)*time.Second + time.Duration(t.Nanosecond())
+}
+
+// waitForWindow blocks until the window is open. A nil window is always open.
+func (w *Window) waitForWindow() {
+	if w == nil {
+		return
+	}
+	for current := now(); !w.Contains(current); current = now() {
+		sleep(w.NextStart(current).Sub(current))
+	}
+}
+
+// archiveOptions returns a copy of opts pausing archives outside the window
+// when w.Pause is set
+func (w *Window) archiveOptions(opts *io_archive.CompressOptions) *io_archive.CompressOptions {
+	if w == nil || !w.Pause {
+		return opts
+	}
+	var result io_archive.CompressOptions
+	if opts != nil {
+		result = *opts
+	}
+	var throttle io_archive.Throttle
+	if result.Throttle != nil {
+		throttle = *result.Throttle
+	}
+	paused := false
+	throttle.Wait = func() {
+		if !paused && !w.Contains(now()) {
+			paused = true
+			fmt.Printf("Compression window %s closed, pausing until %s\n", w,
+				w.NextStart(now()).Format(time.DateTime))
+		}
+		w.waitForWindow()
+		paused = false
+	}
+	result.Throttle = &throttle
+	return &result
+}
@@ -0,0 +1,119 @@
+package compress
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// fakeClock replaces now and sleep with a clock at current that sleeping advances
+func fakeClock(t *testing.T, current time.Time) *time.Time {
+	t.Helper()
+	originalNow, originalSleep := now, sleep
+	now = func() time.Time { return current }
+	sleep = func(d time.Duration) { current = current.Add(d) }
+	t.Cleanup(func() { now, sleep = originalNow, originalSleep })
+	return &current
+}
+
+func at(hour, minute int) time.Time {
+	return time.Date(2024, 1, 2, hour, minute, 0, 0, time.Local)
+}
+
+func TestParseWindow(t *testing.T) {
+	window, err := ParseWindow("01:00-07:30")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if window.Start != time.Hour || window.End != 7*time.Hour+30*time.Minute || window.String() != "01:00-07:30" {
+		t.Errorf("Unexpected window %+v", window)
+	}
+	for _, value := range []string{"", "01:00", "1-7", "01:00-25:00", "03:00-03:00"} {
+		if _, err := ParseWindow(value); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	night, _ := ParseWindow("22:00-06:00")
+	cases := []struct {
+		time     time.Time
+		expected bool
+	}{
+		{at(23, 0), true},
+		{at(2, 0), true},
+		{at(6, 0), false},
+		{at(12, 0), false},
+		{at(22, 0), true},
+	}
+	for _, c := range cases {
+		if got := night.Contains(c.time); got != c.expected {
+			t.Errorf("Contains(%s) = %v, expected %v", c.time.Format("15:04"), got, c.expected)
+		}
+	}
+
+	if next := night.NextStart(at(12, 0)); !next.Equal(at(22, 0)) {
+		t.Errorf("Expected window to open at 22:00, got %s", next)
+	}
+	if next := night.NextStart(at(23, 0)); !next.Equal(at(22, 0).AddDate(0, 0, 1)) {
+		t.Errorf("Expected window to open the next day, got %s", next)
+	}
+}
+
+func TestSyncAndCompressOutsideWindowLeavesMovies(t *testing.T) {
+	fakeClock(t, at(12, 0))
+	window, _ := ParseWindow("01:00-07:00")
+	source := storage.NewMemory()
+	source.WriteFile("a/movie.mkv", []byte("content"), time.Now().Add(-time.Hour))
+	target := storage.NewMemory()
+
+	opts := &SyncOptions{Source: source, Target: target, Window: window}
+	if err := SyncAndCompress("", "", []string{"a/movie.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if info, _ := target.Stat("a/movie.mkv.tar.gz"); info != nil {
+		t.Error("Expected no compression outside the window")
+	}
+}
+
+func TestSyncAndCompressWaitsForWindow(t *testing.T) {
+	clock := fakeClock(t, at(12, 0))
+	window, _ := ParseWindow("01:00-07:00")
+	window.Pause = true
+	source := storage.NewMemory()
+	source.WriteFile("a/movie.mkv", []byte("content"), time.Now().Add(-time.Hour))
+	target := storage.NewMemory()
+
+	opts := &SyncOptions{Source: source, Target: target, Window: window}
+	if err := SyncAndCompress("", "", []string{"a/movie.mkv"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if info, _ := target.Stat("a/movie.mkv.tar.gz"); info == nil {
+		t.Error("Expected compression once the window opened")
+	}
+	if !clock.Equal(at(1, 0).AddDate(0, 0, 1)) {
+		t.Errorf("Expected to wait until 01:00, got %s", clock)
+	}
+}
+
+func TestWindowPausesArchiveInProgress(t *testing.T) {
+	clock := fakeClock(t, at(7, 30))
+	window, _ := ParseWindow("01:00-07:00")
+	window.Pause = true
+
+	opts := window.archiveOptions(nil)
+	if opts == nil || opts.Throttle == nil || opts.Throttle.Wait == nil {
+		t.Fatal("Expected archive options pausing outside the window")
+	}
+	opts.Throttle.Wait()
+	if !clock.Equal(at(1, 0).AddDate(0, 0, 1)) {
+		t.Errorf("Expected archive paused until 01:00, got %s", clock)
+	}
+
+	window.Pause = false
+	if window.archiveOptions(nil) != nil {
+		t.Error("Expected archives to finish when not pausing")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
+)
+
+// compressionFlags holds the settings for how new archives are written,
+// shared by the sync and the commands rewriting archives so both write
+// them alike.
+type compressionFlags struct {
+	volumeSize    *int64
+	adaptive      *bool
+	policies      policyList
+	deterministic *bool
+	fixedMtime    *string
+}
+
+// registerCompressionFlags adds the archive compression flags to flags.
+func registerCompressionFlags(flags *flag.FlagSet) *compressionFlags {
+	f := &compressionFlags{
+		volumeSize: flags.Int64(flagVolumeSize, 0, "Split archives into volumes of this many MiB, 0 keeps single files"),
+		adaptive: flags.Bool(flagAdaptive, false,
+			"Store media uncompressed, compress text sidecars at level 9 and sample the entropy of other files"),
+	}
+	flags.Var(&f.policies, flagPolicy,
+		"Compression level for matching files as name=level:pattern,..., e.g. media=0:*.mkv (repeatable)")
+	f.deterministic = flags.Bool(flagDeterminism, false,
+		"Write byte-identical archives for identical movies: sorted entries, no owners, PAX headers")
+	f.fixedMtime = flags.String(flagEntryMtime, "",
+		"Modification time of every entry of deterministic archives, e.g. 2024-01-01T00:00:00Z (default each file's)")
+	return f
+}
+
+// options returns the compression options selected by the flags, encrypting
+// with encryption.
+func (f *compressionFlags) options(encryption *io_archive.Encryption) (*io_archive.CompressOptions, error) {
+	opts := &io_archive.CompressOptions{
+		Encryption: encryption,
+		VolumeSize: *f.volumeSize << 20,
+		Policies:   f.policies,
+	}
+	if *f.deterministic {
+		opts.Deterministic = &io_archive.Deterministic{}
+		if *f.fixedMtime != "" {
+			modTime, err := time.Parse(time.RFC3339, *f.fixedMtime)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", flagEntryMtime, err)
+			}
+			opts.Deterministic.ModTime = modTime
+		}
+	}
+	if *f.adaptive {
+		// Explicit policies take precedence over the defaults
+		opts.Policies = append(opts.Policies, io_archive.DefaultPolicies()...)
+		opts.SampleEntropy = true
+	}
+	return opts, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/compress"
+)
+
+// runConsolidate merges the deltas of incremental archives whose chain
+// reached the given length into fresh bases, without reading the movies.
+// New bases are written with the compression flags of the sync, which
+// should be given as they are to it.
+func runConsolidate(args []string) error {
+	flags := flag.NewFlagSet("consolidate", flag.ExitOnError)
+	target := flags.String(flagTarget, "", "Directory or URL with compressed files")
+	trashDir := flags.String(flagTrashDir, compress.DefaultTrashDir, "Trash directory inside target")
+	chainLength := flags.Int("chain-length", compress.DefaultChainLength,
+		"Consolidate incremental archives with at least this many deltas")
+	identity := flags.String(flagIdentity, "", "File with identities opening encrypted archives, "+
+		"the passphrase is read from "+passphraseEnv)
+	catalogPath := flags.String(flagCatalog, "", "Catalog file of the target, updated with the new bases")
+	remote := registerTargetFlags(flags)
+	encrypt := registerEncryptionFlags(flags)
+	archiving := registerCompressionFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *target == "" {
+		return fmt.Errorf("target is required")
+	}
+
+	store, err := remote.open(*target)
+	if err != nil {
+		return err
+	}
+	encryption, err := encrypt.encryption()
+	if err != nil {
+		return err
+	}
+	dec, err := decryption(*identity)
+	if err != nil {
+		return err
+	}
+	archiveOpts, err := archiving.options(encryption)
+	if err != nil {
+		return err
+	}
+	opts := compress.ConsolidateOptions{
+		ChainLength: *chainLength,
+		TrashDir:    *trashDir,
+		Compress:    archiveOpts,
+		Decryption:  dec,
+	}
+	if *catalogPath != "" {
+		if opts.Catalog, err = compress.OpenCatalog(*catalogPath); err != nil {
+			return err
+		}
+	}
+
+	consolidated, err := compress.Consolidate(store, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Consolidated %d archives in %s\n", consolidated, store)
+	return nil
+}
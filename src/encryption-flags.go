@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
+)
+
+// Encryption flag names
+const (
+	flagRecipients = "recipients"
+	flagPassphrase = "passphrase"
+	flagIdentity   = "identity"
+)
+
+// passphraseEnv holds the archive passphrase, read from the environment
+// to keep it out of process listings
+const passphraseEnv = "ARCHIVE_PASSPHRASE"
+
+// encryptionFlags holds the settings for encrypting new archives.
+type encryptionFlags struct {
+	recipients *string
+	passphrase *bool
+}
+
+// registerEncryptionFlags adds the archive encryption flags to flags.
+func registerEncryptionFlags(flags *flag.FlagSet) *encryptionFlags {
+	return &encryptionFlags{
+		recipients: flags.String(flagRecipients, "", "File with public keys to encrypt archives to, one per line"),
+		passphrase: flags.Bool(flagPassphrase, false, "Encrypt archives with the passphrase in "+passphraseEnv),
+	}
+}
+
+// encryption returns the encryption selected by the flags, or nil when
+// archives are not encrypted.
+func (f *encryptionFlags) encryption() (*io_archive.Encryption, error) {
+	var enc io_archive.Encryption
+	if *f.recipients != "" {
+		recipients, err := io_archive.ReadKeys(*f.recipients)
+		if err != nil {
+			return nil, err
+		}
+		enc.Recipients = recipients
+	}
+	if *f.passphrase {
+		enc.Passphrase = os.Getenv(passphraseEnv)
+		if enc.Passphrase == "" {
+			return nil, fmt.Errorf("%s is required with -%s", passphraseEnv, flagPassphrase)
+		}
+	}
+	if enc.Passphrase == "" && len(enc.Recipients) == 0 {
+		return nil, nil
+	}
+	return &enc, nil
+}
+
+// decryption returns the keys to open encrypted archives: the identities in
+// identityFile, if set, and the passphrase in ARCHIVE_PASSPHRASE, if set.
+func decryption(identityFile string) (*io_archive.Decryption, error) {
+	dec := &io_archive.Decryption{Passphrase: os.Getenv(passphraseEnv)}
+	if identityFile != "" {
+		identities, err := io_archive.ReadKeys(identityFile)
+		if err != nil {
+			return nil, err
+		}
+		dec.Identities = identities
+	}
+	return dec, nil
+}
@@ -2,24 +2,67 @@ package io_archive
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
 )
 
 const Extension = "tar.gz"
 
+// Codec names the archive format: a tar stream compressed with gzip
+const Codec = "tar+gzip"
+
 // CompressOptions configures compression options
 type CompressOptions struct {
 	// CompressionLevel sets gzip compression level (1-9, default 7)
 	CompressionLevel int
+	// Encryption, if set, encrypts the archive stream (see Encrypt).
+	// Encrypted archives are named with EncryptedExtension.
+	Encryption *Encryption
+	// VolumeSize, if positive, splits archives written to a storage into
+	// volumes of at most this many bytes (see VolumeName)
+	VolumeSize int64
+	// Include, if not empty, limits the files of a directory to those
+	// matching one of these patterns (see MatchPattern), e.g. "*.mkv"
+	Include []string
+	// Exclude leaves out files and directories matching one of these
+	// patterns, e.g. "**/sample/**" or "*.part". Exclude wins over Include.
+	// Skipped files are counted in the manifest.
+	Exclude []string
+	// Symlinks selects how symbolic links inside a directory are stored
+	// (default SymlinkSkip). Hard-linked files are always stored once,
+	// further names become tar hard-link entries.
+	Symlinks SymlinkMode
+	// Progress, if set, is called as the archive is written (see Progress)
+	Progress func(Progress)
+	// Policies set the gzip level of the files they match, the first
+	// matching policy applies (see DefaultPolicies). Other files use
+	// CompressionLevel. Results per policy are recorded in the manifest.
+	Policies []CompressionPolicy
+	// SampleEntropy stores files no policy matches uncompressed when a
+	// sample of their content looks already compressed
+	SampleEntropy bool
+	// Throttle, if set, limits bandwidth and pauses compression on demand
+	Throttle *Throttle
+	// Deterministic, if set, writes archives that are byte-identical for
+	// identical sources (see Deterministic)
+	Deterministic *Deterministic
+	// Metadata, if set, is embedded in the archive and its manifest
+	// (see MovieMetadata)
+	Metadata *MovieMetadata
 }
 
 // Compress creates a tar.gz archive from source to target directory.
 // If source is a directory, it compresses recursively preserving structure.
 // If source is a file, it compresses just that file.
+// A checksum manifest is written next to the archive (see ManifestPath).
 // Returns the path to created archive file.
 //
 // Returns error if:
@@ -34,19 +77,36 @@ func Compress(source, target string, opts *CompressOptions) (string, error) {
 		return "", err
 	}
 
-	sourceInfo, err := os.Stat(source)
+	sourceStore, sourceName := localStorage(source)
+	sourceInfo, err := statSource(sourceStore, sourceName, source)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return "", fmt.Errorf("source does not exist: %s", source)
-		}
-		return "", fmt.Errorf("cannot access source: %w", err)
+		return "", err
 	}
 
-	level := getCompressionLevel(opts)
-	outputPath := filepath.Join(target, filepath.Base(source)+"."+Extension)
+	outputName := filepath.Base(source) + "." + ArchiveExtension(opts)
 
 	// Create and write archive
-	return createArchive(source, sourceInfo, outputPath, level)
+	if _, err := createArchive(sourceStore, sourceInfo, storage.NewLocal(target), outputName, opts); err != nil {
+		return "", err
+	}
+	return filepath.Join(target, outputName), nil
+}
+
+// localStorage returns a local storage for the directory of path, and the name of path in it
+func localStorage(path string) (storage.Storage, string) {
+	return storage.NewLocal(filepath.Dir(path)), filepath.Base(path)
+}
+
+// statSource returns the source to compress, described as label in errors
+func statSource(source storage.Storage, name, label string) (*storage.File, error) {
+	info, err := source.Stat(name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot access source: %w", err)
+	}
+	if info == nil {
+		return nil, fmt.Errorf("source does not exist: %s", label)
+	}
+	return info, nil
 }
 
 func validateCompressInputs(source, target string) error {
@@ -77,123 +137,299 @@ func getCompressionLevel(opts *CompressOptions) int {
 	return level
 }
 
-func createArchive(source string, sourceInfo os.FileInfo, outputPath string, level int) (string, error) {
-	// Create archive file
-	tarfile, err := os.Create(outputPath)
+// CompressInto writes the archive of the file or directory name in source
+// to archive in target, split into volumes if opts.VolumeSize is set,
+// followed by its checksum manifest, which is returned. Files of a previous
+// version of the archive that are not overwritten are removed, deltas included.
+//
+// Example: CompressInto(target, "a/movie.mkv.tar.gz", source, "a/movie.mkv", nil)
+func CompressInto(target storage.Storage, archive string, source storage.Storage, name string,
+	opts *CompressOptions) (*Manifest, error) {
+	if name == "" {
+		return nil, fmt.Errorf("source path cannot be empty")
+	}
+	if archive == "" {
+		return nil, fmt.Errorf("archive name cannot be empty")
+	}
+	sourceInfo, err := statSource(source, name, name)
 	if err != nil {
-		return "", fmt.Errorf("failed to create archive: %w", err)
+		return nil, err
 	}
-	defer tarfile.Close()
+	return createArchive(source, sourceInfo, target, archive, opts)
+}
 
-	// Create gzip writer
-	gz, err := gzip.NewWriterLevel(tarfile, level)
+// createArchive writes the archive of sourceInfo to outputName in target,
+// followed by its checksum manifest. The chain and deltas of a previous
+// incremental version of the archive are removed (see CompressIncremental).
+func createArchive(source storage.Storage, sourceInfo *storage.File, target storage.Storage,
+	outputName string, opts *CompressOptions) (*Manifest, error) {
+	walker, err := collectArchive(source, sourceInfo, opts)
 	if err != nil {
-		tarfile.Close()
-		os.Remove(outputPath)
-		return "", fmt.Errorf("failed to create gzip writer: %w", err)
+		return nil, err
 	}
-	defer gz.Close()
+	return storeFull(target, outputName, walker, opts)
+}
+
+// partialSuffix names an archive, its volumes and its manifest while they
+// are written, until they replace the previous version of the archive
+const partialSuffix = ".partial"
 
-	// Create tar writer
-	writer := tar.NewWriter(gz)
-	defer writer.Close()
+// storeArchive writes the archive produced by write to outputName in target,
+// split into volumes if opts.VolumeSize is set, followed by its checksum
+// manifest. Everything is staged under outputName+partialSuffix and swapped
+// in only once stored completely, so a failure leaves a previous version of
+// the archive untouched. Single files are written straight to outputName on
+// stores creating files atomically instead, see storeDirect.
+func storeArchive(target storage.Storage, outputName string, opts *CompressOptions,
+	write func(w io.Writer) (*Manifest, error)) (*Manifest, error) {
+	if atomic, ok := target.(storage.AtomicCreator); ok && atomic.CreatesAtomically() &&
+		(opts == nil || opts.VolumeSize <= 0) {
+		return storeDirect(target, outputName, write)
+	}
+	manifest, err := stageArchive(target, outputName, opts, write)
+	if err != nil {
+		return nil, err
+	}
+	if err := commitArchive(target, outputName+partialSuffix, outputName, len(manifest.Volumes)); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
 
-	var baseDir string
-	if sourceInfo.IsDir() {
-		baseDir = filepath.Base(source)
+// storeDirect is storeArchive writing the archive under outputName, for
+// stores replacing it only once Close succeeds, which saves renaming a
+// staged copy where that copies the archive again. The previous manifest is
+// removed just before, so a failing Close leaves the previous archive
+// without a manifest, as an interrupted swap does.
+func storeDirect(target storage.Storage, outputName string,
+	write func(w io.Writer) (*Manifest, error)) (*Manifest, error) {
+	writer, err := target.Create(outputName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive: %w", err)
+	}
+	manifest, err := write(writer)
+	if err != nil {
+		writer.Abort()
+		return nil, err
+	}
+	if err := removeIfExists(target, ManifestPath(outputName)); err != nil {
+		writer.Abort()
+		return nil, fmt.Errorf("failed to replace manifest of %s: %w", outputName, err)
+	}
+	if err := writer.Close(); err != nil {
+		writer.Abort()
+		return nil, fmt.Errorf("failed to close archive: %w", err)
 	}
 
-	// Walk source and add files to archive
-	walkErr := filepath.Walk(source, archiveWalker(writer, source, baseDir))
+	manifest.Archive = path.Base(outputName)
+	if err := removeStaleFiles(target, outputName, 0); err != nil {
+		return nil, fmt.Errorf("failed to remove previous archive files: %w", err)
+	}
+	if err := writeManifest(target, outputName, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
 
-	// Handle walk errors
-	if walkErr != nil {
-		writer.Close()
-		gz.Close()
-		tarfile.Close()
-		os.Remove(outputPath)
-		return "", fmt.Errorf("compression failed: %w", walkErr)
+// stageArchive is storeArchive without the swap: the archive and its
+// manifest are left under outputName+partialSuffix, the manifest already
+// naming the files of outputName
+func stageArchive(target storage.Storage, outputName string, opts *CompressOptions,
+	write func(w io.Writer) (*Manifest, error)) (*Manifest, error) {
+	staged := outputName + partialSuffix
+	if err := removeArchiveFiles(target, staged); err != nil {
+		return nil, fmt.Errorf("failed to remove interrupted archive: %w", err)
 	}
 
-	// Close writers in correct order
-	closeErr := writer.Close()
-	gzipErr := gz.Close()
+	var writer storage.Writer
+	var volumes *volumeWriter
+	if opts != nil && opts.VolumeSize > 0 {
+		volumes = newVolumeWriter(target, staged, opts.VolumeSize)
+		writer = volumes
+	} else {
+		var err error
+		if writer, err = target.Create(staged); err != nil {
+			return nil, fmt.Errorf("failed to create archive: %w", err)
+		}
+	}
 
-	if closeErr != nil {
-		tarfile.Close()
-		os.Remove(outputPath)
-		return "", fmt.Errorf("failed to close tar writer: %w", closeErr)
+	manifest, err := write(writer)
+	if err != nil {
+		writer.Abort()
+		return nil, err
 	}
-	if gzipErr != nil {
-		tarfile.Close()
-		os.Remove(outputPath)
-		return "", fmt.Errorf("failed to close gzip writer: %w", gzipErr)
+	if err := writer.Close(); err != nil {
+		writer.Abort()
+		return nil, fmt.Errorf("failed to close archive: %w", err)
 	}
 
-	tarfile.Close()
-	return outputPath, nil
+	manifest.Archive = path.Base(outputName)
+	if volumes != nil {
+		manifest.Volumes = volumes.volumes
+		for index := range manifest.Volumes {
+			manifest.Volumes[index].Name = path.Base(VolumeName(outputName, index+1))
+		}
+	}
+	if err := writeManifest(target, staged, manifest); err != nil {
+		removeArchiveFiles(target, staged)
+		return nil, err
+	}
+	return manifest, nil
 }
 
-// archiveWalker creates a filepath.WalkFunc that adds files to a tar archive
-func archiveWalker(writer *tar.Writer, source, baseDir string) filepath.WalkFunc {
-	return func(path string, fileInfo os.FileInfo, err error) error {
-		if err != nil {
-			return fmt.Errorf("walk error at %s: %w", path, err)
-		}
+// CompressTo writes a tar.gz archive of source to w, with the same layout as
+// Compress, and returns its manifest. The manifest Archive name is left for
+// the caller to set. w is not closed.
+//
+// Returns error if source is empty or does not exist, or if compression fails.
+func CompressTo(w io.Writer, source string, opts *CompressOptions) (*Manifest, error) {
+	if source == "" {
+		return nil, fmt.Errorf("source path cannot be empty")
+	}
+	sourceStore, sourceName := localStorage(source)
+	return compressFrom(w, sourceStore, sourceName, source, opts)
+}
 
-		// Skip symlinks
-		if fileInfo.Mode()&os.ModeSymlink != 0 {
-			return nil
-		}
+// CompressFrom is CompressTo for the file or directory name in source.
+//
+// Example: CompressFrom(w, storage.NewLocal("/data"), "movies/movie.mkv", nil)
+func CompressFrom(w io.Writer, source storage.Storage, name string, opts *CompressOptions) (*Manifest, error) {
+	if name == "" {
+		return nil, fmt.Errorf("source path cannot be empty")
+	}
+	return compressFrom(w, source, name, name, opts)
+}
 
-		// Create tar header
-		header, err := tar.FileInfoHeader(fileInfo, "")
-		if err != nil {
-			return fmt.Errorf("failed to create header for %s: %w", path, err)
-		}
+func compressFrom(w io.Writer, source storage.Storage, name, label string, opts *CompressOptions) (*Manifest, error) {
+	sourceInfo, err := statSource(source, name, label)
+	if err != nil {
+		return nil, err
+	}
+	return writeArchive(w, source, sourceInfo, opts)
+}
 
-		// Set header name with correct path separators for tar format
-		if baseDir != "" {
-			relPath, err := filepath.Rel(source, path)
-			if err != nil {
-				return fmt.Errorf("failed to get relative path: %w", err)
-			}
-			// Always use forward slash in tar format
-			header.Name = filepath.ToSlash(filepath.Join(baseDir, relPath))
-		} else {
-			header.Name = filepath.ToSlash(filepath.Base(path))
-		}
+// writeArchive streams the tar.gz archive of sourceInfo into w, encrypted if
+// opts ask for it, and returns its manifest. A directory is stored with its
+// content below its base name, a file under its base name.
+func writeArchive(w io.Writer, source storage.Storage, sourceInfo *storage.File,
+	opts *CompressOptions) (*Manifest, error) {
+	walker, err := collectArchive(source, sourceInfo, opts)
+	if err != nil {
+		return nil, err
+	}
+	return writeCollected(w, walker, opts)
+}
 
-		// Write header
-		if err := writer.WriteHeader(header); err != nil {
-			return fmt.Errorf("failed to write header for %s: %w", path, err)
-		}
+// collectArchive gathers the entries of the archive of sourceInfo
+func collectArchive(source storage.Storage, sourceInfo *storage.File, opts *CompressOptions) (*archiveWalker, error) {
+	filter, err := newEntryFilter(opts)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{CreatedAt: time.Now().UTC()}
+	if opts != nil {
+		manifest.Metadata = opts.Metadata
+	}
+
+	walker := newArchiveWalker(source, filter, opts, manifest)
+	if err := walker.collect(*sourceInfo, path.Base(sourceInfo.Name)); err != nil {
+		return nil, fmt.Errorf("compression failed: %w", err)
+	}
+	return walker, nil
+}
 
-		// Skip directory content
-		if fileInfo.IsDir() {
-			return nil
+// writeCollected streams the entries collected by walker into w as a tar.gz
+// archive, encrypted if opts ask for it, and returns its manifest
+func writeCollected(w io.Writer, walker *archiveWalker, opts *CompressOptions) (*Manifest, error) {
+	manifest := walker.manifest
+	stream, err := newArchiveStream(w, opts)
+	if err != nil {
+		return nil, err
+	}
+	progress := newProgressTracker(opts, walker.contentSize(), stream.hash)
+	if err := walker.write(stream.tar, stream.gz, progress); err != nil {
+		return nil, fmt.Errorf("compression failed: %w", err)
+	}
+	if err := stream.close(manifest); err != nil {
+		return nil, err
+	}
+	progress.finish()
+	return manifest, nil
+}
+
+// archiveStream is the chain of writers of an archive: tar entries,
+// compressed by gz, encrypted if asked, hashed as written to the output
+type archiveStream struct {
+	tar       *tar.Writer
+	gz        *gzipStream
+	encrypted io.WriteCloser
+	// hash the archive bytes as they are written, after encryption
+	hash *hashingWriter
+}
+
+func newArchiveStream(w io.Writer, opts *CompressOptions) (*archiveStream, error) {
+	stream := &archiveStream{hash: newHashingWriter(newThrottledWriter(w, opts))}
+
+	var out io.Writer = stream.hash
+	if opts != nil && opts.Encryption != nil {
+		var err error
+		if stream.encrypted, err = Encrypt(stream.hash, opts.Encryption); err != nil {
+			return nil, err
 		}
+		out = stream.encrypted
+	}
 
-		return addFileToArchive(writer, path, fileInfo)
+	// Create gzip writer, switching levels between files with policies
+	gz, err := newGzipStream(out, opts)
+	if err != nil {
+		return nil, err
 	}
+	stream.gz = gz
+	stream.tar = tar.NewWriter(gz)
+	return stream, nil
 }
 
-// addFileToArchive copies a file's content to the tar archive
-func addFileToArchive(writer *tar.Writer, path string, fileInfo os.FileInfo) error {
-	file, err := os.Open(path)
+// close ends the archive and records its size, checksum and policy results in manifest
+func (s *archiveStream) close(manifest *Manifest) error {
+	// Close writers in correct order
+	if err := s.tar.Close(); err != nil {
+		return fmt.Errorf("failed to close tar writer: %w", err)
+	}
+	if err := s.gz.Close(); err != nil {
+		return err
+	}
+	manifest.Policies = s.gz.results()
+	if s.encrypted != nil {
+		if err := s.encrypted.Close(); err != nil {
+			return fmt.Errorf("failed to close encryption: %w", err)
+		}
+	}
+
+	manifest.Size = s.hash.size
+	manifest.SHA256 = s.hash.Sum()
+	return nil
+}
+
+// addFileToArchive copies a file's content to the tar archive, reading
+// through reads and reporting the bytes read to progress, and returns the
+// hex SHA-256 of the copied content
+func addFileToArchive(writer *tar.Writer, source storage.Storage, file storage.File,
+	reads *readThrottle, progress *progressTracker) (string, error) {
+	reader, err := source.Open(file.Name)
 	if err != nil {
-		return fmt.Errorf("failed to open file %s: %w", path, err)
+		return "", fmt.Errorf("failed to open file %s: %w", file.Name, err)
 	}
-	defer file.Close()
+	defer reader.Close()
 
-	copied, err := io.Copy(writer, file)
+	hash := sha256.New()
+	copied, err := io.Copy(io.MultiWriter(writer, hash, progress), reads.wrap(reader))
 	if err != nil {
-		return fmt.Errorf("failed to copy file %s: %w", path, err)
+		return "", fmt.Errorf("failed to copy file %s: %w", file.Name, err)
 	}
-	if copied != fileInfo.Size() {
-		return fmt.Errorf("incomplete copy of %s: got %d bytes, expected %d",
-			path, copied, fileInfo.Size())
+	if copied != file.Size {
+		return "", fmt.Errorf("incomplete copy of %s: got %d bytes, expected %d",
+			file.Name, copied, file.Size)
 	}
 
-	return nil
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
@@ -0,0 +1,115 @@
+package io_archive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// ChunkSizes bounds the content-defined chunks files are split into by a
+// ChunkStore. Cut points depend on the content around them, not on its
+// offset, so content shared by two files yields the same chunks even when
+// it starts at different offsets.
+type ChunkSizes struct {
+	// Min is the smallest chunk, except for the last chunk of a file
+	Min int `json:"min"`
+	// Average is the expected chunk size, a power of two
+	Average int `json:"average"`
+	// Max is the largest chunk
+	Max int `json:"max"`
+}
+
+// DefaultChunkSizes returns chunk sizes suited to movie files: chunks of 1
+// to 16 MiB, 4 MiB on average
+func DefaultChunkSizes() ChunkSizes {
+	return ChunkSizes{Min: 1 << 20, Average: 4 << 20, Max: 16 << 20}
+}
+
+// validate checks that 0 < Min <= Average <= Max and that Average is a power of two
+func (s ChunkSizes) validate() error {
+	if s.Min <= 0 || s.Min > s.Average || s.Average > s.Max {
+		return fmt.Errorf("invalid chunk sizes %d/%d/%d: expected 0 < min <= average <= max",
+			s.Min, s.Average, s.Max)
+	}
+	if s.Average&(s.Average-1) != 0 {
+		return fmt.Errorf("invalid average chunk size %d: must be a power of two", s.Average)
+	}
+	return nil
+}
+
+// gear maps each byte to a pseudo-random value rolled into the chunker hash.
+// It is fixed so that the same content is always cut at the same points.
+var gear = func() (table [256]uint64) {
+	state := uint64(0x5241444152520001)
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		value := state
+		value = (value ^ value>>30) * 0xbf58476d1ce4e5b9
+		value = (value ^ value>>27) * 0x94d049bb133111eb
+		table[i] = value ^ value>>31
+	}
+	return table
+}()
+
+// chunker splits a stream into content-defined chunks with a gear rolling
+// hash: a chunk ends where the top bits of the hash of the bytes before are
+// all zero, which happens once every Average bytes on average.
+type chunker struct {
+	reader io.Reader
+	sizes  ChunkSizes
+	mask   uint64
+	buf    []byte
+	// start and end delimit the bytes of buf not returned yet
+	start, end int
+	eof        bool
+}
+
+func newChunker(reader io.Reader, sizes ChunkSizes) *chunker {
+	return &chunker{
+		reader: reader,
+		sizes:  sizes,
+		mask:   ^uint64(0) << (64 - bits.TrailingZeros(uint(sizes.Average))),
+		buf:    make([]byte, sizes.Max),
+	}
+}
+
+// next returns the next chunk, or io.EOF after the last one. The chunk is
+// only valid until the following call.
+func (c *chunker) next() ([]byte, error) {
+	if c.start > 0 {
+		c.end = copy(c.buf, c.buf[c.start:c.end])
+		c.start = 0
+	}
+	for c.end < len(c.buf) && !c.eof {
+		n, err := c.reader.Read(c.buf[c.end:])
+		c.end += n
+		if errors.Is(err, io.EOF) {
+			c.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if c.end == 0 {
+		return nil, io.EOF
+	}
+
+	c.start = c.cut(c.buf[:c.end])
+	return c.buf[:c.start], nil
+}
+
+// cut returns the length of the chunk at the start of data
+func (c *chunker) cut(data []byte) int {
+	if len(data) <= c.sizes.Min {
+		return len(data)
+	}
+	var hash uint64
+	for i := c.sizes.Min; i < len(data); i++ {
+		hash = hash<<1 + gear[data[i]]
+		if hash&c.mask == 0 {
+			return i + 1
+		}
+	}
+	return len(data)
+}
@@ -0,0 +1,779 @@
+package io_archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// DedupDir is the directory of a storage holding its chunk store
+const DedupDir = ".chunks"
+
+// SnapshotExtension is appended to a movie path to name its snapshot in a
+// chunk store, e.g. "a/movie.mkv" -> "a/movie.mkv.snapshot.json"
+const SnapshotExtension = "snapshot.json"
+
+// DedupCodec names the format of snapshots: files split into
+// content-defined chunks stored once by SHA-256
+const DedupCodec = "chunks+sha256"
+
+// chunkIndexName is the file of the chunk store counting chunk references
+const chunkIndexName = DedupDir + "/index.json"
+
+// ChunkStore keeps files as content-defined chunks stored once by hash under
+// DedupDir of a storage, so content shared by movies, e.g. extras left
+// byte-identical by an upgrade, is stored once. Each movie is described by a
+// snapshot listing its files and their chunks (see Snapshot).
+//
+// The index counts the references of snapshots to each chunk. A chunk is
+// removed once no snapshot references it. Updates are ordered so that an
+// interruption leaves unreferenced chunks behind, never a snapshot missing
+// a chunk; Sweep removes them. Chunks are gzipped at the level the
+// compression options select for their file, unless that does not make
+// them smaller, and are never encrypted.
+type ChunkStore struct {
+	store storage.Storage
+	index chunkIndex
+}
+
+// chunkIndex is the content of the index of a chunk store
+type chunkIndex struct {
+	// Sizes are the chunk sizes of the store, kept from its creation
+	Sizes  ChunkSizes           `json:"sizes"`
+	Chunks map[string]*chunkRef `json:"chunks"`
+}
+
+// chunkRef counts the references to a chunk, once per use in a snapshot
+type chunkRef struct {
+	Size int64 `json:"size"`
+	Refs int   `json:"refs"`
+	// Stored is the size of the chunk file, 0 in stores written before
+	// chunks were compressed, which hold them as is
+	Stored int64 `json:"stored,omitempty"`
+}
+
+// storedSize returns the bytes the chunk takes in the store
+func (r *chunkRef) storedSize() int64 {
+	if r.Stored > 0 {
+		return r.Stored
+	}
+	return r.Size
+}
+
+// Snapshot describes a file or directory stored in a chunk store
+type Snapshot struct {
+	// Source is the name of the file or directory stored
+	Source    string          `json:"source"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Entries   []SnapshotEntry `json:"entries"`
+	// Size is the content size of the files, StoredSize the size of the
+	// chunks first stored with this snapshot, as compressed
+	Size       int64 `json:"size"`
+	StoredSize int64 `json:"storedSize"`
+	// Chunks counts the chunks referenced, NewChunks those first stored
+	Chunks    int `json:"chunks"`
+	NewChunks int `json:"newChunks"`
+	// Skipped counts the files left out by CompressOptions patterns, of SkippedSize bytes
+	Skipped     int   `json:"skipped,omitempty"`
+	SkippedSize int64 `json:"skippedSize,omitempty"`
+	// Warnings describe entries left out for another reason, e.g. symbolic links
+	Warnings []string `json:"warnings,omitempty"`
+	// Metadata identifies the movie, as given with CompressOptions.Metadata
+	Metadata *MovieMetadata `json:"metadata,omitempty"`
+}
+
+// SnapshotEntry is a file, directory or link of a snapshot
+type SnapshotEntry struct {
+	Name    string      `json:"name"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"modTime"`
+	Size    int64       `json:"size,omitempty"`
+	SHA256  string      `json:"sha256,omitempty"`
+	// Link is the target of a symbolic link
+	Link string `json:"link,omitempty"`
+	// HardLink names the entry holding the content of a further name of a file
+	HardLink string `json:"hardLink,omitempty"`
+	// Chunks are the hashes of the content of a file, in order
+	Chunks []string `json:"chunks,omitempty"`
+}
+
+// ChunkStats describe the content of a chunk store
+type ChunkStats struct {
+	// Chunks counts the chunks stored, taking Size bytes
+	Chunks int
+	Size   int64
+	// Referenced is the content size of all snapshots, chunks counted once per use
+	Referenced int64
+}
+
+// Ratio returns how many bytes of content each stored byte holds, 1 for an empty store
+func (s ChunkStats) Ratio() float64 {
+	if s.Size <= 0 {
+		return 1
+	}
+	return float64(s.Referenced) / float64(s.Size)
+}
+
+// SnapshotPath returns the snapshot name of a movie path
+func SnapshotPath(name string) string {
+	return name + "." + SnapshotExtension
+}
+
+// IsSnapshot reports whether name is a snapshot
+func IsSnapshot(name string) bool {
+	return strings.HasSuffix(name, "."+SnapshotExtension)
+}
+
+// chunkPath returns the name of the chunk with hex SHA-256 hash
+func chunkPath(hash string) string {
+	return DedupDir + "/" + hash[:2] + "/" + hash
+}
+
+// OpenChunkStore opens the chunk store of store, created with sizes (default
+// DefaultChunkSizes) if it does not exist yet. An existing store keeps the
+// sizes it was created with, so the same content keeps the same chunks.
+func OpenChunkStore(store storage.Storage, sizes *ChunkSizes) (*ChunkStore, error) {
+	chunks := &ChunkStore{store: store}
+	info, err := store.Stat(chunkIndexName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk index: %w", err)
+	}
+	if info == nil {
+		chunks.index.Sizes = DefaultChunkSizes()
+		if sizes != nil {
+			chunks.index.Sizes = *sizes
+		}
+	} else {
+		data, err := storage.ReadFile(store, chunkIndexName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chunk index: %w", err)
+		}
+		if err := json.Unmarshal(data, &chunks.index); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", chunkIndexName, err)
+		}
+	}
+	if err := chunks.index.Sizes.validate(); err != nil {
+		return nil, err
+	}
+	if chunks.index.Chunks == nil {
+		chunks.index.Chunks = make(map[string]*chunkRef)
+	}
+	return chunks, nil
+}
+
+// HasChunkStore reports whether store holds a chunk store
+func HasChunkStore(store storage.Storage) (bool, error) {
+	info, err := store.Stat(chunkIndexName)
+	if err != nil {
+		return false, fmt.Errorf("failed to read chunk index: %w", err)
+	}
+	return info != nil, nil
+}
+
+// Sizes returns the chunk sizes of the store
+func (c *ChunkStore) Sizes() ChunkSizes {
+	return c.index.Sizes
+}
+
+// Stats returns the number and size of the chunks stored and the content they hold
+func (c *ChunkStore) Stats() ChunkStats {
+	var stats ChunkStats
+	for _, ref := range c.index.Chunks {
+		stats.Chunks++
+		stats.Size += ref.storedSize()
+		stats.Referenced += ref.Size * int64(ref.Refs)
+	}
+	return stats
+}
+
+// saveIndex writes the index of the store
+func (c *ChunkStore) saveIndex() error {
+	data, err := json.Marshal(c.index)
+	if err != nil {
+		return fmt.Errorf("failed to encode chunk index: %w", err)
+	}
+	if err := storage.WriteFile(c.store, chunkIndexName, data); err != nil {
+		return fmt.Errorf("failed to write chunk index: %w", err)
+	}
+	return nil
+}
+
+// Put stores the file or directory name of source as the snapshot named
+// snapshot, writing the chunks the store does not hold yet, and returns the
+// snapshot. opts select the files stored and how links are handled as for
+// archives (see CompressOptions), and may embed metadata and throttle or
+// report progress. Chunks are compressed at the level opts select for their
+// file as in archives, encryption is refused. A previous snapshot of the
+// same name is replaced and its chunks released. A failed Put removes the
+// chunks it wrote and the references it added.
+//
+// Example: chunks.Put("a/movie.snapshot.json", source, "a/movie", nil)
+func (c *ChunkStore) Put(snapshot string, source storage.Storage, name string,
+	opts *CompressOptions) (*Snapshot, error) {
+	if name == "" {
+		return nil, fmt.Errorf("source path cannot be empty")
+	}
+	if !IsSnapshot(snapshot) {
+		return nil, fmt.Errorf("snapshot name %q must end with .%s", snapshot, SnapshotExtension)
+	}
+	if opts != nil && opts.Encryption != nil {
+		return nil, fmt.Errorf("chunk stores cannot encrypt their content")
+	}
+	sourceInfo, err := statSource(source, name, name)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := ReadSnapshot(c.store, snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	walker, err := collectArchive(source, sourceInfo, opts)
+	if err != nil {
+		return nil, err
+	}
+	result := &Snapshot{
+		Source:      name,
+		CreatedAt:   walker.manifest.CreatedAt,
+		Skipped:     walker.manifest.Skipped,
+		SkippedSize: walker.manifest.SkippedSize,
+		Warnings:    walker.manifest.Warnings,
+		Metadata:    walker.manifest.Metadata,
+	}
+
+	// Chunks are written and counted before the snapshot referencing them
+	stored := newHashingWriter(io.Discard)
+	progress := newProgressTracker(opts, walker.contentSize(), stored)
+	refs := make(map[string]*chunkRef)
+	for _, walked := range walker.entries {
+		entry, err := c.putEntry(walker, walked, opts, refs, stored, progress)
+		if err != nil {
+			c.removeChunks(c.unindexed(refs))
+			return nil, fmt.Errorf("failed to store %s: %w", walked.name, err)
+		}
+		result.Entries = append(result.Entries, entry)
+		result.Size += entry.Size
+	}
+	result.StoredSize = stored.size
+	written := c.unindexed(refs)
+	for hash, ref := range refs {
+		result.Chunks += ref.Refs
+		if c.index.Chunks[hash] == nil {
+			c.index.Chunks[hash] = &chunkRef{Size: ref.Size, Stored: ref.Stored}
+		}
+		c.index.Chunks[hash].Refs += ref.Refs
+	}
+	result.NewChunks = len(written)
+	if err := c.saveIndex(); err != nil {
+		c.undoPut(refs, written)
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err == nil {
+		if err = storage.WriteFile(c.store, snapshot, data); err != nil {
+			err = fmt.Errorf("failed to write snapshot: %w", err)
+		}
+	} else {
+		err = fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	if err != nil {
+		c.undoPut(refs, written)
+		return nil, err
+	}
+	progress.finish()
+
+	if previous != nil {
+		if _, err := c.release(previous); err != nil {
+			return nil, fmt.Errorf("failed to release the previous snapshot: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// PutSize returns the content size Put of name in source as snapshot would
+// chunk: the files that are not in the previous version of snapshot with
+// the same size and modification time. Chunks of those files other
+// snapshots hold already are not looked up, so Put may store less.
+func (c *ChunkStore) PutSize(snapshot string, source storage.Storage, name string,
+	opts *CompressOptions) (int64, error) {
+	sourceInfo, err := statSource(source, name, name)
+	if err != nil {
+		return 0, err
+	}
+	walker, err := collectArchive(source, sourceInfo, opts)
+	if err != nil {
+		return 0, err
+	}
+	previous, err := ReadSnapshot(c.store, snapshot)
+	if err != nil || previous == nil {
+		return walker.contentSize(), err
+	}
+
+	stored := make(map[string]SnapshotEntry, len(previous.Entries))
+	for _, entry := range previous.Entries {
+		stored[entry.Name] = entry
+	}
+	var changed []string
+	for _, walked := range walker.entries {
+		if !walked.file.Mode.IsRegular() || walked.hardLink != "" {
+			continue
+		}
+		entry, ok := stored[walked.name]
+		if !ok || entry.Mode != walked.file.Mode || entry.Size != walked.file.Size ||
+			!entry.ModTime.Truncate(time.Second).Equal(walked.file.ModTime.Truncate(time.Second)) {
+			changed = append(changed, walked.name)
+		}
+	}
+	walker.keepOnly(changed)
+	return walker.contentSize(), nil
+}
+
+// unindexed returns the chunks of refs the index does not hold, those Put
+// wrote before it adds refs to the index
+func (c *ChunkStore) unindexed(refs map[string]*chunkRef) []string {
+	var hashes []string
+	for hash := range refs {
+		if c.index.Chunks[hash] == nil {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes
+}
+
+// undoPut drops the references refs added to the index and removes the
+// chunks written, those refs added to it, once the index is saved without
+// them. A failure leaves references and chunks behind for Sweep.
+func (c *ChunkStore) undoPut(refs map[string]*chunkRef, written []string) {
+	for hash, ref := range refs {
+		if indexed := c.index.Chunks[hash]; indexed != nil {
+			indexed.Refs -= ref.Refs
+		}
+	}
+	for _, hash := range written {
+		delete(c.index.Chunks, hash)
+	}
+	if c.saveIndex() == nil {
+		c.removeChunks(written)
+	}
+}
+
+// removeChunks removes the chunks with hashes that are not indexed, as far
+// as possible: chunks left behind are removed by Sweep
+func (c *ChunkStore) removeChunks(hashes []string) {
+	for _, hash := range hashes {
+		removeIfExists(c.store, chunkPath(hash))
+	}
+}
+
+// putEntry describes the walked entry, storing the chunks of a file content
+// that are neither in the store nor in refs, the chunks of this snapshot
+func (c *ChunkStore) putEntry(walker *archiveWalker, walked walkEntry, opts *CompressOptions,
+	refs map[string]*chunkRef, stored *hashingWriter, progress *progressTracker) (SnapshotEntry, error) {
+	file := walked.file
+	entry := SnapshotEntry{Name: walked.name, Mode: file.Mode, ModTime: file.ModTime, HardLink: walked.hardLink}
+	if file.IsDir {
+		entry.Mode |= fs.ModeDir
+	}
+	if file.Mode&fs.ModeSymlink != 0 {
+		entry.Link = file.Link
+	}
+	if !file.Mode.IsRegular() || walked.hardLink != "" {
+		return entry, nil
+	}
+
+	reader, err := walker.source.Open(file.Name)
+	if err != nil {
+		return entry, err
+	}
+	defer reader.Close()
+
+	level, err := chunkLevel(walker, walked, opts)
+	if err != nil {
+		return entry, err
+	}
+	progress.startFile(walked.name)
+	hash := sha256.New()
+	chunker := newChunker(io.TeeReader(walker.reads.wrap(reader), io.MultiWriter(hash, progress)), c.index.Sizes)
+	for {
+		chunk, err := chunker.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return entry, err
+		}
+		sum := sha256.Sum256(chunk)
+		chunkHash := hex.EncodeToString(sum[:])
+		if refs[chunkHash] == nil {
+			refs[chunkHash] = &chunkRef{Size: int64(len(chunk))}
+			if c.index.Chunks[chunkHash] == nil {
+				data, err := c.writeChunk(chunkHash, chunk, level, opts)
+				if err != nil {
+					return entry, err
+				}
+				refs[chunkHash].Stored = int64(len(data))
+				stored.Write(data)
+			}
+		}
+		refs[chunkHash].Refs++
+		entry.Chunks = append(entry.Chunks, chunkHash)
+		entry.Size += int64(len(chunk))
+	}
+	if entry.Size != file.Size {
+		return entry, fmt.Errorf("incomplete copy of %s: got %d bytes, expected %d", file.Name, entry.Size, file.Size)
+	}
+	entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return entry, nil
+}
+
+// chunkLevel returns the gzip level of the chunks of the walked file, as
+// its policy in an archive written with opts would be
+func chunkLevel(walker *archiveWalker, walked walkEntry, opts *CompressOptions) (int, error) {
+	if opts == nil {
+		return getCompressionLevel(nil), nil
+	}
+	if policy := matchPolicy(opts.Policies, strings.TrimPrefix(walked.name, walker.root+"/")); policy != nil {
+		return policy.Level, nil
+	}
+	if opts.SampleEntropy {
+		incompressible, err := isIncompressible(walker.source, walked.file)
+		if err != nil || incompressible {
+			return gzip.NoCompression, err
+		}
+	}
+	return getCompressionLevel(opts), nil
+}
+
+// writeChunk stores the chunk with hash, gzipped at level if that makes it
+// smaller, throttled as opts ask, and returns the bytes stored
+func (c *ChunkStore) writeChunk(hash string, chunk []byte, level int, opts *CompressOptions) ([]byte, error) {
+	data := chunk
+	if level != gzip.NoCompression {
+		var compressed bytes.Buffer
+		gz, err := gzip.NewWriterLevel(&compressed, level)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create gzip writer: %w", err)
+		}
+		gz.Write(chunk)
+		if err := gz.Close(); err != nil {
+			return nil, fmt.Errorf("failed to compress chunk %s: %w", hash, err)
+		}
+		if compressed.Len() < len(chunk) {
+			data = compressed.Bytes()
+		}
+	}
+
+	writer, err := c.store.Create(chunkPath(hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chunk %s: %w", hash, err)
+	}
+	if _, err := newThrottledWriter(writer, opts).Write(data); err != nil {
+		writer.Abort()
+		return nil, fmt.Errorf("failed to write chunk %s: %w", hash, err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write chunk %s: %w", hash, err)
+	}
+	return data, nil
+}
+
+// ReadSnapshot loads the snapshot name of store.
+// Returns (nil, nil) if there is none.
+func ReadSnapshot(store storage.Storage, name string) (*Snapshot, error) {
+	info, err := store.Stat(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	if info == nil {
+		return nil, nil
+	}
+	data, err := storage.ReadFile(store, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot %s: %w", name, err)
+	}
+	return &snapshot, nil
+}
+
+// FindSnapshots returns the snapshots below dir in store, sorted by name
+func FindSnapshots(store storage.Storage, dir string) ([]string, error) {
+	files, err := storage.Walk(context.Background(), store, dir, storage.WalkOptions{NamesOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []string
+	for _, file := range storage.Files(files) {
+		if IsSnapshot(file.Name) && !strings.HasPrefix(file.Name, DedupDir+"/") {
+			snapshots = append(snapshots, file.Name)
+		}
+	}
+	sort.Strings(snapshots)
+	return snapshots, nil
+}
+
+// Remove deletes the snapshot name and the chunks no other snapshot
+// references, and returns the bytes of chunks freed. The snapshot may have
+// been moved since it was stored, e.g. to a trash directory.
+func (c *ChunkStore) Remove(name string) (int64, error) {
+	snapshot, err := ReadSnapshot(c.store, name)
+	if err != nil {
+		return 0, err
+	}
+	if snapshot == nil {
+		return 0, fmt.Errorf("snapshot %s does not exist", name)
+	}
+	if err := c.store.Remove(name); err != nil {
+		return 0, err
+	}
+	return c.release(snapshot)
+}
+
+// release drops the references of snapshot, then removes the chunks left
+// unreferenced, and returns their size
+func (c *ChunkStore) release(snapshot *Snapshot) (int64, error) {
+	var unused []string
+	var freed int64
+	for _, entry := range snapshot.Entries {
+		for _, hash := range entry.Chunks {
+			ref := c.index.Chunks[hash]
+			if ref == nil {
+				continue
+			}
+			if ref.Refs--; ref.Refs <= 0 {
+				delete(c.index.Chunks, hash)
+				unused = append(unused, hash)
+				freed += ref.storedSize()
+			}
+		}
+	}
+	// An interruption past this point leaves unreferenced chunks, which
+	// are written again if needed
+	if err := c.saveIndex(); err != nil {
+		return 0, err
+	}
+	for _, hash := range unused {
+		if err := c.store.Remove(chunkPath(hash)); err != nil {
+			return freed, fmt.Errorf("failed to remove chunk %s: %w", hash, err)
+		}
+	}
+	return freed, nil
+}
+
+// Sweep recounts the references to chunks of every snapshot in the storage,
+// trashed ones included, and removes the chunks none references, e.g. those
+// left by an interrupted Put, and returns the bytes freed.
+func (c *ChunkStore) Sweep() (int64, error) {
+	snapshots, err := FindSnapshots(c.store, "")
+	if err != nil {
+		return 0, err
+	}
+	counts := make(map[string]int)
+	for _, name := range snapshots {
+		snapshot, err := ReadSnapshot(c.store, name)
+		if err != nil {
+			return 0, err
+		}
+		for _, entry := range snapshot.Entries {
+			for _, hash := range entry.Chunks {
+				counts[hash]++
+			}
+		}
+	}
+	files, err := storage.Walk(context.Background(), c.store, DedupDir, storage.WalkOptions{})
+	if err != nil {
+		return 0, err
+	}
+
+	var unused []string
+	var freed int64
+	for hash, ref := range c.index.Chunks {
+		if ref.Refs = counts[hash]; ref.Refs == 0 {
+			delete(c.index.Chunks, hash)
+			unused = append(unused, hash)
+			freed += ref.storedSize()
+		}
+	}
+	for _, file := range storage.Files(files) {
+		hash := path.Base(file.Name)
+		if len(hash) != 2*sha256.Size || file.Name != chunkPath(hash) || c.index.Chunks[hash] != nil ||
+			slices.Contains(unused, hash) {
+			continue
+		}
+		if counts[hash] > 0 {
+			// Referenced but missing from the index, counted at its stored size
+			c.index.Chunks[hash] = &chunkRef{Size: file.Size, Refs: counts[hash], Stored: file.Size}
+			continue
+		}
+		unused = append(unused, hash)
+		freed += file.Size
+	}
+	if err := c.saveIndex(); err != nil {
+		return 0, err
+	}
+	for _, hash := range unused {
+		if err := removeIfExists(c.store, chunkPath(hash)); err != nil {
+			return freed, fmt.Errorf("failed to remove chunk %s: %w", hash, err)
+		}
+	}
+	return freed, nil
+}
+
+// Restore writes the files of the snapshot name into the local directory
+// dest, as Extract does for archives, and returns the restored paths. Every
+// chunk is checked against its hash and every file against its checksum.
+//
+// Example: chunks.Restore("a/movie.snapshot.json", "/data/movies", nil)
+func (c *ChunkStore) Restore(name, dest string, opts *ExtractOptions) ([]string, error) {
+	if dest == "" {
+		return nil, fmt.Errorf("destination path cannot be empty")
+	}
+	if opts == nil {
+		opts = &ExtractOptions{}
+	}
+	snapshot, err := ReadSnapshot(c.store, name)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil {
+		return nil, fmt.Errorf("snapshot %s does not exist", name)
+	}
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create destination: %w", err)
+	}
+
+	var restored []string
+	links := make(map[string]bool)
+	dirs := dirTimes{}
+	for _, entry := range snapshot.Entries {
+		target, err := safeJoin(dest, entry.Name)
+		if err != nil {
+			return restored, err
+		}
+		if isLinked(dest, target, links) {
+			return restored, fmt.Errorf("unsafe entry name %q: inside a symbolic link", entry.Name)
+		}
+
+		header := &tar.Header{
+			Name:    entry.Name,
+			Mode:    int64(entry.Mode.Perm()),
+			ModTime: entry.ModTime,
+			Size:    entry.Size,
+		}
+		switch {
+		case entry.Mode.IsDir():
+			if err := extractDir(target, header, opts); err != nil {
+				return restored, err
+			}
+			dirs[target] = entry.ModTime
+		case entry.Mode&fs.ModeSymlink != 0:
+			header.Linkname = entry.Link
+			if err := extractSymlink(target, header, opts); err != nil {
+				return restored, err
+			}
+			links[target] = true
+		case entry.HardLink != "":
+			source, err := safeJoin(dest, entry.HardLink)
+			if err != nil || isLinked(dest, source, links) {
+				return restored, fmt.Errorf("unsafe hard link %q to %q", entry.Name, entry.HardLink)
+			}
+			if err := extractHardLink(source, target, opts); err != nil {
+				return restored, err
+			}
+		case entry.Mode.IsRegular():
+			if err := c.restoreFile(entry, target, header, opts); err != nil {
+				return restored, err
+			}
+		default:
+			return restored, fmt.Errorf("unsupported entry mode %s for %s", entry.Mode, entry.Name)
+		}
+		restored = append(restored, target)
+	}
+
+	if opts.PreserveTimes {
+		for dir, modTime := range dirs {
+			if err := os.Chtimes(dir, modTime, modTime); err != nil {
+				return restored, fmt.Errorf("failed to set times on %s: %w", dir, err)
+			}
+		}
+	}
+	return restored, nil
+}
+
+// restoreFile writes the chunks of entry to target and checks its checksum
+func (c *ChunkStore) restoreFile(entry SnapshotEntry, target string, header *tar.Header, opts *ExtractOptions) error {
+	hash := sha256.New()
+	reader := io.TeeReader(&chunkReader{store: c.store, chunks: entry.Chunks}, hash)
+	if err := extractFile(reader, target, header, opts); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); got != entry.SHA256 {
+		return fmt.Errorf("checksum mismatch for %s: got %s, expected %s", entry.Name, got, entry.SHA256)
+	}
+	return nil
+}
+
+// chunkReader reads the content of chunks in order, each checked against
+// its hash before any of it is returned
+type chunkReader struct {
+	store   storage.Storage
+	chunks  []string
+	current bytes.Reader
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for r.current.Len() == 0 {
+		if len(r.chunks) == 0 {
+			return 0, io.EOF
+		}
+		hash := r.chunks[0]
+		r.chunks = r.chunks[1:]
+		data, err := storage.ReadFile(r.store, chunkPath(hash))
+		if err != nil {
+			return 0, fmt.Errorf("failed to read chunk %s: %w", hash, err)
+		}
+		// A chunk is stored either as is or gzipped, its hash tells which
+		if !hasHash(data, hash) {
+			if data, err = gunzip(data); err != nil || !hasHash(data, hash) {
+				return 0, fmt.Errorf("chunk %s is corrupted", hash)
+			}
+		}
+		r.current.Reset(data)
+	}
+	return r.current.Read(p)
+}
+
+// hasHash reports whether the hex SHA-256 hash of data is hash
+func hasHash(data []byte, hash string) bool {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]) == hash
+}
+
+// gunzip returns the content of the gzip stream data
+func gunzip(data []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(gz)
+}
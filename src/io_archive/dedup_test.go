@@ -0,0 +1,344 @@
+package io_archive
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// testChunkSizes keeps chunks small so test files span many of them
+var testChunkSizes = ChunkSizes{Min: 256, Average: 1024, Max: 4096}
+
+// randomContent returns size pseudo-random bytes, the same for the same seed
+func randomContent(seed uint64, size int) []byte {
+	random := rand.New(rand.NewPCG(seed, seed))
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(random.Uint32())
+	}
+	return data
+}
+
+// splitChunks returns the chunks of data
+func splitChunks(t *testing.T, data []byte, sizes ChunkSizes) [][]byte {
+	t.Helper()
+	var chunks [][]byte
+	chunker := newChunker(bytes.NewReader(data), sizes)
+	for {
+		chunk, err := chunker.next()
+		if errors.Is(err, io.EOF) {
+			return chunks
+		}
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		chunks = append(chunks, bytes.Clone(chunk))
+	}
+}
+
+// dedupMovies returns two editions of a movie sharing their extras
+func dedupMovies(modTime time.Time) *storage.Memory {
+	source := storage.NewMemory()
+	extras := randomContent(3, 20000)
+	source.WriteFile("a/movie/movie.mkv", randomContent(1, 30000), modTime)
+	source.WriteFile("a/movie/extras/making-of.mkv", extras, modTime)
+	source.WriteFile("b/movie/movie.mkv", randomContent(2, 30000), modTime)
+	source.WriteFile("b/movie/extras/making-of.mkv", extras, modTime)
+	return source
+}
+
+// chunkFiles returns the names of the chunks stored in store
+func chunkFiles(t *testing.T, store storage.Storage) []string {
+	t.Helper()
+	files, err := store.List(DedupDir)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var chunks []string
+	for _, file := range storage.Files(files) {
+		if file.Name != chunkIndexName {
+			chunks = append(chunks, file.Name)
+		}
+	}
+	return chunks
+}
+
+func TestChunkerCutsByContent(t *testing.T) {
+	data := randomContent(1, 64<<10)
+	chunks := splitChunks(t, data, testChunkSizes)
+	if joined := bytes.Join(chunks, nil); !bytes.Equal(joined, data) {
+		t.Fatal("Expected the chunks to join into the content")
+	}
+	for index, chunk := range chunks {
+		if len(chunk) > testChunkSizes.Max || (len(chunk) < testChunkSizes.Min && index < len(chunks)-1) {
+			t.Errorf("Chunk %d of %d bytes is out of bounds", index, len(chunk))
+		}
+	}
+	if len(chunks) < 16 || len(chunks) > 256 {
+		t.Errorf("Expected about 64 chunks, got %d", len(chunks))
+	}
+
+	// Bytes inserted at the start only change the first chunks
+	shifted := splitChunks(t, append([]byte("inserted"), data...), testChunkSizes)
+	seen := make(map[string]bool)
+	for _, chunk := range chunks {
+		seen[string(chunk)] = true
+	}
+	shared := 0
+	for _, chunk := range shifted {
+		if seen[string(chunk)] {
+			shared++
+		}
+	}
+	if shared < len(chunks)-2 {
+		t.Errorf("Expected all but the first chunks to be shared, %d of %d are", shared, len(chunks))
+	}
+
+	for _, sizes := range []ChunkSizes{{}, {Min: 512, Average: 256, Max: 1024}, {Min: 1, Average: 1000, Max: 2000}} {
+		if err := sizes.validate(); err == nil {
+			t.Errorf("Expected an error for sizes %+v", sizes)
+		}
+	}
+}
+
+func TestChunkStorePutDeduplicates(t *testing.T) {
+	source := dedupMovies(time.Now())
+	target := storage.NewMemory()
+	chunks, err := OpenChunkStore(target, &testChunkSizes)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	first, err := chunks.Put(SnapshotPath("a/movie"), source, "a/movie", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if first.Size != 50000 || first.StoredSize != first.Size || first.NewChunks != first.Chunks {
+		t.Errorf("Expected every chunk of the first movie to be new, got %+v", first)
+	}
+	second, err := chunks.Put(SnapshotPath("b/movie"), source, "b/movie", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if second.StoredSize > 31000 {
+		t.Errorf("Expected the shared extras to be stored once, %d bytes stored", second.StoredSize)
+	}
+
+	// The index is kept with the chunks
+	reopened, err := OpenChunkStore(target, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	stats := reopened.Stats()
+	if reopened.Sizes() != testChunkSizes || stats.Referenced != 100000 || stats.Ratio() < 1.2 {
+		t.Errorf("Expected the sizes of the store and a ratio above 1.2, got %+v %+v", reopened.Sizes(), stats)
+	}
+	if len(chunkFiles(t, target)) != stats.Chunks {
+		t.Errorf("Expected %d chunk files, got %d", stats.Chunks, len(chunkFiles(t, target)))
+	}
+	if snapshots, _ := FindSnapshots(target, ""); len(snapshots) != 2 {
+		t.Errorf("Expected two snapshots, got %v", snapshots)
+	}
+
+	if _, err := chunks.Put(SnapshotPath("c/movie"), source, "a/movie",
+		&CompressOptions{Encryption: &Encryption{Passphrase: "secret"}}); err == nil {
+		t.Error("Expected an error for encryption")
+	}
+}
+
+func TestChunkStoreRemoveReleasesChunks(t *testing.T) {
+	start := time.Now()
+	source := dedupMovies(start)
+	target := storage.NewMemory()
+	chunks, _ := OpenChunkStore(target, &testChunkSizes)
+	for _, movie := range []string{"a/movie", "b/movie"} {
+		if _, err := chunks.Put(SnapshotPath(movie), source, movie, nil); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	before := chunks.Stats()
+
+	// A new version releases the chunks of the previous one
+	source.WriteFile("a/movie/movie.mkv", randomContent(4, 30000), start.Add(time.Hour))
+	if _, err := chunks.Put(SnapshotPath("a/movie"), source, "a/movie", nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if after := chunks.Stats(); after.Referenced != before.Referenced || len(chunkFiles(t, target)) != after.Chunks {
+		t.Errorf("Expected the previous version to be released, got %+v, was %+v", after, before)
+	}
+
+	freed, err := chunks.Remove(SnapshotPath("a/movie"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if freed < 29000 || freed > 31000 {
+		t.Errorf("Expected the movie but not the shared extras to be freed, freed %d bytes", freed)
+	}
+	dest := t.TempDir()
+	if _, err := chunks.Restore(SnapshotPath("b/movie"), dest, nil); err != nil {
+		t.Fatalf("Expected the other movie to be restored, got %v", err)
+	}
+
+	if _, err := chunks.Remove(SnapshotPath("b/movie")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if stats := chunks.Stats(); stats.Chunks != 0 || len(chunkFiles(t, target)) != 0 {
+		t.Errorf("Expected an empty store, got %+v and files %v", stats, chunkFiles(t, target))
+	}
+}
+
+func TestChunkStoreRestore(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	source := dedupMovies(modTime)
+	source.WriteFile("a/movie/movie.en.srt", []byte("english"), modTime)
+	target := storage.NewMemory()
+	chunks, _ := OpenChunkStore(target, &testChunkSizes)
+	snapshot, err := chunks.Put(SnapshotPath("a/movie"), source, "a/movie",
+		&CompressOptions{Exclude: []string{"*.srt"}, Metadata: &MovieMetadata{TmdbID: 603}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if snapshot.Skipped != 1 || snapshot.Metadata == nil || snapshot.Metadata.TmdbID != 603 {
+		t.Errorf("Expected the subtitle skipped and the metadata kept, got %+v", snapshot)
+	}
+
+	dest := t.TempDir()
+	if _, err := chunks.Restore(SnapshotPath("a/movie"), dest, &ExtractOptions{PreserveTimes: true}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	movie, _ := storage.ReadFile(source, "a/movie/movie.mkv")
+	extras, _ := storage.ReadFile(source, "a/movie/extras/making-of.mkv")
+	expected := map[string]string{"movie/movie.mkv": string(movie), "movie/extras/making-of.mkv": string(extras)}
+	if got := readTree(t, dest); !maps.Equal(got, expected) {
+		t.Errorf("Expected the movie and its extras to be restored, got %d files", len(got))
+	}
+	if _, err := chunks.Restore(SnapshotPath("a/movie"), dest, nil); err == nil ||
+		!strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Expected existing files to be kept, got %v", err)
+	}
+
+	// A corrupted chunk is detected
+	corrupted := chunkPath(snapshot.Entries[len(snapshot.Entries)-1].Chunks[0])
+	target.WriteFile(corrupted, []byte("corrupted"), modTime)
+	if _, err := chunks.Restore(SnapshotPath("a/movie"), t.TempDir(), nil); err == nil ||
+		!strings.Contains(err.Error(), "corrupted") {
+		t.Errorf("Expected a corrupted chunk error, got %v", err)
+	}
+}
+
+func TestChunkStoreCompressesChunks(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	subtitles := strings.Repeat("1\n00:00:01,000 --> 00:00:02,000\nHello there\n\n", 500)
+	source := storage.NewMemory()
+	source.WriteFile("a/movie/movie.en.srt", []byte(subtitles), modTime)
+	source.WriteFile("b/movie/movie.en.srt", []byte(subtitles+"the end\n"), modTime)
+	target := storage.NewMemory()
+	chunks, _ := OpenChunkStore(target, &testChunkSizes)
+
+	snapshot, err := chunks.Put(SnapshotPath("a/movie"), source, "a/movie", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if snapshot.StoredSize*4 > snapshot.Size {
+		t.Errorf("Expected the subtitle chunks to be compressed, %d of %d bytes stored", snapshot.StoredSize, snapshot.Size)
+	}
+	if stats := chunks.Stats(); stats.Size != snapshot.StoredSize {
+		t.Errorf("Expected the store to count compressed sizes, got %+v", stats)
+	}
+
+	// A policy storing subtitles uncompressed applies to their chunks
+	opts := &CompressOptions{Policies: []CompressionPolicy{{Name: "text", Patterns: []string{"*.srt"}}}}
+	snapshot, err = chunks.Put(SnapshotPath("b/movie"), source, "b/movie", opts)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	entry := snapshot.Entries[len(snapshot.Entries)-1]
+	if last := chunks.index.Chunks[entry.Chunks[len(entry.Chunks)-1]]; snapshot.NewChunks == 0 || last.Stored != last.Size {
+		t.Errorf("Expected the new chunks to be stored as is, got %+v", snapshot)
+	}
+
+	for _, movie := range []string{"a/movie", "b/movie"} {
+		dest := t.TempDir()
+		if _, err := chunks.Restore(SnapshotPath(movie), dest, nil); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		content, _ := storage.ReadFile(source, movie+"/movie.en.srt")
+		if got := readTree(t, dest); got["movie/movie.en.srt"] != string(content) {
+			t.Errorf("Expected the subtitle of %s to be restored, got %d files", movie, len(got))
+		}
+	}
+}
+
+// snapshotFailure fails creating snapshots
+type snapshotFailure struct {
+	*storage.Memory
+}
+
+func (s *snapshotFailure) Create(name string) (storage.Writer, error) {
+	if IsSnapshot(name) {
+		return nil, errors.New("disk full")
+	}
+	return s.Memory.Create(name)
+}
+
+func TestChunkStorePutFailureRollsBack(t *testing.T) {
+	source := dedupMovies(time.Now())
+	target := storage.NewMemory()
+	chunks, _ := OpenChunkStore(target, &testChunkSizes)
+	if _, err := chunks.Put(SnapshotPath("a/movie"), source, "a/movie", nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	before := chunks.Stats()
+
+	failing, _ := OpenChunkStore(&snapshotFailure{Memory: target}, nil)
+	if _, err := failing.Put(SnapshotPath("b/movie"), source, "b/movie", nil); err == nil {
+		t.Fatal("Expected the snapshot write to fail")
+	}
+	reopened, _ := OpenChunkStore(target, nil)
+	if after := reopened.Stats(); after != before || len(chunkFiles(t, target)) != before.Chunks {
+		t.Errorf("Expected the references and chunks of the failed snapshot to be dropped, got %+v, was %+v",
+			after, before)
+	}
+}
+
+func TestChunkStoreSweep(t *testing.T) {
+	source := dedupMovies(time.Now())
+	target := storage.NewMemory()
+	chunks, _ := OpenChunkStore(target, &testChunkSizes)
+	snapshot, err := chunks.Put(SnapshotPath("a/movie"), source, "a/movie", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	before := chunks.Stats()
+
+	// An interrupted Put leaves a chunk nothing references and a reference
+	// of a snapshot never written
+	orphan := []byte("orphan chunk")
+	sum := sha256.Sum256(orphan)
+	target.WriteFile(chunkPath(hex.EncodeToString(sum[:])), orphan, time.Now())
+	chunks.index.Chunks[snapshot.Entries[len(snapshot.Entries)-1].Chunks[0]].Refs++
+
+	freed, err := chunks.Sweep()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if freed != int64(len(orphan)) {
+		t.Errorf("Expected the orphaned chunk to be freed, freed %d bytes", freed)
+	}
+	reopened, _ := OpenChunkStore(target, nil)
+	if after := reopened.Stats(); after != before || len(chunkFiles(t, target)) != before.Chunks {
+		t.Errorf("Expected the references to be recounted, got %+v, was %+v", after, before)
+	}
+	if _, err := chunks.Restore(SnapshotPath("a/movie"), t.TempDir(), nil); err != nil {
+		t.Errorf("Expected the snapshot to be restored, got %v", err)
+	}
+}
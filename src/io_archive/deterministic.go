@@ -0,0 +1,71 @@
+package io_archive
+
+import (
+	"archive/tar"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Deterministic makes archives of identical sources byte-identical, wherever
+// and whenever they are written, so their checksums can be compared and
+// backup storage can deduplicate them. Encrypted archives still differ, as
+// each one uses a random salt and nonce.
+//
+// Entries are sorted by name and stored in PAX format with no owner,
+// whatever the source reports. gzip headers never record a name or time.
+type Deterministic struct {
+	// ModTime is the modification time of every entry. Zero keeps the
+	// modification time of each file, in whole seconds.
+	ModTime time.Time
+}
+
+// header normalizes header for a reproducible archive
+func (d *Deterministic) header(header *tar.Header) {
+	// PAX records hold names that are too long or not ASCII the same way
+	// every time, instead of depending on which format fits
+	header.Format = tar.FormatPAX
+	header.Uid, header.Gid = 0, 0
+	header.Uname, header.Gname = "", ""
+	header.Devmajor, header.Devminor = 0, 0
+	header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+	header.PAXRecords = nil
+	if d.ModTime.IsZero() {
+		header.ModTime = header.ModTime.Truncate(time.Second)
+	} else {
+		header.ModTime = d.ModTime.Truncate(time.Second)
+	}
+}
+
+// sortEntries orders entries like a directory walk, independently of the
+// order the source lists them in, and moves the content of hard-linked files
+// to the first of their names in the new order
+func sortEntries(entries []walkEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return walkOrder(entries[i].name) < walkOrder(entries[j].name)
+	})
+	linkDuplicates(entries)
+}
+
+// linkDuplicates stores the content of each file in the first entry naming
+// it, later names becoming hard links to it
+func linkDuplicates(entries []walkEntry) {
+	first := make(map[string]string)
+	for i := range entries {
+		entry := &entries[i]
+		if !entry.file.Mode.IsRegular() || entry.file.ID == "" {
+			continue
+		}
+		if name, ok := first[entry.file.ID]; ok {
+			entry.hardLink = name
+		} else {
+			first[entry.file.ID] = entry.name
+			entry.hardLink = ""
+		}
+	}
+}
+
+// walkOrder returns the sort key of an entry name: "a", "a/b", "a.txt"
+func walkOrder(name string) string {
+	return strings.ReplaceAll(name, "/", "\x00")
+}
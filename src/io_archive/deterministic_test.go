@@ -0,0 +1,131 @@
+package io_archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// reversedStorage lists its files in reverse order, like a store without sorted listings
+type reversedStorage struct {
+	*storage.Memory
+}
+
+func (s reversedStorage) List(dir string) ([]storage.File, error) {
+	files, err := s.Memory.List(dir)
+	slices.Reverse(files)
+	return files, err
+}
+
+// deterministicSource returns a movie directory whose files have modTime
+func deterministicSource(modTime time.Time) *storage.Memory {
+	source := storage.NewMemory()
+	source.WriteFile("movie/movie.mkv", []byte("video content"), modTime)
+	source.WriteFile("movie/extras/trailer.mkv", []byte("trailer"), modTime)
+	source.WriteFile("movie/Amélie, o Fabuloso Destino de Amélie Poulain - Versão Estendida do Diretor (2001).srt",
+		[]byte("subtitle"), modTime)
+	return source
+}
+
+func TestCompressDeterministicIsReproducible(t *testing.T) {
+	fixed := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	opts := &CompressOptions{Deterministic: &Deterministic{ModTime: fixed}}
+
+	var first, second bytes.Buffer
+	if _, err := CompressFrom(&first, deterministicSource(time.Now()), "movie", opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	later := reversedStorage{deterministicSource(time.Now().Add(time.Hour))}
+	if _, err := CompressFrom(&second, later, "movie", opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Fatal("Expected identical archives for identical sources")
+	}
+
+	// gzip MTIME, bytes 4 to 7 of the header, is zero
+	if mtime := first.Bytes()[4:8]; !bytes.Equal(mtime, make([]byte, 4)) {
+		t.Errorf("Expected zero gzip timestamp, got %v", mtime)
+	}
+
+	gz, err := gzip.NewReader(&first)
+	if err != nil {
+		t.Fatalf("Failed to read gzip stream: %v", err)
+	}
+	reader := tar.NewReader(gz)
+	var names []string
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		names = append(names, header.Name)
+		if !header.ModTime.Equal(fixed) || header.Uid != 0 || header.Gid != 0 || header.Uname != "" {
+			t.Errorf("Expected normalized header for %s, got %+v", header.Name, header)
+		}
+		if strings.HasSuffix(header.Name, ".srt") && header.PAXRecords["path"] != header.Name {
+			t.Errorf("Expected a PAX path record for the unicode name, got %v", header.PAXRecords)
+		}
+	}
+	expected := []string{
+		"movie",
+		"movie/Amélie, o Fabuloso Destino de Amélie Poulain - Versão Estendida do Diretor (2001).srt",
+		"movie/extras",
+		"movie/extras/trailer.mkv",
+		"movie/movie.mkv",
+	}
+	if !slices.Equal(names, expected) {
+		t.Errorf("Expected sorted entries %v, got %v", expected, names)
+	}
+}
+
+func TestCompressDeterministicKeepsFileTimes(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 600, time.UTC)
+	var buf bytes.Buffer
+	opts := &CompressOptions{Deterministic: &Deterministic{}}
+	if _, err := CompressFrom(&buf, deterministicSource(modTime), "movie/movie.mkv", opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("Failed to read gzip stream: %v", err)
+	}
+	header, err := tar.NewReader(gz).Next()
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	if !header.ModTime.Equal(modTime.Truncate(time.Second)) {
+		t.Errorf("Expected file time in whole seconds, got %s", header.ModTime)
+	}
+	if len(header.PAXRecords) != 0 {
+		t.Errorf("Expected no PAX records for a short ASCII name, got %v", header.PAXRecords)
+	}
+}
+
+func TestSortEntriesMovesHardLinkContent(t *testing.T) {
+	file := storage.File{Mode: 0o644, ID: "1:2"}
+	entries := []walkEntry{
+		{file: storage.File{IsDir: true, Mode: 0o755}, name: "movie"},
+		{file: file, name: "movie/movie.mkv"},
+		{file: file, name: "movie/library.mkv", hardLink: "movie/movie.mkv"},
+	}
+	sortEntries(entries)
+
+	if entries[1].name != "movie/library.mkv" || entries[1].hardLink != "" {
+		t.Errorf("Expected the first name to hold the content, got %+v", entries[1])
+	}
+	if entries[2].name != "movie/movie.mkv" || entries[2].hardLink != "movie/library.mkv" {
+		t.Errorf("Expected a hard link to the first name, got %+v", entries[2])
+	}
+}
@@ -0,0 +1,570 @@
+package io_archive
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// EncryptedExtension is the extension of archives encrypted with Encrypt
+const EncryptedExtension = Extension + ".enc"
+
+// EncryptedCodec names the format of encrypted archives
+const EncryptedCodec = Codec + "+aes-256-gcm"
+
+// Key string prefixes, see GenerateIdentity
+const (
+	identityPrefix  = "RSG-SECRET-KEY-"
+	recipientPrefix = "rsg-pub-"
+)
+
+// Encrypted stream layout:
+//
+//	radarr-sync-go/encrypted/v1
+//	-> scrypt <salt> <log2 N>
+//	<wrapped file key>
+//	-> x25519 <ephemeral public key>
+//	<wrapped file key>
+//	--- <header HMAC>
+//	<16 byte nonce><payload chunks>
+//
+// A random file key is wrapped with AES-GCM once per passphrase or recipient.
+// The payload is cut into chunks of encryptChunkSize bytes, each sealed with
+// AES-256-GCM under a key derived from the file key and nonce. Chunk nonces
+// hold a counter and a final chunk flag, so reordered, dropped or truncated
+// chunks fail authentication.
+const (
+	encryptMagic     = "radarr-sync-go/encrypted/v1"
+	encryptChunkSize = 64 * 1024
+	fileKeySize      = 16
+	streamNonceSize  = 16
+	maxScryptLogN    = 22
+)
+
+// scryptLogN is the scrypt work factor for new passphrase stanzas
+var scryptLogN = 18
+
+// ErrEncrypted is returned when an encrypted archive is read without keys
+var ErrEncrypted = errors.New("archive is encrypted, a passphrase or identity is required")
+
+// ErrNoMatchingKey is returned when no passphrase or identity opens an archive
+var ErrNoMatchingKey = errors.New("no passphrase or identity matches the archive")
+
+// Encryption selects who can decrypt an archive: anyone knowing Passphrase
+// or holding the identity of one of Recipients. At least one must be set.
+type Encryption struct {
+	Passphrase string
+	// Recipients are public keys as printed by GenerateIdentity
+	Recipients []string
+}
+
+// Decryption holds the secrets tried to open encrypted archives
+type Decryption struct {
+	Passphrase string
+	// Identities are secret keys as returned by GenerateIdentity
+	Identities []string
+}
+
+// IsEncrypted reports whether name is an encrypted archive
+func IsEncrypted(name string) bool {
+	return strings.HasSuffix(name, "."+EncryptedExtension)
+}
+
+// TrimExtension returns name without its plain or encrypted archive
+// extension, and whether it had one.
+func TrimExtension(name string) (string, bool) {
+	if trimmed, ok := strings.CutSuffix(name, "."+EncryptedExtension); ok {
+		return trimmed, true
+	}
+	return strings.CutSuffix(name, "."+Extension)
+}
+
+// ArchiveExtension returns the extension of archives written with opts
+func ArchiveExtension(opts *CompressOptions) string {
+	if opts != nil && opts.Encryption != nil {
+		return EncryptedExtension
+	}
+	return Extension
+}
+
+// GenerateIdentity creates an X25519 key pair for recipient encryption.
+// The identity is secret and decrypts archives encrypted to the recipient.
+func GenerateIdentity() (identity, recipient string, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	identity = identityPrefix + base64.RawURLEncoding.EncodeToString(key.Bytes())
+	recipient = recipientPrefix + base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes())
+	return identity, recipient, nil
+}
+
+// RecipientOf returns the public recipient key of identity
+func RecipientOf(identity string) (string, error) {
+	key, err := parseIdentity(identity)
+	if err != nil {
+		return "", err
+	}
+	return recipientPrefix + base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// ReadKeys reads one identity or recipient per line from path,
+// ignoring blank lines and lines starting with #.
+func ReadKeys(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys: %w", err)
+	}
+	var keys []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys found in %s", path)
+	}
+	return keys, nil
+}
+
+func parseIdentity(identity string) (*ecdh.PrivateKey, error) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(identity), identityPrefix)
+	if !ok {
+		return nil, fmt.Errorf("invalid identity: missing %s prefix", identityPrefix)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity: %w", err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity: %w", err)
+	}
+	return key, nil
+}
+
+func parseRecipient(recipient string) (*ecdh.PublicKey, error) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(recipient), recipientPrefix)
+	if !ok {
+		return nil, fmt.Errorf("invalid recipient %q: missing %s prefix", recipient, recipientPrefix)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient %q: %w", recipient, err)
+	}
+	key, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient %q: %w", recipient, err)
+	}
+	return key, nil
+}
+
+// Encrypt writes the encryption header to w and returns a writer encrypting
+// into w. Close must be called to write the final chunk; w is not closed.
+func Encrypt(w io.Writer, enc *Encryption) (io.WriteCloser, error) {
+	if enc == nil || (enc.Passphrase == "" && len(enc.Recipients) == 0) {
+		return nil, fmt.Errorf("encryption requires a passphrase or recipients")
+	}
+
+	fileKey := make([]byte, fileKeySize)
+	rand.Read(fileKey)
+
+	var header bytes.Buffer
+	header.WriteString(encryptMagic + "\n")
+	if enc.Passphrase != "" {
+		salt := make([]byte, 16)
+		rand.Read(salt)
+		wrapKey, err := scryptKey(enc.Passphrase, salt, scryptLogN)
+		if err != nil {
+			return nil, err
+		}
+		writeStanza(&header, "scrypt", []string{encodeBase64(salt), strconv.Itoa(scryptLogN)}, wrapKey, fileKey)
+	}
+	for _, recipient := range enc.Recipients {
+		public, err := parseRecipient(recipient)
+		if err != nil {
+			return nil, err
+		}
+		ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate key: %w", err)
+		}
+		wrapKey, err := x25519Key(ephemeral, public, ephemeral.PublicKey(), public)
+		if err != nil {
+			return nil, err
+		}
+		writeStanza(&header, "x25519", []string{encodeBase64(ephemeral.PublicKey().Bytes())}, wrapKey, fileKey)
+	}
+	header.WriteString("---")
+	mac, err := headerMAC(fileKey, header.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	header.WriteString(" " + encodeBase64(mac) + "\n")
+
+	nonce := make([]byte, streamNonceSize)
+	rand.Read(nonce)
+	aead, err := payloadCipher(fileKey, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(nonce); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, buf: make([]byte, 0, encryptChunkSize)}, nil
+}
+
+// Decrypt reads the encryption header from r and returns a reader of the
+// plaintext. Reading fails if the stream was modified or truncated.
+// Returns ErrEncrypted without keys and ErrNoMatchingKey if none fits.
+func Decrypt(r io.Reader, dec *Decryption) (io.Reader, error) {
+	if dec == nil || (dec.Passphrase == "" && len(dec.Identities) == 0) {
+		return nil, ErrEncrypted
+	}
+	identities := make([]*ecdh.PrivateKey, 0, len(dec.Identities))
+	for _, identity := range dec.Identities {
+		key, err := parseIdentity(identity)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, key)
+	}
+
+	reader := bufio.NewReader(r)
+	var header bytes.Buffer
+	readLine := func() (string, error) {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("invalid encryption header: %w", err)
+		}
+		header.WriteString(line)
+		return strings.TrimSuffix(line, "\n"), nil
+	}
+
+	if line, err := readLine(); err != nil {
+		return nil, err
+	} else if line != encryptMagic {
+		return nil, fmt.Errorf("invalid encryption header: unknown format")
+	}
+
+	var fileKey []byte
+	for {
+		line, err := readLine()
+		if err != nil {
+			return nil, err
+		}
+		if mac, ok := strings.CutPrefix(line, "--- "); ok {
+			if fileKey == nil {
+				return nil, ErrNoMatchingKey
+			}
+			// The MAC covers the header up to and including "---"
+			if err := checkHeaderMAC(fileKey, header.Bytes()[:header.Len()-len(mac)-2], mac); err != nil {
+				return nil, err
+			}
+			break
+		}
+
+		args, ok := strings.CutPrefix(line, "-> ")
+		if !ok {
+			return nil, fmt.Errorf("invalid encryption header: unexpected line %q", line)
+		}
+		body, err := readLine()
+		if err != nil {
+			return nil, err
+		}
+		if fileKey != nil {
+			continue
+		}
+		fileKey, err = unwrapStanza(strings.Fields(args), body, dec.Passphrase, identities)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	nonce := make([]byte, streamNonceSize)
+	if _, err := io.ReadFull(reader, nonce); err != nil {
+		return nil, fmt.Errorf("truncated encrypted stream: %w", err)
+	}
+	aead, err := payloadCipher(fileKey, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: reader, aead: aead, buf: make([]byte, encryptChunkSize+aead.Overhead())}, nil
+}
+
+// unwrapStanza returns the file key of one header stanza, or nil if it is
+// not for any of the given keys
+func unwrapStanza(args []string, body, passphrase string, identities []*ecdh.PrivateKey) ([]byte, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("invalid encryption header: empty stanza")
+	}
+	wrapped, err := decodeBase64(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption header: %w", err)
+	}
+
+	switch args[0] {
+	case "scrypt":
+		if passphrase == "" {
+			return nil, nil
+		}
+		if len(args) != 3 {
+			return nil, fmt.Errorf("invalid encryption header: malformed scrypt stanza")
+		}
+		salt, err := decodeBase64(args[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption header: %w", err)
+		}
+		logN, err := strconv.Atoi(args[2])
+		if err != nil || logN <= 0 || logN > maxScryptLogN {
+			return nil, fmt.Errorf("invalid encryption header: scrypt work factor %q", args[2])
+		}
+		wrapKey, err := scryptKey(passphrase, salt, logN)
+		if err != nil {
+			return nil, err
+		}
+		return unwrapFileKey(wrapKey, wrapped), nil
+	case "x25519":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("invalid encryption header: malformed x25519 stanza")
+		}
+		raw, err := decodeBase64(args[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption header: %w", err)
+		}
+		ephemeral, err := ecdh.X25519().NewPublicKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption header: %w", err)
+		}
+		for _, identity := range identities {
+			wrapKey, err := x25519Key(identity, ephemeral, ephemeral, identity.PublicKey())
+			if err != nil {
+				continue
+			}
+			if fileKey := unwrapFileKey(wrapKey, wrapped); fileKey != nil {
+				return fileKey, nil
+			}
+		}
+		return nil, nil
+	default:
+		// Unknown stanza types are skipped so newer writers stay readable
+		return nil, nil
+	}
+}
+
+func writeStanza(header *bytes.Buffer, kind string, args []string, wrapKey, fileKey []byte) {
+	block, _ := aes.NewCipher(wrapKey)
+	aead, _ := cipher.NewGCM(block)
+	// Each wrap key is used once, so a fixed nonce is safe
+	wrapped := aead.Seal(nil, make([]byte, aead.NonceSize()), fileKey, nil)
+	fmt.Fprintf(header, "-> %s %s\n%s\n", kind, strings.Join(args, " "), encodeBase64(wrapped))
+}
+
+// unwrapFileKey opens a wrapped file key, returning nil if wrapKey does not match
+func unwrapFileKey(wrapKey, wrapped []byte) []byte {
+	block, err := aes.NewCipher(wrapKey)
+	if err != nil {
+		return nil
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil
+	}
+	fileKey, err := aead.Open(nil, make([]byte, aead.NonceSize()), wrapped, nil)
+	if err != nil || len(fileKey) != fileKeySize {
+		return nil
+	}
+	return fileKey
+}
+
+func scryptKey(passphrase string, salt []byte, logN int) ([]byte, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<logN, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	return key, nil
+}
+
+// x25519Key derives the wrap key shared by private and peer,
+// bound to the ephemeral key of the stanza and the recipient
+func x25519Key(private *ecdh.PrivateKey, peer, ephemeral, recipient *ecdh.PublicKey) ([]byte, error) {
+	shared, err := private.ECDH(peer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	salt := append(append([]byte{}, ephemeral.Bytes()...), recipient.Bytes()...)
+	return hkdf.Key(sha256.New, shared, salt, "radarr-sync-go/x25519", 32)
+}
+
+func headerMAC(fileKey, header []byte) ([]byte, error) {
+	key, err := hkdf.Key(sha256.New, fileKey, nil, "radarr-sync-go/header", 32)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(header)
+	return mac.Sum(nil), nil
+}
+
+func checkHeaderMAC(fileKey, header []byte, encoded string) error {
+	expected, err := headerMAC(fileKey, header)
+	if err != nil {
+		return err
+	}
+	mac, err := decodeBase64(encoded)
+	if err != nil || !hmac.Equal(mac, expected) {
+		return fmt.Errorf("invalid encryption header: authentication failed")
+	}
+	return nil
+}
+
+func payloadCipher(fileKey, nonce []byte) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, fileKey, nonce, "radarr-sync-go/payload", 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce returns the nonce of chunk counter: the big-endian counter
+// followed by 1 for the final chunk, 0 otherwise
+func chunkNonce(aead cipher.AEAD, counter uint64, last bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+func encodeBase64(data []byte) string {
+	return base64.RawStdEncoding.EncodeToString(data)
+}
+
+func decodeBase64(data string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(data)
+}
+
+// encryptWriter seals full chunks as non-final once more data follows,
+// and the remaining chunk as final on Close
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, fmt.Errorf("write to closed encryption stream")
+	}
+	written := 0
+	for len(p) > 0 {
+		if len(e.buf) == encryptChunkSize {
+			if err := e.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):encryptChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) flush(last bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.aead, e.counter, last), e.buf, nil)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+// Close writes the final chunk
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.flush(true)
+}
+
+// decryptReader opens one chunk at a time; a chunk followed by the end
+// of the stream must be the final chunk
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	buf     []byte
+	plain   []byte
+	counter uint64
+	done    bool
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) next() error {
+	n, err := io.ReadFull(d.r, d.buf)
+	last := false
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF):
+		last = true
+	case err != nil:
+		return err
+	default:
+		if _, err := d.r.Peek(1); errors.Is(err, io.EOF) {
+			last = true
+		}
+	}
+	if n < d.aead.Overhead() {
+		return fmt.Errorf("truncated encrypted stream")
+	}
+
+	plain, err := d.aead.Open(d.buf[:0], chunkNonce(d.aead, d.counter, last), d.buf[:n], nil)
+	if err != nil {
+		return fmt.Errorf("encrypted stream authentication failed at chunk %d", d.counter)
+	}
+	d.counter++
+	d.plain = plain
+	d.done = last
+	return nil
+}
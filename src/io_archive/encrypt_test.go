@@ -0,0 +1,230 @@
+package io_archive
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// fastScrypt lowers the scrypt work factor for the duration of a test
+func fastScrypt(t *testing.T) {
+	t.Helper()
+	previous := scryptLogN
+	scryptLogN = 10
+	t.Cleanup(func() { scryptLogN = previous })
+}
+
+func encryptBytes(t *testing.T, data []byte, enc *Encryption) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	writer, err := Encrypt(&out, enc)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return out.Bytes()
+}
+
+func decryptBytes(data []byte, dec *Decryption) ([]byte, error) {
+	reader, err := Decrypt(bytes.NewReader(data), dec)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+func TestEncryptPassphraseRoundTrip(t *testing.T) {
+	fastScrypt(t)
+	// Spans several chunks, ending on a chunk boundary
+	for _, size := range []int{0, 10, encryptChunkSize, 3*encryptChunkSize + 7} {
+		data := bytes.Repeat([]byte("movie"), size/5+1)[:size]
+		encrypted := encryptBytes(t, data, &Encryption{Passphrase: "secret"})
+
+		decrypted, err := decryptBytes(encrypted, &Decryption{Passphrase: "secret"})
+		if err != nil {
+			t.Fatalf("Expected no error for %d bytes, got %v", size, err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Errorf("Expected round trip of %d bytes, got %d bytes", size, len(decrypted))
+		}
+	}
+}
+
+func TestDecryptWrongPassphrase(t *testing.T) {
+	fastScrypt(t)
+	encrypted := encryptBytes(t, []byte("content"), &Encryption{Passphrase: "secret"})
+
+	if _, err := decryptBytes(encrypted, &Decryption{Passphrase: "wrong"}); !errors.Is(err, ErrNoMatchingKey) {
+		t.Errorf("Expected ErrNoMatchingKey, got %v", err)
+	}
+	if _, err := decryptBytes(encrypted, nil); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("Expected ErrEncrypted, got %v", err)
+	}
+}
+
+func TestEncryptRecipients(t *testing.T) {
+	identity1, recipient1, _ := GenerateIdentity()
+	identity2, recipient2, _ := GenerateIdentity()
+	other, _, _ := GenerateIdentity()
+	encrypted := encryptBytes(t, []byte("content"), &Encryption{Recipients: []string{recipient1, recipient2}})
+
+	for _, identity := range []string{identity1, identity2} {
+		decrypted, err := decryptBytes(encrypted, &Decryption{Identities: []string{other, identity}})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if string(decrypted) != "content" {
+			t.Errorf("Expected 'content', got %q", decrypted)
+		}
+	}
+	if _, err := decryptBytes(encrypted, &Decryption{Identities: []string{other}}); !errors.Is(err, ErrNoMatchingKey) {
+		t.Errorf("Expected ErrNoMatchingKey, got %v", err)
+	}
+
+	if recipient, _ := RecipientOf(identity1); recipient != recipient1 {
+		t.Errorf("Expected recipient %s, got %s", recipient1, recipient)
+	}
+}
+
+func TestDecryptDetectsTampering(t *testing.T) {
+	identity, recipient, _ := GenerateIdentity()
+	dec := &Decryption{Identities: []string{identity}}
+	data := bytes.Repeat([]byte("x"), 2*encryptChunkSize+100)
+	encrypted := encryptBytes(t, data, &Encryption{Recipients: []string{recipient}})
+
+	modified := bytes.Clone(encrypted)
+	modified[len(modified)-encryptChunkSize] ^= 1
+	if _, err := decryptBytes(modified, dec); err == nil {
+		t.Error("Expected error for modified payload")
+	}
+
+	// Dropping the final chunk leaves a stream ending on a non-final chunk
+	truncated := encrypted[:len(encrypted)-(100+16)]
+	if _, err := decryptBytes(truncated, dec); err == nil {
+		t.Error("Expected error for truncated stream")
+	}
+
+}
+
+func TestDecryptDetectsHeaderTampering(t *testing.T) {
+	fastScrypt(t)
+	identity, recipient, _ := GenerateIdentity()
+	encrypted := encryptBytes(t, []byte("content"), &Encryption{Passphrase: "secret", Recipients: []string{recipient}})
+
+	// The scrypt stanza is not needed to open the archive with the identity,
+	// changing it is only caught by the header MAC
+	header := bytes.Clone(encrypted)
+	salt := bytes.Index(header, []byte("scrypt ")) + len("scrypt ")
+	if header[salt] == 'A' {
+		header[salt] = 'B'
+	} else {
+		header[salt] = 'A'
+	}
+	_, err := decryptBytes(header, &Decryption{Identities: []string{identity}})
+	if err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("Expected header authentication error, got %v", err)
+	}
+}
+
+func TestEncryptRejectsInvalidKeys(t *testing.T) {
+	if _, err := Encrypt(io.Discard, &Encryption{}); err == nil {
+		t.Error("Expected error without passphrase or recipients")
+	}
+	if _, err := Encrypt(io.Discard, &Encryption{Recipients: []string{"not-a-key"}}); err == nil {
+		t.Error("Expected error for invalid recipient")
+	}
+	if _, err := Decrypt(bytes.NewReader(nil), &Decryption{Identities: []string{"not-a-key"}}); err == nil {
+		t.Error("Expected error for invalid identity")
+	}
+}
+
+func TestReadKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.txt")
+	os.WriteFile(path, []byte("# comment\n\nkey-one\n  key-two  \n"), 0o600)
+
+	keys, err := ReadKeys(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(keys) != 2 || keys[0] != "key-one" || keys[1] != "key-two" {
+		t.Errorf("Expected two keys, got %v", keys)
+	}
+}
+
+func TestTrimExtension(t *testing.T) {
+	cases := map[string]string{
+		"a/movie.mkv.tar.gz":     "a/movie.mkv",
+		"a/movie.mkv.tar.gz.enc": "a/movie.mkv",
+	}
+	for name, expected := range cases {
+		if got, ok := TrimExtension(name); !ok || got != expected {
+			t.Errorf("Expected '%s' for %s, got '%s'", expected, name, got)
+		}
+	}
+	if _, ok := TrimExtension("a/movie.mkv.tar.gz.sha256.json"); ok {
+		t.Error("Expected manifest not to be an archive")
+	}
+}
+
+func TestCompressEncryptedArchive(t *testing.T) {
+	identity, recipient, _ := GenerateIdentity()
+	source := storage.NewMemory()
+	source.WriteFile("movie/movie.mkv", []byte("content"), time.Now())
+	target := storage.NewMemory()
+
+	name := "movie." + ArchiveExtension(&CompressOptions{Encryption: &Encryption{}})
+	if name != "movie.tar.gz.enc" {
+		t.Fatalf("Expected encrypted archive name, got %s", name)
+	}
+	writer, _ := target.Create(name)
+	manifest, err := CompressFrom(writer, source, "movie", &CompressOptions{
+		Encryption: &Encryption{Recipients: []string{recipient}},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	writer.Close()
+	manifest.Archive = name
+	if err := writeManifest(target, name, manifest); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Verification checks the ciphertext without keys
+	result, err := VerifyIn(target, name)
+	if err != nil || !result.OK() {
+		t.Errorf("Expected valid archive, got %s %v: %v", result.Status, result.Problems, err)
+	}
+
+	if _, err := ListIn(target, name); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("Expected ErrEncrypted listing without keys, got %v", err)
+	}
+	dec := &Decryption{Identities: []string{identity}}
+	listing, err := ListDecrypted(target, name, dec)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if listing.Files != 1 {
+		t.Errorf("Expected 1 file, got %d", listing.Files)
+	}
+
+	dest := t.TempDir()
+	if _, err := ExtractFrom(target, name, dest, &ExtractOptions{Decryption: dec}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	content, _ := os.ReadFile(filepath.Join(dest, "movie", "movie.mkv"))
+	if string(content) != "content" {
+		t.Errorf("Expected restored content, got %q", content)
+	}
+}
@@ -0,0 +1,317 @@
+package io_archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// ExtractOptions configures how archive entries are written to disk
+type ExtractOptions struct {
+	// PreserveTimes restores modification times stored in the archive
+	PreserveTimes bool
+	// PreservePermissions restores permission bits stored in the archive
+	PreservePermissions bool
+	// Overwrite replaces existing files instead of failing
+	Overwrite bool
+	// Decryption opens encrypted archives
+	Decryption *Decryption
+}
+
+// Extract unpacks a tar.gz archive into dest and returns the extracted paths.
+// Encrypted archives are opened with opts.Decryption.
+// Entries with absolute names or names escaping dest through ".." are rejected,
+// as are hard links to such names and entries written through a symbolic link
+// extracted before them. Existing files are never replaced unless opts.Overwrite is set.
+//
+// Returns error if:
+// - archive or dest path is empty
+// - archive cannot be read or contains an unsafe entry
+// - a file already exists and overwrite is disabled
+//
+// Example: Extract("/backups/movie.tar.gz", "/data/movies", nil)
+func Extract(archive, dest string, opts *ExtractOptions) ([]string, error) {
+	if archive == "" {
+		return nil, fmt.Errorf("archive path cannot be empty")
+	}
+	store, name := localStorage(archive)
+	return ExtractFrom(store, name, dest, opts)
+}
+
+// ExtractFrom is Extract for the archive name in store.
+// Entries are always written to the local directory dest. The base of an
+// incremental archive is extracted with its deltas applied (see Chain).
+func ExtractFrom(store storage.Storage, name, dest string, opts *ExtractOptions) ([]string, error) {
+	if dest == "" {
+		return nil, fmt.Errorf("destination path cannot be empty")
+	}
+	if opts == nil {
+		opts = &ExtractOptions{}
+	}
+
+	chain, err := ReadChain(store, name)
+	if err != nil {
+		return nil, err
+	}
+	if chain != nil && chain.Merged > 0 {
+		return nil, fmt.Errorf("consolidation of %s was interrupted, consolidate it again first", name)
+	}
+	if chain != nil {
+		return extractChain(store, name, chain, dest, opts)
+	}
+
+	reader, closer, err := openArchive(store, name, opts.Decryption)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create destination: %w", err)
+	}
+
+	return extractEntries(reader, dest, opts, nil, make(map[string]bool))
+}
+
+// openArchive opens the archive name in store and returns its tar stream,
+// joining volumes and decrypting archives named with EncryptedExtension with dec.
+// The returned closer releases the archive.
+func openArchive(store storage.Storage, name string, dec *Decryption) (*tar.Reader, io.Closer, error) {
+	file, err := OpenArchive(store, name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+
+	var stream io.Reader = file
+	if IsEncrypted(name) {
+		if stream, err = Decrypt(file, dec); err != nil {
+			file.Close()
+			return nil, nil, fmt.Errorf("failed to decrypt archive: %w", err)
+		}
+	}
+
+	gz, err := gzip.NewReader(stream)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("failed to read gzip stream: %w", err)
+	}
+	return tar.NewReader(gz), file, nil
+}
+
+// dirTimes remembers directory mtimes, applied after their content is written
+type dirTimes map[string]time.Time
+
+// extractEntries writes the entries of reader for which keep, if not nil,
+// returns true into dest. links are the symbolic links extracted so far,
+// nothing is written through them as they may point anywhere.
+func extractEntries(reader *tar.Reader, dest string, opts *ExtractOptions, keep func(name string) bool,
+	links map[string]bool) ([]string, error) {
+	var extracted []string
+	dirs := dirTimes{}
+
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return extracted, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if keep != nil && !keep(header.Name) {
+			continue
+		}
+
+		target, err := safeJoin(dest, header.Name)
+		if err != nil {
+			return extracted, err
+		}
+		if isLinked(dest, target, links) {
+			return extracted, fmt.Errorf("unsafe entry name %q: inside a symbolic link", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := extractDir(target, header, opts); err != nil {
+				return extracted, err
+			}
+			dirs[target] = header.ModTime
+		case tar.TypeReg:
+			if err := extractFile(reader, target, header, opts); err != nil {
+				return extracted, err
+			}
+		case tar.TypeSymlink:
+			if err := extractSymlink(target, header, opts); err != nil {
+				return extracted, err
+			}
+			links[target] = true
+		case tar.TypeLink:
+			source, err := safeJoin(dest, header.Linkname)
+			if err != nil || isLinked(dest, source, links) {
+				return extracted, fmt.Errorf("unsafe hard link %q to %q", header.Name, header.Linkname)
+			}
+			if err := extractHardLink(source, target, opts); err != nil {
+				return extracted, err
+			}
+		default:
+			return extracted, fmt.Errorf("unsupported entry type %q for %s", header.Typeflag, header.Name)
+		}
+		extracted = append(extracted, target)
+	}
+
+	if opts.PreserveTimes {
+		for dir, modTime := range dirs {
+			if err := os.Chtimes(dir, modTime, modTime); err != nil {
+				return extracted, fmt.Errorf("failed to set times on %s: %w", dir, err)
+			}
+		}
+	}
+
+	return extracted, nil
+}
+
+// safeJoin resolves an archive entry name inside dest, rejecting absolute
+// names and names that would escape dest (zip-slip)
+func safeJoin(dest, name string) (string, error) {
+	if name == "" || path.IsAbs(name) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("unsafe entry name %q", name)
+	}
+	for _, part := range strings.Split(filepath.ToSlash(name), "/") {
+		if part == ".." {
+			return "", fmt.Errorf("unsafe entry name %q", name)
+		}
+	}
+
+	target := filepath.Join(dest, filepath.FromSlash(name))
+	rel, err := filepath.Rel(dest, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("unsafe entry name %q", name)
+	}
+	return target, nil
+}
+
+// isLinked reports whether target, inside dest, is or is below one of links
+func isLinked(dest, target string, links map[string]bool) bool {
+	for ; target != dest && len(target) > len(dest); target = filepath.Dir(target) {
+		if links[target] {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareTarget creates the directory of target and, with opts.Overwrite,
+// removes an existing file at target so a link can take its place
+func prepareTarget(target string, opts *ExtractOptions) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", target, err)
+	}
+	info, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", target, err)
+	}
+	if !opts.Overwrite || info.IsDir() {
+		return fmt.Errorf("file already exists: %s", target)
+	}
+	if err := os.Remove(target); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", target, err)
+	}
+	return nil
+}
+
+func extractSymlink(target string, header *tar.Header, opts *ExtractOptions) error {
+	if err := prepareTarget(target, opts); err != nil {
+		return err
+	}
+	if err := os.Symlink(header.Linkname, target); err != nil {
+		return fmt.Errorf("failed to create symbolic link %s: %w", target, err)
+	}
+	return nil
+}
+
+func extractHardLink(source, target string, opts *ExtractOptions) error {
+	if err := prepareTarget(target, opts); err != nil {
+		return err
+	}
+	if err := os.Link(source, target); err != nil {
+		return fmt.Errorf("failed to create hard link %s: %w", target, err)
+	}
+	return nil
+}
+
+func extractDir(target string, header *tar.Header, opts *ExtractOptions) error {
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", target, err)
+	}
+	if opts.PreservePermissions {
+		if err := os.Chmod(target, header.FileInfo().Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to set permissions on %s: %w", target, err)
+		}
+	}
+	return nil
+}
+
+func extractFile(reader io.Reader, target string, header *tar.Header, opts *ExtractOptions) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", target, err)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !opts.Overwrite {
+		flags |= os.O_EXCL
+	} else if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		// Replace the link itself, opening it would write wherever it points
+		if err := os.Remove(target); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", target, err)
+		}
+	}
+
+	perm := os.FileMode(0o644)
+	if opts.PreservePermissions {
+		perm = header.FileInfo().Mode().Perm()
+	}
+
+	file, err := os.OpenFile(target, flags, perm)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("file already exists: %s", target)
+		}
+		return fmt.Errorf("failed to create file %s: %w", target, err)
+	}
+
+	copied, copyErr := io.Copy(file, reader)
+	closeErr := file.Close()
+	if copyErr != nil {
+		return fmt.Errorf("failed to write file %s: %w", target, copyErr)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("failed to close file %s: %w", target, closeErr)
+	}
+	if copied != header.Size {
+		return fmt.Errorf("incomplete extraction of %s: got %d bytes, expected %d", target, copied, header.Size)
+	}
+
+	if opts.PreservePermissions {
+		// Chmod again, OpenFile perms are filtered by umask and ignored for existing files
+		if err := os.Chmod(target, perm); err != nil {
+			return fmt.Errorf("failed to set permissions on %s: %w", target, err)
+		}
+	}
+	if opts.PreserveTimes {
+		if err := os.Chtimes(target, header.ModTime, header.ModTime); err != nil {
+			return fmt.Errorf("failed to set times on %s: %w", target, err)
+		}
+	}
+	return nil
+}
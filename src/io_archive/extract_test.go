@@ -0,0 +1,162 @@
+package io_archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeRawArchive builds a tar.gz containing a single file entry named name.
+func writeRawArchive(t *testing.T, name string) string {
+	t.Helper()
+	archivePath := filepath.Join(t.TempDir(), "raw."+Extension)
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	writer := tar.NewWriter(gz)
+	content := []byte("evil")
+	header := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+	if err := writer.WriteHeader(header); err != nil {
+		t.Fatalf("Failed to write header: %v", err)
+	}
+	if _, err := writer.Write(content); err != nil {
+		t.Fatalf("Failed to write content: %v", err)
+	}
+	writer.Close()
+	gz.Close()
+	return archivePath
+}
+
+func TestExtractRoundTrip(t *testing.T) {
+	archivePath := createTestArchive(t)
+	dest := t.TempDir()
+
+	extracted, err := Extract(archivePath, dest, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(extracted) != 3 {
+		t.Errorf("Expected 3 extracted entries, got %d", len(extracted))
+	}
+
+	content, err := os.ReadFile(filepath.Join(dest, "movie", "movie.mkv"))
+	if err != nil {
+		t.Fatalf("Expected extracted file, got error: %v", err)
+	}
+	if string(content) != "video content" {
+		t.Errorf("Expected content 'video content', got '%s'", content)
+	}
+}
+
+func TestExtractRejectsParentTraversal(t *testing.T) {
+	archivePath := writeRawArchive(t, "../evil.txt")
+	dest := filepath.Join(t.TempDir(), "dest")
+
+	_, err := Extract(archivePath, dest, nil)
+	if err == nil {
+		t.Fatal("Expected error for entry escaping destination")
+	}
+	if _, statErr := os.Stat(filepath.Join(filepath.Dir(dest), "evil.txt")); !os.IsNotExist(statErr) {
+		t.Error("Expected no file written outside destination")
+	}
+}
+
+func TestExtractRejectsAbsoluteName(t *testing.T) {
+	archivePath := writeRawArchive(t, "/tmp/evil.txt")
+
+	_, err := Extract(archivePath, t.TempDir(), nil)
+	if err == nil {
+		t.Error("Expected error for absolute entry name")
+	}
+}
+
+func TestExtractRefusesOverwrite(t *testing.T) {
+	archivePath := createTestArchive(t)
+	dest := t.TempDir()
+
+	if _, err := Extract(archivePath, dest, nil); err != nil {
+		t.Fatalf("Expected no error on first extraction, got %v", err)
+	}
+	if _, err := Extract(archivePath, dest, nil); err == nil {
+		t.Error("Expected error when files already exist")
+	}
+	if _, err := Extract(archivePath, dest, &ExtractOptions{Overwrite: true}); err != nil {
+		t.Errorf("Expected no error with overwrite, got %v", err)
+	}
+}
+
+func TestExtractOverwriteReplacesSymlink(t *testing.T) {
+	archivePath := createTestArchive(t)
+	dest := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "outside.txt")
+	if err := os.WriteFile(outside, []byte("keep"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dest, "movie"), 0o755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	link := filepath.Join(dest, "movie", "movie.mkv")
+	if err := os.Symlink(outside, link); err != nil {
+		t.Fatalf("Failed to create link: %v", err)
+	}
+
+	if _, err := Extract(archivePath, dest, &ExtractOptions{Overwrite: true}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if content, _ := os.ReadFile(outside); string(content) != "keep" {
+		t.Errorf("Expected the link target to be left alone, got %q", content)
+	}
+	if info, err := os.Lstat(link); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("Expected the link to be replaced by a file, got %v, %v", info, err)
+	}
+}
+
+func TestExtractPreserveTimes(t *testing.T) {
+	sourceDir := t.TempDir()
+	sourceFile := filepath.Join(sourceDir, "movie.mkv")
+	if err := os.WriteFile(sourceFile, []byte("content"), 0o600); err != nil {
+		t.Fatalf("Failed to create source file: %v", err)
+	}
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(sourceFile, modTime, modTime); err != nil {
+		t.Fatalf("Failed to set times: %v", err)
+	}
+
+	archivePath, err := Compress(sourceFile, t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("Failed to compress: %v", err)
+	}
+
+	dest := t.TempDir()
+	opts := &ExtractOptions{PreserveTimes: true, PreservePermissions: true}
+	if _, err := Extract(archivePath, dest, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dest, "movie.mkv"))
+	if err != nil {
+		t.Fatalf("Expected extracted file, got error: %v", err)
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("Expected mod time %v, got %v", modTime, info.ModTime())
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+}
+
+func TestExtractValidation(t *testing.T) {
+	if _, err := Extract("", t.TempDir(), nil); err == nil {
+		t.Error("Expected error for empty archive")
+	}
+	if _, err := Extract("archive.tar.gz", "", nil); err == nil {
+		t.Error("Expected error for empty destination")
+	}
+}
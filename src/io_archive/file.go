@@ -1,47 +1,125 @@
 package io_archive
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
-	"os"
+	"path"
 	"path/filepath"
+	"slices"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
 )
 
 // FindWildcard searches recursively for files matching pattern in root directory.
 // Returns absolute paths of matching files. Returns nil slice if no matches found.
 // Errors are returned only for path traversal issues, not missing files.
+// pattern is matched as by MatchPattern: without "/" it matches base names,
+// with "/" (e.g. "a/*.tar.gz") the path relative to root. See FindFiles for
+// more options.
 //
 // Example: FindWildcard("/movies", "*.tar.gz") returns ["/movies/file.tar.gz"]
 func FindWildcard(root, pattern string) ([]string, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("pattern cannot be empty")
+	}
+	return FindFiles(context.Background(), root, FindOptions{Patterns: []string{pattern}})
+}
+
+// FindOptions selects the files returned by FindFiles
+type FindOptions struct {
+	// Patterns select files whose path relative to the root matches one of
+	// them (see MatchPattern), e.g. "*.mkv" or "**/extras/*.srt"
+	Patterns []string
+	// Exclude leaves out files and directories matching one of these
+	// patterns, excluded directories are not entered, e.g. "**/sample"
+	Exclude []string
+	// MaxDepth, if positive, limits the search to this many levels below
+	// the root: 1 only searches the files directly in it
+	MaxDepth int
+	// IgnorePermissionErrors skips files and directories that cannot be
+	// read instead of failing the search
+	IgnorePermissionErrors bool
+}
+
+// FindFiles searches recursively for files in root directory selected by
+// opts, in lexical order. Directory entries are read without a stat per
+// file (see storage.Walk). The search stops with ctx's error once ctx is done.
+//
+// Example: FindFiles(ctx, "/movies", FindOptions{Patterns: []string{"*.mkv", "*.mp4"}})
+func FindFiles(ctx context.Context, root string, opts FindOptions) ([]string, error) {
 	if root == "" {
 		return nil, fmt.Errorf("root path cannot be empty")
 	}
-	if pattern == "" {
-		return nil, fmt.Errorf("pattern cannot be empty")
+	names, err := findFiles(ctx, storage.NewLocal(root), opts)
+	if err != nil {
+		return nil, err
 	}
 
 	var matches []string
-	walkErr := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
-		}
+	for _, name := range names {
+		matches = append(matches, filepath.Join(root, filepath.FromSlash(name)))
+	}
+	return matches, nil
+}
 
-		matched, patternErr := filepath.Match(pattern, filepath.Base(path))
-		if patternErr != nil {
-			return patternErr
+// findFiles is FindFiles in store, returning slash-separated names
+func findFiles(ctx context.Context, store storage.Storage, opts FindOptions) ([]string, error) {
+	if len(opts.Patterns) == 0 {
+		return nil, fmt.Errorf("pattern cannot be empty")
+	}
+	for _, pattern := range slices.Concat(opts.Patterns, opts.Exclude) {
+		if err := ValidatePattern(pattern); err != nil {
+			return nil, err
 		}
+	}
 
-		if matched {
-			matches = append(matches, path)
-		}
-		return nil
+	files, err := storage.Walk(ctx, store, "", storage.WalkOptions{
+		Skip: func(name string, isDir bool) bool {
+			return matchesAny(opts.Exclude, name)
+		},
+		MaxDepth:               opts.MaxDepth,
+		IgnorePermissionErrors: opts.IgnorePermissionErrors,
+		NamesOnly:              true,
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	if walkErr != nil {
-		return nil, walkErr
+	var matches []string
+	for _, file := range storage.Files(files) {
+		if matchesAny(opts.Patterns, file.Name) {
+			matches = append(matches, file.Name)
+		}
+	}
+	return matches, nil
+}
+
+// FindIn searches recursively below dir ("" for the root) in store for files
+// whose base name matches pattern. Returns the matching names.
+//
+// Example: FindIn(store, "", "*.tar.gz") returns ["a/file.tar.gz"]
+func FindIn(store storage.Storage, dir, pattern string) ([]string, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("pattern cannot be empty")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	files, err := storage.Walk(context.Background(), store, dir, storage.WalkOptions{NamesOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	for _, file := range files {
+		if file.IsDir {
+			continue
+		}
+		if matched, _ := path.Match(pattern, path.Base(file.Name)); matched {
+			matches = append(matches, file.Name)
+		}
 	}
 	return matches, nil
 }
@@ -63,21 +141,17 @@ func GetFileInfo(root, filename, extension string) (fs.FileInfo, error) {
 		return nil, fmt.Errorf("filename cannot be empty")
 	}
 
-	var path string
+	name := filename
 	if extension != "" {
-		path = filepath.Join(root, filename+"."+extension)
-	} else {
-		path = filepath.Join(root, filename)
+		name = filename + "." + extension
 	}
 
-	info, err := os.Stat(path)
+	file, err := storage.NewLocal(root).Stat(filepath.ToSlash(name))
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil
-		}
-		return nil, fmt.Errorf("failed to stat file %s: %w", path, err)
+		return nil, fmt.Errorf("failed to stat file %s: %w", filepath.Join(root, name), err)
 	}
-
-	return info, nil
+	if file == nil {
+		return nil, nil
+	}
+	return file.Info(), nil
 }
-
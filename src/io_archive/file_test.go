@@ -1,9 +1,15 @@
 package io_archive
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
 )
 
 func TestFindWildcardInNestedDirectories(t *testing.T) {
@@ -73,3 +79,152 @@ func TestExtensionConstant(t *testing.T) {
 		t.Errorf("Expected Extension constant 'tar.gz', got '%s'", Extension)
 	}
 }
+
+// createFindTree creates files at the slash-separated names below a temporary root
+func createFindTree(t testing.TB, names ...string) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, name := range names {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("Failed to create directory for %s: %v", name, err)
+		}
+		if err := os.WriteFile(path, []byte("test"), 0o644); err != nil {
+			t.Fatalf("Failed to create file %s: %v", name, err)
+		}
+	}
+	return root
+}
+
+// relNames returns paths relative to root, slash-separated
+func relNames(t *testing.T, root string, paths []string) []string {
+	t.Helper()
+	var names []string
+	for _, path := range paths {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, filepath.ToSlash(rel))
+	}
+	return names
+}
+
+func TestFindFilesPatternsAndExcludes(t *testing.T) {
+	root := createFindTree(t,
+		"a/movie.mkv",
+		"a/movie.nfo",
+		"a/extras/trailer.srt",
+		"a/sample/sample.mkv",
+		"b/other.mp4",
+		"b/other.srt",
+	)
+
+	matches, err := FindFiles(context.Background(), root, FindOptions{
+		Patterns: []string{"*.mkv", "*.mp4", "**/extras/*.srt"},
+		Exclude:  []string{"**/sample"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := []string{"a/extras/trailer.srt", "a/movie.mkv", "b/other.mp4"}
+	if got := relNames(t, root, matches); !slices.Equal(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	if _, err := FindFiles(context.Background(), root, FindOptions{Patterns: []string{"[a-"}}); err == nil {
+		t.Error("Expected error for an invalid pattern")
+	}
+}
+
+func TestFindFilesMaxDepth(t *testing.T) {
+	root := createFindTree(t, "top.mkv", "a/one.mkv", "a/b/two.mkv")
+
+	cases := []struct {
+		depth    int
+		expected []string
+	}{
+		{1, []string{"top.mkv"}},
+		{2, []string{"a/one.mkv", "top.mkv"}},
+		{0, []string{"a/b/two.mkv", "a/one.mkv", "top.mkv"}},
+	}
+	for _, c := range cases {
+		matches, err := FindFiles(context.Background(), root, FindOptions{Patterns: []string{"*.mkv"}, MaxDepth: c.depth})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if got := relNames(t, root, matches); !slices.Equal(got, c.expected) {
+			t.Errorf("MaxDepth %d: expected %v, got %v", c.depth, c.expected, got)
+		}
+	}
+}
+
+func TestFindFilesCancelled(t *testing.T) {
+	root := createFindTree(t, "a/movie.mkv")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := FindFiles(ctx, root, FindOptions{Patterns: []string{"*.mkv"}}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestFindWildcardPatternWithSlash(t *testing.T) {
+	root := createFindTree(t, "a/movie.tar.gz", "b/movie.tar.gz", "a/nested/other.tar.gz")
+
+	// A pattern with "/" matches the path relative to the root, not the base name
+	matches, err := FindWildcard(root, "a/*.tar.gz")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got, expected := relNames(t, root, matches), []string{"a/movie.tar.gz"}; !slices.Equal(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+// benchmarkTree creates a library of 100 movie folders with 50 files each
+func benchmarkTree(b *testing.B) string {
+	b.Helper()
+	var names []string
+	for movie := range 100 {
+		for file := range 50 {
+			extension := "nfo"
+			if file%10 == 0 {
+				extension = "mkv"
+			}
+			names = append(names, fmt.Sprintf("movie-%03d/extras/file-%02d.%s", movie, file, extension))
+		}
+	}
+	return createFindTree(b, names...)
+}
+
+func BenchmarkFindWildcard(b *testing.B) {
+	root := benchmarkTree(b)
+	for b.Loop() {
+		if _, err := FindWildcard(root, "*.mkv"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFindFilesDoublestar(b *testing.B) {
+	root := benchmarkTree(b)
+	opts := FindOptions{
+		Patterns: []string{"**/extras/*.mkv", "*.mp4"},
+		Exclude:  []string{"**/sample"},
+	}
+	for b.Loop() {
+		if _, err := FindFiles(context.Background(), root, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStorageList(b *testing.B) {
+	root := benchmarkTree(b)
+	for b.Loop() {
+		if _, err := FindIn(storage.NewLocal(root), "", "*.mkv"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package io_archive
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// entryFilter selects the entries of a source written to its archive,
+// see CompressOptions.Include and CompressOptions.Exclude
+type entryFilter struct {
+	include []string
+	exclude []string
+}
+
+// newEntryFilter validates the patterns of opts.
+// Returns nil if opts select every entry.
+func newEntryFilter(opts *CompressOptions) (*entryFilter, error) {
+	if opts == nil || (len(opts.Include) == 0 && len(opts.Exclude) == 0) {
+		return nil, nil
+	}
+	for _, pattern := range append(append([]string{}, opts.Include...), opts.Exclude...) {
+		if err := ValidatePattern(pattern); err != nil {
+			return nil, err
+		}
+	}
+	return &entryFilter{include: opts.Include, exclude: opts.Exclude}, nil
+}
+
+// skip reports whether the entry at rel, relative to the archived directory,
+// is left out. Directories are only skipped when excluded, their content
+// is then skipped as well; include patterns apply to files.
+func (f *entryFilter) skip(rel string, isDir bool) bool {
+	if f == nil {
+		return false
+	}
+	if matchesAny(f.exclude, rel) {
+		return true
+	}
+	if isDir || len(f.include) == 0 {
+		return false
+	}
+	return !matchesAny(f.include, rel)
+}
+
+// ValidatePattern returns an error if pattern is not a valid glob
+func ValidatePattern(pattern string) error {
+	if strings.Trim(pattern, "/") == "" {
+		return fmt.Errorf("pattern cannot be empty")
+	}
+	for _, segment := range strings.Split(strings.Trim(pattern, "/"), "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// MatchPattern reports whether the slash-separated relative name matches
+// the glob pattern. A pattern without "/" matches the base name at any
+// depth, e.g. "*.part". Otherwise it matches the whole name, segment by
+// segment as path.Match, where a "**" segment matches any number of
+// directories, e.g. "**/sample/**".
+func MatchPattern(pattern, name string) bool {
+	pattern = strings.Trim(pattern, "/")
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(name))
+		return matched
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// matchesAny reports whether name matches one of patterns
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if MatchPattern(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Collapse repeated "**" and try every split of the remaining name
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := range name {
+				if matchSegments(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], name[0]); !matched {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
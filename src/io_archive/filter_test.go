@@ -0,0 +1,102 @@
+package io_archive
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+func TestMatchPattern(t *testing.T) {
+	cases := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"*.part", "movie.mkv.part", true},
+		{"*.part", "extras/movie.mkv.part", true},
+		{"*.!qB", "movie.mkv.!qB", true},
+		{"*.part", "movie.mkv", false},
+		{"**/sample/**", "sample", true},
+		{"**/sample/**", "sample/clip.mkv", true},
+		{"**/sample/**", "extras/sample/clip.mkv", true},
+		{"**/sample/**", "samples/clip.mkv", false},
+		{"extras/*.jpg", "extras/poster.jpg", true},
+		{"extras/*.jpg", "extras/art/poster.jpg", false},
+		{"**/*.jpg", "poster.jpg", true},
+		{"**/*.jpg", "extras/art/poster.jpg", true},
+	}
+	for _, c := range cases {
+		if got := MatchPattern(c.pattern, c.name); got != c.match {
+			t.Errorf("MatchPattern(%q, %q) = %v, expected %v", c.pattern, c.name, got, c.match)
+		}
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	if err := ValidatePattern("**/sample/**"); err != nil {
+		t.Errorf("Expected valid pattern, got %v", err)
+	}
+	if err := ValidatePattern("[abc"); err == nil {
+		t.Error("Expected error for malformed pattern")
+	}
+	if err := ValidatePattern("/"); err == nil {
+		t.Error("Expected error for empty pattern")
+	}
+}
+
+func TestCompressFromSkipsExcludedEntries(t *testing.T) {
+	source := storage.NewMemory()
+	now := time.Now()
+	source.WriteFile("movie/movie.mkv", []byte("movie"), now)
+	source.WriteFile("movie/movie.nfo", []byte("info"), now)
+	source.WriteFile("movie/movie.mkv.part", []byte("partial"), now)
+	source.WriteFile("movie/sample/sample.mkv", []byte("sample"), now)
+	source.WriteFile("movie/subs/movie.srt", []byte("subtitles"), now)
+	target := storage.NewMemory()
+
+	opts := &CompressOptions{
+		Include: []string{"*.mkv", "*.srt", "*.part"},
+		Exclude: []string{"**/sample/**", "*.part"},
+	}
+	manifest, err := CompressInto(target, "movie.tar.gz", source, "movie", opts)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Excluded wins over included, include leaves out the .nfo
+	if manifest.Skipped != 3 || manifest.SkippedSize != int64(len("info")+len("partial")+len("sample")) {
+		t.Errorf("Expected 3 skipped files, got %d (%d bytes)", manifest.Skipped, manifest.SkippedSize)
+	}
+
+	listing, err := ListIn(target, "movie.tar.gz")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var names []string
+	for _, entry := range listing.Entries {
+		names = append(names, entry.Name)
+	}
+	expected := []string{"movie", "movie/movie.mkv", "movie/subs", "movie/subs/movie.srt"}
+	if len(names) != len(expected) {
+		t.Fatalf("Expected entries %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("Expected entry %s, got %s", expected[i], names[i])
+		}
+	}
+	if listing.Skipped != 3 || listing.SkippedSize != manifest.SkippedSize {
+		t.Errorf("Expected skipped totals in listing, got %d (%d bytes)", listing.Skipped, listing.SkippedSize)
+	}
+}
+
+func TestCompressFromRejectsInvalidPattern(t *testing.T) {
+	source := storage.NewMemory()
+	source.WriteFile("movie/movie.mkv", []byte("movie"), time.Now())
+
+	_, err := CompressInto(storage.NewMemory(), "movie.tar.gz", source, "movie", &CompressOptions{Exclude: []string{"[abc"}})
+	if err == nil {
+		t.Error("Expected error for invalid pattern")
+	}
+}
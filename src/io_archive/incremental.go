@@ -0,0 +1,638 @@
+package io_archive
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// An incremental archive is a base archive, written in full, followed by
+// delta archives holding only the entries added or changed since the
+// previous archive: "a/movie.mkv.tar.gz", then "a/movie.mkv.delta-001.tar.gz",
+// "a/movie.mkv.delta-002.tar.gz", ... Its chain manifest, stored next to the
+// base (see ChainPath), orders the deltas and names the archive holding the
+// current version of every entry, so each entry is extracted exactly once.
+// Deltas are not listed by FindArchives, they belong to their base.
+
+// ChainExtension is appended to a base archive path to name its chain manifest,
+// e.g. "movie.mkv.tar.gz" -> "movie.mkv.tar.gz.chain.json".
+const ChainExtension = "chain.json"
+
+// deltaInfix separates the movie name from the delta index in delta names
+const deltaInfix = ".delta-"
+
+// Chain is the chain manifest of an incremental archive
+type Chain struct {
+	// Base is the name of the base archive, deltas are in the same directory
+	Base string `json:"base"`
+	// Deltas are the delta archives in the order they were written,
+	// delta i (starting at 1) being Deltas[i-1]
+	Deltas []ChainDelta `json:"deltas,omitempty"`
+	// UpdatedAt is when the source was last compared to the chain
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Entries describe the archived source by entry name, as of the last delta
+	Entries map[string]ChainEntry `json:"entries"`
+	// Merged, if not 0, is the number of deltas merged into a new base by
+	// Consolidate that is still being moved in place of the previous one.
+	// Consolidate and CompressIncremental finish that first, the chain does
+	// not restore until then.
+	Merged int `json:"merged,omitempty"`
+}
+
+// ChainDelta is one delta archive of a chain
+type ChainDelta struct {
+	Archive   string    `json:"archive"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Changed are the entries added or changed since the previous archive
+	Changed []string `json:"changed,omitempty"`
+	// Removed are the entries removed from the source since the previous archive
+	Removed []string `json:"removed,omitempty"`
+}
+
+// ChainEntry is the version of a source entry stored in a chain
+type ChainEntry struct {
+	Mode    fs.FileMode `json:"mode"`
+	Size    int64       `json:"size,omitempty"`
+	ModTime time.Time   `json:"modTime"`
+	// Link is the target of a symbolic link, or the entry holding the
+	// content of a hard link
+	Link string `json:"link,omitempty"`
+	// Delta is the index of the delta holding the entry, 0 for the base
+	Delta int `json:"delta,omitempty"`
+}
+
+// same reports whether e and other describe the same version of an entry
+func (e ChainEntry) same(other ChainEntry) bool {
+	return e.Mode == other.Mode && e.Size == other.Size && e.Link == other.Link &&
+		e.ModTime.Truncate(time.Second).Equal(other.ModTime.Truncate(time.Second))
+}
+
+// ChainPath returns the chain manifest path for the base archive base.
+func ChainPath(base string) string {
+	return base + "." + ChainExtension
+}
+
+// DeltaName returns the name of delta index (starting at 1) of the base
+// archive base, e.g. "movie.mkv.tar.gz" -> "movie.mkv.delta-001.tar.gz".
+func DeltaName(base string, index int) string {
+	name, _ := TrimExtension(base)
+	return fmt.Sprintf("%s%s%03d%s", name, deltaInfix, index, base[len(name):])
+}
+
+// SplitDelta returns the base archive and index of a delta name,
+// e.g. "movie.mkv.delta-002.tar.gz" is delta 2 of "movie.mkv.tar.gz".
+func SplitDelta(name string) (string, int, bool) {
+	trimmed, ok := TrimExtension(name)
+	dot := strings.LastIndex(trimmed, deltaInfix)
+	if !ok || dot < 0 {
+		return "", 0, false
+	}
+	suffix := trimmed[dot+len(deltaInfix):]
+	index, err := strconv.Atoi(suffix)
+	if err != nil || index < 1 || len(suffix) < 3 || strings.ContainsAny(suffix, "+-") {
+		return "", 0, false
+	}
+	return trimmed[:dot] + name[len(trimmed):], index, true
+}
+
+// ReadChain loads the chain manifest of the base archive base in store.
+// Returns (nil, nil) if the archive is not incremental.
+func ReadChain(store storage.Storage, base string) (*Chain, error) {
+	chainName := ChainPath(base)
+	info, err := store.Stat(chainName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chain: %w", err)
+	}
+	if info == nil {
+		return nil, nil
+	}
+	data, err := storage.ReadFile(store, chainName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chain: %w", err)
+	}
+
+	var chain Chain
+	if err := json.Unmarshal(data, &chain); err != nil {
+		return nil, fmt.Errorf("failed to parse chain %s: %w", chainName, err)
+	}
+	return &chain, nil
+}
+
+// writeChain stores chain as the chain manifest of the base archive base in store
+func writeChain(store storage.Storage, base string, chain *Chain) error {
+	data, err := json.MarshalIndent(chain, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode chain: %w", err)
+	}
+	if err := storage.WriteFile(store, ChainPath(base), data); err != nil {
+		return fmt.Errorf("failed to write chain: %w", err)
+	}
+	return nil
+}
+
+// archives returns the names of the base archive base and of its deltas, in order
+func (c *Chain) archives(base string) []string {
+	names := []string{base}
+	for index := range c.Deltas {
+		names = append(names, DeltaName(base, index+1))
+	}
+	return names
+}
+
+// ChainFiles returns the stored files of the chain of the base archive base
+// other than the base itself: the chain manifest, the files of its deltas
+// and their manifests. Returns nil if the archive is not incremental.
+func ChainFiles(store storage.Storage, base string) ([]string, error) {
+	chain, err := ReadChain(store, base)
+	if err != nil || chain == nil {
+		return nil, err
+	}
+	files, err := chain.files(store, base)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{ChainPath(base)}, files...), nil
+}
+
+// files returns the stored files of the deltas of the chain of base and
+// their manifests, with those of the deltas merged and of the new base not
+// moved in yet when a consolidation was interrupted
+func (c *Chain) files(store storage.Storage, base string) ([]string, error) {
+	files, err := deltaFiles(store, base, len(c.Deltas)+c.Merged)
+	if err != nil || c.Merged == 0 {
+		return files, err
+	}
+	staged := base + partialSuffix
+	volumes, err := ArchiveFiles(store, staged)
+	if err != nil {
+		return nil, err
+	}
+	return appendExisting(store, append(files, volumes...), ManifestPath(staged))
+}
+
+// deltaFiles returns the stored files of the first count deltas of the base
+// archive base and their manifests
+func deltaFiles(store storage.Storage, base string, count int) ([]string, error) {
+	var files []string
+	for index := 1; index <= count; index++ {
+		delta := DeltaName(base, index)
+		volumes, err := ArchiveFiles(store, delta)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, volumes...)
+		if files, err = appendExisting(store, files, ManifestPath(delta)); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// appendExisting appends name to files if it exists in store
+func appendExisting(store storage.Storage, files []string, name string) ([]string, error) {
+	info, err := store.Stat(name)
+	if err != nil || info == nil {
+		return files, err
+	}
+	return append(files, name), nil
+}
+
+// removeFiles removes names from store
+func removeFiles(store storage.Storage, names []string) error {
+	for _, name := range names {
+		if err := store.Remove(name); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// removeChain forgets the chain of the base archive base, if any, before
+// the base is replaced by a full archive. The chain manifest goes first, so
+// deltas are never applied to the new base, and the returned delta files
+// are removed once the base is written.
+func removeChain(store storage.Storage, base string) ([]string, error) {
+	chain, err := ReadChain(store, base)
+	if err != nil || chain == nil {
+		return nil, err
+	}
+	// Staged files of an interrupted consolidation are replaced by the new base
+	deltas, err := deltaFiles(store, base, len(chain.Deltas)+chain.Merged)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Remove(ChainPath(base)); err != nil {
+		return nil, fmt.Errorf("failed to remove chain: %w", err)
+	}
+	return deltas, nil
+}
+
+// CompressIncremental writes the directory name in source as the incremental
+// archive base in target, and returns the manifest of the archive written
+// with the chain after the write:
+//   - a full base archive and a new chain, when base has no chain yet
+//   - a delta of the entries added or changed since the last archive of the
+//     chain, compared by type, size, modification time and link target
+//   - nothing when nothing changed, with a nil manifest
+//
+// A file is always archived in full, without a chain. Deltas use the
+// extension of base, so opts must encrypt them as the base is.
+//
+// Example: CompressIncremental(target, "a/movie.mkv.tar.gz", source, "a/movie.mkv", nil)
+func CompressIncremental(target storage.Storage, base string, source storage.Storage, name string,
+	opts *CompressOptions) (*Manifest, *Chain, error) {
+	if name == "" {
+		return nil, nil, fmt.Errorf("source path cannot be empty")
+	}
+	if base == "" {
+		return nil, nil, fmt.Errorf("archive name cannot be empty")
+	}
+	sourceInfo, err := statSource(source, name, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !sourceInfo.IsDir {
+		manifest, err := createArchive(source, sourceInfo, target, base, opts)
+		return manifest, nil, err
+	}
+
+	chain, err := ReadChain(target, base)
+	if err != nil {
+		return nil, nil, err
+	}
+	if chain != nil && chain.Merged > 0 {
+		if err := finishConsolidate(target, base, chain); err != nil {
+			return nil, nil, err
+		}
+	}
+	baseInfo, err := StatArchive(target, base)
+	if err != nil {
+		return nil, nil, err
+	}
+	walker, err := collectArchive(source, sourceInfo, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries := walker.chainEntries()
+
+	if chain == nil || baseInfo == nil {
+		manifest, err := storeFull(target, base, walker, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		chain := &Chain{Base: path.Base(base), UpdatedAt: time.Now().UTC(), Entries: entries}
+		if err := writeChain(target, base, chain); err != nil {
+			return nil, nil, err
+		}
+		return manifest, chain, nil
+	}
+
+	changed, removed := chain.diff(entries)
+	chain.UpdatedAt = time.Now().UTC()
+	if len(changed) == 0 && len(removed) == 0 {
+		// Record the comparison, so the source is not compared again until it changes
+		return nil, chain, writeChain(target, base, chain)
+	}
+
+	index := len(chain.Deltas) + 1
+	for name, entry := range entries {
+		if slices.Contains(changed, name) {
+			entry.Delta = index
+		} else {
+			entry.Delta = chain.Entries[name].Delta
+		}
+		entries[name] = entry
+	}
+	walker.keepOnly(changed)
+	deltaName := DeltaName(base, index)
+	manifest, err := storeArchive(target, deltaName, opts, func(w io.Writer) (*Manifest, error) {
+		return writeCollected(w, walker, opts)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	chain.Deltas = append(chain.Deltas, ChainDelta{
+		Archive:   manifest.Archive,
+		Size:      manifest.Size,
+		SHA256:    manifest.SHA256,
+		CreatedAt: manifest.CreatedAt,
+		Changed:   changed,
+		Removed:   removed,
+	})
+	chain.Entries = entries
+	if err := writeChain(target, base, chain); err != nil {
+		return nil, nil, err
+	}
+	return manifest, chain, nil
+}
+
+// storeFull writes the entries collected by walker as the base archive base,
+// replacing a previous chain
+func storeFull(target storage.Storage, base string, walker *archiveWalker, opts *CompressOptions) (*Manifest, error) {
+	stale, err := removeChain(target, base)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := storeArchive(target, base, opts, func(w io.Writer) (*Manifest, error) {
+		return writeCollected(w, walker, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return manifest, removeFiles(target, stale)
+}
+
+// diff returns the sorted names of entries added or changed and of those
+// removed since the last archive of the chain. A hard link whose content
+// moved to another entry counts as changed, as its link target differs.
+func (c *Chain) diff(entries map[string]ChainEntry) (changed, removed []string) {
+	for name, entry := range entries {
+		if previous, ok := c.Entries[name]; !ok || !previous.same(entry) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range c.Entries {
+		if _, ok := entries[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	slices.Sort(changed)
+	slices.Sort(removed)
+	return changed, removed
+}
+
+// IncrementalSize returns the content size CompressIncremental of name in
+// source into the incremental archive base in target would archive: every
+// file when base has no chain or name is a file, the files added or changed
+// since the last archive of the chain otherwise.
+func IncrementalSize(target storage.Storage, base string, source storage.Storage, name string,
+	opts *CompressOptions) (int64, error) {
+	sourceInfo, err := statSource(source, name, name)
+	if err != nil {
+		return 0, err
+	}
+	walker, err := collectArchive(source, sourceInfo, opts)
+	if err != nil {
+		return 0, err
+	}
+	if !sourceInfo.IsDir {
+		return walker.contentSize(), nil
+	}
+	chain, err := ReadChain(target, base)
+	if err != nil {
+		return 0, err
+	}
+	baseInfo, err := StatArchive(target, base)
+	if err != nil {
+		return 0, err
+	}
+	if chain == nil || baseInfo == nil {
+		return walker.contentSize(), nil
+	}
+	changed, _ := chain.diff(walker.chainEntries())
+	walker.keepOnly(changed)
+	return walker.contentSize(), nil
+}
+
+// chainEntries returns the collected entries as chain entries, all held by the base
+func (w *archiveWalker) chainEntries() map[string]ChainEntry {
+	entries := make(map[string]ChainEntry, len(w.entries))
+	for _, collected := range w.entries {
+		entry := ChainEntry{
+			Mode:    collected.file.Mode,
+			ModTime: collected.file.ModTime.UTC(),
+			Link:    collected.hardLink,
+		}
+		if collected.file.IsDir {
+			entry.Mode |= fs.ModeDir
+		}
+		switch {
+		case collected.file.Mode&fs.ModeSymlink != 0:
+			entry.Link = collected.file.Link
+		case collected.file.Mode.IsRegular():
+			entry.Size = collected.file.Size
+		}
+		entries[collected.name] = entry
+	}
+	return entries
+}
+
+// keepOnly leaves the collected entries named in names, storing the content
+// of hard-linked files in the first of their names left
+func (w *archiveWalker) keepOnly(names []string) {
+	w.entries = slices.DeleteFunc(w.entries, func(entry walkEntry) bool {
+		return !slices.Contains(names, entry.name)
+	})
+	linkDuplicates(w.entries)
+}
+
+// extractChain extracts the base archive base and its deltas into dest,
+// each entry from the archive holding its current version
+func extractChain(store storage.Storage, base string, chain *Chain, dest string, opts *ExtractOptions) ([]string, error) {
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create destination: %w", err)
+	}
+
+	var extracted []string
+	links := make(map[string]bool)
+	for index, archive := range chain.archives(base) {
+		reader, closer, err := openArchive(store, archive, opts.Decryption)
+		if err != nil {
+			return extracted, err
+		}
+		names, err := extractEntries(reader, dest, opts, chain.holds(index), links)
+		closer.Close()
+		extracted = append(extracted, names...)
+		if err != nil {
+			return extracted, fmt.Errorf("%s: %w", archive, err)
+		}
+	}
+	return extracted, nil
+}
+
+// holds returns whether entries belong to archive index of the chain, 0 being the base
+func (c *Chain) holds(index int) func(name string) bool {
+	return func(name string) bool {
+		entry, ok := c.Entries[name]
+		return ok && entry.Delta == index
+	}
+}
+
+// Consolidate merges the deltas of the incremental archive base in store
+// into a fresh base, reading archives with dec and writing with opts, and
+// returns the manifest of the new base. Returns (nil, nil) if base has no
+// deltas. Entries are copied from the archives, the source is not needed,
+// in the order of the chain. Content copied this way is never sampled for
+// CompressOptions.SampleEntropy.
+//
+// The new base is staged beside the old one, volumes and manifest included,
+// and the chain is reset with Merged set before anything is moved: until
+// then the old base and its deltas still restore, afterwards the new base
+// is moved in and the merged deltas removed by this or the next call, which
+// finishes an interrupted consolidation before anything else.
+// An encrypted base stays encrypted and requires opts.Encryption.
+func Consolidate(store storage.Storage, base string, dec *Decryption, opts *CompressOptions) (*Manifest, error) {
+	chain, err := ReadChain(store, base)
+	if err != nil || chain == nil {
+		return nil, err
+	}
+	if chain.Merged > 0 {
+		if err := finishConsolidate(store, base, chain); err != nil {
+			return nil, err
+		}
+		if len(chain.Deltas) == 0 {
+			return ReadManifestIn(store, base)
+		}
+	}
+	if len(chain.Deltas) == 0 {
+		return nil, nil
+	}
+
+	writeOpts := &CompressOptions{}
+	if opts != nil {
+		*writeOpts = *opts
+	}
+	if !IsEncrypted(base) {
+		writeOpts.Encryption = nil
+	} else if writeOpts.Encryption == nil {
+		return nil, fmt.Errorf("%s is encrypted, encryption is required to consolidate it", base)
+	}
+	manifest, err := stageArchive(store, base, writeOpts, func(w io.Writer) (*Manifest, error) {
+		return writeMerged(w, store, base, chain, dec, writeOpts)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name, entry := range chain.Entries {
+		entry.Delta = 0
+		chain.Entries[name] = entry
+	}
+	chain.Merged = len(chain.Deltas)
+	chain.Deltas = nil
+	chain.UpdatedAt = time.Now().UTC()
+	if err := writeChain(store, base, chain); err != nil {
+		removeArchiveFiles(store, base+partialSuffix)
+		return nil, err
+	}
+	return manifest, finishConsolidate(store, base, chain)
+}
+
+// finishConsolidate moves the new base staged by Consolidate in place of the
+// previous one, unless that is done, removes the deltas merged into it and
+// clears chain.Merged
+func finishConsolidate(store storage.Storage, base string, chain *Chain) error {
+	staged := base + partialSuffix
+	manifest, err := ReadManifestIn(store, staged)
+	if err != nil {
+		return err
+	}
+	// The staged manifest is moved last, the swap is done without it
+	if manifest != nil {
+		if err := commitArchive(store, staged, base, len(manifest.Volumes)); err != nil {
+			return err
+		}
+	}
+	merged, err := deltaFiles(store, base, chain.Merged)
+	if err != nil {
+		return err
+	}
+	if err := removeFiles(store, merged); err != nil {
+		return err
+	}
+	chain.Merged = 0
+	return writeChain(store, base, chain)
+}
+
+// writeMerged streams the current version of every entry of the chain of
+// base into w as a single archive
+func writeMerged(w io.Writer, store storage.Storage, base string, chain *Chain, dec *Decryption,
+	opts *CompressOptions) (*Manifest, error) {
+	stream, err := newArchiveStream(w, opts)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{CreatedAt: time.Now().UTC()}
+	for index, archive := range chain.archives(base) {
+		reader, closer, err := openArchive(store, archive, dec)
+		if err != nil {
+			return nil, err
+		}
+		err = copyEntries(stream, reader, chain.holds(index), manifest, index == 0)
+		closer.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge %s: %w", archive, err)
+		}
+	}
+	if err := stream.close(manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// copyEntries copies the entries of reader for which keep returns true to
+// stream, recording the checksum of their content in manifest. The movie
+// metadata of the first entry of the base is kept.
+func copyEntries(stream *archiveStream, reader *tar.Reader, keep func(name string) bool, manifest *Manifest,
+	isBase bool) error {
+	for first := true; ; first = false {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		if isBase && first {
+			if manifest.Metadata, err = metadataFromRecords(header.PAXRecords); err != nil {
+				return err
+			}
+		}
+		if !keep(header.Name) {
+			continue
+		}
+
+		if header.Typeflag == tar.TypeReg {
+			_, rel, _ := strings.Cut(header.Name, "/")
+			if err := stream.gz.selectFor(nil, storage.File{Size: header.Size}, rel); err != nil {
+				return err
+			}
+		}
+		if err := stream.tar.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write header for %s: %w", header.Name, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		hash := sha256.New()
+		copied, err := io.Copy(io.MultiWriter(stream.tar, hash), reader)
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %w", header.Name, err)
+		}
+		if copied != header.Size {
+			return fmt.Errorf("incomplete copy of %s: got %d bytes, expected %d", header.Name, copied, header.Size)
+		}
+		manifest.Entries = append(manifest.Entries, ManifestEntry{
+			Name:   header.Name,
+			Size:   header.Size,
+			SHA256: hex.EncodeToString(hash.Sum(nil)),
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package io_archive
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// incrementalMovie returns a movie directory with a video and a subtitle
+func incrementalMovie(modTime time.Time) *storage.Memory {
+	source := storage.NewMemory()
+	source.WriteFile("a/movie/movie.mkv", []byte("video content"), modTime)
+	source.WriteFile("a/movie/movie.en.srt", []byte("english"), modTime)
+	return source
+}
+
+// readTree returns the content of the files below dir by slash-separated relative name
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", dir, err)
+	}
+	return files
+}
+
+// changeMovie changes the subtitle and adds another
+func changeMovie(source *storage.Memory, modTime time.Time) {
+	source.WriteFile("a/movie/movie.en.srt", []byte("english, fixed"), modTime)
+	source.WriteFile("a/movie/movie.pt.srt", []byte("portugues"), modTime)
+}
+
+func TestDeltaNames(t *testing.T) {
+	cases := []struct {
+		base  string
+		index int
+		delta string
+	}{
+		{"a/movie.mkv.tar.gz", 1, "a/movie.mkv.delta-001.tar.gz"},
+		{"movie." + EncryptedExtension, 12, "movie.delta-012." + EncryptedExtension},
+	}
+	for _, c := range cases {
+		if got := DeltaName(c.base, c.index); got != c.delta {
+			t.Errorf("DeltaName(%q, %d): expected %q, got %q", c.base, c.index, c.delta, got)
+		}
+		base, index, ok := SplitDelta(c.delta)
+		if !ok || base != c.base || index != c.index {
+			t.Errorf("SplitDelta(%q): expected %q %d, got %q %d %v", c.delta, c.base, c.index, base, index, ok)
+		}
+	}
+	for _, name := range []string{"movie.tar.gz", "movie.delta-1.tar.gz", "movie.delta-000.tar.gz", "movie.delta-001"} {
+		if _, _, ok := SplitDelta(name); ok {
+			t.Errorf("Expected %q not to be a delta", name)
+		}
+	}
+}
+
+func TestCompressIncrementalWritesDeltas(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	source := incrementalMovie(start)
+	target := storage.NewMemory()
+	base := "a/movie.tar.gz"
+
+	manifest, chain, err := CompressIncremental(target, base, source, "a/movie", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if manifest == nil || manifest.Archive != "movie.tar.gz" || chain == nil || len(chain.Deltas) != 0 {
+		t.Fatalf("Expected a base archive and an empty chain, got %+v %+v", manifest, chain)
+	}
+
+	// Nothing changed, nothing is written
+	if manifest, chain, err = CompressIncremental(target, base, source, "a/movie", nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if manifest != nil || len(chain.Deltas) != 0 {
+		t.Fatalf("Expected no delta for an unchanged movie, got %+v", manifest)
+	}
+
+	changeMovie(source, start.Add(time.Hour))
+	if manifest, chain, err = CompressIncremental(target, base, source, "a/movie", nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if manifest == nil || manifest.Archive != "movie.delta-001.tar.gz" {
+		t.Fatalf("Expected delta 1, got %+v", manifest)
+	}
+	var stored []string
+	for _, entry := range manifest.Entries {
+		stored = append(stored, entry.Name)
+	}
+	if expected := []string{"movie/movie.en.srt", "movie/movie.pt.srt"}; !slices.Equal(stored, expected) {
+		t.Errorf("Expected the delta to hold %v, got %v", expected, stored)
+	}
+
+	source.Remove("a/movie/movie.pt.srt")
+	if manifest, chain, err = CompressIncremental(target, base, source, "a/movie", nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(chain.Deltas) != 2 || !slices.Equal(chain.Deltas[1].Removed, []string{"movie/movie.pt.srt"}) {
+		t.Fatalf("Expected delta 2 to remove the subtitle, got %+v", chain.Deltas)
+	}
+	if len(manifest.Entries) != 0 {
+		t.Errorf("Expected no file in delta 2, got %v", manifest.Entries)
+	}
+
+	if archives, _ := FindArchives(target, ""); !slices.Equal(archives, []string{base}) {
+		t.Errorf("Expected only the base to be listed, got %v", archives)
+	}
+
+	dest := t.TempDir()
+	if _, err := ExtractFrom(target, base, dest, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := map[string]string{"movie/movie.mkv": "video content", "movie/movie.en.srt": "english, fixed"}
+	if got := readTree(t, dest); !maps.Equal(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestConsolidateMergesDeltas(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	source := incrementalMovie(start)
+	target := storage.NewMemory()
+	base := "a/movie.tar.gz"
+	opts := &CompressOptions{VolumeSize: 100, Metadata: &MovieMetadata{TmdbID: 603, Title: "The Matrix"}}
+
+	for _, change := range []func(){func() {}, func() { changeMovie(source, start.Add(time.Hour)) }} {
+		change()
+		if _, _, err := CompressIncremental(target, base, source, "a/movie", opts); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	manifest, err := Consolidate(target, base, nil, opts)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if manifest == nil || manifest.Archive != "movie.tar.gz" || len(manifest.Entries) != 3 {
+		t.Fatalf("Expected a base of 3 files, got %+v", manifest)
+	}
+	chain, err := ReadChain(target, base)
+	if err != nil || chain == nil || len(chain.Deltas) != 0 {
+		t.Fatalf("Expected a chain without deltas, got %+v, %v", chain, err)
+	}
+	files, _ := target.List("")
+	for _, file := range files {
+		if _, _, ok := SplitVolume(file.Name); !ok && !file.IsDir && file.Name != ManifestPath(base) &&
+			file.Name != ChainPath(base) {
+			t.Errorf("Expected only the base volumes, manifest and chain, found %s", file.Name)
+		}
+	}
+
+	if metadata, err := ReadMetadata(target, base, nil); err != nil || metadata == nil || metadata.TmdbID != 603 {
+		t.Errorf("Expected the metadata of the base to be kept, got %+v, %v", metadata, err)
+	}
+	result, err := VerifyIn(target, base)
+	if err != nil || !result.OK() {
+		t.Errorf("Expected the new base to verify, got %+v, %v", result, err)
+	}
+	dest := t.TempDir()
+	if _, err := ExtractFrom(target, base, dest, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := map[string]string{
+		"movie/movie.mkv":    "video content",
+		"movie/movie.en.srt": "english, fixed",
+		"movie/movie.pt.srt": "portugues",
+	}
+	if got := readTree(t, dest); !maps.Equal(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	// Later deltas build on the consolidated base
+	source.WriteFile("a/movie/movie.fr.srt", []byte("francais"), start.Add(2*time.Hour))
+	manifest, chain, err = CompressIncremental(target, base, source, "a/movie", opts)
+	if err != nil || manifest == nil || len(manifest.Entries) != 1 || len(chain.Deltas) != 1 {
+		t.Errorf("Expected a delta of the new subtitle, got %+v, %v", manifest, err)
+	}
+}
+
+// renameFailure lets the given number of renames succeed, then fails one
+type renameFailure struct {
+	*storage.Memory
+	renames int
+}
+
+func (s *renameFailure) Rename(oldName, newName string) error {
+	if s.renames == 0 {
+		s.renames = -1
+		return errors.New("connection lost")
+	}
+	s.renames--
+	return s.Memory.Rename(oldName, newName)
+}
+
+func TestConsolidateFinishesInterruptedSwap(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	source := incrementalMovie(start)
+	target := storage.NewMemory()
+	base := "a/movie.tar.gz"
+	opts := &CompressOptions{VolumeSize: 100}
+
+	for _, change := range []func(){func() {}, func() { changeMovie(source, start.Add(time.Hour)) }} {
+		change()
+		if _, _, err := CompressIncremental(target, base, source, "a/movie", opts); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	// Interrupted once the first volume of the new base is moved in
+	if _, err := Consolidate(&renameFailure{Memory: target, renames: 1}, base, nil, opts); err == nil {
+		t.Fatal("Expected the consolidation to fail")
+	}
+	if _, err := ExtractFrom(target, base, t.TempDir(), nil); err == nil || !strings.Contains(err.Error(), "interrupted") {
+		t.Errorf("Expected the mixed base not to be restored, got %v", err)
+	}
+	if removed, err := SweepPartial(target, ""); err != nil || removed != 0 {
+		t.Errorf("Expected the staged base to be kept by the sweep, got %d, %v", removed, err)
+	}
+
+	manifest, err := Consolidate(target, base, nil, opts)
+	if err != nil || manifest == nil || len(manifest.Volumes) < 2 {
+		t.Fatalf("Expected the new base of several volumes to be moved in, got %+v, %v", manifest, err)
+	}
+	chain, err := ReadChain(target, base)
+	if err != nil || chain == nil || len(chain.Deltas) != 0 || chain.Merged != 0 {
+		t.Fatalf("Expected a finished chain without deltas, got %+v, %v", chain, err)
+	}
+	files, _ := target.List("")
+	for _, file := range files {
+		if strings.Contains(file.Name, partialSuffix) || strings.Contains(file.Name, deltaInfix) {
+			t.Errorf("Expected no staged file or delta left, found %s", file.Name)
+		}
+	}
+	result, err := VerifyIn(target, base)
+	if err != nil || !result.OK() {
+		t.Errorf("Expected the new base to verify, got %+v, %v", result, err)
+	}
+	dest := t.TempDir()
+	if _, err := ExtractFrom(target, base, dest, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := map[string]string{
+		"movie/movie.mkv":    "video content",
+		"movie/movie.en.srt": "english, fixed",
+		"movie/movie.pt.srt": "portugues",
+	}
+	if got := readTree(t, dest); !maps.Equal(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestCompressIntoReplacesChain(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	source := incrementalMovie(start)
+	target := storage.NewMemory()
+	base := "a/movie.tar.gz"
+	CompressIncremental(target, base, source, "a/movie", nil)
+	changeMovie(source, start.Add(time.Hour))
+	CompressIncremental(target, base, source, "a/movie", nil)
+
+	if _, err := CompressInto(target, base, source, "a/movie", nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, name := range []string{ChainPath(base), DeltaName(base, 1), ManifestPath(DeltaName(base, 1))} {
+		if info, _ := target.Stat(name); info != nil {
+			t.Errorf("Expected %s to be removed with the chain", name)
+		}
+	}
+}
+
+func TestConsolidateEncryptedNeedsEncryption(t *testing.T) {
+	fastScrypt(t)
+	source := incrementalMovie(time.Now())
+	target := storage.NewMemory()
+	base := "a/movie." + EncryptedExtension
+	opts := &CompressOptions{Encryption: &Encryption{Passphrase: "secret"}}
+	CompressIncremental(target, base, source, "a/movie", opts)
+	changeMovie(source, time.Now().Add(time.Hour))
+	CompressIncremental(target, base, source, "a/movie", opts)
+
+	if _, err := Consolidate(target, base, &Decryption{Passphrase: "secret"}, nil); err == nil {
+		t.Fatal("Expected an error without encryption")
+	}
+	if _, err := Consolidate(target, base, &Decryption{Passphrase: "secret"}, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	dest := t.TempDir()
+	if _, err := ExtractFrom(target, base, dest, &ExtractOptions{Decryption: &Decryption{Passphrase: "secret"}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := readTree(t, dest)["movie/movie.pt.srt"]; got != "portugues" {
+		t.Errorf("Expected the consolidated subtitle, got %q", got)
+	}
+}
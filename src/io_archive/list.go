@@ -0,0 +1,135 @@
+package io_archive
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// ArchiveEntry describes one entry stored in an archive
+type ArchiveEntry struct {
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"modTime"`
+	IsDir   bool        `json:"isDir"`
+	// Link is the target of a symbolic link
+	Link string `json:"link,omitempty"`
+	// HardLink names the entry holding the content of a hard link
+	HardLink string `json:"hardLink,omitempty"`
+}
+
+// ArchiveListing is the content of an archive with its totals
+type ArchiveListing struct {
+	Archive     string         `json:"archive"`
+	Entries     []ArchiveEntry `json:"entries"`
+	Files       int            `json:"files"`
+	TotalSize   int64          `json:"totalSize"`
+	ArchiveSize int64          `json:"archiveSize"`
+	// Skipped files of SkippedSize bytes were left out when compressing,
+	// as recorded in the manifest
+	Skipped     int   `json:"skipped"`
+	SkippedSize int64 `json:"skippedSize"`
+	// Metadata identifies the movie, if embedded in the archive
+	Metadata *MovieMetadata `json:"metadata,omitempty"`
+}
+
+// Ratio returns the compressed size as a fraction of the content size,
+// e.g. 0.25 when the archive is a quarter of its content.
+// Returns 0 for an archive without content.
+func (l *ArchiveListing) Ratio() float64 {
+	if l.TotalSize == 0 {
+		return 0
+	}
+	return float64(l.ArchiveSize) / float64(l.TotalSize)
+}
+
+// List reads the entries of a tar.gz archive without extracting it.
+// Only the tar headers are decoded; file content is streamed and discarded.
+//
+// Example: List("/backups/movie.mkv.tar.gz")
+func List(archivePath string) (*ArchiveListing, error) {
+	if archivePath == "" {
+		return nil, fmt.Errorf("archive path cannot be empty")
+	}
+
+	store, name := localStorage(archivePath)
+	listing, err := ListIn(store, name)
+	if err != nil {
+		return nil, err
+	}
+	listing.Archive = archivePath
+	return listing, nil
+}
+
+// ListIn is List for the archive name in store.
+func ListIn(store storage.Storage, name string) (*ArchiveListing, error) {
+	return ListDecrypted(store, name, nil)
+}
+
+// ListDecrypted is ListIn opening encrypted archives with dec.
+func ListDecrypted(store storage.Storage, name string, dec *Decryption) (*ArchiveListing, error) {
+	info, err := StatArchive(store, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat archive: %w", err)
+	}
+	if info == nil {
+		return nil, fmt.Errorf("failed to open archive: %s does not exist", name)
+	}
+
+	reader, closer, err := openArchive(store, name, dec)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	listing := &ArchiveListing{Archive: name, ArchiveSize: info.Size}
+	manifest, err := ReadManifestIn(store, name)
+	if err != nil {
+		return nil, err
+	}
+	if manifest != nil {
+		listing.Skipped = manifest.Skipped
+		listing.SkippedSize = manifest.SkippedSize
+	}
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		entry := ArchiveEntry{
+			Name:    header.Name,
+			Size:    header.Size,
+			Mode:    header.FileInfo().Mode(),
+			ModTime: header.ModTime,
+			IsDir:   header.Typeflag == tar.TypeDir,
+		}
+		switch header.Typeflag {
+		case tar.TypeSymlink:
+			entry.Link = header.Linkname
+		case tar.TypeLink:
+			entry.HardLink = header.Linkname
+		}
+		if len(listing.Entries) == 0 {
+			if listing.Metadata, err = metadataFromRecords(header.PAXRecords); err != nil {
+				return nil, err
+			}
+		}
+		listing.Entries = append(listing.Entries, entry)
+		if !entry.IsDir {
+			listing.Files++
+			listing.TotalSize += entry.Size
+		}
+	}
+
+	return listing, nil
+}
@@ -0,0 +1,115 @@
+package io_archive
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+func TestListEntries(t *testing.T) {
+	archivePath := createTestArchive(t)
+
+	listing, err := List(archivePath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(listing.Entries) != 3 {
+		t.Errorf("Expected 3 entries, got %d", len(listing.Entries))
+	}
+	if listing.Files != 2 {
+		t.Errorf("Expected 2 files, got %d", listing.Files)
+	}
+
+	expectedSize := int64(len("video content") + len("subtitle"))
+	if listing.TotalSize != expectedSize {
+		t.Errorf("Expected total size %d, got %d", expectedSize, listing.TotalSize)
+	}
+
+	if !listing.Entries[0].IsDir || listing.Entries[0].Name != "movie" {
+		t.Errorf("Expected first entry to be directory 'movie', got '%s'", listing.Entries[0].Name)
+	}
+}
+
+func TestListArchiveSize(t *testing.T) {
+	archivePath := createTestArchive(t)
+
+	listing, err := List(archivePath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		t.Fatalf("Failed to stat archive: %v", err)
+	}
+	if listing.ArchiveSize != info.Size() {
+		t.Errorf("Expected archive size %d, got %d", info.Size(), listing.ArchiveSize)
+	}
+	if listing.Ratio() <= 0 {
+		t.Errorf("Expected positive ratio, got %f", listing.Ratio())
+	}
+}
+
+func TestListRatioEmpty(t *testing.T) {
+	listing := &ArchiveListing{ArchiveSize: 100}
+	if listing.Ratio() != 0 {
+		t.Errorf("Expected ratio 0 for empty content, got %f", listing.Ratio())
+	}
+}
+
+func TestListInvalidArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "invalid."+Extension)
+	if err := os.WriteFile(path, []byte("not gzip"), 0o644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	if _, err := List(path); err == nil {
+		t.Error("Expected error for invalid archive")
+	}
+	if _, err := List(""); err == nil {
+		t.Error("Expected error for empty path")
+	}
+}
+
+func TestListInStorage(t *testing.T) {
+	store := storage.NewMemory()
+	store.WriteFile("movies/movie/movie.mkv", []byte("video content"), time.Now())
+	store.WriteFile("movies/movie/extras/trailer.mkv", []byte("trailer"), time.Now())
+
+	var archive bytes.Buffer
+	manifest, err := CompressFrom(&archive, store, "movies/movie", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(manifest.Entries) != 2 {
+		t.Errorf("Expected 2 manifest entries, got %d", len(manifest.Entries))
+	}
+	store.WriteFile("backups/movie.tar.gz", archive.Bytes(), time.Now())
+
+	listing, err := ListIn(store, "backups/movie.tar.gz")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var names []string
+	for _, entry := range listing.Entries {
+		names = append(names, entry.Name)
+	}
+	expected := []string{"movie", "movie/extras", "movie/extras/trailer.mkv", "movie/movie.mkv"}
+	if len(names) != len(expected) {
+		t.Fatalf("Expected entries %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("Expected entries %v, got %v", expected, names)
+			break
+		}
+	}
+	if listing.ArchiveSize != int64(archive.Len()) {
+		t.Errorf("Expected archive size %d, got %d", archive.Len(), listing.ArchiveSize)
+	}
+}
@@ -0,0 +1,129 @@
+package io_archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// ManifestExtension is appended to an archive path to name its checksum sidecar,
+// e.g. "movie.mkv.tar.gz" -> "movie.mkv.tar.gz.sha256.json".
+const ManifestExtension = "sha256.json"
+
+// Manifest records the checksum of an archive and of every file stored in it.
+type Manifest struct {
+	Archive   string          `json:"archive"`
+	Size      int64           `json:"size"`
+	SHA256    string          `json:"sha256"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Entries   []ManifestEntry `json:"entries"`
+	// Volumes index the files of an archive split with CompressOptions.VolumeSize
+	Volumes []ManifestVolume `json:"volumes,omitempty"`
+	// Skipped counts the files left out by CompressOptions patterns, of SkippedSize bytes
+	Skipped     int   `json:"skipped,omitempty"`
+	SkippedSize int64 `json:"skippedSize,omitempty"`
+	// Warnings describe entries left out for another reason, e.g. symbolic links
+	Warnings []string `json:"warnings,omitempty"`
+	// Policies record the results of CompressOptions.Policies and SampleEntropy
+	Policies []PolicyStats `json:"policies,omitempty"`
+	// Metadata identifies the movie, as embedded with CompressOptions.Metadata
+	Metadata *MovieMetadata `json:"metadata,omitempty"`
+}
+
+// ManifestEntry is the checksum of a single regular file inside an archive.
+type ManifestEntry struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ManifestVolume is the checksum of one volume of a split archive.
+type ManifestVolume struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ManifestPath returns the sidecar path for archivePath.
+func ManifestPath(archivePath string) string {
+	return archivePath + "." + ManifestExtension
+}
+
+// ReadManifest loads the sidecar manifest of archivePath.
+// Returns (nil, nil) if the archive has no manifest.
+func ReadManifest(archivePath string) (*Manifest, error) {
+	store, name := localStorage(archivePath)
+	return ReadManifestIn(store, name)
+}
+
+// ReadManifestIn loads the sidecar manifest of the archive name in store.
+// Returns (nil, nil) if the archive has no manifest.
+func ReadManifestIn(store storage.Storage, name string) (*Manifest, error) {
+	manifestName := ManifestPath(name)
+	info, err := store.Stat(manifestName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if info == nil {
+		return nil, nil
+	}
+	data, err := storage.ReadFile(store, manifestName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", manifestName, err)
+	}
+	return &manifest, nil
+}
+
+// EncodeManifest returns the sidecar file content for manifest.
+func EncodeManifest(manifest *Manifest) ([]byte, error) {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	return data, nil
+}
+
+// writeManifest stores manifest as the sidecar of the archive name in store.
+func writeManifest(store storage.Storage, name string, manifest *Manifest) error {
+	data, err := EncodeManifest(manifest)
+	if err != nil {
+		return err
+	}
+	if err := storage.WriteFile(store, ManifestPath(name), data); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// hashingWriter counts and hashes everything written through it.
+type hashingWriter struct {
+	w    io.Writer
+	hash hash.Hash
+	size int64
+}
+
+func newHashingWriter(w io.Writer) *hashingWriter {
+	return &hashingWriter{w: w, hash: sha256.New()}
+}
+
+func (h *hashingWriter) Write(p []byte) (int, error) {
+	n, err := h.w.Write(p)
+	h.hash.Write(p[:n])
+	h.size += int64(n)
+	return n, err
+}
+
+func (h *hashingWriter) Sum() string {
+	return hex.EncodeToString(h.hash.Sum(nil))
+}
@@ -0,0 +1,101 @@
+package io_archive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// metadataPrefix namespaces the PAX records holding MovieMetadata, following
+// the VENDOR.keyword form other tar readers ignore
+const metadataPrefix = "RADARRSYNC."
+
+// MovieMetadata identifies the movie an archive holds, so a catalog can be
+// rebuilt from the archives alone. It is stored as PAX records of the first
+// entry of the archive and copied to its manifest.
+type MovieMetadata struct {
+	TmdbID  int    `json:"tmdbId,omitempty"`
+	ImdbID  string `json:"imdbId,omitempty"`
+	Title   string `json:"title,omitempty"`
+	Year    int    `json:"year,omitempty"`
+	Quality string `json:"quality,omitempty"`
+	// SourcePath is the movie path relative to the source it was archived from
+	SourcePath string `json:"sourcePath,omitempty"`
+}
+
+// records returns the PAX records of the fields that are set
+func (m *MovieMetadata) records() map[string]string {
+	records := make(map[string]string)
+	set := func(key, value string) {
+		if value != "" && value != "0" {
+			records[metadataPrefix+key] = value
+		}
+	}
+	set("tmdbId", strconv.Itoa(m.TmdbID))
+	set("imdbId", m.ImdbID)
+	set("title", m.Title)
+	set("year", strconv.Itoa(m.Year))
+	set("quality", m.Quality)
+	set("sourcePath", m.SourcePath)
+	return records
+}
+
+// metadataFromRecords returns the metadata stored in the PAX records of a
+// header, or nil if there is none
+func metadataFromRecords(records map[string]string) (*MovieMetadata, error) {
+	var metadata MovieMetadata
+	found := false
+	for key, value := range records {
+		field, ok := strings.CutPrefix(key, metadataPrefix)
+		if !ok {
+			continue
+		}
+		found = true
+		var err error
+		switch field {
+		case "tmdbId":
+			metadata.TmdbID, err = strconv.Atoi(value)
+		case "imdbId":
+			metadata.ImdbID = value
+		case "title":
+			metadata.Title = value
+		case "year":
+			metadata.Year, err = strconv.Atoi(value)
+		case "quality":
+			metadata.Quality = value
+		case "sourcePath":
+			metadata.SourcePath = value
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid movie metadata %s=%q", key, value)
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+	return &metadata, nil
+}
+
+// ReadMetadata returns the movie metadata embedded in the archive name in
+// store, opening encrypted archives with dec. Only the first header is
+// decoded. Returns (nil, nil) if the archive has no metadata.
+func ReadMetadata(store storage.Storage, name string, dec *Decryption) (*MovieMetadata, error) {
+	reader, closer, err := openArchive(store, name, dec)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	header, err := reader.Next()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+	return metadataFromRecords(header.PAXRecords)
+}
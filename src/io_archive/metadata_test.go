@@ -0,0 +1,91 @@
+package io_archive
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+func TestCompressEmbedsMetadata(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "Amélie (2001)")
+	if err := os.MkdirAll(source, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "movie.mkv"), []byte("video content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	metadata := &MovieMetadata{
+		TmdbID:     194,
+		ImdbID:     "tt0211915",
+		Title:      "Le Fabuleux Destin d'Amélie Poulain",
+		Year:       2001,
+		Quality:    "Bluray-1080p",
+		SourcePath: "a/Amélie (2001)",
+	}
+	target := t.TempDir()
+	archivePath, err := Compress(source, target, &CompressOptions{Metadata: metadata})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	embedded, err := ReadMetadata(storage.NewLocal(target), filepath.Base(archivePath), nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(embedded, metadata) {
+		t.Errorf("Expected %+v, got %+v", metadata, embedded)
+	}
+	manifest, err := ReadManifest(archivePath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(manifest.Metadata, metadata) {
+		t.Errorf("Expected manifest metadata %+v, got %+v", metadata, manifest.Metadata)
+	}
+	listing, err := List(archivePath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(listing.Metadata, metadata) {
+		t.Errorf("Expected listing metadata %+v, got %+v", metadata, listing.Metadata)
+	}
+
+	// The records are not extracted as files
+	dest := t.TempDir()
+	extracted, err := Extract(archivePath, dest, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := []string{filepath.Join(dest, "Amélie (2001)"), filepath.Join(dest, "Amélie (2001)", "movie.mkv")}
+	if !reflect.DeepEqual(extracted, expected) {
+		t.Errorf("Expected %v extracted, got %v", expected, extracted)
+	}
+}
+
+func TestReadMetadataWithoutMetadata(t *testing.T) {
+	source := storage.NewMemory()
+	source.WriteFile("movie.mkv", []byte("video content"), time.Now())
+	target := storage.NewMemory()
+	if _, err := CompressInto(target, "movie.mkv.tar.gz", source, "movie.mkv", nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	metadata, err := ReadMetadata(target, "movie.mkv.tar.gz", nil)
+	if err != nil || metadata != nil {
+		t.Errorf("Expected no metadata, got %+v, %v", metadata, err)
+	}
+}
+
+func TestMetadataFromRecordsRejectsInvalidNumbers(t *testing.T) {
+	if _, err := metadataFromRecords(map[string]string{metadataPrefix + "year": "two thousand"}); err == nil {
+		t.Error("Expected error for an invalid year")
+	}
+	metadata, err := metadataFromRecords(map[string]string{"SCHILY.xattr.user.test": "x"})
+	if err != nil || metadata != nil {
+		t.Errorf("Expected other records to be ignored, got %+v, %v", metadata, err)
+	}
+}
@@ -0,0 +1,314 @@
+package io_archive
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// Names of the policies applied to files no CompressionPolicy matches
+const (
+	// DefaultPolicy compresses at CompressOptions.CompressionLevel
+	DefaultPolicy = "default"
+	// IncompressiblePolicy stores files whose sampled content looks
+	// already compressed, see CompressOptions.SampleEntropy
+	IncompressiblePolicy = "incompressible"
+)
+
+const (
+	// entropySampleSize is how much of a file is read to estimate its entropy
+	entropySampleSize = 64 << 10
+	// incompressibleEntropy is the entropy in bits per byte above which
+	// content is not worth compressing
+	incompressibleEntropy = 7.5
+)
+
+// CompressionPolicy selects the gzip level of the files matching it
+type CompressionPolicy struct {
+	// Name labels the policy in manifests and reports, e.g. "media"
+	Name string
+	// Patterns select files as MatchPattern, ignoring case, e.g. "*.mkv"
+	Patterns []string
+	// Level is the gzip level from 0, storing files uncompressed, to 9
+	Level int
+}
+
+// DefaultPolicies stores already compressed media uncompressed and
+// compresses text sidecars at the highest level
+func DefaultPolicies() []CompressionPolicy {
+	return []CompressionPolicy{
+		{Name: "media", Level: gzip.NoCompression, Patterns: []string{
+			"*.mkv", "*.mp4", "*.m4v", "*.avi", "*.mov", "*.wmv", "*.webm", "*.ts", "*.m2ts",
+			"*.mp3", "*.aac", "*.ac3", "*.flac", "*.jpg", "*.jpeg", "*.png", "*.webp",
+			"*.zip", "*.rar", "*.7z", "*.gz",
+		}},
+		{Name: "text", Level: gzip.BestCompression, Patterns: []string{
+			"*.srt", "*.sub", "*.idx", "*.ass", "*.ssa", "*.vtt", "*.nfo", "*.txt", "*.xml", "*.json",
+		}},
+	}
+}
+
+// ParseCompressionPolicy parses a policy written as name=level:pattern,...
+// e.g. "media=0:*.mkv,*.mp4"
+func ParseCompressionPolicy(value string) (CompressionPolicy, error) {
+	name, rest, found := strings.Cut(value, "=")
+	level, patterns, hasPatterns := strings.Cut(rest, ":")
+	if !found || !hasPatterns || name == "" || patterns == "" {
+		return CompressionPolicy{}, fmt.Errorf("invalid policy %q, expected name=level:pattern,...", value)
+	}
+	policy := CompressionPolicy{Name: name, Patterns: strings.Split(patterns, ",")}
+	var err error
+	if policy.Level, err = strconv.Atoi(level); err != nil {
+		return CompressionPolicy{}, fmt.Errorf("invalid level in policy %q: %w", value, err)
+	}
+	return policy, validatePolicy(policy)
+}
+
+func validatePolicy(policy CompressionPolicy) error {
+	if policy.Level < gzip.NoCompression || policy.Level > gzip.BestCompression {
+		return fmt.Errorf("invalid level %d in policy %s, expected 0 to 9", policy.Level, policy.Name)
+	}
+	for _, pattern := range policy.Patterns {
+		if err := ValidatePattern(pattern); err != nil {
+			return fmt.Errorf("policy %s: %w", policy.Name, err)
+		}
+	}
+	return nil
+}
+
+// PolicyStats records what a compression policy achieved in an archive
+type PolicyStats struct {
+	Name  string `json:"name"`
+	Level int    `json:"level"`
+	Files int    `json:"files"`
+	// Size is the content of the files, CompressedSize what it was
+	// compressed to, tar headers included
+	Size           int64 `json:"size"`
+	CompressedSize int64 `json:"compressedSize"`
+}
+
+// Saved returns the bytes saved by compressing, negative when stored
+// files grew by their framing
+func (s PolicyStats) Saved() int64 {
+	return s.Size - s.CompressedSize
+}
+
+// gzipStream compresses the tar stream of an archive. With policies or
+// entropy sampling, each run of files sharing a policy is written as its own
+// gzip member at the policy level; readers join the members transparently.
+type gzipStream struct {
+	out      *countingWriter
+	gz       *gzip.Writer
+	policies []CompressionPolicy
+	sample   bool
+	// stats of each policy in use, current is that of the open member
+	// started at offset start of out
+	stats   []*PolicyStats
+	current *PolicyStats
+	start   int64
+}
+
+func newGzipStream(out io.Writer, opts *CompressOptions) (*gzipStream, error) {
+	stream := &gzipStream{out: &countingWriter{w: out}}
+	if opts != nil {
+		stream.policies = opts.Policies
+		stream.sample = opts.SampleEntropy
+	}
+	for _, policy := range stream.policies {
+		if err := validatePolicy(policy); err != nil {
+			return nil, err
+		}
+	}
+	if err := stream.use(DefaultPolicy, getCompressionLevel(opts)); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// adaptive reports whether files may be compressed at different levels
+func (s *gzipStream) adaptive() bool {
+	return len(s.policies) > 0 || s.sample
+}
+
+func (s *gzipStream) Write(p []byte) (int, error) {
+	return s.gz.Write(p)
+}
+
+// selectFor switches to the policy of the file at entry rel, relative to the
+// archived directory, before its header and content are written. A nil
+// source, for content copied from another archive, is never sampled.
+func (s *gzipStream) selectFor(source storage.Storage, file storage.File, rel string) error {
+	if !s.adaptive() {
+		return nil
+	}
+	name, level := DefaultPolicy, s.defaultLevel()
+	if policy := s.match(rel); policy != nil {
+		name, level = policy.Name, policy.Level
+	} else if s.sample && source != nil {
+		incompressible, err := isIncompressible(source, file)
+		if err != nil {
+			return err
+		}
+		if incompressible {
+			name, level = IncompressiblePolicy, gzip.NoCompression
+		}
+	}
+	if err := s.use(name, level); err != nil {
+		return err
+	}
+	s.current.Files++
+	s.current.Size += file.Size
+	return nil
+}
+
+func (s *gzipStream) defaultLevel() int {
+	for _, stats := range s.stats {
+		if stats.Name == DefaultPolicy {
+			return stats.Level
+		}
+	}
+	return 0
+}
+
+// match returns the first policy matching rel, or nil
+func (s *gzipStream) match(rel string) *CompressionPolicy {
+	return matchPolicy(s.policies, rel)
+}
+
+// matchPolicy returns the first of policies matching rel, or nil
+func matchPolicy(policies []CompressionPolicy, rel string) *CompressionPolicy {
+	rel = strings.ToLower(rel)
+	for i, policy := range policies {
+		for _, pattern := range policy.Patterns {
+			if MatchPattern(strings.ToLower(pattern), rel) {
+				return &policies[i]
+			}
+		}
+	}
+	return nil
+}
+
+// use continues the current member if it belongs to policy name,
+// otherwise closes it and starts a member at level
+func (s *gzipStream) use(name string, level int) error {
+	if s.current != nil && s.current.Name == name {
+		return nil
+	}
+	if err := s.closeMember(); err != nil {
+		return err
+	}
+
+	var stats *PolicyStats
+	for _, existing := range s.stats {
+		if existing.Name == name {
+			stats = existing
+		}
+	}
+	if stats == nil {
+		stats = &PolicyStats{Name: name, Level: level}
+		s.stats = append(s.stats, stats)
+	}
+
+	// The member header is left without name and MTIME, so equal input
+	// always compresses to equal bytes
+	gz, err := gzip.NewWriterLevel(s.out, level)
+	if err != nil {
+		return fmt.Errorf("failed to create gzip writer: %w", err)
+	}
+	s.gz, s.current, s.start = gz, stats, s.out.n
+	return nil
+}
+
+func (s *gzipStream) closeMember() error {
+	if s.gz == nil {
+		return nil
+	}
+	if err := s.gz.Close(); err != nil {
+		return fmt.Errorf("failed to close gzip writer: %w", err)
+	}
+	s.current.CompressedSize += s.out.n - s.start
+	s.gz = nil
+	return nil
+}
+
+// Close ends the last member
+func (s *gzipStream) Close() error {
+	return s.closeMember()
+}
+
+// results returns the stats of the policies applied to files,
+// or nil unless compression is adaptive
+func (s *gzipStream) results() []PolicyStats {
+	if !s.adaptive() {
+		return nil
+	}
+	var results []PolicyStats
+	for _, stats := range s.stats {
+		if stats.Files > 0 {
+			results = append(results, *stats)
+		}
+	}
+	return results
+}
+
+// isIncompressible estimates the entropy of a sample of file, taken from its
+// middle when the source can seek. Small files are always compressed.
+func isIncompressible(source storage.Storage, file storage.File) (bool, error) {
+	if file.Size < entropySampleSize {
+		return false, nil
+	}
+	reader, err := source.Open(file.Name)
+	if err != nil {
+		return false, fmt.Errorf("failed to open file %s: %w", file.Name, err)
+	}
+	defer reader.Close()
+
+	// Containers start with low entropy headers, the middle is representative
+	if seeker, ok := reader.(io.Seeker); ok && file.Size > 2*entropySampleSize {
+		if _, err := seeker.Seek(file.Size/2, io.SeekStart); err != nil {
+			return false, fmt.Errorf("failed to sample %s: %w", file.Name, err)
+		}
+	}
+	sample := make([]byte, entropySampleSize)
+	n, err := io.ReadFull(reader, sample)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return false, fmt.Errorf("failed to sample %s: %w", file.Name, err)
+	}
+	return entropy(sample[:n]) > incompressibleEntropy, nil
+}
+
+// entropy returns the Shannon entropy of data in bits per byte
+func entropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+	var bits float64
+	for _, count := range counts {
+		if count > 0 {
+			p := float64(count) / float64(len(data))
+			bits -= p * math.Log2(p)
+		}
+	}
+	return bits
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,114 @@
+package io_archive
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// policySource returns a movie with compressed media, a subtitle, and
+// unmatched files with random and repetitive content
+func policySource() *storage.Memory {
+	media := make([]byte, 200<<10)
+	rand.Read(media)
+	noise := make([]byte, 100<<10)
+	rand.Read(noise)
+
+	source := storage.NewMemory()
+	now := time.Now()
+	source.WriteFile("movie/movie.MKV", media, now)
+	source.WriteFile("movie/movie.srt", bytes.Repeat([]byte("1\n00:00:01,000 --> 00:00:02,000\nHello\n\n"), 500), now)
+	source.WriteFile("movie/extras/noise.bin", noise, now)
+	source.WriteFile("movie/extras/table.dat", bytes.Repeat([]byte("row;"), 50<<10), now)
+	return source
+}
+
+func TestCompressAppliesPolicies(t *testing.T) {
+	source := policySource()
+	target := storage.NewMemory()
+	opts := &CompressOptions{Policies: DefaultPolicies(), SampleEntropy: true}
+	manifest, err := CompressInto(target, "movie.tar.gz", source, "movie", opts)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	stats := make(map[string]PolicyStats)
+	for _, policy := range manifest.Policies {
+		stats[policy.Name] = policy
+	}
+	if len(stats) != 4 {
+		t.Fatalf("Expected 4 policies applied, got %+v", manifest.Policies)
+	}
+	if media := stats["media"]; media.Files != 1 || media.Level != 0 || media.Saved() > 0 {
+		t.Errorf("Expected media stored uncompressed, got %+v", media)
+	}
+	if text := stats["text"]; text.Files != 1 || text.Level != 9 || text.Saved() < text.Size/2 {
+		t.Errorf("Expected subtitle compressed at level 9, got %+v", text)
+	}
+	if noise := stats[IncompressiblePolicy]; noise.Files != 1 || noise.Size != 100<<10 {
+		t.Errorf("Expected random file detected as incompressible, got %+v", noise)
+	}
+	if table := stats[DefaultPolicy]; table.Files != 1 || table.Level != 7 || table.Saved() < table.Size/2 {
+		t.Errorf("Expected repetitive file compressed at the default level, got %+v", table)
+	}
+
+	// The gzip members read back as one archive
+	result, err := VerifyIn(target, "movie.tar.gz")
+	if err != nil || !result.OK() {
+		t.Fatalf("Expected valid archive, got %+v, %v", result, err)
+	}
+	dest := t.TempDir()
+	if _, err := ExtractFrom(target, "movie.tar.gz", dest, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected, _ := storage.ReadFile(source, "movie/extras/table.dat")
+	content, err := os.ReadFile(filepath.Join(dest, "movie", "extras", "table.dat"))
+	if err != nil || !bytes.Equal(content, expected) {
+		t.Errorf("Expected extracted content to match, got %d bytes, %v", len(content), err)
+	}
+}
+
+func TestCompressWithoutPoliciesRecordsNone(t *testing.T) {
+	manifest, err := CompressInto(storage.NewMemory(), "movie.tar.gz", policySource(), "movie", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if manifest.Policies != nil {
+		t.Errorf("Expected no policy results, got %+v", manifest.Policies)
+	}
+}
+
+func TestParseCompressionPolicy(t *testing.T) {
+	policy, err := ParseCompressionPolicy("media=0:*.mkv,*.mp4")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if policy.Name != "media" || policy.Level != 0 || len(policy.Patterns) != 2 || policy.Patterns[1] != "*.mp4" {
+		t.Errorf("Unexpected policy %+v", policy)
+	}
+
+	for _, value := range []string{"media", "media=0", "=0:*.mkv", "media=x:*.mkv", "media=10:*.mkv", "media=1:[abc"} {
+		if _, err := ParseCompressionPolicy(value); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	random := make([]byte, entropySampleSize)
+	rand.Read(random)
+	if bits := entropy(random); bits < incompressibleEntropy {
+		t.Errorf("Expected random data above %.1f bits per byte, got %.2f", incompressibleEntropy, bits)
+	}
+	if bits := entropy(bytes.Repeat([]byte("ab"), 1000)); bits != 1 {
+		t.Errorf("Expected 1 bit per byte, got %.2f", bits)
+	}
+	if bits := entropy(nil); bits != 0 {
+		t.Errorf("Expected no entropy for empty data, got %.2f", bits)
+	}
+}
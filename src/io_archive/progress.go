@@ -0,0 +1,92 @@
+package io_archive
+
+import "time"
+
+// progressStep is how many bytes of a file are read between progress reports
+var progressStep int64 = 4 << 20
+
+// Progress reports how far writing an archive is. It is sent when a file
+// starts, every few MiB of its content and once the archive is complete.
+type Progress struct {
+	// File is the entry name of the file being read
+	File string
+	// Read is the file content read so far, of Total bytes to archive.
+	// Total leaves out filtered files and hard links.
+	Read  int64
+	Total int64
+	// Written is the archive size so far, behind Read by what the
+	// compressor buffers
+	Written int64
+	// Elapsed is the time since the archive was started
+	Elapsed time.Duration
+	// Done is set on the last report, once the archive is complete
+	Done bool
+}
+
+// Throughput returns the content read per second
+func (p Progress) Throughput() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Read) / p.Elapsed.Seconds()
+}
+
+// progressTracker counts the content copied into an archive and sends
+// reports to CompressOptions.Progress. A nil tracker reports nothing.
+type progressTracker struct {
+	report   func(Progress)
+	progress Progress
+	started  time.Time
+	written  *hashingWriter
+	// next is the Read count of the next report
+	next int64
+}
+
+func newProgressTracker(opts *CompressOptions, total int64, written *hashingWriter) *progressTracker {
+	if opts == nil || opts.Progress == nil {
+		return nil
+	}
+	return &progressTracker{
+		report:   opts.Progress,
+		progress: Progress{Total: total},
+		started:  time.Now(),
+		written:  written,
+	}
+}
+
+// Write counts copied content, so the tracker can be written to alongside the archive
+func (t *progressTracker) Write(p []byte) (int, error) {
+	if t == nil {
+		return len(p), nil
+	}
+	t.progress.Read += int64(len(p))
+	if t.progress.Read >= t.next {
+		t.send()
+	}
+	return len(p), nil
+}
+
+// startFile reports that the content of entry name is read next
+func (t *progressTracker) startFile(name string) {
+	if t == nil {
+		return
+	}
+	t.progress.File = name
+	t.send()
+}
+
+// finish sends the last report
+func (t *progressTracker) finish() {
+	if t == nil {
+		return
+	}
+	t.progress.Done = true
+	t.send()
+}
+
+func (t *progressTracker) send() {
+	t.progress.Written = t.written.size
+	t.progress.Elapsed = time.Since(t.started)
+	t.next = t.progress.Read + progressStep
+	t.report(t.progress)
+}
@@ -0,0 +1,57 @@
+package io_archive
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+func TestCompressReportsProgress(t *testing.T) {
+	defer func(step int64) { progressStep = step }(progressStep)
+	progressStep = 100
+
+	source := storage.NewMemory()
+	now := time.Now()
+	source.WriteFile("movie/movie.mkv", bytes.Repeat([]byte("v"), 1000), now)
+	source.WriteFile("movie/movie.nfo", []byte("info"), now)
+	source.WriteFile("movie/movie.mkv.part", []byte("partial"), now)
+
+	var reports []Progress
+	opts := &CompressOptions{
+		Exclude:  []string{"*.part"},
+		Progress: func(progress Progress) { reports = append(reports, progress) },
+	}
+	manifest, err := CompressInto(storage.NewMemory(), "movie.tar.gz", source, "movie", opts)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// A report when each file starts, after movie.mkv is read past the
+	// step in one write, and at the end
+	if len(reports) != 4 {
+		t.Fatalf("Expected 4 reports, got %+v", reports)
+	}
+	if reports[0].File != "movie/movie.mkv" || reports[0].Read != 0 {
+		t.Errorf("Expected first report at the start of movie.mkv, got %+v", reports[0])
+	}
+	if reports[1].File != "movie/movie.mkv" || reports[1].Read != 1000 {
+		t.Errorf("Expected report after reading movie.mkv, got %+v", reports[1])
+	}
+	for i, progress := range reports {
+		if progress.Total != 1004 {
+			t.Fatalf("Expected total of 1004 bytes without excluded files, got %d", progress.Total)
+		}
+		if i > 0 && progress.Read < reports[i-1].Read {
+			t.Errorf("Expected read bytes not to decrease, got %d after %d", progress.Read, reports[i-1].Read)
+		}
+		if progress.Done != (i == len(reports)-1) {
+			t.Errorf("Expected only the last report to be done, got %+v at %d", progress, i)
+		}
+	}
+	last := reports[len(reports)-1]
+	if last.Read != last.Total || last.Written != manifest.Size || last.File != "movie/movie.nfo" {
+		t.Errorf("Expected complete last report, got %+v", last)
+	}
+}
@@ -0,0 +1,112 @@
+package io_archive
+
+import (
+	"io"
+	"time"
+)
+
+// sleep waits between throttled transfers, replaced in tests
+var sleep = time.Sleep
+
+// Throttle limits the resources compression takes from other users of the disks
+type Throttle struct {
+	// ReadRate caps the bytes per second read from the source, 0 for no limit
+	ReadRate int64
+	// WriteRate caps the bytes per second written to the archive, 0 for no limit
+	WriteRate int64
+	// Wait, if set, is called before each chunk of file content is read
+	// and blocks while compression is paused
+	Wait func()
+}
+
+// rateLimiter is a token bucket allowing rate bytes per second, in bursts
+// of up to one second of transfer
+type rateLimiter struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter of rate bytes per second, or nil for no limit
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(rate), tokens: float64(rate)}
+}
+
+// take waits until n bytes may be transferred
+func (l *rateLimiter) take(n int) {
+	if l == nil {
+		return
+	}
+	now := time.Now()
+	if !l.last.IsZero() && now.After(l.last) {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens < 0 {
+		wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+		sleep(wait)
+		l.tokens = 0
+		l.last = now.Add(wait)
+	}
+}
+
+// readThrottle limits the reads of every file of an archive together.
+// A nil readThrottle leaves reads unlimited.
+type readThrottle struct {
+	limiter *rateLimiter
+	wait    func()
+}
+
+func newReadThrottle(opts *CompressOptions) *readThrottle {
+	if opts == nil || opts.Throttle == nil || (opts.Throttle.ReadRate <= 0 && opts.Throttle.Wait == nil) {
+		return nil
+	}
+	return &readThrottle{limiter: newRateLimiter(opts.Throttle.ReadRate), wait: opts.Throttle.Wait}
+}
+
+// wrap returns r limited by the throttle
+func (t *readThrottle) wrap(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &throttledReader{r: r, throttle: t}
+}
+
+// throttledReader reads through a rate limiter, pausing while wait blocks.
+// It hides io.WriterTo of r, so copies go through Read in chunks.
+type throttledReader struct {
+	r        io.Reader
+	throttle *readThrottle
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if t.throttle.wait != nil {
+		t.throttle.wait()
+	}
+	n, err := t.r.Read(p)
+	t.throttle.limiter.take(n)
+	return n, err
+}
+
+// throttledWriter writes through a rate limiter
+type throttledWriter struct {
+	w       io.Writer
+	limiter *rateLimiter
+}
+
+// newThrottledWriter returns w limited to the write rate of opts
+func newThrottledWriter(w io.Writer, opts *CompressOptions) io.Writer {
+	if opts == nil || opts.Throttle == nil || opts.Throttle.WriteRate <= 0 {
+		return w
+	}
+	return &throttledWriter{w: w, limiter: newRateLimiter(opts.Throttle.WriteRate)}
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	t.limiter.take(len(p))
+	return t.w.Write(p)
+}
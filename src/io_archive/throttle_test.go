@@ -0,0 +1,77 @@
+package io_archive
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// recordSleeps replaces sleep with one adding up the requested waits
+func recordSleeps(t *testing.T) *time.Duration {
+	t.Helper()
+	var slept time.Duration
+	original := sleep
+	sleep = func(d time.Duration) { slept += d }
+	t.Cleanup(func() { sleep = original })
+	return &slept
+}
+
+func TestCompressLimitsReadRate(t *testing.T) {
+	slept := recordSleeps(t)
+	content := make([]byte, 300<<10)
+	rand.Read(content)
+	source := storage.NewMemory()
+	source.WriteFile("movie/movie.mkv", content, time.Now())
+
+	waits := 0
+	opts := &CompressOptions{Throttle: &Throttle{ReadRate: 100 << 10, Wait: func() { waits++ }}}
+	if _, err := CompressInto(storage.NewMemory(), "movie.tar.gz", source, "movie", opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// The first second of transfer is allowed as a burst
+	if *slept < 1900*time.Millisecond || *slept > 2100*time.Millisecond {
+		t.Errorf("Expected about 2s of waiting at 100 KiB/s, got %v", *slept)
+	}
+	if waits < 2 {
+		t.Errorf("Expected Wait before each chunk, got %d calls", waits)
+	}
+}
+
+func TestCompressLimitsWriteRate(t *testing.T) {
+	slept := recordSleeps(t)
+	content := make([]byte, 300<<10)
+	rand.Read(content)
+	source := storage.NewMemory()
+	source.WriteFile("movie.mkv", content, time.Now())
+
+	opts := &CompressOptions{Throttle: &Throttle{WriteRate: 100 << 10}}
+	manifest, err := CompressInto(storage.NewMemory(), "movie.mkv.tar.gz", source, "movie.mkv", opts)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := time.Duration(float64(manifest.Size-100<<10) / float64(100<<10) * float64(time.Second))
+	if diff := *slept - expected; diff < -100*time.Millisecond || diff > 100*time.Millisecond {
+		t.Errorf("Expected about %v of waiting, got %v", expected, *slept)
+	}
+}
+
+func TestRateLimiterRefillsOverTime(t *testing.T) {
+	slept := recordSleeps(t)
+	limiter := newRateLimiter(1000)
+	limiter.take(1000)
+	if *slept != 0 {
+		t.Fatalf("Expected burst without waiting, got %v", *slept)
+	}
+	limiter.last = limiter.last.Add(-500 * time.Millisecond)
+	limiter.take(1000)
+	if *slept < 490*time.Millisecond || *slept > 510*time.Millisecond {
+		t.Errorf("Expected to wait for the missing half second, got %v", *slept)
+	}
+	if newRateLimiter(0) != nil {
+		t.Error("Expected no limiter without a rate")
+	}
+}
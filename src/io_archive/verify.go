@@ -0,0 +1,267 @@
+package io_archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// VerifyStatus is the outcome of verifying a single archive.
+type VerifyStatus string
+
+const (
+	VerifyOK         VerifyStatus = "ok"
+	VerifyCorrupt    VerifyStatus = "corrupt"
+	VerifyMissing    VerifyStatus = "missing"
+	VerifyNoManifest VerifyStatus = "no-manifest"
+)
+
+const verifyBufferSize = 32 * 1024
+
+// VerifyResult describes the state of one archive and, when not OK, why.
+type VerifyResult struct {
+	Archive  string
+	Status   VerifyStatus
+	Problems []string
+}
+
+// OK reports whether the archive matched its manifest.
+func (r VerifyResult) OK() bool {
+	return r.Status == VerifyOK
+}
+
+// Verify decompresses archivePath, joining its volumes, and checks the archive
+// checksum, the volume sizes and every file checksum against its sidecar manifest. Encrypted archives are checked
+// against the archive checksum only, so no keys are needed.
+// Corruption is reported in the result; error is returned only when the
+// archive or manifest cannot be accessed.
+func Verify(archivePath string) (VerifyResult, error) {
+	store, name := localStorage(archivePath)
+	result, err := VerifyIn(store, name)
+	result.Archive = archivePath
+	return result, err
+}
+
+// VerifyIn is Verify for the archive name in store.
+func VerifyIn(store storage.Storage, name string) (VerifyResult, error) {
+	result := VerifyResult{Archive: name}
+
+	manifest, err := ReadManifestIn(store, name)
+	if err != nil {
+		return result, err
+	}
+
+	info, err := StatArchive(store, name)
+	if err != nil {
+		return result, fmt.Errorf("cannot access archive: %w", err)
+	}
+	if info == nil {
+		result.Status = VerifyMissing
+		return result, nil
+	}
+
+	if manifest == nil {
+		result.Status = VerifyNoManifest
+		return result, nil
+	}
+
+	result.Problems, err = checkArchive(store, name, manifest)
+	if err != nil {
+		return result, err
+	}
+
+	result.Status = VerifyOK
+	if len(result.Problems) > 0 {
+		result.Status = VerifyCorrupt
+	}
+	return result, nil
+}
+
+// VerifyAll verifies every archive in store, including archives whose
+// manifest exists but whose archive file is gone. Archives below skipDir,
+// e.g. a trash directory, are left out when it is set.
+func VerifyAll(store storage.Storage, skipDir string) ([]VerifyResult, error) {
+	archives, err := FindArchives(store, "")
+	if err != nil {
+		return nil, err
+	}
+	var manifests []string
+	for _, extension := range []string{Extension, EncryptedExtension} {
+		found, err := FindIn(store, "", "*."+extension+"."+ManifestExtension)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, found...)
+	}
+
+	known := make(map[string]bool, len(archives))
+	for _, archive := range archives {
+		known[archive] = true
+	}
+	for _, manifest := range manifests {
+		archive := strings.TrimSuffix(manifest, "."+ManifestExtension)
+		if !known[archive] {
+			archives = append(archives, archive)
+		}
+	}
+
+	skipDir = strings.Trim(skipDir, "/")
+	results := make([]VerifyResult, 0, len(archives))
+	for _, archive := range archives {
+		if skipDir != "" && strings.HasPrefix(archive, skipDir+"/") {
+			continue
+		}
+		result, err := VerifyIn(store, archive)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify %s: %w", archive, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// checkArchive streams the archive once, hashing both the raw archive bytes and
+// the content of each entry, and returns the mismatches against manifest.
+func checkArchive(store storage.Storage, name string, manifest *Manifest) ([]string, error) {
+	volumeProblems, err := checkVolumes(store, name, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := OpenArchive(store, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer file.Close()
+
+	archiveHash := newHashingWriter(io.Discard)
+	raw := io.TeeReader(file, archiveHash)
+
+	// Encrypted archives are checked against the archive checksum only,
+	// which covers their authenticated ciphertext
+	var problems []string
+	if !IsEncrypted(name) {
+		var readErr error
+		problems, readErr = checkEntries(raw, manifest)
+		if readErr != nil {
+			problems = append(problems, readErr.Error())
+		}
+	}
+
+	// Consume trailing bytes so the archive checksum covers the whole file
+	if _, err := io.Copy(io.Discard, raw); err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	if archiveHash.size != manifest.Size {
+		problems = append(problems, fmt.Sprintf("archive size %d, expected %d", archiveHash.size, manifest.Size))
+	}
+	if archiveHash.Sum() != manifest.SHA256 {
+		problems = append(problems, "archive checksum mismatch")
+	}
+	return append(volumeProblems, problems...), nil
+}
+
+// checkVolumes compares the stored volumes of archive name with the volume
+// index of manifest. The content of volumes is covered by the archive checksum.
+func checkVolumes(store storage.Storage, name string, manifest *Manifest) ([]string, error) {
+	files, err := ArchiveFiles(store, name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot access archive: %w", err)
+	}
+	if len(manifest.Volumes) == 0 {
+		if len(files) != 1 || files[0] != name {
+			return []string{fmt.Sprintf("expected a single file, found %d volumes", len(files))}, nil
+		}
+		return nil, nil
+	}
+
+	var problems []string
+	for index, volume := range manifest.Volumes {
+		info, err := store.Stat(VolumeName(name, index+1))
+		if err != nil {
+			return nil, fmt.Errorf("cannot access volume: %w", err)
+		}
+		switch {
+		case info == nil:
+			problems = append(problems, fmt.Sprintf("missing volume %s", volume.Name))
+		case info.Size != volume.Size:
+			problems = append(problems, fmt.Sprintf("volume %s size %d, expected %d", volume.Name, info.Size, volume.Size))
+		}
+	}
+	if len(files) > len(manifest.Volumes) {
+		problems = append(problems, fmt.Sprintf("unexpected volumes after %d", len(manifest.Volumes)))
+	}
+	return problems, nil
+}
+
+// checkEntries compares the files stored in the gzip tar stream r with manifest.
+// The returned error describes a stream that could not be fully decoded.
+func checkEntries(r io.Reader, manifest *Manifest) ([]string, error) {
+	expected := make(map[string]ManifestEntry, len(manifest.Entries))
+	for _, entry := range manifest.Entries {
+		expected[entry.Name] = entry
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("unreadable gzip stream: %w", err)
+	}
+	defer gz.Close()
+
+	var problems []string
+	reader := tar.NewReader(gz)
+	buf := make([]byte, verifyBufferSize)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return problems, fmt.Errorf("unreadable tar stream: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		hash := sha256.New()
+		size, err := io.CopyBuffer(hash, reader, buf)
+		if err != nil {
+			return problems, fmt.Errorf("truncated entry %s: %w", header.Name, err)
+		}
+
+		entry, ok := expected[header.Name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unexpected entry %s", header.Name))
+			continue
+		}
+		delete(expected, header.Name)
+
+		if size != entry.Size || hex.EncodeToString(hash.Sum(nil)) != entry.SHA256 {
+			problems = append(problems, fmt.Sprintf("checksum mismatch for %s", header.Name))
+		}
+	}
+
+	// Drain the gzip stream so its CRC and size trailer are validated
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		return problems, fmt.Errorf("unreadable gzip stream: %w", err)
+	}
+
+	missing := make([]string, 0, len(expected))
+	for name := range expected {
+		missing = append(missing, name)
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		problems = append(problems, fmt.Sprintf("missing entry %s", name))
+	}
+	return problems, nil
+}
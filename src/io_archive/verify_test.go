@@ -0,0 +1,175 @@
+package io_archive
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+func createTestArchive(t *testing.T) string {
+	t.Helper()
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+
+	movieDir := filepath.Join(sourceDir, "movie")
+	if err := os.Mkdir(movieDir, 0o755); err != nil {
+		t.Fatalf("Failed to create movie directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(movieDir, "movie.mkv"), []byte("video content"), 0o644); err != nil {
+		t.Fatalf("Failed to create movie file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(movieDir, "movie.srt"), []byte("subtitle"), 0o644); err != nil {
+		t.Fatalf("Failed to create subtitle file: %v", err)
+	}
+
+	outputPath, err := Compress(movieDir, targetDir, nil)
+	if err != nil {
+		t.Fatalf("Failed to compress: %v", err)
+	}
+	return outputPath
+}
+
+func TestCompressWritesManifest(t *testing.T) {
+	archivePath := createTestArchive(t)
+
+	manifest, err := ReadManifest(archivePath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if manifest == nil {
+		t.Fatal("Expected manifest, got nil")
+	}
+
+	if len(manifest.Entries) != 2 {
+		t.Errorf("Expected 2 manifest entries, got %d", len(manifest.Entries))
+	}
+
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		t.Fatalf("Failed to stat archive: %v", err)
+	}
+	if manifest.Size != info.Size() {
+		t.Errorf("Expected manifest size %d, got %d", info.Size(), manifest.Size)
+	}
+}
+
+func TestReadManifestMissing(t *testing.T) {
+	manifest, err := ReadManifest(filepath.Join(t.TempDir(), "none.tar.gz"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if manifest != nil {
+		t.Error("Expected nil manifest for archive without sidecar")
+	}
+}
+
+func TestVerifyValidArchive(t *testing.T) {
+	archivePath := createTestArchive(t)
+
+	result, err := Verify(archivePath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !result.OK() {
+		t.Errorf("Expected archive to be OK, got %s: %v", result.Status, result.Problems)
+	}
+}
+
+func TestVerifyCorruptArchive(t *testing.T) {
+	archivePath := createTestArchive(t)
+
+	data, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(archivePath, data, 0o644); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+
+	result, err := Verify(archivePath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.Status != VerifyCorrupt {
+		t.Errorf("Expected status %s, got %s", VerifyCorrupt, result.Status)
+	}
+}
+
+func TestVerifyTruncatedArchive(t *testing.T) {
+	archivePath := createTestArchive(t)
+
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		t.Fatalf("Failed to stat archive: %v", err)
+	}
+	if err := os.Truncate(archivePath, info.Size()/2); err != nil {
+		t.Fatalf("Failed to truncate archive: %v", err)
+	}
+
+	result, err := Verify(archivePath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.Status != VerifyCorrupt {
+		t.Errorf("Expected status %s, got %s", VerifyCorrupt, result.Status)
+	}
+}
+
+func TestVerifyWithoutManifest(t *testing.T) {
+	archivePath := createTestArchive(t)
+	if err := os.Remove(ManifestPath(archivePath)); err != nil {
+		t.Fatalf("Failed to remove manifest: %v", err)
+	}
+
+	result, err := Verify(archivePath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.Status != VerifyNoManifest {
+		t.Errorf("Expected status %s, got %s", VerifyNoManifest, result.Status)
+	}
+}
+
+func TestVerifyAllReportsMissingArchive(t *testing.T) {
+	archivePath := createTestArchive(t)
+	if err := os.Remove(archivePath); err != nil {
+		t.Fatalf("Failed to remove archive: %v", err)
+	}
+
+	results, err := VerifyAll(storage.NewLocal(filepath.Dir(archivePath)), "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("Expected 1 result, got %d", len(results))
+	}
+	if results[0].Status != VerifyMissing {
+		t.Errorf("Expected status %s, got %s", VerifyMissing, results[0].Status)
+	}
+}
+
+func TestVerifyAllSkipsDir(t *testing.T) {
+	archivePath := createTestArchive(t)
+	root := filepath.Dir(archivePath)
+	trashed := filepath.Join(root, ".trash", "20240102T030405Z", filepath.Base(archivePath))
+	if err := os.MkdirAll(filepath.Dir(trashed), 0o755); err != nil {
+		t.Fatalf("Failed to create trash: %v", err)
+	}
+	if err := os.WriteFile(trashed, []byte("corrupt"), 0o644); err != nil {
+		t.Fatalf("Failed to write trashed archive: %v", err)
+	}
+
+	results, err := VerifyAll(storage.NewLocal(root), ".trash")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(results) != 1 || results[0].Archive != filepath.Base(archivePath) {
+		t.Errorf("Expected only the live archive to be verified, got %+v", results)
+	}
+	if results, _ := VerifyAll(storage.NewLocal(root), ""); len(results) != 2 {
+		t.Errorf("Expected the trashed archive to be verified without a skipped dir, got %d results", len(results))
+	}
+}
@@ -0,0 +1,398 @@
+package io_archive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// An archive written with CompressOptions.VolumeSize is split into volumes
+// "<archive>.001", "<archive>.002", ... that concatenate to the archive.
+// Its manifest lists the volumes, so the set is handled as one archive by
+// StatArchive, OpenArchive, ArchiveFiles and FindArchives.
+
+// VolumeName returns the name of volume index (starting at 1) of archive
+func VolumeName(archive string, index int) string {
+	return fmt.Sprintf("%s.%03d", archive, index)
+}
+
+// SplitVolume returns the archive and index of a volume name,
+// e.g. "movie.mkv.tar.gz.002" is volume 2 of "movie.mkv.tar.gz".
+func SplitVolume(name string) (string, int, bool) {
+	dot := strings.LastIndex(name, ".")
+	suffix := name[dot+1:]
+	if dot < 0 || len(suffix) < 3 {
+		return "", 0, false
+	}
+	index, err := strconv.Atoi(suffix)
+	if err != nil || index < 1 || strings.ContainsAny(suffix, "+-") {
+		return "", 0, false
+	}
+	archive := name[:dot]
+	if _, ok := TrimExtension(archive); !ok {
+		return "", 0, false
+	}
+	return archive, index, true
+}
+
+// StatArchive describes the archive name in store, stored as a single file or
+// as volumes. The size of a volume set is the sum of its volumes and its
+// modification time the latest of them. Returns (nil, nil) if neither exists.
+func StatArchive(store storage.Storage, name string) (*storage.File, error) {
+	info, err := store.Stat(name)
+	if err != nil || info != nil {
+		return info, err
+	}
+
+	var archive *storage.File
+	for index := 1; ; index++ {
+		volume, err := store.Stat(VolumeName(name, index))
+		if err != nil {
+			return nil, err
+		}
+		if volume == nil {
+			return archive, nil
+		}
+		if archive == nil {
+			archive = &storage.File{Name: name, Mode: volume.Mode}
+		}
+		archive.Size += volume.Size
+		if volume.ModTime.After(archive.ModTime) {
+			archive.ModTime = volume.ModTime
+		}
+	}
+}
+
+// ArchiveFiles returns the stored files making up the archive name: the
+// archive itself or its volumes in order. Returns nil if neither exists.
+func ArchiveFiles(store storage.Storage, name string) ([]string, error) {
+	info, err := store.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info != nil {
+		return []string{name}, nil
+	}
+
+	var files []string
+	for index := 1; ; index++ {
+		volume := VolumeName(name, index)
+		info, err := store.Stat(volume)
+		if err != nil {
+			return nil, err
+		}
+		if info == nil {
+			return files, nil
+		}
+		files = append(files, volume)
+	}
+}
+
+// OpenArchive opens the archive name in store, joining its volumes
+func OpenArchive(store storage.Storage, name string) (io.ReadCloser, error) {
+	files, err := ArchiveFiles(store, name)
+	if err != nil {
+		return nil, err
+	}
+	switch len(files) {
+	case 0:
+		return nil, fmt.Errorf("%s does not exist", name)
+	case 1:
+		if files[0] == name {
+			return store.Open(name)
+		}
+	}
+	return &volumeReader{store: store, volumes: files}, nil
+}
+
+// FindArchives returns the archives below dir in store, plain or encrypted,
+// single files or volume sets, sorted by name. Deltas of incremental
+// archives are left out, they belong to their base (see ChainFiles).
+func FindArchives(store storage.Storage, dir string) ([]string, error) {
+	files, err := storage.Walk(context.Background(), store, dir, storage.WalkOptions{NamesOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	var archives []string
+	for _, file := range files {
+		if file.IsDir {
+			continue
+		}
+		archive := ""
+		if _, ok := TrimExtension(file.Name); ok {
+			archive = file.Name
+		} else if name, index, ok := SplitVolume(file.Name); ok && index == 1 {
+			archive = name
+		}
+		if _, _, ok := SplitDelta(archive); archive != "" && !ok {
+			archives = append(archives, archive)
+		}
+	}
+	sort.Strings(archives)
+	return archives, nil
+}
+
+// SweepPartial removes the archives left staged below dir in store by a run
+// interrupted before swapping them in, with their volumes and manifests,
+// and returns how many were removed. The new base of an interrupted
+// consolidation is kept, it is moved in by the next consolidation.
+func SweepPartial(store storage.Storage, dir string) (int, error) {
+	files, err := storage.Walk(context.Background(), store, dir, storage.WalkOptions{NamesOnly: true})
+	if err != nil {
+		return 0, err
+	}
+
+	staged := make(map[string]bool)
+	for _, file := range files {
+		if base, ok := stagedArchive(file.Name); ok && !file.IsDir {
+			staged[base] = true
+		}
+	}
+	removed := 0
+	for base := range staged {
+		chain, err := ReadChain(store, base)
+		if err != nil {
+			return removed, err
+		}
+		if chain != nil && chain.Merged > 0 {
+			continue
+		}
+		if err := removeArchiveFiles(store, base+partialSuffix); err != nil {
+			return removed, fmt.Errorf("failed to remove interrupted archive %s: %w", base, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// stagedArchive returns the archive name is staged for, when name is a
+// staged archive, one of its volumes or its manifest
+func stagedArchive(name string) (string, bool) {
+	name = strings.TrimSuffix(name, "."+ManifestExtension)
+	if dot := strings.LastIndex(name, "."); dot >= 0 && strings.HasSuffix(name[:dot], partialSuffix) {
+		if _, _, ok := SplitVolume(strings.TrimSuffix(name[:dot], partialSuffix) + name[dot:]); ok {
+			name = name[:dot]
+		}
+	}
+	base, ok := strings.CutSuffix(name, partialSuffix)
+	if !ok {
+		return "", false
+	}
+	_, ok = TrimExtension(base)
+	return base, ok
+}
+
+// removeStaleFiles removes files of a previous version of archive name that
+// are not part of its current files: a single file replaced by volumes,
+// volumes replaced by a single file, or volumes beyond the current count.
+func removeStaleFiles(store storage.Storage, name string, volumes int) error {
+	if volumes > 0 {
+		if info, err := store.Stat(name); err != nil {
+			return err
+		} else if info != nil {
+			if err := store.Remove(name); err != nil {
+				return err
+			}
+		}
+	}
+	for index := volumes + 1; ; index++ {
+		volume := VolumeName(name, index)
+		info, err := store.Stat(volume)
+		if err != nil || info == nil {
+			return err
+		}
+		if err := store.Remove(volume); err != nil {
+			return err
+		}
+	}
+}
+
+// removeArchiveFiles removes the archive name, its volumes and its manifest
+// from store, if any
+func removeArchiveFiles(store storage.Storage, name string) error {
+	if err := removeStaleFiles(store, name, 0); err != nil {
+		return err
+	}
+	for _, file := range []string{name, ManifestPath(name)} {
+		if err := removeIfExists(store, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commitArchive moves the archive staged, a single file or volumes volumes,
+// and its manifest to name, replacing the previous version of name. The
+// previous manifest is removed first and the new one moved last, so an
+// interrupted swap leaves an archive without a manifest, reported by
+// VerifyIn, rather than a manifest describing files of another version.
+// Files already moved by an interrupted swap are skipped, so calling it
+// again while the staged manifest exists completes the swap.
+func commitArchive(store storage.Storage, staged, name string, volumes int) error {
+	if err := removeIfExists(store, ManifestPath(name)); err != nil {
+		return fmt.Errorf("failed to replace manifest of %s: %w", name, err)
+	}
+	move := func(from, to string) error {
+		info, err := store.Stat(from)
+		if err == nil && info != nil {
+			err = store.Rename(from, to)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to replace %s: %w", to, err)
+		}
+		return nil
+	}
+	if volumes == 0 {
+		if err := move(staged, name); err != nil {
+			return err
+		}
+	}
+	for index := 1; index <= volumes; index++ {
+		if err := move(VolumeName(staged, index), VolumeName(name, index)); err != nil {
+			return err
+		}
+	}
+	if err := removeStaleFiles(store, name, volumes); err != nil {
+		return fmt.Errorf("failed to remove previous archive files: %w", err)
+	}
+	if err := store.Rename(ManifestPath(staged), ManifestPath(name)); err != nil {
+		return fmt.Errorf("failed to replace manifest of %s: %w", name, err)
+	}
+	return nil
+}
+
+// removeIfExists removes name from store unless it does not exist
+func removeIfExists(store storage.Storage, name string) error {
+	info, err := store.Stat(name)
+	if err != nil || info == nil {
+		return err
+	}
+	return store.Remove(name)
+}
+
+// volumeReader reads volumes one after the other
+type volumeReader struct {
+	store   storage.Storage
+	volumes []string
+	current io.ReadCloser
+}
+
+func (r *volumeReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.volumes) == 0 {
+				return 0, io.EOF
+			}
+			file, err := r.store.Open(r.volumes[0])
+			if err != nil {
+				return 0, fmt.Errorf("failed to open volume %s: %w", path.Base(r.volumes[0]), err)
+			}
+			r.current = file
+			r.volumes = r.volumes[1:]
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *volumeReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	err := r.current.Close()
+	r.current = nil
+	return err
+}
+
+// volumeWriter writes an archive as volumes of at most size bytes, each
+// stored atomically, and records them for the manifest
+type volumeWriter struct {
+	store   storage.Storage
+	name    string
+	size    int64
+	current storage.Writer
+	hash    *hashingWriter
+	volumes []ManifestVolume
+}
+
+func newVolumeWriter(store storage.Storage, name string, size int64) *volumeWriter {
+	return &volumeWriter{store: store, name: name, size: size}
+}
+
+func (w *volumeWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if w.current != nil && w.hash.size == w.size {
+			if err := w.finishVolume(); err != nil {
+				return written, err
+			}
+		}
+		if w.current == nil {
+			volume, err := w.store.Create(VolumeName(w.name, len(w.volumes)+1))
+			if err != nil {
+				return written, fmt.Errorf("failed to create volume: %w", err)
+			}
+			w.current = volume
+			w.hash = newHashingWriter(volume)
+		}
+
+		chunk := p[:min(int64(len(p)), w.size-w.hash.size)]
+		n, err := w.hash.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *volumeWriter) finishVolume() error {
+	name := VolumeName(w.name, len(w.volumes)+1)
+	if err := w.current.Close(); err != nil {
+		return fmt.Errorf("failed to store volume %s: %w", path.Base(name), err)
+	}
+	w.volumes = append(w.volumes, ManifestVolume{Name: path.Base(name), Size: w.hash.size, SHA256: w.hash.Sum()})
+	w.current = nil
+	return nil
+}
+
+// Close stores the last volume
+func (w *volumeWriter) Close() error {
+	if w.current == nil {
+		return nil
+	}
+	return w.finishVolume()
+}
+
+// Abort discards the volume being written and removes those already stored
+func (w *volumeWriter) Abort() error {
+	var err error
+	if w.current != nil {
+		err = w.current.Abort()
+		w.current = nil
+	}
+	for index := range w.volumes {
+		if removeErr := w.store.Remove(VolumeName(w.name, index+1)); err == nil {
+			err = removeErr
+		}
+	}
+	w.volumes = nil
+	return err
+}
@@ -0,0 +1,262 @@
+package io_archive
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// newVolumeSource returns a source with an incompressible movie of size bytes
+func newVolumeSource(size int) (*storage.Memory, []byte) {
+	content := make([]byte, size)
+	rand.Read(content)
+	source := storage.NewMemory()
+	source.WriteFile("movie.mkv", content, time.Now())
+	return source, content
+}
+
+func TestCompressIntoVolumes(t *testing.T) {
+	source, content := newVolumeSource(5000)
+	target := storage.NewMemory()
+
+	manifest, err := CompressInto(target, "movie.mkv.tar.gz", source, "movie.mkv", &CompressOptions{VolumeSize: 2048})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(manifest.Volumes) < 3 {
+		t.Fatalf("Expected at least 3 volumes, got %d", len(manifest.Volumes))
+	}
+	for i, volume := range manifest.Volumes {
+		info, _ := target.Stat(VolumeName("movie.mkv.tar.gz", i+1))
+		if info == nil || info.Size != volume.Size || info.Size > 2048 {
+			t.Errorf("Expected volume %s of at most 2048 bytes, got %v", volume.Name, info)
+		}
+	}
+	if info, _ := target.Stat("movie.mkv.tar.gz"); info != nil {
+		t.Error("Expected no single archive file")
+	}
+
+	info, err := StatArchive(target, "movie.mkv.tar.gz")
+	if err != nil || info == nil || info.Size != manifest.Size {
+		t.Errorf("Expected archive size %d, got %v (%v)", manifest.Size, info, err)
+	}
+	archives, _ := FindArchives(target, "")
+	if len(archives) != 1 || archives[0] != "movie.mkv.tar.gz" {
+		t.Errorf("Expected one logical archive, got %v", archives)
+	}
+
+	result, err := VerifyIn(target, "movie.mkv.tar.gz")
+	if err != nil || !result.OK() {
+		t.Errorf("Expected valid archive, got %s %v: %v", result.Status, result.Problems, err)
+	}
+
+	dest := t.TempDir()
+	if _, err := ExtractFrom(target, "movie.mkv.tar.gz", dest, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	restored, _ := os.ReadFile(filepath.Join(dest, "movie.mkv"))
+	if string(restored) != string(content) {
+		t.Error("Expected restored content to match")
+	}
+}
+
+func TestVerifyMissingVolume(t *testing.T) {
+	source, _ := newVolumeSource(5000)
+	target := storage.NewMemory()
+	if _, err := CompressInto(target, "movie.mkv.tar.gz", source, "movie.mkv", &CompressOptions{VolumeSize: 2048}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	target.Remove(VolumeName("movie.mkv.tar.gz", 2))
+
+	result, err := VerifyIn(target, "movie.mkv.tar.gz")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.Status != VerifyCorrupt || !strings.Contains(strings.Join(result.Problems, "\n"), "missing volume") {
+		t.Errorf("Expected missing volume, got %s: %v", result.Status, result.Problems)
+	}
+	if _, err := ExtractFrom(target, "movie.mkv.tar.gz", t.TempDir(), nil); err == nil {
+		t.Error("Expected error extracting incomplete volume set")
+	}
+}
+
+func TestCompressIntoRemovesStaleFiles(t *testing.T) {
+	source, _ := newVolumeSource(5000)
+	target := storage.NewMemory()
+	name := "movie.mkv.tar.gz"
+
+	if _, err := CompressInto(target, name, source, "movie.mkv", nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := CompressInto(target, name, source, "movie.mkv", &CompressOptions{VolumeSize: 1024}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if info, _ := target.Stat(name); info != nil {
+		t.Error("Expected single archive to be replaced by volumes")
+	}
+
+	// Fewer, larger volumes leave no trailing volume of the previous version
+	manifest, err := CompressInto(target, name, source, "movie.mkv", &CompressOptions{VolumeSize: 4096})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	files, _ := ArchiveFiles(target, name)
+	if len(files) != len(manifest.Volumes) {
+		t.Errorf("Expected %d volumes, got %v", len(manifest.Volumes), files)
+	}
+
+	if _, err := CompressInto(target, name, source, "movie.mkv", nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if files, _ := ArchiveFiles(target, name); len(files) != 1 || files[0] != name {
+		t.Errorf("Expected volumes to be replaced by a single archive, got %v", files)
+	}
+	if info, _ := target.Stat(VolumeName(name, 1)); info != nil {
+		t.Error("Expected no volume left")
+	}
+}
+
+func TestStoreArchiveFailureKeepsPreviousVersion(t *testing.T) {
+	source, _ := newVolumeSource(5000)
+	target := storage.NewMemory()
+	name := "movie.mkv.tar.gz"
+	opts := &CompressOptions{VolumeSize: 1024}
+	previous, err := CompressInto(target, name, source, "movie.mkv", opts)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// A rewrite failing after several volumes were written
+	_, err = storeArchive(target, name, opts, func(w io.Writer) (*Manifest, error) {
+		if _, err := w.Write(make([]byte, 3000)); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("source vanished")
+	})
+	if err == nil {
+		t.Fatal("Expected the rewrite to fail")
+	}
+	result, err := VerifyIn(target, name)
+	if err != nil || !result.OK() {
+		t.Errorf("Expected the previous volumes to be intact, got %s %v: %v", result.Status, result.Problems, err)
+	}
+	if manifest, _ := ReadManifestIn(target, name); manifest == nil || manifest.SHA256 != previous.SHA256 {
+		t.Error("Expected the previous manifest to be kept")
+	}
+	files, _ := target.List("")
+	for _, file := range files {
+		if strings.Contains(file.Name, partialSuffix) {
+			t.Errorf("Expected no staged file left, got %s", file.Name)
+		}
+	}
+}
+
+// atomicStore is a memory store creating files atomically, counting renames
+type atomicStore struct {
+	*storage.Memory
+	renames int
+}
+
+func (s *atomicStore) CreatesAtomically() bool {
+	return true
+}
+
+func (s *atomicStore) Rename(oldName, newName string) error {
+	s.renames++
+	return s.Memory.Rename(oldName, newName)
+}
+
+func TestStoreArchiveWritesDirectlyOnAtomicStore(t *testing.T) {
+	source, _ := newVolumeSource(5000)
+	target := &atomicStore{Memory: storage.NewMemory()}
+	name := "movie.mkv.tar.gz"
+	// A previous version split into volumes
+	if _, err := CompressInto(target, name, source, "movie.mkv", &CompressOptions{VolumeSize: 1024}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	target.renames = 0
+
+	manifest, err := CompressInto(target, name, source, "movie.mkv", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if target.renames != 0 {
+		t.Errorf("Expected the archive to be written without renames, got %d", target.renames)
+	}
+	if result, err := VerifyIn(target, name); err != nil || !result.OK() || len(manifest.Volumes) != 0 {
+		t.Errorf("Expected a single verified archive, got %s %v: %v", result.Status, result.Problems, err)
+	}
+	if info, _ := target.Stat(VolumeName(name, 1)); info != nil {
+		t.Error("Expected the previous volumes to be removed")
+	}
+
+	// A failing rewrite keeps the previous version
+	_, err = storeArchive(target, name, nil, func(w io.Writer) (*Manifest, error) {
+		w.Write(make([]byte, 3000))
+		return nil, errors.New("source vanished")
+	})
+	if err == nil {
+		t.Fatal("Expected the rewrite to fail")
+	}
+	if result, err := VerifyIn(target, name); err != nil || !result.OK() {
+		t.Errorf("Expected the previous archive to be intact, got %s %v: %v", result.Status, result.Problems, err)
+	}
+}
+
+func TestSweepPartial(t *testing.T) {
+	source, _ := newVolumeSource(5000)
+	target := storage.NewMemory()
+	name := "movie.mkv.tar.gz"
+	opts := &CompressOptions{VolumeSize: 1024}
+	if _, err := CompressInto(target, name, source, "movie.mkv", opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// A crash after staging a rewrite and another movie
+	for _, staged := range []string{"movie.mkv.tar.gz", "other.mkv.tar.gz"} {
+		if _, err := stageArchive(target, staged, opts, func(w io.Writer) (*Manifest, error) {
+			_, err := w.Write(make([]byte, 3000))
+			return &Manifest{}, err
+		}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if archives, _ := FindArchives(target, ""); len(archives) != 1 || archives[0] != name {
+		t.Errorf("Expected staged archives not to be listed, got %v", archives)
+	}
+
+	removed, err := SweepPartial(target, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("Expected 2 staged archives removed, got %d", removed)
+	}
+	files, _ := target.List("")
+	for _, file := range files {
+		if strings.Contains(file.Name, partialSuffix) {
+			t.Errorf("Expected no staged file left, got %s", file.Name)
+		}
+	}
+	if result, err := VerifyIn(target, name); err != nil || !result.OK() {
+		t.Errorf("Expected the archive to be intact, got %s %v: %v", result.Status, result.Problems, err)
+	}
+}
+
+func TestSplitVolume(t *testing.T) {
+	archive, index, ok := SplitVolume("a/movie.mkv.tar.gz.enc.012")
+	if !ok || archive != "a/movie.mkv.tar.gz.enc" || index != 12 {
+		t.Errorf("Expected volume 12 of encrypted archive, got %s %d %v", archive, index, ok)
+	}
+	for _, name := range []string{"movie.mkv.tar.gz", "movie.mkv.001", "movie.mkv.tar.gz.000", "movie.mkv.tar.gz.1"} {
+		if _, _, ok := SplitVolume(name); ok {
+			t.Errorf("Expected %s not to be a volume", name)
+		}
+	}
+}
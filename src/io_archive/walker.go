@@ -0,0 +1,329 @@
+package io_archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// SymlinkMode selects how symbolic links found while archiving are stored
+type SymlinkMode string
+
+const (
+	// SymlinkSkip leaves links out of the archive, with a manifest warning
+	SymlinkSkip SymlinkMode = "skip"
+	// SymlinkFollow stores the file or directory a link points to under the
+	// name of the link. Links to a directory containing them are skipped
+	// with a warning instead of looping.
+	SymlinkFollow SymlinkMode = "follow"
+	// SymlinkStore stores links as tar symlink entries
+	SymlinkStore SymlinkMode = "store"
+)
+
+// ParseSymlinkMode returns the SymlinkMode named value, "" being SymlinkSkip
+func ParseSymlinkMode(value string) (SymlinkMode, error) {
+	switch mode := SymlinkMode(value); mode {
+	case "":
+		return SymlinkSkip, nil
+	case SymlinkSkip, SymlinkFollow, SymlinkStore:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid symlink mode %q, expected skip, follow or store", value)
+	}
+}
+
+// archiveWalker adds a file or directory tree of a source to a tar archive,
+// recording file checksums, skipped files and warnings in the manifest.
+// The tree is collected first so the content size is known before writing.
+type archiveWalker struct {
+	source   storage.Storage
+	filter   *entryFilter
+	symlinks SymlinkMode
+	reads    *readThrottle
+	manifest *Manifest
+	// deterministic, if set, normalizes the order and headers of entries
+	deterministic *Deterministic
+	// metadata are the PAX records added to the first entry
+	metadata map[string]string
+	// root is the entry name of the archived file or directory
+	root string
+	// entries are the collected entries in archive order
+	entries []walkEntry
+	// files maps file IDs to the entry first storing their content,
+	// later names of the same file are stored as hard links to it
+	files map[string]string
+	// dirs maps entry names of directories to their file IDs, so links
+	// back to a directory containing them are detected
+	dirs map[string]string
+}
+
+// walkEntry is a file to store under name in the archive
+type walkEntry struct {
+	file storage.File
+	name string
+	// hardLink names the entry already holding the content of file
+	hardLink string
+}
+
+func newArchiveWalker(source storage.Storage, filter *entryFilter, opts *CompressOptions,
+	manifest *Manifest) *archiveWalker {
+	symlinks := SymlinkSkip
+	var deterministic *Deterministic
+	var metadata map[string]string
+	if opts != nil {
+		if opts.Symlinks != "" {
+			symlinks = opts.Symlinks
+		}
+		deterministic = opts.Deterministic
+		if opts.Metadata != nil {
+			metadata = opts.Metadata.records()
+		}
+	}
+	return &archiveWalker{
+		source:        source,
+		filter:        filter,
+		symlinks:      symlinks,
+		reads:         newReadThrottle(opts),
+		manifest:      manifest,
+		deterministic: deterministic,
+		metadata:      metadata,
+		files:         make(map[string]string),
+		dirs:          make(map[string]string),
+	}
+}
+
+// collect gathers file as entry name, followed by its content if it is a
+// directory, sorted by name for deterministic archives. The file itself is
+// never filtered.
+func (w *archiveWalker) collect(file storage.File, name string) error {
+	w.root = name
+	w.addEntry(file, name)
+	if file.IsDir {
+		if err := w.collectTree(file, name); err != nil {
+			return err
+		}
+	}
+	if w.deterministic != nil {
+		sortEntries(w.entries)
+	}
+	return nil
+}
+
+// collectTree gathers the content of dir below entry name
+func (w *archiveWalker) collectTree(dir storage.File, name string) error {
+	entries, err := w.source.List(dir.Name)
+	if err != nil {
+		return err
+	}
+
+	prefix := strings.TrimSuffix(dir.Name, "/") + "/"
+	var skippedDirs []string
+	for _, entry := range entries {
+		rel := strings.TrimPrefix(entry.Name, prefix)
+		entryName := name + "/" + rel
+		if isBelowAny(rel, skippedDirs) {
+			if entry.IsDir {
+				skippedDirs = append(skippedDirs, rel)
+			}
+			w.skip(entry)
+			continue
+		}
+
+		followed := false
+		if entry.Mode&fs.ModeSymlink != 0 {
+			target, err := w.resolveLink(&entry, entryName)
+			if err != nil {
+				return err
+			}
+			if target == nil {
+				continue
+			}
+			followed = target != &entry
+			entry = *target
+		}
+
+		if w.filter.skip(strings.TrimPrefix(entryName, w.root+"/"), entry.IsDir) {
+			if entry.IsDir {
+				skippedDirs = append(skippedDirs, rel)
+			}
+			w.skip(entry)
+			continue
+		}
+		w.addEntry(entry, entryName)
+		// The listing of dir does not enter links, followed directories
+		// are walked on their own
+		if followed && entry.IsDir {
+			if err := w.collectTree(entry, entryName); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveLink returns what to store for the symbolic link file at entry
+// name: link itself, the file it points to when following links, or nil
+// when it is left out.
+func (w *archiveWalker) resolveLink(link *storage.File, name string) (*storage.File, error) {
+	switch w.symlinks {
+	case SymlinkStore:
+		return link, nil
+	case SymlinkFollow:
+	default:
+		w.warn("skipped symbolic link %s -> %s", name, link.Link)
+		return nil, nil
+	}
+
+	target, err := w.source.Stat(link.Name)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil {
+		w.warn("skipped broken symbolic link %s -> %s", name, link.Link)
+		return nil, nil
+	}
+	if target.IsDir {
+		if target.ID == "" {
+			w.warn("skipped symbolic link %s: %s cannot detect link loops", name, w.source)
+			return nil, nil
+		}
+		for dir := path.Dir(name); ; dir = path.Dir(dir) {
+			if w.dirs[dir] == target.ID {
+				w.warn("skipped symbolic link %s: loops back to %s", name, dir)
+				return nil, nil
+			}
+			if dir == w.root || dir == "." {
+				break
+			}
+		}
+	}
+	return target, nil
+}
+
+// addEntry collects file as entry name. Symbolic links are stored as links,
+// further names of a file as hard links to the first, special files are skipped.
+func (w *archiveWalker) addEntry(file storage.File, name string) {
+	if file.Mode&fs.ModeSymlink == 0 && !file.IsDir && !file.Mode.IsRegular() {
+		return
+	}
+
+	entry := walkEntry{file: file, name: name}
+	if file.IsDir {
+		w.dirs[name] = file.ID
+	} else if file.Mode.IsRegular() && file.ID != "" {
+		if first, ok := w.files[file.ID]; ok {
+			entry.hardLink = first
+		} else {
+			w.files[file.ID] = name
+		}
+	}
+	w.entries = append(w.entries, entry)
+}
+
+// contentSize returns the size of the file content to store
+func (w *archiveWalker) contentSize() int64 {
+	var size int64
+	for _, entry := range w.entries {
+		if entry.file.Mode.IsRegular() && entry.hardLink == "" {
+			size += entry.file.Size
+		}
+	}
+	return size
+}
+
+// write stores the collected entries in writer, compressed by gz at the
+// level of each file's policy, reporting to progress
+func (w *archiveWalker) write(writer *tar.Writer, gz *gzipStream, progress *progressTracker) error {
+	for _, entry := range w.entries {
+		if entry.file.Mode.IsRegular() && entry.hardLink == "" {
+			rel := strings.TrimPrefix(entry.name, w.root+"/")
+			if err := gz.selectFor(w.source, entry.file, rel); err != nil {
+				return err
+			}
+		}
+		if err := w.writeEntry(writer, entry, progress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEntry writes entry and records its checksum in the manifest
+func (w *archiveWalker) writeEntry(writer *tar.Writer, entry walkEntry, progress *progressTracker) error {
+	file := entry.file
+	link := ""
+	if file.Mode&fs.ModeSymlink != 0 {
+		link = file.Link
+	}
+
+	// Create tar header
+	header, err := tar.FileInfoHeader(file.Info(), link)
+	if err != nil {
+		return fmt.Errorf("failed to create header for %s: %w", file.Name, err)
+	}
+	header.Name = entry.name
+	if entry.hardLink != "" {
+		header.Typeflag = tar.TypeLink
+		header.Linkname = entry.hardLink
+		header.Size = 0
+	}
+	if w.deterministic != nil {
+		w.deterministic.header(header)
+	}
+	if entry.name == w.root && len(w.metadata) > 0 {
+		if header.PAXRecords == nil {
+			header.PAXRecords = make(map[string]string)
+		}
+		for key, value := range w.metadata {
+			header.PAXRecords[key] = value
+		}
+	}
+
+	// Write header
+	if err := writer.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write header for %s: %w", file.Name, err)
+	}
+
+	// Only regular files have content
+	if header.Typeflag != tar.TypeReg {
+		return nil
+	}
+
+	progress.startFile(entry.name)
+	checksum, err := addFileToArchive(writer, w.source, file, w.reads, progress)
+	if err != nil {
+		return err
+	}
+	w.manifest.Entries = append(w.manifest.Entries, ManifestEntry{
+		Name:   header.Name,
+		Size:   file.Size,
+		SHA256: checksum,
+	})
+	return nil
+}
+
+// skip counts a regular file left out of the archive
+func (w *archiveWalker) skip(file storage.File) {
+	if file.Mode.IsRegular() {
+		w.manifest.Skipped++
+		w.manifest.SkippedSize += file.Size
+	}
+}
+
+func (w *archiveWalker) warn(format string, args ...any) {
+	w.manifest.Warnings = append(w.manifest.Warnings, fmt.Sprintf(format, args...))
+}
+
+// isBelowAny reports whether name is inside one of dirs
+func isBelowAny(name string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
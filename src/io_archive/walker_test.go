@@ -0,0 +1,206 @@
+package io_archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// createLinkedMovie builds a movie directory in a temporary root with a
+// symbolic link to a file, one to a directory outside the movie, one
+// looping back to the movie and a hard-linked file.
+func createLinkedMovie(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	movie := filepath.Join(root, "movie")
+	extras := filepath.Join(root, "extras")
+	for _, dir := range []string{movie, extras} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	files := map[string]string{
+		filepath.Join(movie, "movie.mkv"):    "video content",
+		filepath.Join(extras, "trailer.mkv"): "trailer",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	links := map[string]string{
+		"poster.mkv": "movie.mkv",
+		"extras":     "../extras",
+		"loop":       ".",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(movie, name)); err != nil {
+			t.Fatalf("Failed to create link %s: %v", name, err)
+		}
+	}
+	if err := os.Link(filepath.Join(movie, "movie.mkv"), filepath.Join(movie, "library.mkv")); err != nil {
+		t.Fatalf("Failed to create hard link: %v", err)
+	}
+	return movie
+}
+
+// readHeaders returns the tar headers of the archive at archivePath by entry name
+func readHeaders(t *testing.T, archivePath string) map[string]*tar.Header {
+	t.Helper()
+	file, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("Failed to read gzip stream: %v", err)
+	}
+	headers := make(map[string]*tar.Header)
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err != nil {
+			break
+		}
+		headers[header.Name] = header
+	}
+	return headers
+}
+
+func TestCompressSkipsSymlinksByDefault(t *testing.T) {
+	movie := createLinkedMovie(t)
+	archivePath, err := Compress(movie, t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	headers := readHeaders(t, archivePath)
+	for _, name := range []string{"movie/poster.mkv", "movie/extras", "movie/loop"} {
+		if _, ok := headers[name]; ok {
+			t.Errorf("Expected link %s to be skipped", name)
+		}
+	}
+	manifest, _ := ReadManifest(archivePath)
+	if len(manifest.Warnings) != 3 {
+		t.Errorf("Expected a warning per skipped link, got %v", manifest.Warnings)
+	}
+}
+
+func TestCompressStoresSymlinks(t *testing.T) {
+	movie := createLinkedMovie(t)
+	archivePath, err := Compress(movie, t.TempDir(), &CompressOptions{Symlinks: SymlinkStore})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	headers := readHeaders(t, archivePath)
+	header := headers["movie/extras"]
+	if header == nil || header.Typeflag != tar.TypeSymlink || header.Linkname != "../extras" {
+		t.Fatalf("Expected symlink entry for movie/extras, got %+v", header)
+	}
+	if _, ok := headers["movie/extras/trailer.mkv"]; ok {
+		t.Error("Expected stored link not to be followed")
+	}
+
+	dest := t.TempDir()
+	if _, err := Extract(archivePath, dest, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(dest, "movie", "poster.mkv")); err != nil || target != "movie.mkv" {
+		t.Errorf("Expected extracted link to movie.mkv, got %q, %v", target, err)
+	}
+}
+
+func TestCompressFollowsSymlinks(t *testing.T) {
+	movie := createLinkedMovie(t)
+	archivePath, err := Compress(movie, t.TempDir(), &CompressOptions{Symlinks: SymlinkFollow})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	headers := readHeaders(t, archivePath)
+	if header := headers["movie/extras/trailer.mkv"]; header == nil || header.Typeflag != tar.TypeReg {
+		t.Errorf("Expected content of linked directory, got %+v", header)
+	}
+	if header := headers["movie/extras"]; header == nil || header.Typeflag != tar.TypeDir {
+		t.Errorf("Expected linked directory stored as directory, got %+v", header)
+	}
+	for name := range headers {
+		if strings.HasPrefix(name, "movie/loop") {
+			t.Errorf("Expected looping link to be skipped, got %s", name)
+		}
+	}
+	manifest, _ := ReadManifest(archivePath)
+	if len(manifest.Warnings) != 1 || !strings.Contains(manifest.Warnings[0], "movie/loop") {
+		t.Errorf("Expected loop warning, got %v", manifest.Warnings)
+	}
+
+	result, err := Verify(archivePath)
+	if err != nil || !result.OK() {
+		t.Errorf("Expected valid archive, got %+v, %v", result, err)
+	}
+}
+
+func TestCompressStoresHardLinksOnce(t *testing.T) {
+	movie := createLinkedMovie(t)
+	archivePath, err := Compress(movie, t.TempDir(), &CompressOptions{Symlinks: SymlinkFollow})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// library.mkv, movie.mkv and the followed poster.mkv are one file
+	headers := readHeaders(t, archivePath)
+	content := 0
+	for _, name := range []string{"movie/library.mkv", "movie/movie.mkv", "movie/poster.mkv"} {
+		switch header := headers[name]; {
+		case header == nil:
+			t.Fatalf("Expected entry %s", name)
+		case header.Typeflag == tar.TypeReg:
+			content++
+		case header.Typeflag != tar.TypeLink || header.Size != 0:
+			t.Errorf("Expected hard link entry for %s, got %+v", name, header)
+		}
+	}
+	if content != 1 {
+		t.Errorf("Expected content stored once, got %d copies", content)
+	}
+
+	dest := t.TempDir()
+	if _, err := Extract(archivePath, dest, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	first, _ := os.Stat(filepath.Join(dest, "movie", "movie.mkv"))
+	second, _ := os.Stat(filepath.Join(dest, "movie", "library.mkv"))
+	if first == nil || second == nil || !os.SameFile(first, second) {
+		t.Error("Expected extracted hard links to share one file")
+	}
+}
+
+func TestExtractRejectsEntryThroughSymlink(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "raw."+Extension)
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	gz := gzip.NewWriter(file)
+	writer := tar.NewWriter(gz)
+	outside := t.TempDir()
+	writer.WriteHeader(&tar.Header{Name: "movie/escape", Typeflag: tar.TypeSymlink, Linkname: outside})
+	content := []byte("evil")
+	writer.WriteHeader(&tar.Header{Name: "movie/escape/evil.txt", Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+	writer.Write(content)
+	writer.Close()
+	gz.Close()
+	file.Close()
+
+	if _, err := Extract(archivePath, t.TempDir(), nil); err == nil {
+		t.Fatal("Expected error for entry inside an extracted link")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "evil.txt")); !os.IsNotExist(err) {
+		t.Error("Expected no file written through the link")
+	}
+}
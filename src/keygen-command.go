@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
+)
+
+// runKeygen creates a key pair for archive encryption. The secret identity is
+// written to a new file and the public recipient printed for -recipients.
+func runKeygen(args []string) error {
+	flags := flag.NewFlagSet("keygen", flag.ExitOnError)
+	out := flags.String("out", "", "File to write the secret identity to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return fmt.Errorf("out is required")
+	}
+
+	identity, recipient, err := io_archive.GenerateIdentity()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create identity file: %w", err)
+	}
+	_, writeErr := fmt.Fprintf(file, "# public key: %s\n%s\n", recipient, identity)
+	if err := file.Close(); writeErr == nil {
+		writeErr = err
+	}
+	if writeErr != nil {
+		return fmt.Errorf("failed to write identity file: %w", writeErr)
+	}
+
+	fmt.Printf("Public key: %s\n", recipient)
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// runList prints the entries of one or more archives as a table or JSON.
+// Archives are local paths, or names relative to the target when one is given.
+func runList(args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	target := flags.String(flagTarget, "", "Directory or URL the archives are relative to "+
+		"(default the directory of each archive)")
+	asJSON := flags.Bool("json", false, "Print entries as JSON")
+	identity := flags.String(flagIdentity, "", "File with identities opening encrypted archives, "+
+		"the passphrase is read from "+passphraseEnv)
+	remote := registerTargetFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("at least one archive is required")
+	}
+	dec, err := decryption(*identity)
+	if err != nil {
+		return err
+	}
+
+	var targetStore storage.Storage
+	if *target != "" {
+		if targetStore, err = remote.open(*target); err != nil {
+			return err
+		}
+	}
+
+	var listings []*io_archive.ArchiveListing
+	for _, archive := range flags.Args() {
+		store, name := targetStore, archive
+		if store == nil {
+			store, name = storage.NewLocal(filepath.Dir(archive)), filepath.Base(archive)
+		}
+		listing, err := io_archive.ListDecrypted(store, name, dec)
+		if err != nil {
+			return fmt.Errorf("%s: %w", archive, err)
+		}
+		listing.Archive = archive
+		listings = append(listings, listing)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(listings)
+	}
+
+	for _, listing := range listings {
+		printListing(listing)
+	}
+	return nil
+}
+
+// printListing renders a listing as an aligned table followed by its totals.
+func printListing(listing *io_archive.ArchiveListing) {
+	fmt.Printf("%s\n", listing.Archive)
+	if listing.Metadata != nil {
+		fmt.Printf("Movie: %s\n", describeMovie(listing.Metadata))
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "Mode\tSize\tModified\tName")
+	for _, entry := range listing.Entries {
+		name := entry.Name
+		if entry.Link != "" {
+			name += " -> " + entry.Link
+		} else if entry.HardLink != "" {
+			name += " link to " + entry.HardLink
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", entry.Mode, formatBytes(entry.Size),
+			entry.ModTime.Local().Format(time.DateTime), name)
+	}
+	writer.Flush()
+	fmt.Printf("%d files, %s content, %s archive, ratio %.1f%%\n", listing.Files,
+		formatBytes(listing.TotalSize), formatBytes(listing.ArchiveSize), listing.Ratio()*100)
+	if listing.Skipped > 0 {
+		fmt.Printf("%d files skipped, %s\n", listing.Skipped, formatBytes(listing.SkippedSize))
+	}
+	fmt.Println()
+}
+
+// describeMovie renders embedded movie metadata on one line,
+// e.g. "The Matrix (1999), TMDB 603, IMDb tt0133093, Bluray-1080p"
+func describeMovie(metadata *io_archive.MovieMetadata) string {
+	parts := []string{metadata.Title}
+	if metadata.Year > 0 {
+		parts[0] += fmt.Sprintf(" (%d)", metadata.Year)
+	}
+	if metadata.TmdbID > 0 {
+		parts = append(parts, fmt.Sprintf("TMDB %d", metadata.TmdbID))
+	}
+	if metadata.ImdbID != "" {
+		parts = append(parts, "IMDb "+metadata.ImdbID)
+	}
+	if metadata.Quality != "" {
+		parts = append(parts, metadata.Quality)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatBytes renders a byte count with a binary unit, e.g. 1.5 GiB.
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/pedrosantosdev/radarr-sync-go/src/client"
 	"github.com/pedrosantosdev/radarr-sync-go/src/compress"
+	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
 	"github.com/pedrosantosdev/radarr-sync-go/src/model"
 )
 
@@ -21,19 +28,97 @@ const (
 	flagRadarrKey    = "radarr-key"
 	flagSkipCompress = "skip-compress"
 	flagDebug        = "debug"
+	flagTrashDir     = "trash-dir"
+	flagRetention    = "trash-retention"
+	flagMaxDelete    = "max-delete-percent"
+	flagUpload       = "upload"
+	flagUploadChunk  = "upload-chunk-size"
+	flagVolumeSize   = "volume-size"
+	flagInclude      = "include"
+	flagExclude      = "exclude"
+	flagSymlinks     = "symlinks"
+	flagProgress     = "progress-interval"
+	flagAdaptive     = "adaptive-compression"
+	flagPolicy       = "compression-policy"
+	flagReadLimit    = "read-limit"
+	flagWriteLimit   = "write-limit"
+	flagLowPriority  = "low-priority"
+	flagWindow       = "window"
+	flagWindowPause  = "window-pause"
+	flagWatch        = "watch"
+	flagStableFor    = "stable-for"
+	flagQuota        = "quota"
+	flagQuotaPolicy  = "quota-policy"
+	flagDeterminism  = "deterministic"
+	flagEntryMtime   = "deterministic-mtime"
+	flagMetadata     = "embed-metadata"
+	flagCatalog      = "catalog"
+	flagIncremental  = "incremental"
+	flagDedup        = "dedup"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd := findCommand(os.Args[1]); cmd != nil {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				log.Fatalf("%s failed: %v\n", cmd.name, err)
+			}
+			return
+		}
+	}
+
 	fmt.Println("Init app")
 	url := flag.String(flagURL, "", "Server URL")
 	login := flag.String(flagLogin, "", "Server username")
 	password := flag.String(flagPassword, "", "Server password")
 	source := flag.String(flagSource, "", "Folder with files to compress")
-	target := flag.String(flagTarget, "", "Target path for compressed files")
+	target := flag.String(flagTarget, "", "Target path or s3://, sftp://, webdav:// URL for compressed files")
 	radarrUrl := flag.String(flagRadarrURL, "", "URL from Radarr")
 	radarrKey := flag.String(flagRadarrKey, "", "API key from Radarr")
 	skipCompress := flag.Bool(flagSkipCompress, false, "Skip the compression stage")
 	debug := flag.Bool(flagDebug, false, "Enable debug mode")
+	trashDir := flag.String(flagTrashDir, compress.DefaultTrashDir, "Trash directory inside target")
+	retention := flag.Duration(flagRetention, compress.DefaultTrashRetention, "How long trashed archives are kept")
+	maxDelete := flag.Float64(flagMaxDelete, compress.DefaultMaxDeletePercent,
+		"Abort when more than this percentage of archives would be removed")
+	upload := flag.Bool(flagUpload, false, "Upload new archives to the server, resuming interrupted uploads")
+	uploadChunk := flag.Int64(flagUploadChunk, client.DefaultUploadChunkSize, "Size in bytes of each upload request")
+	var include, exclude patternList
+	flag.Var(&include, flagInclude, "Only archive files matching this pattern, e.g. *.mkv (repeatable)")
+	flag.Var(&exclude, flagExclude, "Leave out files matching this pattern, e.g. **/sample/** (repeatable)")
+	symlinks := flag.String(flagSymlinks, string(io_archive.SymlinkSkip),
+		"How symbolic links in movie folders are archived: skip, follow or store")
+	progressInterval := flag.Duration(flagProgress, 30*time.Second,
+		"How often compression progress is logged when output is not a terminal, 0 disables it")
+	readLimit := flag.Float64(flagReadLimit, 0, "Limit reading movies to this many MiB/s, 0 for no limit")
+	writeLimit := flag.Float64(flagWriteLimit, 0, "Limit writing archives to this many MiB/s, 0 for no limit")
+	lowPriority := flag.Bool(flagLowPriority, false, "Run with the lowest CPU and idle I/O priority (Linux)")
+	window := flag.String(flagWindow, "", "Daily time range for compression, e.g. 01:00-07:00")
+	windowPause := flag.Bool(flagWindowPause, false,
+		"Pause archives in progress when the window ends instead of finishing them and stopping")
+	watch := flag.Bool(flagWatch, false, "Keep running and compress movies as they land in the source (Linux)")
+	stableFor := flag.Duration(flagStableFor, compress.DefaultStableFor,
+		"How long a new movie must keep its size before it is compressed in watch mode")
+	quota := flag.Int64(flagQuota, 0, "Most GiB the target may hold, trash included, 0 for no quota")
+	quotaPolicy := flag.String(flagQuotaPolicy, string(compress.QuotaAbort),
+		"When archives would not fit in the target or quota: abort, skip or prune (oldest trashed archives first)")
+	embedMetadata := flag.Bool(flagMetadata, false,
+		"Embed the TMDB and IMDb IDs, title, year and quality Radarr reports in each archive")
+	catalogPath := flag.String(flagCatalog, "",
+		"Local file indexing the archives of the target, so runs need not scan it (see the catalog command)")
+	incremental := flag.Bool(flagIncremental, false,
+		"Store changes to archived movie folders as small delta archives (see the consolidate command)")
+	dedup := flag.Bool(flagDedup, false,
+		"Store movies as content-defined chunks shared between movies instead of archives, "+
+			"compressed as archives are but never encrypted, restored with the restore command")
+	remote := registerTargetFlags(flag.CommandLine)
+	encrypt := registerEncryptionFlags(flag.CommandLine)
+	archiving := registerCompressionFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] | <command> [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+		printCommands()
+	}
 	flag.Parse()
 
 	if err := validateFlags(*url, *login, *password, *radarrUrl, *radarrKey,
@@ -54,9 +139,94 @@ func main() {
 	}
 
 	if !*skipCompress {
-		if err := compressNSyncRemote(token.Token, *source, *target); err != nil {
+		store, err := remote.open(*target)
+		if err != nil {
+			log.Fatalf("Target error: %v\n", err)
+		}
+		encryption, err := encrypt.encryption()
+		if err != nil {
+			log.Fatalf("Encryption error: %v\n", err)
+		}
+		symlinkMode, err := io_archive.ParseSymlinkMode(*symlinks)
+		if err != nil {
+			log.Fatalf("Validation error: %v\n", err)
+		}
+		var compressWindow *compress.Window
+		if *window != "" {
+			if compressWindow, err = compress.ParseWindow(*window); err != nil {
+				log.Fatalf("Validation error: %v\n", err)
+			}
+			compressWindow.Pause = *windowPause
+		}
+		spacePolicy, err := compress.ParseQuotaPolicy(*quotaPolicy)
+		if err != nil {
+			log.Fatalf("Validation error: %v\n", err)
+		}
+		if *lowPriority {
+			if err := lowerPriority(); err != nil {
+				log.Fatalf("Priority error: %v\n", err)
+			}
+		}
+		archiveOpts, err := archiving.options(encryption)
+		if err != nil {
+			log.Fatalf("Validation error: %v\n", err)
+		}
+		archiveOpts.Include = include
+		archiveOpts.Exclude = exclude
+		archiveOpts.Symlinks = symlinkMode
+		if *readLimit > 0 || *writeLimit > 0 {
+			archiveOpts.Throttle = &io_archive.Throttle{
+				ReadRate:  int64(*readLimit * (1 << 20)),
+				WriteRate: int64(*writeLimit * (1 << 20)),
+			}
+		}
+		opts := &compress.SyncOptions{
+			Target:           store,
+			Compress:         archiveOpts,
+			TrashDir:         *trashDir,
+			TrashRetention:   *retention,
+			MaxDeletePercent: *maxDelete,
+			Window:           compressWindow,
+			Quota:            *quota << 30,
+			QuotaPolicy:      spacePolicy,
+			Incremental:      *incremental,
+			Dedup:            *dedup,
+			Report: func(report model.CompressReport) error {
+				return client.ReportCompression(token.Token, report)
+			},
+		}
+		progress := newProgressPrinter(*progressInterval)
+		opts.Progress = progress.print
+		if *upload {
+			opts.Uploader = &compress.Uploader{Token: token.Token, ChunkSize: *uploadChunk}
+		}
+		if *catalogPath != "" {
+			if opts.Catalog, err = compress.OpenCatalog(*catalogPath); err != nil {
+				log.Fatalf("Catalog error: %v\n", err)
+			}
+		}
+		library := &movieLibrary{token: token.Token, radarr: *embedMetadata}
+		if *embedMetadata {
+			opts.Metadata = library.metadata
+		}
+		err = compressNSyncRemote(library, *source, *target, opts)
+		progress.end()
+		if err != nil {
 			log.Fatalf("Compression failed: %v\n", err)
 		}
+
+		if *watch {
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			err := compress.Watch(ctx, *source, *target, opts, compress.WatchOptions{
+				StableFor: *stableFor,
+				Movies:    library.fetch,
+			})
+			stop()
+			progress.end()
+			if err != nil {
+				log.Fatalf("Watch failed: %v\n", err)
+			}
+		}
 	}
 
 	fmt.Println("Finish app")
@@ -124,6 +294,41 @@ func syncRadarrToServer(token string, moviesOnServer []model.MovieToRadarrRespon
 
 // Helper functions
 
+// patternList collects the values of a repeatable pattern flag.
+type patternList []string
+
+func (p *patternList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patternList) Set(value string) error {
+	if err := io_archive.ValidatePattern(value); err != nil {
+		return err
+	}
+	*p = append(*p, value)
+	return nil
+}
+
+// policyList collects the values of the repeatable compression policy flag.
+type policyList []io_archive.CompressionPolicy
+
+func (p *policyList) String() string {
+	names := make([]string, len(*p))
+	for i, policy := range *p {
+		names[i] = policy.Name
+	}
+	return strings.Join(names, ",")
+}
+
+func (p *policyList) Set(value string) error {
+	policy, err := io_archive.ParseCompressionPolicy(value)
+	if err != nil {
+		return err
+	}
+	*p = append(*p, policy)
+	return nil
+}
+
 // validateFlags validates required command line flags.
 func validateFlags(url, login, password, radarrUrl, radarrKey string,
 	needSourceTarget bool, source, target string) error {
@@ -173,18 +378,13 @@ func movieExistsOnServer(tmdbId int, movies []model.MovieToRadarrResponse) bool
 	return false
 }
 
-func compressNSyncRemote(token, source, target string) error {
-	movies, err := client.FetchMoviesListToCompress(token)
+func compressNSyncRemote(library *movieLibrary, source, target string, opts *compress.SyncOptions) error {
+	listMovies, err := library.fetch()
 	if err != nil {
-		return fmt.Errorf("fetch movies list failed: %w", err)
-	}
-
-	var listMovies []string
-	for _, movie := range movies {
-		listMovies = append(listMovies, movie.Path)
+		return err
 	}
 
-	if err := compress.SyncAndCompress(source, target, listMovies); err != nil {
+	if err := compress.SyncAndCompress(source, target, listMovies, opts); err != nil {
 		return fmt.Errorf("sync and compress failed: %w", err)
 	}
 
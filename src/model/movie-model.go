@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 type MovieResponse []struct {
 	Title string `json:"title"`
 	Path  string `json:"path"`
@@ -25,13 +27,29 @@ type AddMovieToRadarrModel MovieToRadarrResponse
 type GetMovieRadarrModel []RadarrModel
 
 type RadarrModel struct {
-	Title     string       `json:"title"`
-	Overview  string       `json:"overview"`
-	TmdbId    int          `json:"tmdbId"`
-	Path      string       `json:"path"`
-	HasFile   bool         `json:"hasFile"`
-	InCinemas string       `json:"inCinemas"`
-	Images    []ImageModel `json:"images"`
+	Title     string          `json:"title"`
+	Overview  string          `json:"overview"`
+	TmdbId    int             `json:"tmdbId"`
+	ImdbId    string          `json:"imdbId"`
+	Year      int             `json:"year"`
+	Path      string          `json:"path"`
+	HasFile   bool            `json:"hasFile"`
+	InCinemas string          `json:"inCinemas"`
+	Images    []ImageModel    `json:"images"`
+	MovieFile *MovieFileModel `json:"movieFile,omitempty"`
+}
+
+// MovieFileModel is the file Radarr holds for a movie
+type MovieFileModel struct {
+	RelativePath string       `json:"relativePath"`
+	Quality      QualityModel `json:"quality"`
+}
+
+// QualityModel is the quality of a movie file, e.g. Bluray-1080p
+type QualityModel struct {
+	Quality struct {
+		Name string `json:"name"`
+	} `json:"quality"`
 }
 
 type ImageModel struct {
@@ -41,3 +59,40 @@ type ImageModel struct {
 type MovieLoginResponse struct {
 	Token string `json:"accessToken"`
 }
+
+// Compression report statuses
+const (
+	CompressStatusArchived = "archived"
+	CompressStatusFailed   = "failed"
+	// CompressStatusSkipped is a movie left for a later run, e.g. for lack of space
+	CompressStatusSkipped = "skipped"
+)
+
+// CompressReport acknowledges to the server the outcome of archiving one movie
+type CompressReport struct {
+	Path       string    `json:"path"`
+	Status     string    `json:"status"`
+	Archive    string    `json:"archive,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	SHA256     string    `json:"sha256,omitempty"`
+	Codec      string    `json:"codec,omitempty"`
+	ArchivedAt time.Time `json:"archivedAt"`
+	Error      string    `json:"error,omitempty"`
+	// Files left out by include and exclude patterns
+	SkippedFiles int   `json:"skippedFiles,omitempty"`
+	SkippedBytes int64 `json:"skippedBytes,omitempty"`
+	// Results of each compression policy applied to the movie's files
+	Policies []CompressPolicyReport `json:"policies,omitempty"`
+	// Content of a deduplicated movie already held by the chunk store
+	DedupedBytes int64 `json:"dedupedBytes,omitempty"`
+}
+
+// CompressPolicyReport is what one compression policy saved on a movie
+type CompressPolicyReport struct {
+	Policy          string `json:"policy"`
+	Level           int    `json:"level"`
+	Files           int    `json:"files"`
+	Bytes           int64  `json:"bytes"`
+	CompressedBytes int64  `json:"compressedBytes"`
+	SavedBytes      int64  `json:"savedBytes"`
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"encoding/json"
 	"testing"
 )
 
@@ -134,3 +135,19 @@ func TestRadarrResponseError(t *testing.T) {
 		t.Errorf("Expected ErrorCode 'PathRequired', got '%s'", errors[0].ErrorCode)
 	}
 }
+
+func TestRadarrModelDecodesMovieFile(t *testing.T) {
+	data := `{"title": "Inception", "tmdbId": 27205, "imdbId": "tt1375666", "year": 2010,
+		"movieFile": {"relativePath": "Inception.mkv", "quality": {"quality": {"id": 7, "name": "Bluray-1080p"}}}}`
+
+	var radarr RadarrModel
+	if err := json.Unmarshal([]byte(data), &radarr); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if radarr.ImdbId != "tt1375666" || radarr.Year != 2010 {
+		t.Errorf("Expected IMDb ID and year, got %+v", radarr)
+	}
+	if radarr.MovieFile == nil || radarr.MovieFile.Quality.Quality.Name != "Bluray-1080p" {
+		t.Errorf("Expected movie file quality Bluray-1080p, got %+v", radarr.MovieFile)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/client"
+	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
+	"github.com/pedrosantosdev/radarr-sync-go/src/model"
+)
+
+// movieLibrary fetches the movies the server wants archived and, when radarr
+// is set, what Radarr knows of them, embedded in their archives
+type movieLibrary struct {
+	token  string
+	radarr bool
+	// movies maps normalized movie paths to their metadata, as of the last fetch
+	movies map[string]*io_archive.MovieMetadata
+}
+
+// fetch returns the paths of the movies the server wants archived
+func (l *movieLibrary) fetch() ([]string, error) {
+	movies, err := client.FetchMoviesListToCompress(l.token)
+	if err != nil {
+		return nil, fmt.Errorf("fetch movies list failed: %w", err)
+	}
+
+	var listMovies []string
+	for _, movie := range movies {
+		listMovies = append(listMovies, movie.Path)
+	}
+	if !l.radarr {
+		return listMovies, nil
+	}
+
+	moviesOnRadarr, err := client.GetAllMoviesOnRadarr()
+	if err != nil {
+		return nil, fmt.Errorf("fetch radarr movies failed: %w", err)
+	}
+	l.movies = make(map[string]*io_archive.MovieMetadata)
+	for _, movie := range movies {
+		l.movies[moviePathKey(movie.Path)] = movieMetadata(movie.Title, movie.Path, moviesOnRadarr)
+	}
+	return listMovies, nil
+}
+
+// metadata returns what is known of the movie at moviePath, nil if it was not listed
+func (l *movieLibrary) metadata(moviePath string) *io_archive.MovieMetadata {
+	return l.movies[moviePathKey(moviePath)]
+}
+
+// moviePathKey normalizes a movie path relative to the source
+func moviePathKey(moviePath string) string {
+	return path.Clean(filepath.ToSlash(moviePath))
+}
+
+// movieMetadata describes the movie title at moviePath with what Radarr
+// reports for it, found by its folder name or else by its title
+func movieMetadata(title, moviePath string, moviesOnRadarr []model.RadarrModel) *io_archive.MovieMetadata {
+	metadata := &io_archive.MovieMetadata{Title: title}
+	radarr := findRadarrMovie(title, moviePath, moviesOnRadarr)
+	if radarr == nil {
+		return metadata
+	}
+
+	metadata.TmdbID = radarr.TmdbId
+	metadata.ImdbID = radarr.ImdbId
+	metadata.Title = radarr.Title
+	metadata.Year = radarr.Year
+	if radarr.MovieFile != nil {
+		metadata.Quality = radarr.MovieFile.Quality.Quality.Name
+	}
+	return metadata
+}
+
+// findRadarrMovie returns the Radarr movie whose folder is part of moviePath,
+// or else the one named title. Folders tell apart movies sharing a title.
+func findRadarrMovie(title, moviePath string, moviesOnRadarr []model.RadarrModel) *model.RadarrModel {
+	segments := strings.Split(moviePathKey(moviePath), "/")
+	for i := range moviesOnRadarr {
+		if moviesOnRadarr[i].Path == "" {
+			continue
+		}
+		folder := path.Base(filepath.ToSlash(moviesOnRadarr[i].Path))
+		for _, segment := range segments {
+			if segment == folder {
+				return &moviesOnRadarr[i]
+			}
+		}
+	}
+	for i := range moviesOnRadarr {
+		if strings.EqualFold(moviesOnRadarr[i].Title, title) {
+			return &moviesOnRadarr[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// ioprio_set arguments, see linux/ioprio.h
+const (
+	ioprioWhoProcess = 1
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+)
+
+// lowestNice is the lowest CPU scheduling priority
+const lowestNice = 19
+
+// lowerPriority makes the process yield the CPU and the disks to other
+// processes, e.g. a media server streaming from the same disks. Linux applies
+// both priorities per thread, so every current thread is changed; threads
+// started later inherit them.
+func lowerPriority() error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return fmt.Errorf("failed to list threads: %w", err)
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, lowestNice); err != nil {
+			return fmt.Errorf("failed to lower CPU priority: %w", err)
+		}
+		_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid),
+			ioprioClassIdle<<ioprioClassShift)
+		if errno != 0 {
+			return fmt.Errorf("failed to lower I/O priority: %w", errno)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// lowerPriority is only implemented on Linux
+func lowerPriority() error {
+	return fmt.Errorf("lowering CPU and I/O priority is only supported on Linux")
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/compress"
+)
+
+const (
+	// progressBarWidth is the number of cells of the progress bar
+	progressBarWidth = 30
+	// progressRedraw limits how often the progress bar is redrawn
+	progressRedraw = 200 * time.Millisecond
+)
+
+// progressPrinter renders compression progress as a progress bar redrawn in
+// place on a terminal, or as a log line every interval otherwise.
+type progressPrinter struct {
+	out      io.Writer
+	tty      bool
+	interval time.Duration
+	last     time.Time
+	// drawn is set while the cursor is at the end of a progress bar
+	drawn bool
+}
+
+// newProgressPrinter returns a printer for stdout. A zero interval disables
+// log lines when stdout is not a terminal.
+func newProgressPrinter(interval time.Duration) *progressPrinter {
+	return &progressPrinter{out: os.Stdout, tty: isTerminal(os.Stdout), interval: interval}
+}
+
+// isTerminal reports whether file is a character device such as a terminal
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// print renders progress, throttled unless a movie is complete
+func (p *progressPrinter) print(progress compress.SyncProgress) {
+	if p.tty {
+		p.printBar(progress)
+		return
+	}
+	if p.interval <= 0 || time.Since(p.last) < p.interval {
+		return
+	}
+	p.last = time.Now()
+	fmt.Fprintf(p.out, "Progress: %s of %s (%.1f%%), movie %d/%d %s, %s/s, ETA %s\n",
+		formatBytes(progress.Done), formatBytes(progress.Total), percent(progress.Done, progress.Total),
+		progress.Index, progress.Movies, progress.Movie, formatBytes(int64(progress.Archive.Throughput())),
+		formatETA(progress.ETA))
+}
+
+func (p *progressPrinter) printBar(progress compress.SyncProgress) {
+	if !progress.Archive.Done && time.Since(p.last) < progressRedraw {
+		return
+	}
+	p.last = time.Now()
+
+	filled := int(percent(progress.Done, progress.Total) / 100 * progressBarWidth)
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", progressBarWidth-filled)
+	// \r and the erase-line sequence redraw the bar in place
+	fmt.Fprintf(p.out, "\r\033[K[%s] %5.1f%% %d/%d %s %s/s ETA %s", bar,
+		percent(progress.Done, progress.Total), progress.Index, progress.Movies,
+		progress.Movie, formatBytes(int64(progress.Archive.Throughput())), formatETA(progress.ETA))
+	p.drawn = true
+	if progress.Archive.Done {
+		p.end()
+	}
+}
+
+// end moves past a progress bar, so following output starts on its own line
+func (p *progressPrinter) end() {
+	if p.drawn {
+		fmt.Fprintln(p.out)
+		p.drawn = false
+	}
+}
+
+// percent returns done as a percentage of total, capped at 100
+func percent(done, total int64) float64 {
+	if total <= 0 || done >= total {
+		return 100
+	}
+	return float64(done) / float64(total) * 100
+}
+
+// formatETA renders the time left in whole seconds, or "?" while unknown
+func formatETA(eta time.Duration) string {
+	if eta <= 0 {
+		return "?"
+	}
+	return eta.Round(time.Second).String()
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/compress"
+)
+
+// runPrune permanently removes trashed archives, either those past the
+// retention period or, with -all, the whole trash.
+func runPrune(args []string) error {
+	flags := flag.NewFlagSet("prune", flag.ExitOnError)
+	target := flags.String(flagTarget, "", "Directory or URL with compressed files")
+	trashDir := flags.String(flagTrashDir, compress.DefaultTrashDir, "Trash directory inside target")
+	retention := flags.Duration(flagRetention, compress.DefaultTrashRetention, "How long trashed archives are kept")
+	all := flags.Bool("all", false, "Empty the trash regardless of retention")
+	remote := registerTargetFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *target == "" {
+		return fmt.Errorf("target is required")
+	}
+	if *all {
+		*retention = 0
+	}
+
+	store, err := remote.open(*target)
+	if err != nil {
+		return err
+	}
+	removed, err := compress.PruneTrash(store, *trashDir, *retention)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Pruned %d archives from %s in %s\n", removed, *trashDir, store)
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"path/filepath"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/client"
+	"github.com/pedrosantosdev/radarr-sync-go/src/compress"
+	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
+)
+
+// runRestore extracts the archive of one movie, selected by title, TMDB ID or
+// archive path, or restores its snapshot from the chunk store of the target.
+// Looking up a TMDB ID requires the server credentials to resolve its title.
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	target := flags.String(flagTarget, "", "Directory or URL with compressed files")
+	dest := flags.String("dest", "", "Directory to restore files into")
+	title := flags.String("title", "", "Title of the movie to restore")
+	tmdbId := flags.Int("tmdb", 0, "TMDB ID of the movie to restore")
+	archivePath := flags.String("archive", "", "Archive or snapshot to restore, relative to target, "+
+		"e.g. a/movie.tar.gz when several titles match")
+	trashDir := flags.String(flagTrashDir, compress.DefaultTrashDir, "Trash directory inside target, "+
+		"not searched by title")
+	force := flags.Bool("force", false, "Overwrite existing files")
+	preserveTimes := flags.Bool("preserve-times", false, "Restore modification times")
+	preservePerms := flags.Bool("preserve-perms", false, "Restore file permissions")
+	identity := flags.String(flagIdentity, "", "File with identities opening encrypted archives, "+
+		"the passphrase is read from "+passphraseEnv)
+	url := flags.String(flagURL, "", "Server URL (required with -tmdb)")
+	login := flags.String(flagLogin, "", "Server username (required with -tmdb)")
+	password := flags.String(flagPassword, "", "Server password (required with -tmdb)")
+	remote := registerTargetFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *target == "" {
+		return fmt.Errorf("target is required")
+	}
+	if *dest == "" {
+		return fmt.Errorf("dest is required")
+	}
+	selectors := 0
+	for _, set := range []bool{*title != "", *tmdbId != 0, *archivePath != ""} {
+		if set {
+			selectors++
+		}
+	}
+	if selectors != 1 {
+		return fmt.Errorf("exactly one of title, tmdb or archive is required")
+	}
+
+	if *tmdbId != 0 {
+		resolved, err := titleForTmdbId(*url, *login, *password, *tmdbId)
+		if err != nil {
+			return err
+		}
+		*title = resolved
+	}
+
+	store, err := remote.open(*target)
+	if err != nil {
+		return err
+	}
+	archive := path.Clean(filepath.ToSlash(*archivePath))
+	if *archivePath == "" {
+		if archive, err = compress.FindMovieArchive(store, *title, *trashDir); err != nil {
+			return err
+		}
+	}
+	dec, err := decryption(*identity)
+	if err != nil {
+		return err
+	}
+
+	extractOpts := &io_archive.ExtractOptions{
+		PreserveTimes:       *preserveTimes,
+		PreservePermissions: *preservePerms,
+		Overwrite:           *force,
+		Decryption:          dec,
+	}
+	var extracted []string
+	if io_archive.IsSnapshot(archive) {
+		chunks, err := io_archive.OpenChunkStore(store, nil)
+		if err != nil {
+			return err
+		}
+		extracted, err = chunks.Restore(archive, *dest, extractOpts)
+	} else {
+		extracted, err = io_archive.ExtractFrom(store, archive, *dest, extractOpts)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored %s (%d entries) into %s\n", path.Base(archive), len(extracted), *dest)
+	return nil
+}
+
+// titleForTmdbId looks up the title of a movie on the server by its TMDB ID.
+func titleForTmdbId(url, login, password string, tmdbId int) (string, error) {
+	if url == "" || login == "" || password == "" {
+		return "", fmt.Errorf("url, login and password are required to look up a TMDB ID")
+	}
+
+	client.SetServerUri(url)
+	token, err := client.Login(login, password)
+	if err != nil {
+		return "", fmt.Errorf("login failed: %w", err)
+	}
+
+	movies, err := client.FetchMoviesListToSync(token.Token)
+	if err != nil {
+		return "", fmt.Errorf("fetch server movies failed: %w", err)
+	}
+	for _, movie := range movies {
+		if movie.TmdbId == tmdbId {
+			return movie.Title, nil
+		}
+	}
+	return "", fmt.Errorf("no movie with TMDB ID %d on server", tmdbId)
+}
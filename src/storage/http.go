@@ -0,0 +1,22 @@
+package storage
+
+import (
+	"net/http"
+	"time"
+)
+
+// controlClient sends the requests of the S3 and WebDAV storages that do not
+// carry file content, such as listings, stats and deletes
+var controlClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
+// transferClient shares the pooled transport but has no overall timeout,
+// since uploading or downloading a large file can take longer than
+// regular API calls
+var transferClient = &http.Client{Transport: controlClient.Transport}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores files in a local directory
+type Local struct {
+	root string
+}
+
+// NewLocal returns a Storage for the directory root.
+func NewLocal(root string) *Local {
+	return &Local{root: root}
+}
+
+func (s *Local) String() string {
+	return s.root
+}
+
+// Path returns the local path of name
+func (s *Local) Path(name string) string {
+	return filepath.Join(s.root, filepath.FromSlash(name))
+}
+
+// List walks dir without following symbolic links below it, which are
+// reported with fs.ModeSymlink set and their target in Link. dir itself is
+// followed if it is a link. Fails if dir does not exist.
+func (s *Local) List(dir string) ([]File, error) {
+	return s.Walk(context.Background(), dir, WalkOptions{})
+}
+
+// Walk is List narrowed by opts. Directory entries are read without a stat
+// per entry with opts.NamesOnly.
+func (s *Local) Walk(ctx context.Context, dir string, opts WalkOptions) ([]File, error) {
+	readLink := func(name string) (string, error) {
+		return os.Readlink(s.Path(name))
+	}
+	files, err := walkFS(ctx, os.DirFS(s.root), readLink, dir, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", s.Path(dir), err)
+	}
+	return files, nil
+}
+
+// Stat follows symbolic links.
+func (s *Local) Stat(name string) (*File, error) {
+	info, err := os.Stat(s.Path(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to stat %s: %w", name, err)
+	}
+	file := fileFromInfo(name, info)
+	return &file, nil
+}
+
+func fileFromInfo(name string, info fs.FileInfo) File {
+	id, links := fileIdentity(info)
+	return File{
+		Name:    name,
+		Size:    info.Size(),
+		Mode:    info.Mode(),
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+		ID:      id,
+		Links:   links,
+	}
+}
+
+func (s *Local) Open(name string) (io.ReadCloser, error) {
+	file, err := os.Open(s.Path(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	return file, nil
+}
+
+// Create writes to a temporary file next to name, renamed into place on Close,
+// so an interrupted run never leaves a partial archive under the final name.
+func (s *Local) Create(name string) (Writer, error) {
+	path := s.Path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create directory for %s: %w", name, err)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*"+tempSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	return &localWriter{File: file, path: path}, nil
+}
+
+func (s *Local) Remove(name string) error {
+	path := s.Path(name)
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+	removeEmptyParents(s.root, filepath.Dir(path))
+	return nil
+}
+
+func (s *Local) Rename(oldName, newName string) error {
+	oldPath, newPath := s.Path(oldName), s.Path(newName)
+	if err := os.MkdirAll(filepath.Dir(newPath), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", newName, err)
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return fmt.Errorf("failed to move %s: %w", oldPath, err)
+	}
+	removeEmptyParents(s.root, filepath.Dir(oldPath))
+	return nil
+}
+
+// removeEmptyParents removes dir and its parents while they are empty,
+// stopping at root. Failures are ignored, a leftover directory is harmless.
+func removeEmptyParents(root, dir string) {
+	for dir != root && strings.HasPrefix(dir, root) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+type localWriter struct {
+	*os.File
+	path string
+}
+
+func (w *localWriter) Close() error {
+	if err := w.File.Close(); err != nil {
+		os.Remove(w.Name())
+		return err
+	}
+	if err := os.Rename(w.Name(), w.path); err != nil {
+		os.Remove(w.Name())
+		return fmt.Errorf("failed to move %s into place: %w", w.path, err)
+	}
+	return nil
+}
+
+func (w *localWriter) Abort() error {
+	w.File.Close()
+	return os.Remove(w.Name())
+}
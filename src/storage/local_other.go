@@ -0,0 +1,10 @@
+//go:build !unix
+
+package storage
+
+import "io/fs"
+
+// fileIdentity is unknown on platforms without inodes
+func fileIdentity(info fs.FileInfo) (string, int) {
+	return "", 0
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalCreateIsAtomic(t *testing.T) {
+	root := t.TempDir()
+	target := NewLocal(root)
+
+	writer, err := target.Create("a/movie.mkv.tar.gz")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := writer.Write([]byte("partial")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "a", "movie.mkv.tar.gz")); !os.IsNotExist(err) {
+		t.Error("Expected no file under final name before Close")
+	}
+	files, err := target.List("")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(Files(files)) != 0 {
+		t.Errorf("Expected temporary file to be hidden from List, got %v", files)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	file, err := target.Stat("a/movie.mkv.tar.gz")
+	if err != nil || file == nil {
+		t.Fatalf("Expected file after Close, got %v, %v", file, err)
+	}
+}
+
+func TestLocalAbort(t *testing.T) {
+	root := t.TempDir()
+	target := NewLocal(root)
+
+	writer, err := target.Create("movie.mkv.tar.gz")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	writer.Write([]byte("partial"))
+	if err := writer.Abort(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatalf("Failed to read root: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected no files after abort, got %d", len(entries))
+	}
+}
+
+func TestLocalRenameRemovesEmptyDirs(t *testing.T) {
+	root := t.TempDir()
+	target := NewLocal(root)
+	writeLocalFile(t, root, "a/b/movie.mkv.tar.gz", "archive")
+
+	if err := target.Rename("a/b/movie.mkv.tar.gz", "c/movie.mkv.tar.gz"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "a")); !os.IsNotExist(err) {
+		t.Error("Expected empty source directories to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(root, "c", "movie.mkv.tar.gz")); err != nil {
+		t.Errorf("Expected renamed file, got error: %v", err)
+	}
+}
+
+func TestLocalListIncludesDirectories(t *testing.T) {
+	root := t.TempDir()
+	writeLocalFile(t, root, "a/b/movie.mkv", "movie")
+	writeLocalFile(t, root, "a.txt", "text")
+	if err := os.Mkdir(filepath.Join(root, "empty"), 0o755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	list, err := NewLocal(root).List("")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var names []string
+	for _, file := range list {
+		names = append(names, file.Name)
+	}
+	expected := []string{"a", "a/b", "a/b/movie.mkv", "a.txt", "empty"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v, got %v", expected, names)
+	}
+
+	if _, err := NewLocal(filepath.Join(root, "missing")).List(""); err == nil {
+		t.Error("Expected error for missing root")
+	}
+}
+
+func TestLocalListReportsLinks(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "movie"), 0o755)
+	os.WriteFile(filepath.Join(root, "movie", "movie.mkv"), []byte("content"), 0o644)
+	os.Link(filepath.Join(root, "movie", "movie.mkv"), filepath.Join(root, "movie", "library.mkv"))
+	os.Symlink("movie", filepath.Join(root, "link"))
+
+	files, err := NewLocal(root).List("")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	byName := make(map[string]File)
+	for _, file := range files {
+		byName[file.Name] = file
+	}
+	if link := byName["link"]; link.Mode&os.ModeSymlink == 0 || link.Link != "movie" {
+		t.Errorf("Expected symlink to movie, got %+v", link)
+	}
+	if _, ok := byName["link/movie.mkv"]; ok {
+		t.Error("Expected List not to follow links below dir")
+	}
+	first, second := byName["movie/movie.mkv"], byName["movie/library.mkv"]
+	if first.ID == "" || first.ID != second.ID || first.Links != 2 {
+		t.Errorf("Expected hard links to share an ID, got %+v and %+v", first, second)
+	}
+
+	// A linked dir itself is followed
+	linked, err := NewLocal(root).List("link")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(linked) != 2 || linked[0].Name != "link/library.mkv" {
+		t.Errorf("Expected content of linked directory, got %+v", linked)
+	}
+}
+
+func TestLocalFreeSpace(t *testing.T) {
+	free, err := NewLocal(t.TempDir()).FreeSpace()
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("free space is unknown on this platform")
+	}
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if free <= 0 {
+		t.Errorf("Expected free space, got %d", free)
+	}
+
+	if _, err := NewLocal(filepath.Join(t.TempDir(), "missing")).FreeSpace(); err == nil {
+		t.Error("Expected error for a missing directory")
+	}
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"syscall"
+)
+
+// fileIdentity returns the device and inode of info and its hard link count
+func fileIdentity(info fs.FileInfo) (string, int) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", 0
+	}
+	return fmt.Sprintf("%d:%d", stat.Dev, stat.Ino), int(stat.Nlink)
+}
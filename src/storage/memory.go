@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory keeps files in memory. It is safe for concurrent use and mostly
+// useful in tests. Directories exist while they hold files, like prefixes
+// of an object store, and are reported by List and Stat.
+type Memory struct {
+	mu    sync.Mutex
+	files map[string]*memoryFile
+}
+
+type memoryFile struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// NewMemory returns an empty in-memory Storage.
+func NewMemory() *Memory {
+	return &Memory{files: make(map[string]*memoryFile)}
+}
+
+func (s *Memory) String() string {
+	return "memory://"
+}
+
+// WriteFile stores data at name with the given modification time.
+func (s *Memory) WriteFile(name string, data []byte, modTime time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[cleanName(name)] = &memoryFile{data: bytes.Clone(data), mode: 0o644, modTime: modTime}
+}
+
+// cleanName normalizes name to the form used as map key
+func cleanName(name string) string {
+	cleaned := path.Clean("/" + name)
+	return strings.TrimPrefix(cleaned, "/")
+}
+
+// isDir reports whether any file is stored below name. The caller holds mu.
+func (s *Memory) isDir(name string) bool {
+	if name == "" {
+		return true
+	}
+	prefix := name + "/"
+	for fileName := range s.files {
+		if strings.HasPrefix(fileName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Memory) List(dir string) ([]File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir = cleanName(dir)
+	if !s.isDir(dir) {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, fs.ErrNotExist)
+	}
+
+	prefix := dirPrefix(dir)
+	dirs := make(map[string]bool)
+	var files []File
+	for name, file := range s.files {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		files = append(files, file.info(name))
+		// Report every parent directory below dir once
+		for parent := path.Dir(name); parent != "." && strings.HasPrefix(parent, prefix); parent = path.Dir(parent) {
+			if dirs[parent] {
+				break
+			}
+			dirs[parent] = true
+			files = append(files, File{Name: parent, Mode: fs.ModeDir | 0o755, IsDir: true})
+		}
+	}
+
+	sortFiles(files)
+	return files, nil
+}
+
+func (f *memoryFile) info(name string) File {
+	return File{Name: name, Size: int64(len(f.data)), Mode: f.mode, ModTime: f.modTime}
+}
+
+func (s *Memory) Stat(name string) (*File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name = cleanName(name)
+	if file, ok := s.files[name]; ok {
+		info := file.info(name)
+		return &info, nil
+	}
+	if s.isDir(name) {
+		return &File{Name: name, Mode: fs.ModeDir | 0o755, IsDir: true}, nil
+	}
+	return nil, nil
+}
+
+func (s *Memory) Open(name string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, ok := s.files[cleanName(name)]
+	if !ok {
+		return nil, fmt.Errorf("failed to open %s: %w", name, fs.ErrNotExist)
+	}
+	// Stored data is never modified in place, so it can be read without a copy
+	return io.NopCloser(bytes.NewReader(file.data)), nil
+}
+
+// Create buffers the content, stored under name on Close.
+func (s *Memory) Create(name string) (Writer, error) {
+	name = cleanName(name)
+	if name == "" {
+		return nil, fmt.Errorf("failed to create %s: name cannot be empty", name)
+	}
+	return &memoryWriter{storage: s, name: name}, nil
+}
+
+func (s *Memory) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name = cleanName(name)
+	if _, ok := s.files[name]; !ok {
+		return fmt.Errorf("failed to remove %s: %w", name, fs.ErrNotExist)
+	}
+	delete(s.files, name)
+	return nil
+}
+
+func (s *Memory) Rename(oldName, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldName, newName = cleanName(oldName), cleanName(newName)
+	file, ok := s.files[oldName]
+	if !ok {
+		return fmt.Errorf("failed to move %s: %w", oldName, fs.ErrNotExist)
+	}
+	delete(s.files, oldName)
+	s.files[newName] = file
+	return nil
+}
+
+type memoryWriter struct {
+	bytes.Buffer
+	storage *Memory
+	name    string
+	done    bool
+}
+
+func (w *memoryWriter) Write(p []byte) (int, error) {
+	if w.done {
+		return 0, fmt.Errorf("write to closed file %s", w.name)
+	}
+	return w.Buffer.Write(p)
+}
+
+func (w *memoryWriter) Close() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	w.storage.mu.Lock()
+	defer w.storage.mu.Unlock()
+	w.storage.files[w.name] = &memoryFile{data: w.Bytes(), mode: 0o644, modTime: time.Now()}
+	return nil
+}
+
+func (w *memoryWriter) Abort() error {
+	w.done = true
+	w.Reset()
+	return nil
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// S3 stores files as objects under a key prefix of an S3 bucket
+type S3 struct {
+	client *s3Client
+	bucket string
+	prefix string
+}
+
+// NewS3 returns a Storage for the bucket in config, storing objects
+// under prefix (e.g. "backups/movies").
+func NewS3(config S3Config, prefix string) (*S3, error) {
+	s3, err := newS3Client(config)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &S3{client: s3, bucket: config.Bucket, prefix: prefix}, nil
+}
+
+func (s *S3) String() string {
+	return "s3://" + s.bucket + "/" + s.prefix
+}
+
+func (s *S3) key(name string) string {
+	return s.prefix + name
+}
+
+// List returns the objects below dir. Buckets have no directories, so a
+// missing dir is an empty list.
+func (s *S3) List(dir string) ([]File, error) {
+	objects, err := s.client.ListObjects(s.prefix + dirPrefix(dir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", s, err)
+	}
+
+	files := make([]File, 0, len(objects))
+	for _, object := range objects {
+		files = append(files, File{
+			Name:    strings.TrimPrefix(object.Key, s.prefix),
+			Size:    object.Size,
+			Mode:    0o644,
+			ModTime: object.LastModified,
+		})
+	}
+	sortFiles(files)
+	return files, nil
+}
+
+func (s *S3) Stat(name string) (*File, error) {
+	object, err := s.client.HeadObject(s.key(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", name, err)
+	}
+	if object == nil {
+		return nil, nil
+	}
+	return &File{Name: name, Size: object.Size, Mode: 0o644, ModTime: object.LastModified}, nil
+}
+
+func (s *S3) Open(name string) (io.ReadCloser, error) {
+	body, err := s.client.GetObject(s.key(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	return body, nil
+}
+
+// Create streams the object with a multipart upload once it outgrows one part.
+func (s *S3) Create(name string) (Writer, error) {
+	return s.client.NewUpload(s.key(name)), nil
+}
+
+// CreatesAtomically reports true, an object is replaced by the upload
+// completed on Close.
+func (s *S3) CreatesAtomically() bool {
+	return true
+}
+
+func (s *S3) Remove(name string) error {
+	if err := s.client.DeleteObject(s.key(name)); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", name, err)
+	}
+	return nil
+}
+
+// Rename copies the object server-side, then deletes the original.
+func (s *S3) Rename(oldName, newName string) error {
+	object, err := s.client.HeadObject(s.key(oldName))
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", oldName, err)
+	}
+	if object == nil {
+		return fmt.Errorf("failed to move %s: object does not exist", oldName)
+	}
+
+	if err := s.client.CopyObjectSized(s.key(oldName), s.key(newName), object.Size); err != nil {
+		return fmt.Errorf("failed to move %s: %w", oldName, err)
+	}
+	return s.Remove(oldName)
+}
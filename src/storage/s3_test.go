@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/testutil"
+)
+
+func newTestS3(t *testing.T, server *testutil.S3Server) Storage {
+	t.Helper()
+	store, err := Open("s3://"+server.Bucket+"/movies", Config{S3: S3Config{
+		Endpoint:  server.URL,
+		AccessKey: "access",
+		SecretKey: "secret",
+	}})
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	return store
+}
+
+func TestS3RoundTrip(t *testing.T) {
+	server := testutil.NewS3Server("backups")
+	defer server.Close()
+
+	testRoundTrip(t, newTestS3(t, server))
+}
+
+func TestS3KeysUnderPrefix(t *testing.T) {
+	server := testutil.NewS3Server("backups")
+	defer server.Close()
+	store := newTestS3(t, server)
+
+	if err := WriteFile(store, "a/movie.mkv.tar.gz", []byte("archive")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if server.Object("movies/a/movie.mkv.tar.gz") == nil {
+		t.Errorf("Expected object under prefix, got keys %v", server.Keys())
+	}
+}
@@ -0,0 +1,542 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultS3PartSize is the multipart upload part size used when none is configured
+const DefaultS3PartSize = 16 * 1024 * 1024
+
+// minS3PartSize is the smallest part size S3 accepts for all but the last part
+const minS3PartSize = 5 * 1024 * 1024
+
+// maxS3CopySize is the largest object S3 copies in a single request
+const maxS3CopySize = 5 * 1024 * 1024 * 1024
+
+// s3CopyPartSize is the range size of each part of a multipart copy
+const s3CopyPartSize = 1024 * 1024 * 1024
+
+// S3Config configures access to an S3-compatible object store.
+// Requests use path-style addressing (endpoint/bucket/key), which works with
+// AWS and with self-hosted stores such as MinIO.
+type S3Config struct {
+	Endpoint     string
+	Region       string
+	Bucket       string
+	AccessKey    string
+	SecretKey    string
+	StorageClass string
+	// PartSize is the multipart part size in bytes (default 16 MiB, minimum 5 MiB)
+	PartSize int64
+}
+
+// s3Object describes a stored object
+type s3Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// s3Client is a minimal S3 API client signing requests with AWS Signature V4
+type s3Client struct {
+	config       S3Config
+	now          func() time.Time
+	maxCopySize  int64
+	copyPartSize int64
+}
+
+// newS3Client validates config and returns a client for its bucket.
+func newS3Client(config S3Config) (*s3Client, error) {
+	if config.Endpoint == "" {
+		return nil, fmt.Errorf("s3 endpoint cannot be empty")
+	}
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket cannot be empty")
+	}
+	if config.AccessKey == "" || config.SecretKey == "" {
+		return nil, fmt.Errorf("s3 credentials cannot be empty")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.PartSize == 0 {
+		config.PartSize = DefaultS3PartSize
+	}
+	if config.PartSize < minS3PartSize {
+		return nil, fmt.Errorf("s3 part size must be at least %d bytes", minS3PartSize)
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	return &s3Client{
+		config:       config,
+		now:          time.Now,
+		maxCopySize:  maxS3CopySize,
+		copyPartSize: s3CopyPartSize,
+	}, nil
+}
+
+// PartSize returns the configured multipart part size.
+func (c *s3Client) PartSize() int64 {
+	return c.config.PartSize
+}
+
+type listBucketResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+// ListObjects returns every object whose key starts with prefix.
+func (c *s3Client) ListObjects(prefix string) ([]s3Object, error) {
+	var objects []s3Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		var result listBucketResult
+		resp, err := c.do("GET", "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode object list: %w", err)
+		}
+
+		for _, content := range result.Contents {
+			objects = append(objects, s3Object{
+				Key:          content.Key,
+				Size:         content.Size,
+				LastModified: content.LastModified,
+			})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// HeadObject returns the object stored at key, or (nil, nil) if there is none.
+func (c *s3Client) HeadObject(key string) (*s3Object, error) {
+	resp, err := c.do("HEAD", key, nil, nil, nil)
+	if err != nil {
+		var statusErr *S3Error
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	resp.Body.Close()
+
+	modified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Last-Modified for %s: %w", key, err)
+	}
+	return &s3Object{Key: key, Size: resp.ContentLength, LastModified: modified}, nil
+}
+
+// GetObject opens the content of the object at key.
+func (c *s3Client) GetObject(key string) (io.ReadCloser, error) {
+	resp, err := c.send(transferClient, "GET", key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// PutObject stores body at key in a single request with a SHA-256 checksum.
+func (c *s3Client) PutObject(key string, body []byte) error {
+	headers := c.storageHeaders()
+	headers["x-amz-checksum-sha256"] = checksumSHA256(body)
+	resp, err := c.send(transferClient, "PUT", key, nil, body, headers)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+// CopyObject copies the object at src to dst within the bucket.
+func (c *s3Client) CopyObject(src, dst string) error {
+	headers := c.storageHeaders()
+	headers["x-amz-copy-source"] = "/" + c.config.Bucket + "/" + s3EscapePath(src)
+	resp, err := c.send(transferClient, "PUT", dst, nil, nil, headers)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decodeS3Result(resp.Body, nil)
+}
+
+// CopyObjectSized copies the object at src of the given size to dst, using a
+// multipart copy for objects too large for a single copy request.
+func (c *s3Client) CopyObjectSized(src, dst string, size int64) error {
+	if size <= c.maxCopySize {
+		return c.CopyObject(src, dst)
+	}
+
+	uploadID, err := c.CreateMultipartUpload(dst)
+	if err != nil {
+		return err
+	}
+
+	var parts []s3Part
+	for offset := int64(0); offset < size; offset += c.copyPartSize {
+		last := min(offset+c.copyPartSize, size) - 1
+		part, err := c.uploadPartCopy(src, dst, uploadID, len(parts)+1, offset, last)
+		if err != nil {
+			c.AbortMultipartUpload(dst, uploadID)
+			return err
+		}
+		parts = append(parts, part)
+	}
+
+	if err := c.CompleteMultipartUpload(dst, uploadID, parts); err != nil {
+		c.AbortMultipartUpload(dst, uploadID)
+		return err
+	}
+	return nil
+}
+
+type copyPartResult struct {
+	ETag           string `xml:"ETag"`
+	ChecksumSHA256 string `xml:"ChecksumSHA256"`
+}
+
+// uploadPartCopy copies the byte range [first, last] of src as a part of dst.
+func (c *s3Client) uploadPartCopy(src, dst, uploadID string, partNumber int, first, last int64) (s3Part, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {uploadID}}
+	headers := map[string]string{
+		"x-amz-copy-source":       "/" + c.config.Bucket + "/" + s3EscapePath(src),
+		"x-amz-copy-source-range": fmt.Sprintf("bytes=%d-%d", first, last),
+	}
+
+	resp, err := c.send(transferClient, "PUT", dst, query, nil, headers)
+	if err != nil {
+		return s3Part{}, err
+	}
+	defer resp.Body.Close()
+
+	var result copyPartResult
+	if err := decodeS3Result(resp.Body, &result); err != nil {
+		return s3Part{}, fmt.Errorf("failed to copy part %d: %w", partNumber, err)
+	}
+	return s3Part{PartNumber: partNumber, ETag: result.ETag, ChecksumSHA256: result.ChecksumSHA256}, nil
+}
+
+// DeleteObject removes the object at key. Deleting a missing key is not an error.
+func (c *s3Client) DeleteObject(key string) error {
+	return c.doAndClose("DELETE", key, nil, nil, nil)
+}
+
+type initiateMultipartResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+// s3Part is an uploaded part of a multipart upload
+type s3Part struct {
+	XMLName        xml.Name `xml:"Part"`
+	PartNumber     int      `xml:"PartNumber"`
+	ETag           string   `xml:"ETag"`
+	ChecksumSHA256 string   `xml:"ChecksumSHA256"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []s3Part `xml:"Part"`
+}
+
+// CreateMultipartUpload starts a multipart upload with SHA-256 part checksums.
+func (c *s3Client) CreateMultipartUpload(key string) (string, error) {
+	headers := c.storageHeaders()
+	headers["x-amz-checksum-algorithm"] = "SHA256"
+
+	resp, err := c.do("POST", key, url.Values{"uploads": {""}}, nil, headers)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result initiateMultipartResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode multipart upload: %w", err)
+	}
+	if result.UploadID == "" {
+		return "", fmt.Errorf("multipart upload for %s returned no upload id", key)
+	}
+	return result.UploadID, nil
+}
+
+// UploadPart uploads part number partNumber (starting at 1) of a multipart upload.
+func (c *s3Client) UploadPart(key, uploadID string, partNumber int, body []byte) (s3Part, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {uploadID}}
+	checksum := checksumSHA256(body)
+
+	resp, err := c.send(transferClient, "PUT", key, query, body, map[string]string{"x-amz-checksum-sha256": checksum})
+	if err != nil {
+		return s3Part{}, err
+	}
+	resp.Body.Close()
+
+	return s3Part{PartNumber: partNumber, ETag: resp.Header.Get("ETag"), ChecksumSHA256: checksum}, nil
+}
+
+// CompleteMultipartUpload assembles the uploaded parts into the final object.
+// S3 can still fail after answering 200, so the body is checked for an error.
+func (c *s3Client) CompleteMultipartUpload(key, uploadID string, parts []s3Part) error {
+	body, err := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if err != nil {
+		return fmt.Errorf("failed to encode parts: %w", err)
+	}
+	resp, err := c.do("POST", key, url.Values{"uploadId": {uploadID}}, body, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := decodeS3Result(resp.Body, nil); err != nil {
+		return fmt.Errorf("failed to complete upload of %s: %w", key, err)
+	}
+	return nil
+}
+
+// AbortMultipartUpload discards a multipart upload and its uploaded parts.
+func (c *s3Client) AbortMultipartUpload(key, uploadID string) error {
+	return c.doAndClose("DELETE", key, url.Values{"uploadId": {uploadID}}, nil, nil)
+}
+
+// S3Error is returned for non-2xx responses
+type S3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("HTTP %d", e.StatusCode)
+	}
+	return fmt.Sprintf("HTTP %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+func (c *s3Client) storageHeaders() map[string]string {
+	headers := map[string]string{}
+	if c.config.StorageClass != "" {
+		headers["x-amz-storage-class"] = c.config.StorageClass
+	}
+	return headers
+}
+
+func (c *s3Client) doAndClose(method, key string, query url.Values, body []byte, headers map[string]string) error {
+	resp, err := c.do(method, key, query, body, headers)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+// decodeS3Result decodes the XML body of a 2xx response into result, which
+// may be nil to only check it. Copies and completed multipart uploads report
+// failures found after the 200 status as an <Error> body, returned as *S3Error.
+func decodeS3Result(body io.Reader, result interface{}) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	var root struct {
+		XMLName xml.Name
+	}
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	if root.XMLName.Local == "Error" {
+		statusErr := &S3Error{StatusCode: http.StatusOK}
+		xml.Unmarshal(data, statusErr)
+		return statusErr
+	}
+	if result == nil {
+		return nil
+	}
+	if err := xml.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// do sends a signed control request for key (empty for the bucket itself)
+// with the timeout of controlClient.
+// Returns *S3Error if the status code is not 2xx.
+func (c *s3Client) do(method, key string, query url.Values, body []byte,
+	headers map[string]string) (*http.Response, error) {
+	return c.send(controlClient, method, key, query, body, headers)
+}
+
+// send signs and sends a request for key with client.
+// Returns *S3Error if the status code is not 2xx.
+func (c *s3Client) send(client *http.Client, method, key string, query url.Values, body []byte,
+	headers map[string]string) (*http.Response, error) {
+	path := "/" + s3EscapePath(c.config.Bucket)
+	if key != "" {
+		path += "/" + s3EscapePath(key)
+	}
+
+	req, err := http.NewRequest(method, c.config.Endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.URL.RawQuery = s3CanonicalQuery(query)
+	req.ContentLength = int64(len(body))
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	c.sign(req, path, body)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		statusErr := &S3Error{StatusCode: resp.StatusCode}
+		if method != "HEAD" {
+			// Best effort, the status code alone is enough to report the failure
+			xml.NewDecoder(resp.Body).Decode(statusErr)
+		}
+		return nil, statusErr
+	}
+	return resp, nil
+}
+
+// sign adds AWS Signature V4 headers to req.
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func (c *s3Client) sign(req *http.Request, canonicalURI string, body []byte) {
+	now := c.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := hexSHA256(body)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders, canonicalHeaders := s3CanonicalHeaders(req)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + c.config.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+c.config.SecretKey), day)
+	key = hmacSHA256(key, c.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.config.AccessKey, scope, signedHeaders, signature))
+}
+
+// s3CanonicalHeaders returns the signed header list and canonical header block,
+// covering host and every x-amz-* header.
+func s3CanonicalHeaders(req *http.Request) (string, string) {
+	values := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") {
+			values[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonical strings.Builder
+	for _, name := range names {
+		canonical.WriteString(name + ":" + values[name] + "\n")
+	}
+	return strings.Join(names, ";"), canonical.String()
+}
+
+// s3CanonicalQuery encodes query sorted by key with S3 escaping.
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, s3Escape(key, false)+"="+s3Escape(value, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3EscapePath escapes an object key, keeping "/" separators.
+func s3EscapePath(key string) string {
+	return s3Escape(key, true)
+}
+
+// s3Escape percent-encodes every byte except unreserved characters (and "/" if keepSlash).
+func s3Escape(value string, keepSlash bool) string {
+	var escaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		b := value[i]
+		unreserved := b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z' || b >= '0' && b <= '9' ||
+			b == '-' || b == '_' || b == '.' || b == '~'
+		if unreserved || keepSlash && b == '/' {
+			escaped.WriteByte(b)
+			continue
+		}
+		fmt.Fprintf(&escaped, "%%%02X", b)
+	}
+	return escaped.String()
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func checksumSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/testutil"
+)
+
+func newTestS3Client(t *testing.T, server *testutil.S3Server) *s3Client {
+	t.Helper()
+	s3, err := newS3Client(S3Config{
+		Endpoint:     server.URL,
+		Bucket:       server.Bucket,
+		AccessKey:    "access",
+		SecretKey:    "secret",
+		StorageClass: "STANDARD_IA",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	return s3
+}
+
+func TestNewS3ClientValidation(t *testing.T) {
+	if _, err := newS3Client(S3Config{Bucket: "b", AccessKey: "a", SecretKey: "s"}); err == nil {
+		t.Error("Expected error for empty endpoint")
+	}
+	if _, err := newS3Client(S3Config{Endpoint: "http://s3", AccessKey: "a", SecretKey: "s"}); err == nil {
+		t.Error("Expected error for empty bucket")
+	}
+	if _, err := newS3Client(S3Config{Endpoint: "http://s3", Bucket: "b"}); err == nil {
+		t.Error("Expected error for missing credentials")
+	}
+	config := S3Config{Endpoint: "http://s3", Bucket: "b", AccessKey: "a", SecretKey: "s", PartSize: 1024}
+	if _, err := newS3Client(config); err == nil {
+		t.Error("Expected error for part size below minimum")
+	}
+}
+
+func TestS3Escape(t *testing.T) {
+	if got := s3EscapePath("dir/Movie (2019).mkv"); got != "dir/Movie%20%282019%29.mkv" {
+		t.Errorf("Unexpected escaped path '%s'", got)
+	}
+	if got := s3Escape("a/b", false); got != "a%2Fb" {
+		t.Errorf("Unexpected escaped value '%s'", got)
+	}
+}
+
+func TestS3SignIsDeterministic(t *testing.T) {
+	s3, err := newS3Client(S3Config{Endpoint: "http://s3.local", Bucket: "b", AccessKey: "AK", SecretKey: "SK"})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	s3.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	sign := func() string {
+		req, _ := http.NewRequest("GET", "http://s3.local/b/key", nil)
+		s3.sign(req, "/b/key", nil)
+		return req.Header.Get("Authorization")
+	}
+
+	first := sign()
+	if first != sign() {
+		t.Error("Expected identical signatures for identical requests")
+	}
+	if !strings.HasPrefix(first, "AWS4-HMAC-SHA256 Credential=AK/20240102/us-east-1/s3/aws4_request") {
+		t.Errorf("Unexpected authorization header '%s'", first)
+	}
+}
+
+func TestS3PutHeadGetDelete(t *testing.T) {
+	server := testutil.NewS3Server("backups")
+	defer server.Close()
+	s3 := newTestS3Client(t, server)
+
+	if err := s3.PutObject("dir/Movie (2019).mkv.tar.gz", []byte("archive")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	object, err := s3.HeadObject("dir/Movie (2019).mkv.tar.gz")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if object == nil || object.Size != int64(len("archive")) {
+		t.Fatalf("Expected object of size %d, got %+v", len("archive"), object)
+	}
+	if stored := server.Object("dir/Movie (2019).mkv.tar.gz"); stored.StorageClass != "STANDARD_IA" {
+		t.Errorf("Expected storage class STANDARD_IA, got '%s'", stored.StorageClass)
+	}
+
+	body, err := s3.GetObject("dir/Movie (2019).mkv.tar.gz")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	content, _ := io.ReadAll(body)
+	body.Close()
+	if string(content) != "archive" {
+		t.Errorf("Expected content 'archive', got '%s'", content)
+	}
+
+	if err := s3.DeleteObject("dir/Movie (2019).mkv.tar.gz"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	object, err = s3.HeadObject("dir/Movie (2019).mkv.tar.gz")
+	if err != nil || object != nil {
+		t.Errorf("Expected missing object, got %+v, %v", object, err)
+	}
+}
+
+func TestS3ListObjectsWithPrefix(t *testing.T) {
+	server := testutil.NewS3Server("backups")
+	defer server.Close()
+	s3 := newTestS3Client(t, server)
+
+	for _, key := range []string{"movies/a.tar.gz", "movies/b.tar.gz", "other/c.tar.gz"} {
+		if err := s3.PutObject(key, []byte(key)); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+
+	objects, err := s3.ListObjects("movies/")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(objects) != 2 {
+		t.Errorf("Expected 2 objects, got %d", len(objects))
+	}
+}
+
+func TestS3CopyObject(t *testing.T) {
+	server := testutil.NewS3Server("backups")
+	defer server.Close()
+	s3 := newTestS3Client(t, server)
+
+	if err := s3.PutObject("a b.tar.gz", []byte("data")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := s3.CopyObject("a b.tar.gz", ".trash/a b.tar.gz"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if server.Object(".trash/a b.tar.gz") == nil {
+		t.Error("Expected copied object")
+	}
+}
+
+func TestS3UploadSmallUsesSinglePut(t *testing.T) {
+	server := testutil.NewS3Server("backups")
+	defer server.Close()
+	s3 := newTestS3Client(t, server)
+
+	upload := s3.NewUpload("small.tar.gz")
+	if _, err := upload.Write([]byte("small content")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := upload.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if server.MultipartCount != 0 {
+		t.Errorf("Expected no multipart upload, got %d", server.MultipartCount)
+	}
+	if string(server.Object("small.tar.gz").Data) != "small content" {
+		t.Error("Expected stored content to match")
+	}
+}
+
+func TestS3UploadLargeUsesMultipart(t *testing.T) {
+	server := testutil.NewS3Server("backups")
+	defer server.Close()
+	s3 := newTestS3Client(t, server)
+	s3.config.PartSize = 1024
+
+	data := bytes.Repeat([]byte("0123456789"), 350)
+	upload := s3.NewUpload("large.tar.gz")
+	if _, err := io.Copy(upload, bytes.NewReader(data)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := upload.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if server.MultipartCount != 1 {
+		t.Errorf("Expected 1 multipart upload, got %d", server.MultipartCount)
+	}
+	if !bytes.Equal(server.Object("large.tar.gz").Data, data) {
+		t.Error("Expected reassembled content to match")
+	}
+}
+
+func TestS3UploadAbort(t *testing.T) {
+	server := testutil.NewS3Server("backups")
+	defer server.Close()
+	s3 := newTestS3Client(t, server)
+	s3.config.PartSize = 1024
+
+	upload := s3.NewUpload("aborted.tar.gz")
+	if _, err := upload.Write(make([]byte, 3000)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := upload.Abort(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if server.PendingUploads() != 0 {
+		t.Errorf("Expected no pending uploads, got %d", server.PendingUploads())
+	}
+	if server.Object("aborted.tar.gz") != nil {
+		t.Error("Expected no object after abort")
+	}
+}
+
+func TestS3CopyObjectSizedUsesMultipartCopy(t *testing.T) {
+	server := testutil.NewS3Server("backups")
+	defer server.Close()
+	s3 := newTestS3Client(t, server)
+	s3.maxCopySize = 100
+	s3.copyPartSize = 64
+
+	data := bytes.Repeat([]byte("abcdefgh"), 40)
+	if err := s3.PutObject("big.tar.gz", data); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := s3.CopyObjectSized("big.tar.gz", "copy.tar.gz", int64(len(data))); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if server.MultipartCount != 1 {
+		t.Errorf("Expected multipart copy, got %d multipart uploads", server.MultipartCount)
+	}
+	if !bytes.Equal(server.Object("copy.tar.gz").Data, data) {
+		t.Error("Expected copied content to match")
+	}
+}
+
+func TestS3CompleteMultipartUploadDetectsError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// S3 answers 200 before assembling the parts, a failure is in the body
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>` +
+			`<Error><Code>InternalError</Code><Message>We encountered an internal error.</Message></Error>`))
+	}))
+	defer server.Close()
+	s3, err := newS3Client(S3Config{Endpoint: server.URL, Bucket: "b", AccessKey: "a", SecretKey: "s"})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	err = s3.CompleteMultipartUpload("large.tar.gz", "1", []s3Part{{PartNumber: 1, ETag: `"etag"`}})
+	var statusErr *S3Error
+	if !errors.As(err, &statusErr) || statusErr.Code != "InternalError" {
+		t.Errorf("Expected an InternalError, got %v", err)
+	}
+	if err := s3.CopyObject("src.tar.gz", "dst.tar.gz"); !errors.As(err, &statusErr) {
+		t.Errorf("Expected the copy to fail, got %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// s3Upload streams an object to S3. Content is buffered one part at a time:
+// objects smaller than a part are stored with a single PUT, larger ones with
+// a multipart upload, so the full object never needs to be held or staged.
+type s3Upload struct {
+	client   *s3Client
+	key      string
+	buffer   []byte
+	uploadID string
+	parts    []s3Part
+	err      error
+	done     bool
+}
+
+// NewUpload starts a streaming upload to key. Call Close to store the object
+// or Abort to discard it.
+func (c *s3Client) NewUpload(key string) *s3Upload {
+	return &s3Upload{client: c, key: key, buffer: make([]byte, 0, c.config.PartSize)}
+}
+
+// Write buffers p, uploading a part whenever the buffer is full.
+func (u *s3Upload) Write(p []byte) (int, error) {
+	if u.err != nil {
+		return 0, u.err
+	}
+	if u.done {
+		return 0, errors.New("write to finished upload")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := copy(u.buffer[len(u.buffer):cap(u.buffer)], p)
+		u.buffer = u.buffer[:len(u.buffer)+n]
+		p = p[n:]
+		written += n
+
+		if len(u.buffer) == cap(u.buffer) {
+			if err := u.flushPart(); err != nil {
+				u.err = err
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flushPart uploads the buffered content as the next part.
+func (u *s3Upload) flushPart() error {
+	if u.uploadID == "" {
+		uploadID, err := u.client.CreateMultipartUpload(u.key)
+		if err != nil {
+			return fmt.Errorf("failed to start upload of %s: %w", u.key, err)
+		}
+		u.uploadID = uploadID
+	}
+
+	part, err := u.client.UploadPart(u.key, u.uploadID, len(u.parts)+1, u.buffer)
+	if err != nil {
+		return fmt.Errorf("failed to upload part %d of %s: %w", len(u.parts)+1, u.key, err)
+	}
+	u.parts = append(u.parts, part)
+	u.buffer = u.buffer[:0]
+	return nil
+}
+
+// Close uploads the remaining content and completes the object.
+// A failed upload is aborted so no orphan parts are left behind.
+func (u *s3Upload) Close() error {
+	if u.done {
+		return u.err
+	}
+	if u.err != nil {
+		u.Abort()
+		return u.err
+	}
+	u.done = true
+
+	if u.uploadID == "" {
+		u.err = u.client.PutObject(u.key, u.buffer)
+		return u.err
+	}
+
+	if len(u.buffer) > 0 {
+		if err := u.flushPart(); err != nil {
+			u.err = err
+			u.client.AbortMultipartUpload(u.key, u.uploadID)
+			return err
+		}
+	}
+	if err := u.client.CompleteMultipartUpload(u.key, u.uploadID, u.parts); err != nil {
+		u.err = fmt.Errorf("failed to complete upload of %s: %w", u.key, err)
+		u.client.AbortMultipartUpload(u.key, u.uploadID)
+		return u.err
+	}
+	return nil
+}
+
+// Abort discards the upload and any parts already stored.
+func (u *s3Upload) Abort() error {
+	if u.done && u.err == nil {
+		return nil
+	}
+	u.done = true
+	if u.err == nil {
+		u.err = errors.New("upload aborted")
+	}
+	if u.uploadID == "" {
+		return nil
+	}
+	return u.client.AbortMultipartUpload(u.key, u.uploadID)
+}
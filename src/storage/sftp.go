@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpDialTimeout bounds establishing the SSH connection
+const sftpDialTimeout = 30 * time.Second
+
+// SFTPConfig configures access to an SFTP server.
+// At least one of Password and KeyFile must be set.
+type SFTPConfig struct {
+	// User logs in, overridden by the user of an sftp://user@host/path URL
+	User     string
+	Password string
+	// KeyFile is an unencrypted private key, e.g. ~/.ssh/id_ed25519
+	KeyFile string
+	// KnownHostsFile verifies the server host key, e.g. ~/.ssh/known_hosts
+	KnownHostsFile string
+}
+
+// SFTP stores archives below a directory of an SFTP server
+type SFTP struct {
+	ssh    *ssh.Client
+	client *sftp.Client
+	host   string
+	root   string
+}
+
+// NewSFTP connects to host (host:port, port 22 by default) and returns
+// a Storage for the directory root on it. Call Close to disconnect.
+func NewSFTP(config SFTPConfig, host, root string) (*SFTP, error) {
+	if host == "" {
+		return nil, fmt.Errorf("sftp host cannot be empty")
+	}
+	if config.User == "" {
+		return nil, fmt.Errorf("sftp user cannot be empty")
+	}
+	if config.KnownHostsFile == "" {
+		return nil, fmt.Errorf("sftp known hosts file cannot be empty")
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "22")
+	}
+
+	hostKeyCallback, err := knownhosts.New(config.KnownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known hosts: %w", err)
+	}
+	auth, err := sftpAuthMethods(config)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := ssh.Dial("tcp", host, &ssh.ClientConfig{
+		User:            config.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sftpDialTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", host, err)
+	}
+	client, err := sftp.NewClient(conn, sftp.UseConcurrentWrites(true))
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start sftp session on %s: %w", host, err)
+	}
+
+	root = path.Clean("/" + strings.Trim(root, "/"))
+	return &SFTP{ssh: conn, client: client, host: host, root: root}, nil
+}
+
+// sftpAuthMethods returns the SSH authentication methods available in config
+func sftpAuthMethods(config SFTPConfig) ([]ssh.AuthMethod, error) {
+	var auth []ssh.AuthMethod
+	if config.KeyFile != "" {
+		key, err := os.ReadFile(config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse key file: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if config.Password != "" {
+		auth = append(auth, ssh.Password(config.Password))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("sftp password or key file cannot be empty")
+	}
+	return auth, nil
+}
+
+func (s *SFTP) String() string {
+	return "sftp://" + s.host + s.root
+}
+
+// Close ends the SFTP session and the SSH connection.
+func (s *SFTP) Close() error {
+	s.client.Close()
+	return s.ssh.Close()
+}
+
+func (s *SFTP) path(name string) string {
+	return path.Join(s.root, name)
+}
+
+// List walks dir without following symbolic links. Fails if dir does not exist.
+func (s *SFTP) List(dir string) ([]File, error) {
+	start := s.path(dir)
+	var files []File
+	walker := s.client.Walk(start)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", start, err)
+		}
+		info := walker.Stat()
+		if walker.Path() == start || (!info.IsDir() && isTempName(info.Name())) {
+			continue
+		}
+		name := strings.TrimPrefix(walker.Path(), strings.TrimSuffix(s.root, "/")+"/")
+		files = append(files, fileFromInfo(name, info))
+	}
+	return files, nil
+}
+
+// Stat follows symbolic links.
+func (s *SFTP) Stat(name string) (*File, error) {
+	info, err := s.client.Stat(s.path(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to stat %s: %w", name, err)
+	}
+	file := fileFromInfo(name, info)
+	return &file, nil
+}
+
+func (s *SFTP) Open(name string) (io.ReadCloser, error) {
+	file, err := s.client.Open(s.path(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	return file, nil
+}
+
+// Create streams to a temporary file next to name, renamed into place on Close,
+// so an interrupted run never leaves a partial archive under the final name.
+func (s *SFTP) Create(name string) (Writer, error) {
+	filePath := s.path(name)
+	if err := s.client.MkdirAll(path.Dir(filePath)); err != nil {
+		return nil, fmt.Errorf("failed to create directory for %s: %w", name, err)
+	}
+
+	tempPath := path.Join(path.Dir(filePath),
+		"."+path.Base(filePath)+"."+strconv.FormatInt(time.Now().UnixNano(), 36)+tempSuffix)
+	file, err := s.client.Create(tempPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	return &sftpWriter{File: file, storage: s, tempPath: tempPath, path: filePath}, nil
+}
+
+func (s *SFTP) Remove(name string) error {
+	filePath := s.path(name)
+	if err := s.client.Remove(filePath); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", filePath, err)
+	}
+	s.removeEmptyParents(path.Dir(filePath))
+	return nil
+}
+
+func (s *SFTP) Rename(oldName, newName string) error {
+	oldPath, newPath := s.path(oldName), s.path(newName)
+	if err := s.client.MkdirAll(path.Dir(newPath)); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", newName, err)
+	}
+	if err := s.rename(oldPath, newPath); err != nil {
+		return fmt.Errorf("failed to move %s: %w", oldPath, err)
+	}
+	s.removeEmptyParents(path.Dir(oldPath))
+	return nil
+}
+
+// rename replaces newPath atomically when the server supports the
+// posix-rename extension, falling back to remove and rename otherwise.
+func (s *SFTP) rename(oldPath, newPath string) error {
+	err := s.client.PosixRename(oldPath, newPath)
+	if err == nil {
+		return nil
+	}
+	if _, statErr := s.client.Stat(newPath); statErr == nil {
+		if err := s.client.Remove(newPath); err != nil {
+			return err
+		}
+	}
+	return s.client.Rename(oldPath, newPath)
+}
+
+// removeEmptyParents removes dir and its parents while they are empty,
+// stopping at root. Failures are ignored, a leftover directory is harmless.
+func (s *SFTP) removeEmptyParents(dir string) {
+	for dir != s.root && strings.HasPrefix(dir, s.root) {
+		if err := s.client.RemoveDirectory(dir); err != nil {
+			return
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+type sftpWriter struct {
+	*sftp.File
+	storage  *SFTP
+	tempPath string
+	path     string
+}
+
+func (w *sftpWriter) Close() error {
+	if err := w.File.Close(); err != nil {
+		w.storage.client.Remove(w.tempPath)
+		return err
+	}
+	if err := w.storage.rename(w.tempPath, w.path); err != nil {
+		w.storage.client.Remove(w.tempPath)
+		return fmt.Errorf("failed to move %s into place: %w", w.path, err)
+	}
+	return nil
+}
+
+func (w *sftpWriter) Abort() error {
+	w.File.Close()
+	return w.storage.client.Remove(w.tempPath)
+}
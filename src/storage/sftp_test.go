@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/testutil"
+)
+
+func newTestSFTP(t *testing.T) (*SFTP, string) {
+	t.Helper()
+	server, err := testutil.NewSFTPServer(t.TempDir(), "user", "secret")
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	t.Cleanup(server.Close)
+
+	root := t.TempDir()
+	store, err := Open("sftp://user@"+server.Addr+root, Config{SFTP: SFTPConfig{
+		Password:       "secret",
+		KnownHostsFile: server.KnownHosts,
+	}})
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	sftpStore := store.(*SFTP)
+	t.Cleanup(func() { sftpStore.Close() })
+	return sftpStore, root
+}
+
+func TestSFTPRoundTrip(t *testing.T) {
+	store, root := newTestSFTP(t)
+	testRoundTrip(t, store)
+
+	if _, err := os.Stat(filepath.Join(root, "a")); !os.IsNotExist(err) {
+		t.Error("Expected empty directories to be removed")
+	}
+}
+
+func TestSFTPAbort(t *testing.T) {
+	store, root := newTestSFTP(t)
+
+	writer, err := store.Create("movie.mkv.tar.gz")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	writer.Write([]byte("partial"))
+	if err := writer.Abort(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatalf("Failed to read root: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected no files after abort, got %d", len(entries))
+	}
+}
+
+func TestSFTPRejectsUnknownHost(t *testing.T) {
+	server, err := testutil.NewSFTPServer(t.TempDir(), "user", "secret")
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Close()
+
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(knownHosts, nil, 0o600); err != nil {
+		t.Fatalf("Failed to write known hosts: %v", err)
+	}
+	_, err = Open("sftp://user@"+server.Addr+"/backups", Config{SFTP: SFTPConfig{
+		Password:       "secret",
+		KnownHostsFile: knownHosts,
+	}})
+	if err == nil {
+		t.Error("Expected error for unknown host key")
+	}
+}
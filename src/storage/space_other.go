@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+
+package storage
+
+import "errors"
+
+// FreeSpace is unknown on this platform
+func (s *Local) FreeSpace() (int64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd
+
+package storage
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// FreeSpace returns the bytes available to unprivileged users on the
+// file system holding the root
+func (s *Local) FreeSpace() (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(s.root, &stat); err != nil {
+		return 0, fmt.Errorf("failed to get free space of %s: %w", s.root, err)
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
@@ -0,0 +1,217 @@
+// Package storage abstracts where files are read from and written to, so the
+// compression pipeline works the same on local disks, in memory and on remote stores.
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Storage keeps files under slash-separated names relative to its root,
+// e.g. "a/movie.mkv.tar.gz". The empty name is the root itself.
+type Storage interface {
+	// Stat returns the file or directory at name, or (nil, nil) if there is none
+	Stat(name string) (*File, error)
+	// List returns every file and directory below dir ("" for the root) in
+	// lexical order. Stores without directories only return files.
+	// Fails if dir does not exist.
+	List(dir string) ([]File, error)
+	// Open opens name for reading
+	Open(name string) (io.ReadCloser, error)
+	// Create opens name for writing. Content becomes visible on Close,
+	// Abort discards it
+	Create(name string) (Writer, error)
+	// Remove deletes name
+	Remove(name string) error
+	// Rename moves oldName to newName, replacing newName if it exists
+	Rename(oldName, newName string) error
+	// String describes the storage in messages
+	String() string
+}
+
+// SpaceReporter is implemented by stores that know how much space is left
+// for new files. FreeSpace fails with errors.ErrUnsupported where unknown.
+type SpaceReporter interface {
+	// FreeSpace returns the number of bytes available to new files
+	FreeSpace() (int64, error)
+}
+
+// AtomicCreator is implemented by stores whose Create leaves a previous
+// file untouched until Close succeeds and then replaces it at once, where
+// writing under the final name is as safe as staging and renaming and
+// cheaper, e.g. S3, on which Rename copies the whole object.
+type AtomicCreator interface {
+	// CreatesAtomically reports whether Close replaces the file atomically
+	CreatesAtomically() bool
+}
+
+// Writer receives the content of a file being created in a Storage
+type Writer interface {
+	io.WriteCloser
+	// Abort discards everything written so far
+	Abort() error
+}
+
+// File describes a file or directory kept in a Storage
+type File struct {
+	Name    string
+	Size    int64
+	Mode    fs.FileMode
+	ModTime time.Time
+	IsDir   bool
+	// Link is the target of a symbolic link (Mode has fs.ModeSymlink),
+	// on stores reporting links
+	Link string
+	// ID identifies the underlying file on stores with hard links, e.g. its
+	// device and inode, so names with the same ID are links to one file.
+	// Empty if unknown.
+	ID string
+	// Links is the number of hard links to the file, 0 if unknown
+	Links int
+}
+
+// Info returns the file as fs.FileInfo, named after the last element of Name
+func (f File) Info() fs.FileInfo {
+	return fileInfo{f}
+}
+
+type fileInfo struct {
+	file File
+}
+
+func (i fileInfo) Name() string       { return path.Base(i.file.Name) }
+func (i fileInfo) Size() int64        { return i.file.Size }
+func (i fileInfo) Mode() fs.FileMode  { return i.file.Mode }
+func (i fileInfo) ModTime() time.Time { return i.file.ModTime }
+func (i fileInfo) IsDir() bool        { return i.file.IsDir }
+func (i fileInfo) Sys() any           { return nil }
+
+// Config holds settings for remote storages
+type Config struct {
+	// S3 is used for s3://bucket/prefix locations; Bucket is taken from the URL
+	S3 S3Config
+	// SFTP is used for sftp://[user@]host[:port]/path locations
+	SFTP SFTPConfig
+	// WebDAV is used for webdav:// and webdavs:// locations; URL is taken from the location
+	WebDAV WebDAVConfig
+}
+
+// Open returns the Storage for a location:
+// - s3://bucket/prefix for an S3-compatible object store
+// - sftp://[user@]host[:port]/path for a directory on an SFTP server
+// - webdav://host/path (http) or webdavs://host/path (https) for a WebDAV collection
+// - any other value is a local directory
+func Open(location string, config Config) (Storage, error) {
+	if location == "" {
+		return nil, fmt.Errorf("target path cannot be empty")
+	}
+
+	scheme, _, found := strings.Cut(location, "://")
+	if !found {
+		return NewLocal(location), nil
+	}
+
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target %q: %w", location, err)
+	}
+
+	switch scheme {
+	case "s3":
+		s3Config := config.S3
+		s3Config.Bucket = parsed.Host
+		return NewS3(s3Config, parsed.Path)
+	case "sftp":
+		sftpConfig := config.SFTP
+		if parsed.User != nil {
+			sftpConfig.User = parsed.User.Username()
+		}
+		return NewSFTP(sftpConfig, parsed.Host, parsed.Path)
+	case "webdav", "webdavs":
+		webdavConfig := config.WebDAV
+		if parsed.User != nil {
+			webdavConfig.Username = parsed.User.Username()
+			parsed.User = nil
+		}
+		parsed.Scheme = "http"
+		if scheme == "webdavs" {
+			parsed.Scheme = "https"
+		}
+		webdavConfig.URL = parsed.String()
+		return NewWebDAV(webdavConfig)
+	default:
+		return nil, fmt.Errorf("unsupported target scheme %q", scheme)
+	}
+}
+
+// WriteFile stores data at name in one go
+func WriteFile(store Storage, name string, data []byte) error {
+	writer, err := store.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(data); err != nil {
+		writer.Abort()
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// ReadFile returns the content of name
+func ReadFile(store Storage, name string) ([]byte, error) {
+	reader, err := store.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return data, nil
+}
+
+// Files returns the files of list, leaving out directories
+func Files(list []File) []File {
+	files := make([]File, 0, len(list))
+	for _, file := range list {
+		if !file.IsDir {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// sortFiles orders files like a directory walk: "a", "a/b", "a.txt"
+func sortFiles(files []File) {
+	sort.Slice(files, func(i, j int) bool {
+		return strings.ReplaceAll(files[i].Name, "/", "\x00") < strings.ReplaceAll(files[j].Name, "/", "\x00")
+	})
+}
+
+// tempSuffix marks files being written by Create
+const tempSuffix = ".tmp"
+
+// isTempName reports whether a base name is an in-progress upload
+func isTempName(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, tempSuffix)
+}
+
+// dirPrefix returns the name prefix of entries below dir ("" for the root)
+func dirPrefix(dir string) string {
+	dir = strings.Trim(dir, "/")
+	if dir == "" {
+		return ""
+	}
+	return dir + "/"
+}
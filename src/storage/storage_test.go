@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testRoundTrip checks the behavior every Storage shares: content becomes
+// visible on Close, is listed with its parent directories, can be read
+// back, renamed and removed.
+func testRoundTrip(t *testing.T, store Storage) {
+	t.Helper()
+
+	if err := WriteFile(store, "a/b/movie.mkv.tar.gz", []byte("archive")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	file, err := store.Stat("a/b/movie.mkv.tar.gz")
+	if err != nil || file == nil {
+		t.Fatalf("Expected file, got %v, %v", file, err)
+	}
+	if file.Size != int64(len("archive")) || file.IsDir {
+		t.Errorf("Expected regular file of 7 bytes, got %+v", file)
+	}
+	if missing, err := store.Stat("none.tar.gz"); err != nil || missing != nil {
+		t.Errorf("Expected nil for missing file, got %v, %v", missing, err)
+	}
+
+	list, err := store.List("")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	files := Files(list)
+	if len(files) != 1 || files[0].Name != "a/b/movie.mkv.tar.gz" {
+		t.Errorf("Expected one listed file, got %v", list)
+	}
+	if sub, err := store.List("a/b"); err != nil || len(Files(sub)) != 1 {
+		t.Errorf("Expected one file below a/b, got %v, %v", sub, err)
+	}
+
+	data, err := ReadFile(store, "a/b/movie.mkv.tar.gz")
+	if err != nil || string(data) != "archive" {
+		t.Errorf("Expected content \"archive\", got %q, %v", data, err)
+	}
+
+	if err := store.Rename("a/b/movie.mkv.tar.gz", "c/movie.mkv.tar.gz"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if moved, _ := store.Stat("c/movie.mkv.tar.gz"); moved == nil {
+		t.Error("Expected renamed file")
+	}
+
+	writer, err := store.Create("c/partial.tar.gz")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	io.WriteString(writer, "partial")
+	if err := writer.Abort(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if err := store.Remove("c/movie.mkv.tar.gz"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	list, err = store.List("")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if files := Files(list); len(files) != 0 {
+		t.Errorf("Expected no files left, got %v", files)
+	}
+}
+
+func TestLocalRoundTrip(t *testing.T) {
+	testRoundTrip(t, NewLocal(t.TempDir()))
+}
+
+func TestMemoryRoundTrip(t *testing.T) {
+	testRoundTrip(t, NewMemory())
+}
+
+func TestOpenSchemes(t *testing.T) {
+	store, err := Open("/backups", Config{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, ok := store.(*Local); !ok {
+		t.Errorf("Expected local storage, got %T", store)
+	}
+
+	if _, err := Open("ftp://host/path", Config{}); err == nil {
+		t.Error("Expected error for unsupported scheme")
+	}
+	if _, err := Open("", Config{}); err == nil {
+		t.Error("Expected error for empty target")
+	}
+}
+
+func writeLocalFile(t *testing.T, root, name, content string) {
+	t.Helper()
+	path := filepath.Join(root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+}
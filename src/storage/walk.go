@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+)
+
+// WalkOptions narrows the files returned by Walk
+type WalkOptions struct {
+	// Skip, if set, leaves out the files and directories it returns true
+	// for, with names as in File.Name. Skipped directories are not entered.
+	Skip func(name string, isDir bool) bool
+	// MaxDepth, if positive, limits the walk to this many levels below dir:
+	// 1 only returns the entries directly in it
+	MaxDepth int
+	// IgnorePermissionErrors skips directories that cannot be read instead
+	// of failing the walk
+	IgnorePermissionErrors bool
+	// NamesOnly only fills Name, IsDir and the type bits of Mode, sparing
+	// local storages a stat per entry
+	NamesOnly bool
+}
+
+// Walker is implemented by storages that walk a directory more cheaply with
+// WalkOptions than by listing all of it
+type Walker interface {
+	Walk(ctx context.Context, dir string, opts WalkOptions) ([]File, error)
+}
+
+// Walk returns the files below dir in store as List does, narrowed by opts.
+// The walk stops with ctx's error once ctx is done. Storages that are not
+// a Walker are listed in full and filtered.
+func Walk(ctx context.Context, store Storage, dir string, opts WalkOptions) ([]File, error) {
+	if walker, ok := store.(Walker); ok {
+		return walker.Walk(ctx, dir, opts)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	files, err := store.List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	depth := pathDepth(dir)
+	var kept []File
+	var skipped []string
+	for _, file := range files {
+		if opts.MaxDepth > 0 && pathDepth(file.Name)-depth > opts.MaxDepth {
+			continue
+		}
+		if isBelowAny(file.Name, skipped) {
+			continue
+		}
+		if opts.Skip != nil && opts.Skip(file.Name, file.IsDir) {
+			if file.IsDir {
+				skipped = append(skipped, file.Name)
+			}
+			continue
+		}
+		kept = append(kept, file)
+	}
+	return kept, nil
+}
+
+// walkFS walks dir in fsys as Walk does, with names relative to the root of
+// fsys. Files being written by Create are left out. Symbolic links are not
+// followed below dir, they are reported with their target in Link, read
+// with readLink.
+func walkFS(ctx context.Context, fsys fs.FS, readLink func(name string) (string, error), dir string,
+	opts WalkOptions) ([]File, error) {
+	start := strings.Trim(dir, "/")
+	if start == "" {
+		start = "."
+	}
+	depth := pathDepth(dir)
+
+	var files []File
+	err := fs.WalkDir(fsys, start, func(name string, entry fs.DirEntry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			if name == start || !opts.IgnorePermissionErrors || !errors.Is(err, fs.ErrPermission) {
+				return err
+			}
+			if entry != nil && entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if name == start || (!entry.IsDir() && isTempName(entry.Name())) {
+			return nil
+		}
+
+		isDir := entry.IsDir()
+		if opts.Skip != nil && opts.Skip(name, isDir) {
+			if isDir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		file := File{Name: name, Mode: entry.Type(), IsDir: isDir}
+		if !opts.NamesOnly {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			file = fileFromInfo(name, info)
+			if info.Mode()&fs.ModeSymlink != 0 {
+				if file.Link, err = readLink(name); err != nil {
+					return err
+				}
+			}
+		}
+		files = append(files, file)
+
+		// Entries in the directory are one level deeper
+		if isDir && opts.MaxDepth > 0 && pathDepth(name)-depth >= opts.MaxDepth {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// pathDepth returns how many levels below the root name is, 0 for the root
+func pathDepth(name string) int {
+	name = strings.Trim(name, "/")
+	if name == "" || name == "." {
+		return 0
+	}
+	return strings.Count(name, "/") + 1
+}
+
+// isBelowAny reports whether name is inside one of dirs
+func isBelowAny(name string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// names returns the names of files
+func names(files []File) []string {
+	var result []string
+	for _, file := range files {
+		result = append(result, file.Name)
+	}
+	return result
+}
+
+func TestWalkSkipsAndLimitsDepth(t *testing.T) {
+	local := NewLocal(t.TempDir())
+	memory := NewMemory()
+	for _, name := range []string{"a/movie.mkv", "a/sample/sample.mkv", "a/b/c/deep.mkv", "top.mkv"} {
+		if err := WriteFile(local, name, []byte("content")); err != nil {
+			t.Fatal(err)
+		}
+		memory.WriteFile(name, []byte("content"), time.Now())
+	}
+
+	opts := WalkOptions{
+		Skip:      func(name string, isDir bool) bool { return name == "a/sample" },
+		MaxDepth:  2,
+		NamesOnly: true,
+	}
+	expected := []string{"a", "a/b", "a/movie.mkv", "top.mkv"}
+	for _, store := range []Storage{local, memory} {
+		files, err := Walk(context.Background(), store, "", opts)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", store, err)
+		}
+		if got := names(files); !slices.Equal(got, expected) {
+			t.Errorf("%s: expected %v, got %v", store, expected, got)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Walk(ctx, local, "", WalkOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+// deniedFS fails to read the directory locked
+type deniedFS struct {
+	fstest.MapFS
+}
+
+func (f deniedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name == "locked" {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrPermission}
+	}
+	return f.MapFS.ReadDir(name)
+}
+
+func TestWalkPermissionErrors(t *testing.T) {
+	fsys := deniedFS{fstest.MapFS{
+		"a/movie.mkv":      {},
+		"locked/movie.mkv": {},
+		"z/other.mkv":      {},
+	}}
+	opts := WalkOptions{NamesOnly: true}
+	if _, err := walkFS(context.Background(), fsys, nil, "", opts); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("Expected permission error, got %v", err)
+	}
+
+	opts.IgnorePermissionErrors = true
+	files, err := walkFS(context.Background(), fsys, nil, "", opts)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got, expected := names(Files(files)), []string{"a/movie.mkv", "z/other.mkv"}; !slices.Equal(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
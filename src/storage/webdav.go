@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"time"
+)
+
+// WebDAV stores files below a collection of a WebDAV server
+type WebDAV struct {
+	client *webdavClient
+	url    string
+}
+
+// NewWebDAV returns a Storage for the collection at config.URL.
+func NewWebDAV(config WebDAVConfig) (*WebDAV, error) {
+	dav, err := newWebDAVClient(config)
+	if err != nil {
+		return nil, err
+	}
+	return &WebDAV{client: dav, url: config.URL}, nil
+}
+
+func (s *WebDAV) String() string {
+	return s.url
+}
+
+// List walks the collection dir. Fails if it does not exist.
+func (s *WebDAV) List(dir string) ([]File, error) {
+	resources, err := s.client.Walk(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", s, err)
+	}
+
+	files := make([]File, 0, len(resources))
+	for _, resource := range resources {
+		if !resource.IsDir && isTempName(path.Base(resource.Path)) {
+			continue
+		}
+		files = append(files, fileFromResource(resource))
+	}
+	sortFiles(files)
+	return files, nil
+}
+
+func fileFromResource(resource webdavResource) File {
+	file := File{
+		Name:    resource.Path,
+		Size:    resource.Size,
+		Mode:    0o644,
+		ModTime: resource.ModTime,
+		IsDir:   resource.IsDir,
+	}
+	if resource.IsDir {
+		file.Mode = fs.ModeDir | 0o755
+	}
+	return file
+}
+
+func (s *WebDAV) Stat(name string) (*File, error) {
+	resource, err := s.client.Stat(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", name, err)
+	}
+	if resource == nil {
+		return nil, nil
+	}
+	file := fileFromResource(*resource)
+	file.Name = name
+	return &file, nil
+}
+
+func (s *WebDAV) Open(name string) (io.ReadCloser, error) {
+	body, err := s.client.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	return body, nil
+}
+
+// Create streams the content with a single chunked PUT to a temporary name,
+// moved into place on Close, so no local copy of the archive is staged.
+func (s *WebDAV) Create(name string) (Writer, error) {
+	if err := s.client.MkdirAll(path.Dir(name)); err != nil {
+		return nil, fmt.Errorf("failed to create directory for %s: %w", name, err)
+	}
+
+	tempName := path.Join(path.Dir(name),
+		"."+path.Base(name)+"."+strconv.FormatInt(time.Now().UnixNano(), 36)+tempSuffix)
+	reader, writer := io.Pipe()
+	writerDone := make(chan error, 1)
+	go func() {
+		err := s.client.Put(tempName, reader)
+		// Unblock the writer if the request failed before reading everything
+		reader.CloseWithError(err)
+		writerDone <- err
+	}()
+
+	return &webdavWriter{
+		PipeWriter: writer,
+		done:       writerDone,
+		finish: func() error {
+			return s.client.Move(tempName, name)
+		},
+		discard: func() {
+			s.client.Delete(tempName)
+		},
+	}, nil
+}
+
+func (s *WebDAV) Remove(name string) error {
+	if err := s.client.Delete(name); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", name, err)
+	}
+	return nil
+}
+
+func (s *WebDAV) Rename(oldName, newName string) error {
+	if err := s.client.MkdirAll(path.Dir(newName)); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", newName, err)
+	}
+	if err := s.client.Move(oldName, newName); err != nil {
+		return fmt.Errorf("failed to move %s: %w", oldName, err)
+	}
+	return nil
+}
+
+// webdavWriter feeds a PUT request running in the background
+type webdavWriter struct {
+	*io.PipeWriter
+	done    chan error
+	finish  func() error
+	discard func()
+}
+
+func (w *webdavWriter) Close() error {
+	w.PipeWriter.Close()
+	if err := <-w.done; err != nil {
+		w.discard()
+		return fmt.Errorf("failed to upload: %w", err)
+	}
+	if err := w.finish(); err != nil {
+		w.discard()
+		return fmt.Errorf("failed to move upload into place: %w", err)
+	}
+	return nil
+}
+
+func (w *webdavWriter) Abort() error {
+	w.PipeWriter.CloseWithError(fmt.Errorf("upload aborted"))
+	<-w.done
+	w.discard()
+	return nil
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/testutil"
+)
+
+func newTestWebDAV(t *testing.T) (Storage, *testutil.WebDAVServer) {
+	t.Helper()
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "movies"), 0o755); err != nil {
+		t.Fatalf("Failed to create collection: %v", err)
+	}
+	server := testutil.NewWebDAVServer(root, "user", "secret")
+	t.Cleanup(server.Close)
+
+	location := "webdav://user@" + strings.TrimPrefix(server.URL, "http://") + "/movies"
+	store, err := Open(location, Config{WebDAV: WebDAVConfig{Password: "secret"}})
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	return store, server
+}
+
+func TestWebDAVRoundTrip(t *testing.T) {
+	store, _ := newTestWebDAV(t)
+	testRoundTrip(t, store)
+}
+
+func TestWebDAVAbort(t *testing.T) {
+	store, server := newTestWebDAV(t)
+
+	writer, err := store.Create("a/movie.mkv.tar.gz")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	writer.Write([]byte("partial"))
+	if err := writer.Abort(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	files, err := store.List("")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(Files(files)) != 0 {
+		t.Errorf("Expected no files after abort, got %v", files)
+	}
+	entries, _ := os.ReadDir(filepath.Join(server.Root, "movies", "a"))
+	if len(entries) != 0 {
+		t.Errorf("Expected no temporary upload left, got %d entries", len(entries))
+	}
+}
+
+func TestWebDAVRejectsBadCredentials(t *testing.T) {
+	server := testutil.NewWebDAVServer(t.TempDir(), "user", "secret")
+	defer server.Close()
+
+	location := "webdav://" + strings.TrimPrefix(server.URL, "http://")
+	target, err := Open(location, Config{WebDAV: WebDAVConfig{Username: "user", Password: "wrong"}})
+	if err != nil {
+		t.Fatalf("Failed to open target: %v", err)
+	}
+	if _, err := target.List(""); err == nil || !strings.Contains(err.Error(), "HTTP 401") {
+		t.Errorf("Expected HTTP 401 error, got %v", err)
+	}
+}
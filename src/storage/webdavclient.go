@@ -0,0 +1,281 @@
+package storage
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// webdavPropfindBody requests only the properties used by the client
+const webdavPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop><resourcetype/><getcontentlength/><getlastmodified/></prop></propfind>`
+
+// WebDAVConfig configures access to a WebDAV server
+type WebDAVConfig struct {
+	// URL is the collection used as root, e.g. https://nas.local/dav/movies
+	URL      string
+	Username string
+	Password string
+}
+
+// webdavResource describes a file or collection on the server
+type webdavResource struct {
+	// Path is slash-separated and relative to the client root
+	Path    string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+}
+
+// WebDAVError is returned for non-2xx responses
+type WebDAVError struct {
+	StatusCode int
+}
+
+func (e *WebDAVError) Error() string {
+	return fmt.Sprintf("HTTP %d", e.StatusCode)
+}
+
+// webdavClient is a minimal WebDAV client for storing files below a root collection
+type webdavClient struct {
+	config WebDAVConfig
+	root   *url.URL
+}
+
+// newWebDAVClient validates config and returns a client for its URL.
+func newWebDAVClient(config WebDAVConfig) (*webdavClient, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("webdav url cannot be empty")
+	}
+	root, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webdav url %q: %w", config.URL, err)
+	}
+	if root.Scheme != "http" && root.Scheme != "https" {
+		return nil, fmt.Errorf("invalid webdav url %q: scheme must be http or https", config.URL)
+	}
+	root.Path = strings.TrimSuffix(root.Path, "/") + "/"
+	return &webdavClient{config: config, root: root}, nil
+}
+
+// resourceURL returns the URL of name relative to the root
+func (c *webdavClient) resourceURL(name string) string {
+	location := *c.root
+	location.Path = c.root.Path + strings.TrimPrefix(name, "/")
+	return location.String()
+}
+
+type webdavMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength string `xml:"getcontentlength"`
+				LastModified  string `xml:"getlastmodified"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// PropFind returns the resource at name and, with depth 1, its direct children.
+func (c *webdavClient) PropFind(name string, depth int) ([]webdavResource, error) {
+	resp, err := c.do("PROPFIND", name, strings.NewReader(webdavPropfindBody), map[string]string{
+		"Depth":        strconv.Itoa(depth),
+		"Content-Type": "application/xml; charset=utf-8",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result webdavMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode PROPFIND response: %w", err)
+	}
+
+	resources := make([]webdavResource, 0, len(result.Responses))
+	for _, response := range result.Responses {
+		resourcePath, err := c.relativePath(response.Href)
+		if err != nil {
+			return nil, err
+		}
+		resource := webdavResource{Path: resourcePath}
+		for _, propstat := range response.Propstat {
+			if !strings.Contains(propstat.Status, " 200 ") {
+				continue
+			}
+			prop := propstat.Prop
+			resource.IsDir = prop.ResourceType.Collection != nil
+			if prop.ContentLength != "" {
+				resource.Size, _ = strconv.ParseInt(prop.ContentLength, 10, 64)
+			}
+			if prop.LastModified != "" {
+				resource.ModTime, _ = http.ParseTime(prop.LastModified)
+			}
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
+
+// relativePath converts a PROPFIND href to a path relative to the root
+func (c *webdavClient) relativePath(href string) (string, error) {
+	location, err := url.Parse(href)
+	if err != nil {
+		return "", fmt.Errorf("invalid href %q: %w", href, err)
+	}
+	cleaned := path.Clean("/" + location.Path)
+	rootPath := path.Clean(c.root.Path)
+	if cleaned == rootPath {
+		return "", nil
+	}
+	if rootPath != "/" && !strings.HasPrefix(cleaned, rootPath+"/") {
+		return "", fmt.Errorf("href %q is outside of %s", href, c.root.Path)
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(cleaned, rootPath), "/"), nil
+}
+
+// Stat returns the resource at name, or (nil, nil) if it does not exist.
+func (c *webdavClient) Stat(name string) (*webdavResource, error) {
+	resources, err := c.PropFind(name, 0)
+	if err != nil {
+		var statusErr *WebDAVError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(resources) == 0 {
+		return nil, fmt.Errorf("empty PROPFIND response for %s", name)
+	}
+	return &resources[0], nil
+}
+
+// Walk returns every file and collection below the collection dir, descending
+// one level per request since many servers refuse "Depth: infinity".
+func (c *webdavClient) Walk(dir string) ([]webdavResource, error) {
+	var all []webdavResource
+	pending := []string{strings.Trim(dir, "/")}
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = pending[1:]
+
+		collection := current
+		if collection != "" {
+			collection += "/"
+		}
+		resources, err := c.PropFind(collection, 1)
+		if err != nil {
+			return nil, err
+		}
+		for _, resource := range resources {
+			if resource.Path == current {
+				continue
+			}
+			if resource.IsDir {
+				pending = append(pending, resource.Path)
+			}
+			all = append(all, resource)
+		}
+	}
+	return all, nil
+}
+
+// Get opens the content of name for reading.
+func (c *webdavClient) Get(name string) (io.ReadCloser, error) {
+	resp, err := c.do("GET", name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Put stores body at name. The body is streamed with chunked encoding,
+// so its size does not need to be known in advance.
+func (c *webdavClient) Put(name string, body io.Reader) error {
+	return c.doAndClose("PUT", name, body, nil)
+}
+
+// Delete removes the resource at name.
+func (c *webdavClient) Delete(name string) error {
+	return c.doAndClose("DELETE", name, nil, nil)
+}
+
+// Move moves oldName to newName, replacing newName if it exists.
+func (c *webdavClient) Move(oldName, newName string) error {
+	return c.doAndClose("MOVE", oldName, nil, map[string]string{
+		"Destination": c.resourceURL(newName),
+		"Overwrite":   "T",
+	})
+}
+
+// MkdirAll creates the collection dir and any missing parents.
+func (c *webdavClient) MkdirAll(dir string) error {
+	dir = strings.Trim(dir, "/")
+	if dir == "" || dir == "." {
+		return nil
+	}
+	current := ""
+	for _, segment := range strings.Split(dir, "/") {
+		current += segment + "/"
+		err := c.doAndClose("MKCOL", current, nil, nil)
+		var statusErr *WebDAVError
+		// 405 Method Not Allowed means the collection already exists
+		if err != nil && !(errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusMethodNotAllowed) {
+			return fmt.Errorf("failed to create collection %s: %w", current, err)
+		}
+	}
+	return nil
+}
+
+func (c *webdavClient) doAndClose(method, name string, body io.Reader, headers map[string]string) error {
+	resp, err := c.do(method, name, body, headers)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+// do sends an authenticated request for name. GET and PUT carry file content
+// and a MOVE may copy it server-side, so they are sent without an overall
+// timeout, every other request with the timeout of controlClient.
+// Returns *WebDAVError if the status code is not 2xx.
+func (c *webdavClient) do(method, name string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.resourceURL(name), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	if c.config.Username != "" {
+		req.SetBasicAuth(c.config.Username, c.config.Password)
+	}
+
+	client := controlClient
+	if method == "GET" || method == "PUT" || method == "MOVE" {
+		client = transferClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return nil, &WebDAVError{StatusCode: resp.StatusCode}
+	}
+	return resp, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/storage"
+)
+
+// Remote target flag names
+const (
+	flagS3Endpoint     = "s3-endpoint"
+	flagS3Region       = "s3-region"
+	flagS3StorageClass = "s3-storage-class"
+	flagS3PartSize     = "s3-part-size"
+	flagSFTPKey        = "sftp-key"
+	flagSFTPKnownHosts = "sftp-known-hosts"
+)
+
+// targetFlags holds the settings for remote targets given on the command line.
+// Credentials are read from the environment to keep them out of process listings.
+type targetFlags struct {
+	s3Endpoint     *string
+	s3Region       *string
+	s3StorageClass *string
+	s3PartSizeMiB  *int64
+	sftpKey        *string
+	sftpKnownHosts *string
+}
+
+// registerTargetFlags adds the remote target flags to flags.
+func registerTargetFlags(flags *flag.FlagSet) *targetFlags {
+	return &targetFlags{
+		s3Endpoint:     flags.String(flagS3Endpoint, "", "S3 endpoint URL for s3://bucket/prefix targets"),
+		s3Region:       flags.String(flagS3Region, "us-east-1", "S3 region"),
+		s3StorageClass: flags.String(flagS3StorageClass, "", "S3 storage class, e.g. STANDARD_IA"),
+		s3PartSizeMiB:  flags.Int64(flagS3PartSize, storage.DefaultS3PartSize>>20, "S3 multipart part size in MiB"),
+		sftpKey:        flags.String(flagSFTPKey, "", "Private key file for sftp://user@host/path targets"),
+		sftpKnownHosts: flags.String(flagSFTPKnownHosts, defaultKnownHosts(), "known_hosts file verifying SFTP servers"),
+	}
+}
+
+// defaultKnownHosts returns ~/.ssh/known_hosts, or "" without a home directory
+func defaultKnownHosts() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
+
+// open returns the Target for target using the flag settings.
+// S3 credentials come from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY,
+// the SFTP password from SFTP_PASSWORD and WebDAV credentials from
+// WEBDAV_USERNAME and WEBDAV_PASSWORD. A user in the target URL takes precedence.
+func (f *targetFlags) open(target string) (storage.Storage, error) {
+	return storage.Open(target, storage.Config{
+		S3: storage.S3Config{
+			Endpoint:     *f.s3Endpoint,
+			Region:       *f.s3Region,
+			StorageClass: *f.s3StorageClass,
+			PartSize:     *f.s3PartSizeMiB << 20,
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		},
+		SFTP: storage.SFTPConfig{
+			User:           os.Getenv("USER"),
+			Password:       os.Getenv("SFTP_PASSWORD"),
+			KeyFile:        *f.sftpKey,
+			KnownHostsFile: *f.sftpKnownHosts,
+		},
+		WebDAV: storage.WebDAVConfig{
+			Username: os.Getenv("WEBDAV_USERNAME"),
+			Password: os.Getenv("WEBDAV_PASSWORD"),
+		},
+	})
+}
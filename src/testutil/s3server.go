@@ -0,0 +1,348 @@
+// Package testutil provides local stand-ins for remote services used in tests.
+package testutil
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3Object is an object kept by S3Server
+type S3Object struct {
+	Data         []byte
+	StorageClass string
+	ModTime      time.Time
+}
+
+// S3Server is an in-memory, MinIO-style stand-in for the subset of the S3 API
+// used by the client: list, head, get, put, copy, delete and multipart uploads.
+// It checks that requests are signed and that SHA-256 checksums match their content.
+type S3Server struct {
+	*httptest.Server
+	Bucket string
+
+	mu             sync.Mutex
+	objects        map[string]*S3Object
+	uploads        map[string]map[int][]byte
+	nextUpload     int
+	MultipartCount int
+}
+
+// NewS3Server starts a stand-in server for bucket. Close it when done.
+func NewS3Server(bucket string) *S3Server {
+	server := &S3Server{
+		Bucket:  bucket,
+		objects: map[string]*S3Object{},
+		uploads: map[string]map[int][]byte{},
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.handle))
+	return server
+}
+
+// Object returns the stored object at key, or nil.
+func (s *S3Server) Object(key string) *S3Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects[key]
+}
+
+// Keys returns the sorted keys of all stored objects.
+func (s *S3Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// PendingUploads returns the number of multipart uploads neither completed nor aborted.
+func (s *S3Server) PendingUploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.uploads)
+}
+
+func (s *S3Server) handle(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s3Fail(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if !s.authorized(r, body) {
+		s3Fail(w, http.StatusForbidden, "SignatureDoesNotMatch", "missing or invalid signature headers")
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != s.Bucket {
+		s3Fail(w, http.StatusNotFound, "NoSuchBucket", bucket)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := r.URL.Query()
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		s.list(w, query)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.createUpload(w)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		s.completeUpload(w, key, query.Get("uploadId"), body, r.Header)
+	case r.Method == http.MethodPut && query.Has("uploadId") && r.Header.Get("x-amz-copy-source") != "":
+		s.uploadPartCopy(w, query, r.Header)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		s.uploadPart(w, query, body, r.Header)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(s.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && r.Header.Get("x-amz-copy-source") != "":
+		s.copyObject(w, key, r.Header)
+	case r.Method == http.MethodPut:
+		s.putObject(w, key, body, r.Header)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		s.getObject(w, r.Method, key)
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+// authorized checks the signature headers are present and the payload hash matches.
+func (s *S3Server) authorized(r *http.Request, body []byte) bool {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=") {
+		return false
+	}
+	if r.Header.Get("x-amz-date") == "" {
+		return false
+	}
+	sum := sha256.Sum256(body)
+	return r.Header.Get("x-amz-content-sha256") == hex.EncodeToString(sum[:])
+}
+
+func (s *S3Server) list(w http.ResponseWriter, query url.Values) {
+	type content struct {
+		Key          string `xml:"Key"`
+		Size         int    `xml:"Size"`
+		LastModified string `xml:"LastModified"`
+	}
+	var result struct {
+		XMLName     xml.Name  `xml:"ListBucketResult"`
+		IsTruncated bool      `xml:"IsTruncated"`
+		Contents    []content `xml:"Contents"`
+	}
+
+	prefix := query.Get("prefix")
+	for key, object := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, content{
+				Key:          key,
+				Size:         len(object.Data),
+				LastModified: object.ModTime.UTC().Format(time.RFC3339),
+			})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	s3Write(w, result)
+}
+
+func (s *S3Server) putObject(w http.ResponseWriter, key string, body []byte, header http.Header) {
+	if !checksumMatches(body, header) {
+		s3Fail(w, http.StatusBadRequest, "BadDigest", "checksum mismatch")
+		return
+	}
+	s.objects[key] = &S3Object{
+		Data:         body,
+		StorageClass: header.Get("x-amz-storage-class"),
+		ModTime:      time.Now(),
+	}
+	w.Header().Set("ETag", etag(body))
+}
+
+func (s *S3Server) copyObject(w http.ResponseWriter, key string, header http.Header) {
+	source, err := url.PathUnescape(header.Get("x-amz-copy-source"))
+	if err != nil {
+		s3Fail(w, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+	source = strings.TrimPrefix(strings.TrimPrefix(source, "/"), s.Bucket+"/")
+	object, ok := s.objects[source]
+	if !ok {
+		s3Fail(w, http.StatusNotFound, "NoSuchKey", source)
+		return
+	}
+	s.objects[key] = &S3Object{
+		Data:         object.Data,
+		StorageClass: header.Get("x-amz-storage-class"),
+		ModTime:      time.Now(),
+	}
+	s3Write(w, struct {
+		XMLName xml.Name `xml:"CopyObjectResult"`
+	}{})
+}
+
+func (s *S3Server) getObject(w http.ResponseWriter, method, key string) {
+	object, ok := s.objects[key]
+	if !ok {
+		s3Fail(w, http.StatusNotFound, "NoSuchKey", key)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(object.Data)))
+	w.Header().Set("Last-Modified", object.ModTime.UTC().Format(http.TimeFormat))
+	if method == http.MethodGet {
+		w.Write(object.Data)
+	}
+}
+
+func (s *S3Server) createUpload(w http.ResponseWriter) {
+	s.nextUpload++
+	uploadID := fmt.Sprintf("upload-%d", s.nextUpload)
+	s.uploads[uploadID] = map[int][]byte{}
+	s3Write(w, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		UploadID string   `xml:"UploadId"`
+	}{UploadID: uploadID})
+}
+
+func (s *S3Server) uploadPart(w http.ResponseWriter, query url.Values, body []byte, header http.Header) {
+	parts, ok := s.uploads[query.Get("uploadId")]
+	if !ok {
+		s3Fail(w, http.StatusNotFound, "NoSuchUpload", query.Get("uploadId"))
+		return
+	}
+	if header.Get("x-amz-checksum-sha256") == "" || !checksumMatches(body, header) {
+		s3Fail(w, http.StatusBadRequest, "BadDigest", "part checksum missing or mismatched")
+		return
+	}
+	number, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil {
+		s3Fail(w, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+	parts[number] = body
+	w.Header().Set("ETag", etag(body))
+}
+
+func (s *S3Server) uploadPartCopy(w http.ResponseWriter, query url.Values, header http.Header) {
+	parts, ok := s.uploads[query.Get("uploadId")]
+	if !ok {
+		s3Fail(w, http.StatusNotFound, "NoSuchUpload", query.Get("uploadId"))
+		return
+	}
+	source, err := url.PathUnescape(header.Get("x-amz-copy-source"))
+	if err != nil {
+		s3Fail(w, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+	object, ok := s.objects[strings.TrimPrefix(strings.TrimPrefix(source, "/"), s.Bucket+"/")]
+	if !ok {
+		s3Fail(w, http.StatusNotFound, "NoSuchKey", source)
+		return
+	}
+
+	var first, last int
+	if _, err := fmt.Sscanf(header.Get("x-amz-copy-source-range"), "bytes=%d-%d", &first, &last); err != nil ||
+		first > last || last >= len(object.Data) {
+		s3Fail(w, http.StatusBadRequest, "InvalidRange", header.Get("x-amz-copy-source-range"))
+		return
+	}
+	number, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil {
+		s3Fail(w, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+
+	content := object.Data[first : last+1]
+	parts[number] = content
+	sum := sha256.Sum256(content)
+	s3Write(w, struct {
+		XMLName        xml.Name `xml:"CopyPartResult"`
+		ETag           string   `xml:"ETag"`
+		ChecksumSHA256 string   `xml:"ChecksumSHA256"`
+	}{ETag: etag(content), ChecksumSHA256: base64.StdEncoding.EncodeToString(sum[:])})
+}
+
+func (s *S3Server) completeUpload(w http.ResponseWriter, key, uploadID string, body []byte, header http.Header) {
+	parts, ok := s.uploads[uploadID]
+	if !ok {
+		s3Fail(w, http.StatusNotFound, "NoSuchUpload", uploadID)
+		return
+	}
+	var request struct {
+		Parts []struct {
+			PartNumber     int    `xml:"PartNumber"`
+			ChecksumSHA256 string `xml:"ChecksumSHA256"`
+		} `xml:"Part"`
+	}
+	if err := xml.Unmarshal(body, &request); err != nil {
+		s3Fail(w, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+
+	var data bytes.Buffer
+	for _, part := range request.Parts {
+		content, ok := parts[part.PartNumber]
+		sum := sha256.Sum256(content)
+		if !ok || part.ChecksumSHA256 != base64.StdEncoding.EncodeToString(sum[:]) {
+			s3Fail(w, http.StatusBadRequest, "InvalidPart", strconv.Itoa(part.PartNumber))
+			return
+		}
+		data.Write(content)
+	}
+
+	delete(s.uploads, uploadID)
+	s.MultipartCount++
+	s.objects[key] = &S3Object{Data: data.Bytes(), StorageClass: header.Get("x-amz-storage-class"), ModTime: time.Now()}
+	s3Write(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Key     string   `xml:"Key"`
+	}{Key: key})
+}
+
+func checksumMatches(body []byte, header http.Header) bool {
+	expected := header.Get("x-amz-checksum-sha256")
+	if expected == "" {
+		return true
+	}
+	sum := sha256.Sum256(body)
+	return expected == base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func etag(body []byte) string {
+	sum := md5.Sum(body)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func s3Write(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(value)
+}
+
+func s3Fail(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: message})
+}
@@ -0,0 +1,194 @@
+package testutil
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TusUpload is an upload kept by TusServer
+type TusUpload struct {
+	Length   int64
+	Metadata map[string]string
+	Data     []byte
+}
+
+// Complete reports whether every byte of the upload was received
+func (u *TusUpload) Complete() bool {
+	return int64(len(u.Data)) == u.Length
+}
+
+// TusServer is an in-memory stand-in for the media server upload endpoint,
+// speaking the core tus 1.0 protocol (POST to create, HEAD for the offset,
+// PATCH to append) and its termination extension (DELETE) under
+// /movies/uploads with bearer token authentication.
+type TusServer struct {
+	*httptest.Server
+	Token string
+
+	mu             sync.Mutex
+	uploads        map[string]*TusUpload
+	nextID         int
+	patches        int
+	terminated     int
+	allowedPatches int
+	rejected       map[string]bool
+}
+
+// NewTusServer starts a server accepting token.
+func NewTusServer(token string) *TusServer {
+	s := &TusServer{Token: token, uploads: make(map[string]*TusUpload), allowedPatches: -1,
+		rejected: make(map[string]bool)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// FailPatchesAfter makes every PATCH after the next n fail with HTTP 500,
+// simulating an interrupted upload. A negative n accepts all again.
+func (s *TusServer) FailPatchesAfter(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.allowedPatches = n
+}
+
+// Reject makes creating an upload whose "name" metadata is name fail
+// with HTTP 403, as for a file the server refuses.
+func (s *TusServer) Reject(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejected[name] = true
+}
+
+// Patches returns how many PATCH requests were accepted
+func (s *TusServer) Patches() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.patches
+}
+
+// Terminated returns how many uploads were terminated by DELETE requests
+func (s *TusServer) Terminated() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.terminated
+}
+
+// Uploads returns the uploads created so far and not terminated
+func (s *TusServer) Uploads() []*TusUpload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploads := make([]*TusUpload, 0, len(s.uploads))
+	for i := 1; i <= s.nextID; i++ {
+		if upload, ok := s.uploads[strconv.Itoa(i)]; ok {
+			uploads = append(uploads, upload)
+		}
+	}
+	return uploads
+}
+
+// Forget drops every upload, as a server does when uploads expire
+func (s *TusServer) Forget() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploads = make(map[string]*TusUpload)
+}
+
+func (s *TusServer) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+s.Token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != "1.0.0" {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	w.Header().Set("Tus-Resumable", "1.0.0")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.URL.Path == "/movies/uploads" && r.Method == "POST" {
+		length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil || length < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		metadata := decodeMetadata(r.Header.Get("Upload-Metadata"))
+		if s.rejected[metadata["name"]] {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		s.nextID++
+		id := strconv.Itoa(s.nextID)
+		s.uploads[id] = &TusUpload{Length: length, Metadata: metadata}
+		w.Header().Set("Location", "/movies/uploads/"+id)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	id, found := strings.CutPrefix(r.URL.Path, "/movies/uploads/")
+	upload := s.uploads[id]
+	if !found || upload == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "HEAD":
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(upload.Data)))
+		w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		w.WriteHeader(http.StatusOK)
+	case "PATCH":
+		if s.allowedPatches == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		offset, err := strconv.Atoi(r.Header.Get("Upload-Offset"))
+		if err != nil || offset != len(upload.Data) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil || int64(len(upload.Data)+len(data)) > upload.Length {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		upload.Data = append(upload.Data, data...)
+		s.patches++
+		if s.allowedPatches > 0 {
+			s.allowedPatches--
+		}
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(upload.Data)))
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		delete(s.uploads, id)
+		s.terminated++
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// decodeMetadata parses a tus Upload-Metadata header
+func decodeMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err == nil {
+			metadata[key] = string(decoded)
+		}
+	}
+	return metadata
+}
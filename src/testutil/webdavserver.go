@@ -0,0 +1,48 @@
+package testutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"golang.org/x/net/webdav"
+)
+
+// WebDAVServer serves a local directory over WebDAV with basic authentication
+type WebDAVServer struct {
+	*httptest.Server
+	Root     string
+	Username string
+	Password string
+
+	mu      sync.Mutex
+	methods map[string]int
+}
+
+// NewWebDAVServer starts a WebDAV server for root accepting username and password.
+func NewWebDAVServer(root, username, password string) *WebDAVServer {
+	s := &WebDAVServer{Root: root, Username: username, Password: password, methods: make(map[string]int)}
+	handler := &webdav.Handler{
+		FileSystem: webdav.Dir(root),
+		LockSystem: webdav.NewMemLS(),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != s.Username || pass != s.Password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		s.mu.Lock()
+		s.methods[r.Method]++
+		s.mu.Unlock()
+		handler.ServeHTTP(w, r)
+	}))
+	return s
+}
+
+// Requests returns how many authenticated requests used method
+func (s *WebDAVServer) Requests(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.methods[method]
+}
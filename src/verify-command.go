@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/pedrosantosdev/radarr-sync-go/src/compress"
+	"github.com/pedrosantosdev/radarr-sync-go/src/io_archive"
+)
+
+// runVerify checks every archive in the target against its manifest and
+// fails if any archive is corrupt or missing.
+func runVerify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	target := flags.String(flagTarget, "", "Directory or URL with compressed files")
+	trashDir := flags.String(flagTrashDir, compress.DefaultTrashDir, "Trash directory inside target, not verified")
+	verbose := flags.Bool("verbose", false, "Also print archives that passed")
+	remote := registerTargetFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *target == "" {
+		return fmt.Errorf("target is required")
+	}
+
+	store, err := remote.open(*target)
+	if err != nil {
+		return err
+	}
+	results, err := io_archive.VerifyAll(store, *trashDir)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.OK() && !*verbose {
+			continue
+		}
+		// Archives created before manifests existed are reported but not failed
+		if result.Status == io_archive.VerifyCorrupt || result.Status == io_archive.VerifyMissing {
+			failed++
+		}
+		fmt.Printf("%-12s %s\n", result.Status, result.Archive)
+		if len(result.Problems) > 0 {
+			fmt.Printf("             %s\n", strings.Join(result.Problems, "\n             "))
+		}
+	}
+
+	fmt.Printf("Verified %d archives in %s, %d with problems\n", len(results), store, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d archives failed verification", failed)
+	}
+	return nil
+}